@@ -0,0 +1,325 @@
+// Code generated by cmd/sdkgen from services/auth-service/api/openapi.json. DO NOT EDIT.
+//
+// Client for the auth-service API (version 1.0.0). Failed calls reject
+// with an AuthError carrying the response's status and error code.
+'use strict';
+
+const axios = require('axios');
+
+/**
+ * @typedef {Object} AuthResponse
+ * @property {string} timestamp
+ * @property {string} [user]
+ * @property {boolean} valid
+ */
+
+/**
+ * @typedef {Object} AuthenticateRequest
+ * @property {string} token
+ */
+
+/**
+ * @typedef {Object} BatchItem
+ * @property {string} [apiKey]
+ * @property {string} [clientCertThumbprint]
+ * @property {string} [clientIp]
+ * @property {string} id
+ * @property {string} [token]
+ */
+
+/**
+ * @typedef {Object} BatchRequest
+ * @property {BatchItem[]} items
+ */
+
+/**
+ * @typedef {Object} BatchResponse
+ * @property {number} count
+ * @property {BatchResult[]} results
+ * @property {string} timestamp
+ * @property {number} valid How many results are valid
+ */
+
+/**
+ * @typedef {Object} BatchResult
+ * @property {ErrorDetail} [error]
+ * @property {number} [expiresAt]
+ * @property {string} id
+ * @property {Principal} [principal]
+ * @property {boolean} valid
+ */
+
+/**
+ * @typedef {Object} BuildInfo
+ * @property {string} [buildTime]
+ * @property {string} commit
+ * @property {string} goVersion
+ * @property {boolean} [modified] Built from a checkout with uncommitted changes
+ * @property {string} version
+ */
+
+/**
+ * @typedef {Object} ErrorDetail
+ * @property {string} code
+ * @property {string} message
+ */
+
+/**
+ * @typedef {Object} HealthResponse
+ * @property {BuildInfo} [build]
+ * @property {string} service
+ * @property {string} status
+ * @property {string} timestamp
+ * @property {number} uptime Seconds since start
+ * @property {string} version
+ */
+
+/**
+ * @typedef {Object} LoginRequest
+ * @property {string} [audience]
+ * @property {string} email
+ * @property {string} password
+ */
+
+/**
+ * @typedef {Object} MagicLinkCallbackRequest
+ * @property {string} token
+ */
+
+/**
+ * @typedef {Object} MagicLinkRequest
+ * @property {string} [audience]
+ * @property {string} email
+ */
+
+/**
+ * @typedef {Object} MessageResponse
+ * @property {string} message
+ */
+
+/**
+ * @typedef {Object} Principal
+ * @property {string} [impersonatedBy]
+ * @property {string} kind user, service or workload
+ * @property {string[]} [roles]
+ * @property {string[]} [scopes]
+ * @property {string} subject
+ * @property {string} [tenant]
+ */
+
+/**
+ * @typedef {Object} QuotaResponse
+ * @property {QuotaWindow} daily
+ * @property {string} keyId
+ * @property {QuotaWindow} monthly
+ */
+
+/**
+ * @typedef {Object} QuotaWindow
+ * @property {number} limit 0 when unlimited
+ * @property {number} remaining
+ * @property {string} resetsAt
+ * @property {number} used
+ */
+
+/**
+ * @typedef {Object} RegisterRequest
+ * @property {string} email
+ * @property {string} password
+ */
+
+/**
+ * @typedef {Object} RegisterResponse
+ * @property {string} email
+ * @property {string} id
+ * @property {string} message
+ * @property {string} status
+ */
+
+/**
+ * @typedef {Object} TokenResponse
+ * @property {string} audience
+ * @property {number} expiresIn Seconds
+ * @property {string} token
+ * @property {string} tokenType
+ */
+
+/**
+ * @typedef {Object} ValidateResponse
+ * @property {string} [audience]
+ * @property {string} [impersonatedBy]
+ * @property {string} [message]
+ * @property {string} service
+ * @property {string} timestamp
+ * @property {string} [user]
+ * @property {boolean} valid
+ */
+
+class AuthError extends Error {
+    constructor(status, code, message) {
+        super(message);
+        this.name = 'AuthError';
+        this.status = status;
+        this.code = code;
+    }
+}
+
+class AuthClient {
+    /**
+     * @param {Object} [options]
+     * @param {string} [options.baseURL]
+     * @param {number} [options.timeout] milliseconds
+     * @param {string} [options.apiKey] sent as X-API-Key
+     * @param {string} [options.internalApiKey] sent as X-Internal-API-Key
+     * @param {string} [options.serviceToken] sent as X-Service-Token
+     * @param {string} [options.tenant] sent as X-Tenant-ID
+     */
+    constructor(options = {}) {
+        this.http = axios.create({
+            baseURL: options.baseURL || 'http://auth-service:8080',
+            timeout: options.timeout || 15000
+        });
+        this.headers = {};
+        if (options.apiKey) {
+            this.headers['X-API-Key'] = options.apiKey;
+        }
+        if (options.internalApiKey) {
+            this.headers['X-Internal-API-Key'] = options.internalApiKey;
+        }
+        if (options.serviceToken) {
+            this.headers['X-Service-Token'] = options.serviceToken;
+        }
+        if (options.tenant) {
+            this.headers['X-Tenant-ID'] = options.tenant;
+        }
+    }
+
+    async request(method, url, headers, data) {
+        try {
+            const response = await this.http.request({
+                method,
+                url,
+                data,
+                headers: Object.assign({ Accept: 'application/json' }, this.headers, headers)
+            });
+            return response.data;
+        } catch (error) {
+            const body = error.response && error.response.data && error.response.data.error;
+            if (body) {
+                throw new AuthError(error.response.status, body.code, body.message);
+            }
+            throw error;
+        }
+    }
+
+    /**
+     * POST /authenticate: check a user token on behalf of a service
+     * @param {AuthenticateRequest} body
+     * @returns {Promise<AuthResponse>}
+     */
+    authenticate(body) {
+        const headers = {};
+        return this.request('POST', '/authenticate', headers, body);
+    }
+
+    /**
+     * GET /build-info: version, commit and build time of the running binary
+     * @returns {Promise<BuildInfo>}
+     */
+    buildInfo() {
+        const headers = {};
+        return this.request('GET', '/build-info', headers, undefined);
+    }
+
+    /**
+     * GET /health: liveness and build information
+     * @returns {Promise<HealthResponse>}
+     */
+    health() {
+        const headers = {};
+        return this.request('GET', '/health', headers, undefined);
+    }
+
+    /**
+     * POST /login: exchange credentials for a signed token
+     * @param {LoginRequest} body
+     * @returns {Promise<TokenResponse>}
+     */
+    login(body) {
+        const headers = {};
+        return this.request('POST', '/login', headers, body);
+    }
+
+    /**
+     * POST /login/callback: exchange a sign-in link's token for a signed token
+     * @param {MagicLinkCallbackRequest} body
+     * @returns {Promise<TokenResponse>}
+     */
+    magicLinkCallback(body) {
+        const headers = {};
+        return this.request('POST', '/login/callback', headers, body);
+    }
+
+    /**
+     * POST /login/magic-link: send a single-use sign-in link to the account's address
+     * @param {MagicLinkRequest} body
+     * @returns {Promise<MessageResponse>}
+     */
+    requestMagicLink(body) {
+        const headers = {};
+        return this.request('POST', '/login/magic-link', headers, body);
+    }
+
+    /**
+     * GET /quota: the calling API key's daily and monthly usage
+     * @returns {Promise<QuotaResponse>}
+     */
+    quota() {
+        const headers = {};
+        return this.request('GET', '/quota', headers, undefined);
+    }
+
+    /**
+     * POST /register: create a pending account and mail a verification link
+     * @param {RegisterRequest} body
+     * @returns {Promise<RegisterResponse>}
+     */
+    register(body) {
+        const headers = {};
+        return this.request('POST', '/register', headers, body);
+    }
+
+    /**
+     * GET /validate: check the caller's service credentials and, when given, a user token issued for the caller
+     * @param {Object} [options]
+     * @param {string} [options.subjectToken] sent as X-Subject-Token
+     * @param {string} [options.clientIp] sent as X-Client-IP
+     * @param {string} [options.clientCertThumbprint] sent as X-Client-Cert-Thumbprint
+     * @returns {Promise<ValidateResponse>}
+     */
+    validate(options = {}) {
+        const headers = {};
+        if (options.subjectToken) {
+            headers['X-Subject-Token'] = options.subjectToken;
+        }
+        if (options.clientIp) {
+            headers['X-Client-IP'] = options.clientIp;
+        }
+        if (options.clientCertThumbprint) {
+            headers['X-Client-Cert-Thumbprint'] = options.clientCertThumbprint;
+        }
+        return this.request('GET', '/validate', headers, undefined);
+    }
+
+    /**
+     * POST /validate/batch: check up to VALIDATE_BATCH_MAX tokens or API keys in one round trip
+     * @param {BatchRequest} body
+     * @returns {Promise<BatchResponse>}
+     */
+    validateBatch(body) {
+        const headers = {};
+        return this.request('POST', '/validate/batch', headers, body);
+    }
+}
+
+module.exports = { AuthClient, AuthError };
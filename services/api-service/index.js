@@ -2,6 +2,7 @@ const express = require('express');
 const axios = require('axios');
 const crypto = require('crypto');
 const promClient = require('prom-client');
+const { AuthClient } = require('./authClient');
 
 const app = express();
 app.use(express.json());
@@ -22,6 +23,13 @@ console.log(`  Auth Service Token: ${AUTH_SERVICE_TOKEN ? '✅ Configured' : '
 console.log(`  Database Credentials: ${DB_USER && DB_PASSWORD ? '✅ Configured' : '❌ Missing'}`);
 console.log(`  S3 Credentials: ${S3_ACCESS_KEY ? '✅ Configured' : '❌ Missing'}`);
 
+// Generated from the auth service's OpenAPI spec; see services/auth-service/Makefile
+const auth = new AuthClient({
+    serviceToken: AUTH_SERVICE_TOKEN,
+    internalApiKey: INTERNAL_API_KEY,
+    timeout: 5000
+});
+
 // Prometheus metrics
 const register = new promClient.Registry();
 promClient.collectDefaultMetrics({ register });
@@ -87,10 +95,7 @@ app.get('/health', (req, res) => {
 // Readiness check
 app.get('/ready', async (req, res) => {
     try {
-        const authCheck = await axios.get('http://auth-service:8080/health', {
-            headers: { 'X-Service-Token': AUTH_SERVICE_TOKEN },
-            timeout: 2000
-        }).catch(() => null);
+        const authCheck = await auth.health().catch(() => null);
         
         const imageCheck = await axios.get('http://image-service:5000/health', {
             timeout: 2000
@@ -119,19 +124,14 @@ app.get('/secret-status', (req, res) => {
 // Test inter-service communication
 app.get('/test-communication', async (req, res) => {
     try {
-        const authResponse = await axios.get('http://auth-service:8080/validate', {
-            headers: {
-                'X-Service-Token': AUTH_SERVICE_TOKEN,
-                'X-Internal-API-Key': INTERNAL_API_KEY
-            }
-        });
+        const authResponse = await auth.validate();
         
         const imageResponse = await axios.get('http://image-service:5000/status');
         
         res.json({
             success: true,
             api_service: 'operational',
-            auth_service: authResponse.data,
+            auth_service: authResponse,
             image_service: imageResponse.data,
             secrets_used: true,
             timestamp: new Date().toISOString()
@@ -209,27 +209,20 @@ app.post('/api/process', async (req, res) => {
             return res.status(401).json({ error: 'No authorization header' });
         }
         
-        const authResult = await axios.post('http://auth-service:8080/authenticate', {
-            token: authHeader
-        }, {
-            headers: {
-                'X-Service-Token': AUTH_SERVICE_TOKEN,
-                'X-Internal-API-Key': INTERNAL_API_KEY
-            }
-        });
+        const authResult = await auth.authenticate({ token: authHeader });
         
-        if (!authResult.data.valid) {
+        if (!authResult.valid) {
             return res.status(401).json({ error: 'Invalid token' });
         }
         
         const imageResult = await axios.post('http://image-service:5000/process', {
             image: req.body.image || 'default.jpg',
-            user: authResult.data.user
+            user: authResult.user
         });
         
         res.json({
             success: true,
-            user: authResult.data.user,
+            user: authResult.user,
             image_processed: imageResult.data,
             timestamp: new Date().toISOString()
         });
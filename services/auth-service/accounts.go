@@ -0,0 +1,163 @@
+package main
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+var tokenTTL = getEnvDuration("TOKEN_TTL", time.Hour)
+
+type credentialsRequest struct {
+    Email    string `json:"email"`
+    Password string `json:"password"`
+}
+
+// Register endpoint: creates a pending account and mails a verification link
+func registerHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+
+    var req credentialsRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !strings.Contains(req.Email, "@") || len(req.Password) < 8 {
+        autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("A valid email and a password of at least 8 characters are required"))
+        return
+    }
+
+    user, err := createUser(r.Context(), tenantFromContext(r.Context()), req.Email, req.Password)
+    if err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    recordAudit(r, "user.registered", user.ID, map[string]string{"email": user.Email})
+    resendLimiter.Allow(user.Tenant + "/" + user.Email)
+    if err := sendVerificationEmail(r.Context(), user); err != nil {
+        log.Printf("⚠️  Verification email failed: %v", err)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "id":      user.ID,
+        "email":   user.Email,
+        "status":  user.Status,
+        "message": "Verification email sent",
+    })
+}
+
+// Open a session for the user and sign a token for it, recording the event
+// in the audit log with the session and audience added to details
+func issueSessionToken(r *http.Request, user *User, audience, event string, details map[string]string) (string, error) {
+    now := clock.Now()
+    session := &Session{
+        ID:        randomHex(16),
+        UserID:    user.ID,
+        ClientIP:  clientIP(r).String(),
+        CreatedAt: now,
+        ExpiresAt: now.Add(tokenTTL),
+    }
+    if err := store.CreateSession(r.Context(), session); err != nil {
+        log.Printf("❌ Session create failed: %v", err)
+        return "", autherr.ErrInternal
+    }
+    claims := Claims{
+        ID:        session.ID,
+        Subject:   user.ID,
+        Email:     user.Email,
+        Roles:     user.Roles,
+        Audience:  audience,
+        Tenant:    claimTenant(user.Tenant),
+        IssuedAt:  now.Unix(),
+        NotBefore: now.Unix(),
+        ExpiresAt: session.ExpiresAt.Unix(),
+    }
+    bindToken(r, &claims)
+    token, err := signToken(claims)
+    if err != nil {
+        return "", err
+    }
+    if details == nil {
+        details = make(map[string]string)
+    }
+    details["session"] = session.ID
+    details["audience"] = audience
+    recordAudit(r, event, user.ID, details)
+    return token, nil
+}
+
+// Login endpoint: exchanges credentials for a signed token; pending accounts
+// are refused until their email is verified
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+    if secrets.get().signingKeys[tenantFromContext(r.Context())] == nil {
+        autherr.Write(w, autherr.ErrNotConfigured.WithMessage("JWT secret not configured"))
+        return
+    }
+
+    var req struct {
+        credentialsRequest
+        Audience string `json:"audience"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+        autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("Invalid request body"))
+        return
+    }
+    audience, err := loginAudience(req.Audience)
+    if err != nil {
+        autherr.Write(w, err)
+        return
+    }
+
+    attempt := LoginAttempt{
+        Tenant: tenantFromContext(r.Context()),
+        Email:  normalizeEmail(req.Email),
+        IP:     clientIP(r),
+        Time:   clock.Now(),
+    }
+    user, err := store.GetUserByEmail(r.Context(), attempt.Tenant, attempt.Email)
+    if err != nil || !checkPassword(user.PasswordHash, req.Password) {
+        riskScorer.Observe(attempt, false)
+        recordAudit(r, "login.failed", attempt.Email, nil)
+        autherr.Write(w, autherr.ErrInvalidCredentials.WithMessage("Invalid email or password"))
+        return
+    }
+    if user.Status == UserStatusDisabled {
+        recordAudit(r, "login.failed", user.ID, map[string]string{"reason": "disabled"})
+        autherr.Write(w, autherr.ErrAccountDisabled)
+        return
+    }
+    if user.Status != UserStatusActive {
+        recordAudit(r, "login.failed", user.ID, map[string]string{"reason": "unverified"})
+        autherr.Write(w, autherr.ErrUnverified)
+        return
+    }
+    attempt.UserID = user.ID
+    if err := assessLoginRisk(r, attempt); err != nil {
+        autherr.Write(w, err)
+        return
+    }
+
+    token, err := issueSessionToken(r, user, audience, "login.succeeded", nil)
+    if err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    riskScorer.Observe(attempt, true)
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "token":     token,
+        "tokenType": "Bearer",
+        "expiresIn": int(tokenTTL.Seconds()),
+        "audience":  audience,
+    })
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+    "embed"
+    "encoding/json"
+    "io/fs"
+    "log"
+    "net/http"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Admin dashboard, so the minikube demo needs no Grafana to see what the
+// service is doing. /admin/ui serves the embedded page itself, which holds
+// no data and so may be public; it asks for an admin API key or token and
+// sends it on every call. The page polls /admin/dashboard for sessions and
+// key status and /audit for recent events, which the policy keeps to
+// admins, and /metrics and /slo for the live numbers. Those two are public
+// so Prometheus can scrape them and hold only aggregate counts.
+//
+//go:embed ui
+var adminUIFiles embed.FS
+
+var adminDashboardSessions = getEnvInt("ADMIN_DASHBOARD_SESSIONS", 50)
+
+func adminUIHandler() http.HandlerFunc {
+    files, err := fs.Sub(adminUIFiles, "ui")
+    if err != nil {
+        log.Fatalf("❌ Admin UI files missing: %v", err)
+    }
+    static := http.StripPrefix("/admin/ui/", http.FileServer(http.FS(files)))
+    return withETag(func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet && r.Method != http.MethodHead {
+            autherr.Write(w, autherr.ErrMethodNotAllowed)
+            return
+        }
+        if r.URL.Path == "/admin/ui" {
+            http.Redirect(w, r, "/admin/ui/", http.StatusMovedPermanently)
+            return
+        }
+        w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+        w.Header().Set("X-Frame-Options", "DENY")
+        w.Header().Set("X-Content-Type-Options", "nosniff")
+        static.ServeHTTP(w, r)
+    })
+}
+
+// Dashboard endpoint: the tenant's live sessions, newest first, and which
+// keys are loaded
+func adminDashboardHandler(w http.ResponseWriter, r *http.Request) {
+    if err := requireAdmin(r); err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    if r.Method != http.MethodGet {
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+    tenant := tenantFromContext(r.Context())
+    now := clock.Now()
+    sessions, err := store.ListSessions(r.Context(), SessionFilter{Tenant: tenant, ActiveAt: now, Limit: adminDashboardSessions})
+    if err != nil {
+        log.Printf("❌ Listing sessions failed: %v", err)
+        autherr.Write(w, autherr.ErrInternal)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "tenant":     tenant,
+        "sessions":   sessions,
+        "keys":       secrets.status(tenant),
+        "encryption": fieldJob.status(),
+        "timestamp":  time.Now(),
+    })
+}
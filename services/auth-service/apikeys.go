@@ -0,0 +1,148 @@
+package main
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// ?owner= matches exactly, ?revoked= is true or false
+var apiKeyListSpec = listSpec{
+    defaultLimit: 50,
+    maxLimit:     200,
+    sorts:        []string{"createdAt", "name", "id"},
+    defaultSort:  "createdAt",
+    filters:      []string{"owner", "revoked"},
+}
+
+func apiKeySortKey(k APIKey, field string) string {
+    switch field {
+    case "name":
+        return k.Name
+    case "id":
+        return k.ID
+    }
+    return sortKeyTime(k.CreatedAt)
+}
+
+// API keys endpoint: GET lists the tenant's keys a page at a time, POST
+// creates one and returns its secret exactly once, DELETE
+// /admin/api-keys/{id} revokes. Only admins may use it, and a key can't be
+// given a role its creator doesn't hold.
+func apiKeysHandler(w http.ResponseWriter, r *http.Request) {
+    if err := requireAdmin(r); err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    tenant := tenantFromContext(r.Context())
+    id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/api-keys"), "/")
+
+    switch {
+    case r.Method == http.MethodGet && id == "":
+        lq, err := parseListQuery(r, apiKeyListSpec)
+        if err != nil {
+            autherr.Write(w, err)
+            return
+        }
+        if v := lq.Filters["revoked"]; v != "" && v != "true" && v != "false" {
+            autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("revoked must be true or false"))
+            return
+        }
+        keys, err := store.ListAPIKeys(r.Context())
+        if err != nil {
+            log.Printf("❌ API key list failed: %v", err)
+            autherr.Write(w, autherr.ErrInternal)
+            return
+        }
+        visible := []APIKey{}
+        for _, k := range keys {
+            if k.Tenant == tenant && (lq.Filters["owner"] == "" || k.Owner == lq.Filters["owner"]) &&
+                (lq.Filters["revoked"] == "" || strconv.FormatBool(k.Revoked) == lq.Filters["revoked"]) {
+                visible = append(visible, k)
+            }
+        }
+        page, next := pageOf(visible, lq, apiKeySortKey, func(k APIKey) string { return k.ID })
+        writeListPage(w, "keys", page, len(page), next)
+
+    case r.Method == http.MethodPost && id == "":
+        var req struct {
+            Name         string   `json:"name"`
+            Owner        string   `json:"owner"`
+            Roles        []string `json:"roles"`
+            Scopes       []string `json:"scopes"`
+            DailyQuota   int64    `json:"dailyQuota"`
+            MonthlyQuota int64    `json:"monthlyQuota"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" || req.Owner == "" {
+            autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("name and owner are required"))
+            return
+        }
+        if req.DailyQuota < 0 || req.MonthlyQuota < 0 {
+            autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("Quotas cannot be negative"))
+            return
+        }
+        caller := principalFromContext(r.Context())
+        for _, role := range req.Roles {
+            if !caller.HasRole(role) {
+                autherr.Write(w, autherr.ErrForbidden.WithMessage("Cannot grant role "+role+" you don't hold"))
+                return
+            }
+        }
+        secret := "ak_" + randomHex(24)
+        key := &APIKey{
+            ID:           "key-" + randomHex(8),
+            Tenant:       tenant,
+            Name:         req.Name,
+            Owner:        req.Owner,
+            Hash:         hashAPIKey(secret),
+            Roles:        req.Roles,
+            Scopes:       req.Scopes,
+            CreatedAt:    time.Now(),
+            DailyQuota:   req.DailyQuota,
+            MonthlyQuota: req.MonthlyQuota,
+        }
+        if err := store.CreateAPIKey(r.Context(), key); err != nil {
+            log.Printf("❌ API key create failed: %v", err)
+            autherr.Write(w, autherr.ErrInternal)
+            return
+        }
+        recordAudit(r, "apikey.created", key.Owner, map[string]string{"id": key.ID, "name": key.Name})
+
+        w.Header().Set("Content-Type", "application/json")
+        w.Header().Set("Cache-Control", "no-store")
+        w.WriteHeader(http.StatusCreated)
+        json.NewEncoder(w).Encode(map[string]interface{}{
+            "key":    key,
+            "secret": secret,
+        })
+
+    case r.Method == http.MethodDelete && id != "":
+        keys, err := store.ListAPIKeys(r.Context())
+        if err != nil {
+            autherr.Write(w, autherr.ErrInternal)
+            return
+        }
+        found := false
+        for _, k := range keys {
+            found = found || (k.ID == id && k.Tenant == tenant)
+        }
+        if !found {
+            autherr.Write(w, errAPIKeyNotFound)
+            return
+        }
+        if err := store.RevokeAPIKey(r.Context(), id); err != nil {
+            autherr.Write(w, err)
+            return
+        }
+        recordAudit(r, "apikey.revoked", id, nil)
+        w.WriteHeader(http.StatusNoContent)
+
+    default:
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+    }
+}
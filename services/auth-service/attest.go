@@ -0,0 +1,180 @@
+package main
+
+import (
+    "crypto/ed25519"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "encoding/pem"
+    "errors"
+    "log"
+    "net/http"
+    "sort"
+    "sync"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// /attest returns a JWS (EdDSA) statement of what this instance is running:
+// version and commit, a hash of its effective configuration, its feature
+// flags and the fingerprints of the keys it holds. Verifiers pin the
+// attestation key's thumbprint (the JWS kid); the public key travels in the
+// header so nothing else needs distributing.
+//
+// The key is the attestation-key file (PKCS#8 PEM) in SECRETS_DIR. Without
+// one a key is generated per process, which still proves statements are
+// consistent but changes on every restart.
+var attestTTL = getEnvDuration("ATTEST_TTL", 5*time.Minute)
+
+var (
+    ephemeralAttestOnce sync.Once
+    ephemeralAttestKey  ed25519.PrivateKey
+)
+
+// Parse a PKCS#8 PEM Ed25519 key from the named secret file
+func parseEd25519Key(name string, data []byte) (ed25519.PrivateKey, error) {
+    block, _ := pem.Decode(data)
+    if block == nil {
+        return nil, errors.New(name + ": no PEM block")
+    }
+    key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+    if err != nil {
+        return nil, err
+    }
+    ed, ok := key.(ed25519.PrivateKey)
+    if !ok {
+        return nil, errors.New(name + ": not an Ed25519 key")
+    }
+    return ed, nil
+}
+
+func attestationKey() ed25519.PrivateKey {
+    if set := secrets.get(); set != nil && set.attestationKey != nil {
+        return set.attestationKey
+    }
+    ephemeralAttestOnce.Do(func() {
+        _, ephemeralAttestKey, _ = ed25519.GenerateKey(rand.Reader)
+        log.Printf("⚠️  No attestation-key secret; attesting with a per-process key")
+    })
+    return ephemeralAttestKey
+}
+
+// JWK for an Ed25519 public key and its RFC 7638 thumbprint
+func attestationJWK(pub ed25519.PublicKey) (map[string]string, string) {
+    x := base64.RawURLEncoding.EncodeToString(pub)
+    sum := sha256.Sum256([]byte(`{"crv":"Ed25519","kty":"OKP","x":"` + x + `"}`))
+    return map[string]string{"kty": "OKP", "crv": "Ed25519", "x": x}, base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func fingerprint(b []byte) string {
+    sum := sha256.Sum256(b)
+    return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// Settings that change how the service behaves, hashed so two replicas (or
+// the same one over time) can be compared without exposing values
+func configHash() string {
+    tenantIDs := make([]string, 0, len(tenants))
+    for t := range tenants {
+        tenantIDs = append(tenantIDs, t)
+    }
+    sort.Strings(tenantIDs)
+    config := map[string]interface{}{
+        "storageDriver":  storageDriver,
+        "tokenTTL":       tokenTTL.String(),
+        "exchangeTTL":    exchangeTTL.String(),
+        "tokenLeeway":    tokenLeeway.String(),
+        "signatureSkew":  signatureSkew.String(),
+        "tenants":        tenantIDs,
+        "trustedProxies": getEnv("TRUSTED_PROXIES", ""),
+        "spiffe":         spiffeEnabled,
+        "riskThresholds": []int{riskMFAThreshold, riskDenyThreshold},
+        "quotas":         []int64{defaultDailyQuota, defaultMonthlyQuota},
+    }
+    if p := policies.current.Load(); p != nil {
+        config["policy"] = p.Rules
+    }
+    data, _ := json.Marshal(config) // map keys marshal sorted
+    sum := sha256.Sum256(data)
+    return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Attest endpoint: a signed statement of version, config and keys; ?nonce=
+// is echoed so callers can demand a fresh signature
+func attestHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+    nonce := r.URL.Query().Get("nonce")
+    if len(nonce) > 128 {
+        autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("nonce is limited to 128 characters"))
+        return
+    }
+
+    features := map[string]bool{}
+    if m := flags.current.Load(); m != nil {
+        for name, state := range *m {
+            features[name] = state.Enabled
+        }
+    }
+    keys := map[string]interface{}{}
+    set := secrets.get()
+    signing := map[string]string{}
+    for tenant, k := range set.signingKeys {
+        signing[tenant] = fingerprint([]byte(k.secret))
+    }
+    keys["signing"] = signing
+    if set.tokenKeys != nil {
+        tokenKeys := map[string]string{}
+        for tenant, k := range set.tokenKeys {
+            tokenKeys[tenant] = k.kid
+        }
+        keys["token"] = tokenKeys
+    }
+    if set.cert != nil {
+        keys["tls"] = fingerprint(set.cert.Certificate[0])
+    }
+    if spiffeEnabled {
+        spiffe.mu.RLock()
+        if spiffe.cert != nil {
+            keys["svid"] = map[string]string{"id": spiffe.id, "fingerprint": fingerprint(spiffe.cert.Certificate[0])}
+        }
+        spiffe.mu.RUnlock()
+    }
+
+    now := clock.Now()
+    statement := map[string]interface{}{
+        "iss":        "auth-service",
+        "iat":        now.Unix(),
+        "exp":        now.Add(attestTTL).Unix(),
+        "version":    build.Version,
+        "commit":     build.Commit,
+        "configHash": configHash(),
+        "features":   features,
+        "keys":       keys,
+    }
+    if nonce != "" {
+        statement["nonce"] = nonce
+    }
+
+    key := attestationKey()
+    jwk, kid := attestationJWK(key.Public().(ed25519.PublicKey))
+    header, _ := json.Marshal(map[string]interface{}{"alg": "EdDSA", "kid": kid, "jwk": jwk})
+    payload, _ := json.Marshal(statement)
+    enc := base64.RawURLEncoding
+    signingInput := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+    jws := signingInput + "." + enc.EncodeToString(ed25519.Sign(key, []byte(signingInput)))
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "jws":       jws,
+        "kid":       kid,
+        "statement": statement,
+    })
+}
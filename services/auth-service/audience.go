@@ -0,0 +1,102 @@
+package main
+
+import (
+    "log"
+    "os"
+    "strings"
+
+    "auth-service/internal/autherr"
+)
+
+// Tokens are bound to the service they were minted for by their aud claim,
+// so a token issued to the frontend can't be replayed against internal
+// APIs. Login issues tokens for one of TOKEN_AUDIENCES (the first is the
+// default). When a service validates a token, the audience must be one the
+// service accepts: SERVICE_AUDIENCES maps caller identities to audiences in
+// the SPIFFE_ROLE_MAP syntax, e.g.
+// "internal-service=api-service;spiffe://minikube.local/ns/production/sa/*=frontend|api-service".
+// By default internal-service, the callers holding the shared service
+// credentials, accepts the default login audience, as the gateway validates
+// the frontend's tokens that way. A caller with no mapping accepts tokens
+// addressed to its own subject.
+// Token exchange only issues tokens for the audiences EXCHANGE_AUDIENCES
+// maps the exchanging service to, in the same syntax; a service with no
+// mapping can't exchange at all. Bearer tokens presented to this service's
+// own routes must be addressed to one of SELF_AUDIENCES, by default
+// auth-service and the default login audience, so a token exchanged for
+// another service can't be replayed here.
+var (
+    tokenAudiences    = parseAudiences("TOKEN_AUDIENCES", getEnv("TOKEN_AUDIENCES", "frontend"))
+    selfAudiences     = parseAudiences("SELF_AUDIENCES", getEnv("SELF_AUDIENCES", "auth-service,"+tokenAudiences[0]))
+    serviceAudiences  = parseSPIFFERoleMap(getEnv("SERVICE_AUDIENCES", "internal-service="+tokenAudiences[0]))
+    exchangeAudiences = parseSPIFFERoleMap(os.Getenv("EXCHANGE_AUDIENCES"))
+)
+
+var (
+    errUnknownAudience = autherr.ErrInvalidRequest.WithMessage("Unknown audience")
+    errWrongAudience   = autherr.ErrInvalidToken.WithMessage("Token was not issued for this service")
+    errNoAudience      = autherr.ErrInvalidToken.WithMessage("Token has no audience")
+)
+
+func parseAudiences(name, value string) []string {
+    var list []string
+    for _, aud := range strings.Split(value, ",") {
+        if aud = strings.TrimSpace(aud); aud != "" {
+            list = append(list, aud)
+        }
+    }
+    if len(list) == 0 {
+        log.Fatalf("❌ %s must name at least one audience", name)
+    }
+    return list
+}
+
+// Audience for a login token; empty asks for the default
+func loginAudience(requested string) (string, error) {
+    if requested == "" {
+        return tokenAudiences[0], nil
+    }
+    if !containsString(tokenAudiences, requested) {
+        return "", errUnknownAudience
+    }
+    return requested, nil
+}
+
+// Audience for an exchanged token, which must be one the caller may request
+func exchangeAudience(caller *Principal, requested string) (string, error) {
+    if !containsString(exchangeAudiences.lookup(caller.Subject), requested) {
+        return "", autherr.ErrForbidden.WithMessage("Exchange for audience " + requested + " is not allowed")
+    }
+    return requested, nil
+}
+
+// Audiences whose tokens the caller may validate
+func acceptedAudiences(caller *Principal) []string {
+    if caller == nil {
+        return nil
+    }
+    if auds := serviceAudiences.lookup(caller.Subject); auds != nil {
+        return auds
+    }
+    return []string{caller.Subject}
+}
+
+// Check a token's audience against the service validating it. Tokens issued
+// before audiences existed carry none and pass until audience_enforcement
+// is on.
+func checkAudience(claims *Claims, caller *Principal) error {
+    return checkAudienceIn(claims, acceptedAudiences(caller))
+}
+
+func checkAudienceIn(claims *Claims, accepted []string) error {
+    if claims.Audience == "" {
+        if flags.Enabled("audience_enforcement") {
+            return errNoAudience
+        }
+        return nil
+    }
+    if !containsString(accepted, claims.Audience) {
+        return errWrongAudience
+    }
+    return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/base64"
+    "encoding/hex"
+    "log"
+    "net"
+    "net/http"
+    "strings"
+
+    "auth-service/internal/autherr"
+)
+
+// Token binding, so a token copied off one client is useless from another.
+// TOKEN_BINDING lists what tokens are bound to when issued: "cert" puts the
+// SHA-256 thumbprint of the client's mTLS certificate in the cnf claim
+// (RFC 8705), "ip" puts the client's network in the cidr claim, sized by
+// TOKEN_BINDING_IPV4_PREFIX and TOKEN_BINDING_IPV6_PREFIX. A client without
+// a certificate gets no cnf claim. Bound tokens are held to their binding
+// wherever they are presented, whatever TOKEN_BINDING says now.
+//
+// A token sent to us directly is matched against the connection, or behind
+// a trusted proxy against X-Forwarded-For and the Hash in Envoy's
+// X-Forwarded-Client-Cert. A service asking /validate about its own
+// client's token passes that client's details in X-Client-IP and
+// X-Client-Cert-Thumbprint; a bound token validated without them fails.
+var (
+    tokenBindCert, tokenBindIP = parseTokenBinding(getEnv("TOKEN_BINDING", ""))
+    tokenBindIPv4Prefix        = getEnvInt("TOKEN_BINDING_IPV4_PREFIX", 32)
+    tokenBindIPv6Prefix        = getEnvInt("TOKEN_BINDING_IPV6_PREFIX", 64)
+)
+
+var (
+    errCertBindingMismatch = autherr.ErrInvalidToken.WithMessage("Token is bound to a different client certificate")
+    errIPBindingMismatch   = autherr.ErrInvalidToken.WithMessage("Token is bound to a different client network")
+)
+
+// Confirmation is the cnf claim: the certificate the token is bound to
+type Confirmation struct {
+    X5tS256 string `json:"x5t#S256,omitempty"`
+}
+
+func parseTokenBinding(value string) (cert, ip bool) {
+    for _, mode := range strings.Split(value, ",") {
+        switch strings.TrimSpace(mode) {
+        case "":
+        case "cert":
+            cert = true
+        case "ip":
+            ip = true
+        default:
+            log.Fatalf("❌ Invalid TOKEN_BINDING %q; expected cert, ip or both", value)
+        }
+    }
+    return cert, ip
+}
+
+// tokenPresenter is the client a token came from, as far as we can tell
+type tokenPresenter struct {
+    thumbprint string // x5t#S256 of its certificate, "" without one
+    ip         net.IP
+}
+
+// The client that sent r. Behind a trusted proxy the connection's own
+// certificate is the proxy's, so the client's comes from the XFCC header.
+func requestPresenter(r *http.Request) tokenPresenter {
+    p := tokenPresenter{ip: clientIP(r)}
+    if fromTrustedProxy(r) {
+        p.thumbprint = xfccThumbprint(r.Header.Get("X-Forwarded-Client-Cert"))
+    } else if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+        p.thumbprint = certThumbprint(r.TLS.PeerCertificates[0].Raw)
+    }
+    return p
+}
+
+// The client a calling service is validating a token for
+func forwardedPresenter(r *http.Request) tokenPresenter {
+    return tokenPresenter{
+        thumbprint: r.Header.Get("X-Client-Cert-Thumbprint"),
+        ip:         net.ParseIP(strings.TrimSpace(r.Header.Get("X-Client-IP"))),
+    }
+}
+
+// Whether r came straight from a trusted proxy, whose forwarding headers
+// describe the real client
+func fromTrustedProxy(r *http.Request) bool {
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        host = r.RemoteAddr
+    }
+    peer := net.ParseIP(host)
+    return peer != nil && ipInNets(peer, trustedProxies)
+}
+
+func certThumbprint(der []byte) string {
+    sum := sha256.Sum256(der)
+    return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// The Hash of the first (original client's) element of an
+// X-Forwarded-Client-Cert header, as a thumbprint
+func xfccThumbprint(xfcc string) string {
+    first, _, _ := strings.Cut(xfcc, ",")
+    for _, pair := range strings.Split(first, ";") {
+        key, value, _ := strings.Cut(pair, "=")
+        if strings.EqualFold(strings.TrimSpace(key), "Hash") {
+            sum, err := hex.DecodeString(strings.Trim(strings.TrimSpace(value), `"`))
+            if err != nil || len(sum) != sha256.Size {
+                return ""
+            }
+            return base64.RawURLEncoding.EncodeToString(sum)
+        }
+    }
+    return ""
+}
+
+// Bind claims about to be issued to the client that sent r, as
+// TOKEN_BINDING asks
+func bindToken(r *http.Request, claims *Claims) {
+    p := requestPresenter(r)
+    if tokenBindCert && p.thumbprint != "" {
+        claims.Confirmation = &Confirmation{X5tS256: p.thumbprint}
+    }
+    if tokenBindIP && p.ip != nil {
+        prefix, bits := tokenBindIPv6Prefix, 128
+        ip := p.ip
+        if v4 := ip.To4(); v4 != nil {
+            prefix, bits, ip = tokenBindIPv4Prefix, 32, v4
+        }
+        network := &net.IPNet{IP: ip.Mask(net.CIDRMask(prefix, bits)), Mask: net.CIDRMask(prefix, bits)}
+        claims.BoundCIDR = network.String()
+    }
+}
+
+// Check a token's binding against the client that presented it
+func checkBinding(claims *Claims, p tokenPresenter) error {
+    if claims.Confirmation != nil && claims.Confirmation.X5tS256 != "" {
+        if subtle.ConstantTimeCompare([]byte(claims.Confirmation.X5tS256), []byte(p.thumbprint)) != 1 {
+            return errCertBindingMismatch
+        }
+    }
+    if claims.BoundCIDR != "" {
+        _, network, err := net.ParseCIDR(claims.BoundCIDR)
+        if err != nil || p.ip == nil || !network.Contains(p.ip) {
+            return errIPBindingMismatch
+        }
+    }
+    return nil
+}
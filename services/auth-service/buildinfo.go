@@ -0,0 +1,102 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "runtime/debug"
+
+    "auth-service/internal/autherr"
+)
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%FT%TZ)"
+//
+// (the Dockerfile takes them as build args). Anything left unset is filled
+// from the VCS stamp Go embeds when building inside a checkout.
+var (
+    version   string
+    commit    string
+    buildTime string
+)
+
+// BuildInfo identifies the running binary
+type BuildInfo struct {
+    Version   string `json:"version"`
+    Commit    string `json:"commit"`
+    BuildTime string `json:"buildTime,omitempty"`
+    Modified  bool   `json:"modified,omitempty"` // built from a dirty checkout
+    GoVersion string `json:"goVersion"`
+}
+
+var build = loadBuildInfo()
+
+func loadBuildInfo() BuildInfo {
+    b := BuildInfo{Version: version, Commit: commit, BuildTime: buildTime}
+    if info, ok := debug.ReadBuildInfo(); ok {
+        b.GoVersion = info.GoVersion
+        if b.Version == "" && info.Main.Version != "(devel)" {
+            b.Version = info.Main.Version
+        }
+        for _, s := range info.Settings {
+            switch s.Key {
+            case "vcs.revision":
+                if b.Commit == "" {
+                    b.Commit = s.Value
+                }
+            case "vcs.time":
+                if b.BuildTime == "" {
+                    b.BuildTime = s.Value
+                }
+            case "vcs.modified":
+                b.Modified = s.Value == "true" && commit == ""
+            }
+        }
+    }
+    if b.Version == "" {
+        b.Version = "dev"
+    }
+    if b.Commit == "" {
+        b.Commit = "unknown"
+    }
+
+    // Every log line says which build wrote it
+    log.SetFlags(log.LstdFlags | log.Lmsgprefix)
+    log.SetPrefix(fmt.Sprintf("[%s %s] ", b.Version, b.shortCommit()))
+    return b
+}
+
+func (b BuildInfo) shortCommit() string {
+    if len(b.Commit) > 12 {
+        return b.Commit[:12]
+    }
+    return b.Commit
+}
+
+// Startup banner: the build, then which credentials were found
+func logStartupBanner() {
+    log.Printf("🔐 Auth Service %s (commit %s, built %s, %s)", build.Version, build.shortCommit(), build.BuildTime, build.GoVersion)
+    log.Printf("  JWT Secret: %v", jwtSecret != "")
+    log.Printf("  Internal API Key: %v", internalAPIKey != "")
+    log.Printf("  Auth Service Token: %v", authServiceToken != "")
+    log.Printf("  Database Credentials: %v", dbUser != "" && dbPassword != "")
+}
+
+// Build info endpoint
+func buildInfoHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet && r.Method != http.MethodHead {
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(build)
+}
+
+func writeBuildMetrics(w io.Writer) {
+    fmt.Fprintf(w, "# HELP auth_build_info Build of the running binary\n")
+    fmt.Fprintf(w, "# TYPE auth_build_info gauge\n")
+    fmt.Fprintf(w, "auth_build_info{version=%q,commit=%q,goversion=%q} 1\n", build.Version, build.Commit, build.GoVersion)
+}
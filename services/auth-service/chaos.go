@@ -0,0 +1,278 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "math/rand"
+    "net/http"
+    "runtime/debug"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Fault injection for resilience demos: added latency and random errors on
+// API routes, held memory for HPA and OOM demos, and a failing readiness
+// probe to watch Kubernetes pull the pod from its Service. Faults apply to
+// this pod only and lift by themselves after the requested duration. The
+// endpoint doesn't exist unless CHAOS_ENABLED=true.
+var (
+    chaosEnabled     = getEnv("CHAOS_ENABLED", "false") == "true"
+    chaosMaxDuration = getEnvDuration("CHAOS_MAX_DURATION", 30*time.Minute)
+    chaosMaxMemoryMB = getEnvInt("CHAOS_MAX_MEMORY_MB", 512)
+    chaos            = &chaosEngine{}
+)
+
+var errInjected = autherr.ErrInternal.WithMessage("Injected fault")
+
+// ChaosState is the set of faults in force
+type ChaosState struct {
+    Latency       time.Duration `json:"-"`
+    Jitter        time.Duration `json:"-"`
+    ErrorRate     float64       `json:"errorRate,omitempty"`
+    MemoryMB      int           `json:"memoryMB,omitempty"`
+    FailReadiness bool          `json:"failReadiness,omitempty"`
+    Routes        []string      `json:"routes,omitempty"` // path or "prefix*"; empty means every API route
+    Until         time.Time     `json:"until"`
+}
+
+type chaosEngine struct {
+    current  atomic.Pointer[ChaosState]
+    injected atomic.Int64
+
+    mu      sync.Mutex
+    ballast [][]byte
+    timer   *time.Timer
+}
+
+// Probes, metrics and the chaos endpoint itself are never faulted, so the
+// experiment stays observable and can always be stopped
+var chaosExempt = map[string]bool{
+    "/health":      true,
+    "/readyz":      true,
+    "/metrics":     true,
+    "/admin/chaos": true,
+}
+
+// The faults in force, nil when none are
+func (c *chaosEngine) active() *ChaosState {
+    s := c.current.Load()
+    if s == nil || time.Now().After(s.Until) {
+        return nil
+    }
+    return s
+}
+
+func (c *chaosEngine) start(s *ChaosState) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.stopLocked()
+    for i := 0; i < s.MemoryMB; i++ {
+        // Touch every page so the memory is resident, not just reserved
+        chunk := make([]byte, 1<<20)
+        for j := 0; j < len(chunk); j += 4096 {
+            chunk[j] = 1
+        }
+        c.ballast = append(c.ballast, chunk)
+    }
+    c.current.Store(s)
+    c.timer = time.AfterFunc(time.Until(s.Until), func() {
+        c.mu.Lock()
+        defer c.mu.Unlock()
+        // A replacement experiment may have started as the timer fired
+        if c.current.Load() != s {
+            return
+        }
+        c.stopLocked()
+        log.Printf("🐒 Chaos experiment ended")
+        recordSystemAudit("chaos.ended", nil)
+    })
+    log.Printf("🐒 Chaos experiment until %s: latency %s±%s, error rate %.2f, %d MB held, readiness failing %v",
+        s.Until.Format(time.RFC3339), s.Latency, s.Jitter, s.ErrorRate, s.MemoryMB, s.FailReadiness)
+}
+
+func (c *chaosEngine) stop() {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.stopLocked()
+}
+
+func (c *chaosEngine) stopLocked() {
+    if c.timer != nil {
+        c.timer.Stop()
+        c.timer = nil
+    }
+    c.current.Store(nil)
+    if c.ballast != nil {
+        c.ballast = nil
+        debug.FreeOSMemory()
+    }
+}
+
+func (s *ChaosState) appliesTo(path string) bool {
+    if chaosExempt[path] {
+        return false
+    }
+    if len(s.Routes) == 0 {
+        return true
+    }
+    for _, route := range s.Routes {
+        if prefix, ok := strings.CutSuffix(route, "*"); path == route || (ok && strings.HasPrefix(path, prefix)) {
+            return true
+        }
+    }
+    return false
+}
+
+func chaosMiddleware(next http.Handler) http.Handler {
+    if !chaosEnabled {
+        return next
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        s := chaos.active()
+        if s == nil || !s.appliesTo(r.URL.Path) {
+            next.ServeHTTP(w, r)
+            return
+        }
+        if delay := s.Latency; delay > 0 || s.Jitter > 0 {
+            if s.Jitter > 0 {
+                delay += time.Duration(rand.Int63n(int64(2*s.Jitter))) - s.Jitter
+            }
+            select {
+            case <-time.After(delay):
+            case <-r.Context().Done():
+                return
+            }
+        }
+        if s.ErrorRate > 0 && rand.Float64() < s.ErrorRate {
+            chaos.injected.Add(1)
+            autherr.Write(w, errInjected)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// Readiness failure forced by an experiment, for readyzHandler
+func chaosNotReady() bool {
+    s := chaos.active()
+    return s != nil && s.FailReadiness
+}
+
+// Chaos endpoint: GET shows the experiment in force, POST
+// {"latency": "300ms", "jitter": "100ms", "errorRate": 0.2, "memoryMB": 256,
+// "failReadiness": true, "routes": ["/login"], "duration": "5m"} starts one
+// (replacing any running), DELETE ends it early
+func chaosHandler(w http.ResponseWriter, r *http.Request) {
+    if err := requireAdmin(r); err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    actor := "unknown"
+    if p := principalFromContext(r.Context()); p != nil {
+        actor = p.Subject
+    }
+    switch r.Method {
+    case http.MethodGet:
+    case http.MethodPost:
+        var req struct {
+            Latency       string   `json:"latency"`
+            Jitter        string   `json:"jitter"`
+            ErrorRate     float64  `json:"errorRate"`
+            MemoryMB      int      `json:"memoryMB"`
+            FailReadiness bool     `json:"failReadiness"`
+            Routes        []string `json:"routes"`
+            Duration      string   `json:"duration"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            autherr.Write(w, autherr.ErrInvalidRequest)
+            return
+        }
+        s := &ChaosState{ErrorRate: req.ErrorRate, MemoryMB: req.MemoryMB, FailReadiness: req.FailReadiness, Routes: req.Routes}
+        var err error
+        if s.Latency, err = parseChaosDuration(req.Latency); err != nil {
+            autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("latency must be a duration such as 300ms"))
+            return
+        }
+        if s.Jitter, err = parseChaosDuration(req.Jitter); err != nil {
+            autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("jitter must be a duration such as 100ms"))
+            return
+        }
+        duration, err := time.ParseDuration(req.Duration)
+        if err != nil || duration <= 0 || duration > chaosMaxDuration {
+            autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("duration must be a duration up to "+chaosMaxDuration.String()))
+            return
+        }
+        if s.ErrorRate < 0 || s.ErrorRate > 1 {
+            autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("errorRate must be between 0 and 1"))
+            return
+        }
+        if s.MemoryMB < 0 || s.MemoryMB > chaosMaxMemoryMB {
+            autherr.Write(w, autherr.ErrInvalidRequest.WithMessage(fmt.Sprintf("memoryMB must be between 0 and %d", chaosMaxMemoryMB)))
+            return
+        }
+        s.Until = time.Now().Add(duration)
+        chaos.start(s)
+        recordAudit(r, "chaos.started", actor, map[string]string{
+            "latency":       s.Latency.String(),
+            "jitter":        s.Jitter.String(),
+            "errorRate":     fmt.Sprint(s.ErrorRate),
+            "memoryMB":      fmt.Sprint(s.MemoryMB),
+            "failReadiness": fmt.Sprint(s.FailReadiness),
+            "routes":        strings.Join(s.Routes, ","),
+            "until":         s.Until.Format(time.RFC3339),
+        })
+    case http.MethodDelete:
+        if chaos.active() != nil {
+            chaos.stop()
+            log.Printf("🐒 Chaos experiment stopped by %s", actor)
+            recordAudit(r, "chaos.stopped", actor, nil)
+        }
+    default:
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+
+    response := map[string]interface{}{"active": false, "injectedErrors": chaos.injected.Load()}
+    if s := chaos.active(); s != nil {
+        response["active"] = true
+        response["experiment"] = s
+        response["latency"] = s.Latency.String()
+        response["jitter"] = s.Jitter.String()
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    json.NewEncoder(w).Encode(response)
+}
+
+func parseChaosDuration(value string) (time.Duration, error) {
+    if value == "" {
+        return 0, nil
+    }
+    d, err := time.ParseDuration(value)
+    if err == nil && d < 0 {
+        err = fmt.Errorf("negative duration %s", value)
+    }
+    return d, err
+}
+
+func writeChaosMetrics(w io.Writer) {
+    if !chaosEnabled {
+        return
+    }
+    active := 0
+    if chaos.active() != nil {
+        active = 1
+    }
+    fmt.Fprintf(w, "# HELP auth_chaos_active Whether a chaos experiment is running on this pod\n")
+    fmt.Fprintf(w, "# TYPE auth_chaos_active gauge\n")
+    fmt.Fprintf(w, "auth_chaos_active %d\n", active)
+    fmt.Fprintf(w, "# HELP auth_chaos_injected_errors_total Requests failed by a chaos experiment\n")
+    fmt.Fprintf(w, "# TYPE auth_chaos_injected_errors_total counter\n")
+    fmt.Fprintf(w, "auth_chaos_injected_errors_total %d\n", chaos.injected.Load())
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "time"
+)
+
+// `auth-service check` (or --check) verifies that the service could start and
+// serve: secrets are present, configuration files parse, the database answers
+// and every downstream in CHECK_ENDPOINTS is reachable. It prints a JSON
+// report and exits 1 if anything failed, so it can run as an init container
+// or a Helm test hook ahead of the real pod.
+var (
+    checkTimeout   = getEnvDuration("CHECK_TIMEOUT", 5*time.Second)
+    checkEndpoints = os.Getenv("CHECK_ENDPOINTS") // comma-separated URLs or host:port
+)
+
+// CheckResult is one line of the report
+type CheckResult struct {
+    Name       string `json:"name"`
+    OK         bool   `json:"ok"`
+    Detail     string `json:"detail,omitempty"`
+    DurationMs int64  `json:"durationMs"`
+}
+
+func runCheckCommand() int {
+    ctx := context.Background()
+    migrateOnStartup = false
+
+    var results []CheckResult
+    run := func(name string, fn func(ctx context.Context) (string, error)) {
+        ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+        defer cancel()
+        start := time.Now()
+        detail, err := fn(ctx)
+        result := CheckResult{Name: name, OK: err == nil, Detail: detail, DurationMs: time.Since(start).Milliseconds()}
+        if err != nil {
+            result.Detail = err.Error()
+        }
+        results = append(results, result)
+    }
+
+    run("secrets", checkSecrets)
+    run("policy", func(context.Context) (string, error) {
+        file, _, _ := policies.source()
+        return file, policies.reload()
+    })
+    run("flags", func(context.Context) (string, error) {
+        file, _, _ := flags.source()
+        return file, flags.reload()
+    })
+    run("storage", checkStorage)
+    if spiffeEnabled {
+        run("spiffe", func(context.Context) (string, error) {
+            return "", spiffe.load()
+        })
+    }
+    if smtpHost != "" {
+        run("smtp", func(ctx context.Context) (string, error) {
+            return dialCheck(ctx, net.JoinHostPort(smtpHost, smtpPort))
+        })
+    }
+    for _, target := range strings.Split(checkEndpoints, ",") {
+        if target = strings.TrimSpace(target); target != "" {
+            run("downstream "+target, func(ctx context.Context) (string, error) {
+                return reachCheck(ctx, target)
+            })
+        }
+    }
+
+    ok := true
+    for _, r := range results {
+        ok = ok && r.OK
+    }
+    out, _ := json.MarshalIndent(map[string]interface{}{"ok": ok, "checks": results}, "", "  ")
+    fmt.Println(string(out))
+    if !ok {
+        return 1
+    }
+    return 0
+}
+
+func checkSecrets(context.Context) (string, error) {
+    if err := secrets.load(); err != nil {
+        return "", err
+    }
+    set := secrets.get()
+    var missing []string
+    for name, value := range map[string]string{
+        "jwt-secret":         set.jwtSecret,
+        "internal-api-key":   set.internalAPIKey,
+        "auth-service-token": set.authServiceToken,
+    } {
+        if value == "" {
+            missing = append(missing, name)
+        }
+    }
+    if len(missing) > 0 {
+        return "", fmt.Errorf("missing %s", strings.Join(missing, ", "))
+    }
+    return fmt.Sprintf("%d tenant signing key(s)", len(set.signingKeys)), nil
+}
+
+func checkStorage(ctx context.Context) (string, error) {
+    var err error
+    if store, err = openStorage(ctx); err != nil {
+        return "", err
+    }
+    defer store.Close()
+    if err := store.Ping(ctx); err != nil {
+        return "", err
+    }
+    return storageDriver, nil
+}
+
+// Downstreams given as URLs must answer below 500; bare host:port only needs
+// to accept a connection
+func reachCheck(ctx context.Context, target string) (string, error) {
+    u, err := url.Parse(target)
+    if err != nil || u.Host == "" {
+        return dialCheck(ctx, target)
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+    if err != nil {
+        return "", err
+    }
+    resp, err := http.DefaultClient.Do(req)
+    if err != nil {
+        return "", err
+    }
+    resp.Body.Close()
+    if resp.StatusCode >= 500 {
+        return "", fmt.Errorf("HTTP %d", resp.StatusCode)
+    }
+    return fmt.Sprintf("HTTP %d", resp.StatusCode), nil
+}
+
+func dialCheck(ctx context.Context, addr string) (string, error) {
+    conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+    if err != nil {
+        return "", err
+    }
+    conn.Close()
+    return "connected to " + addr, nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+    "errors"
+    "strings"
+    "unicode/utf8"
+)
+
+// errClaimsFallback tells parseToken to retry with encoding/json
+var errClaimsFallback = errors.New("claims need full JSON decoding")
+
+// Delegation chains deeper than this are left to encoding/json
+const maxActorDepth = 16
+
+var (
+    claimKeys        = []string{"jti", "sub", "email", "roles", "scopes", "aud", "tid", "act", "iat", "nbf", "exp", "impersonated_by", "cnf", "cidr"}
+    actorKeys        = []string{"sub", "act"}
+    confirmationKeys = []string{"x5t#S256"}
+)
+
+// encoding/json matches keys case-insensitively, so a key that only differs
+// in case from one decoded here is left to it
+func foldsToKey(key string, keys []string) bool {
+    for _, k := range keys {
+        if strings.EqualFold(key, k) {
+            return true
+        }
+    }
+    return false
+}
+
+// decodeClaims is a minimal JSON decoder for the exact shape signToken emits.
+// All string fields are substrings of s, so decoding costs one allocation per
+// string slice instead of one per field. Escaped strings, floats and anything
+// unexpected return errClaimsFallback.
+func decodeClaims(s string, c *Claims) error {
+    d := claimScanner{s: s}
+    err := d.object(func(key string) error {
+        var err error
+        switch key {
+        case "jti":
+            c.ID, err = d.str()
+        case "sub":
+            c.Subject, err = d.str()
+        case "email":
+            c.Email, err = d.str()
+        case "roles":
+            c.Roles, err = d.strs()
+        case "scopes":
+            c.Scopes, err = d.strs()
+        case "aud":
+            c.Audience, err = d.str()
+        case "tid":
+            c.Tenant, err = d.str()
+        case "act":
+            // A repeated object is merged by encoding/json
+            if c.Actor != nil {
+                return errClaimsFallback
+            }
+            c.Actor, err = d.actor(0)
+        case "iat":
+            c.IssuedAt, err = d.int()
+        case "nbf":
+            c.NotBefore, err = d.int()
+        case "exp":
+            c.ExpiresAt, err = d.int()
+        case "impersonated_by":
+            c.ImpersonatedBy, err = d.str()
+        case "cnf":
+            if c.Confirmation != nil {
+                return errClaimsFallback
+            }
+            c.Confirmation, err = d.confirmation()
+        case "cidr":
+            c.BoundCIDR, err = d.str()
+        default:
+            if foldsToKey(key, claimKeys) {
+                return errClaimsFallback
+            }
+            err = d.skip()
+        }
+        return err
+    })
+    if err != nil {
+        return err
+    }
+    d.ws()
+    if d.i != len(d.s) {
+        return errClaimsFallback
+    }
+    return nil
+}
+
+type claimScanner struct {
+    s string
+    i int
+}
+
+func (d *claimScanner) ws() {
+    for d.i < len(d.s) {
+        switch d.s[d.i] {
+        case ' ', '\t', '\n', '\r':
+            d.i++
+        default:
+            return
+        }
+    }
+}
+
+func (d *claimScanner) consume(c byte) bool {
+    d.ws()
+    if d.i < len(d.s) && d.s[d.i] == c {
+        d.i++
+        return true
+    }
+    return false
+}
+
+func (d *claimScanner) str() (string, error) {
+    if !d.consume('"') {
+        return "", errClaimsFallback
+    }
+    start := d.i
+    for j := start; j < len(d.s); j++ {
+        switch c := d.s[j]; {
+        case c == '"':
+            // encoding/json replaces invalid UTF-8
+            if !utf8.ValidString(d.s[start:j]) {
+                return "", errClaimsFallback
+            }
+            d.i = j + 1
+            return d.s[start:j], nil
+        case c == '\\' || c < 0x20:
+            return "", errClaimsFallback
+        }
+    }
+    return "", errClaimsFallback
+}
+
+func (d *claimScanner) int() (int64, error) {
+    d.ws()
+    neg := d.consume('-')
+    start := d.i
+    var n int64
+    for d.i < len(d.s) && d.s[d.i] >= '0' && d.s[d.i] <= '9' {
+        if n > (1<<63-1)/10-1 {
+            return 0, errClaimsFallback
+        }
+        n = n*10 + int64(d.s[d.i]-'0')
+        d.i++
+    }
+    // JSON allows no leading zeros
+    if d.i == start || (d.s[start] == '0' && d.i-start > 1) {
+        return 0, errClaimsFallback
+    }
+    if d.i < len(d.s) {
+        switch d.s[d.i] {
+        case '.', 'e', 'E':
+            return 0, errClaimsFallback
+        }
+    }
+    if neg {
+        n = -n
+    }
+    return n, nil
+}
+
+func (d *claimScanner) strs() ([]string, error) {
+    if !d.consume('[') {
+        return nil, errClaimsFallback
+    }
+    list := []string{}
+    if d.consume(']') {
+        return list, nil
+    }
+    for {
+        s, err := d.str()
+        if err != nil {
+            return nil, err
+        }
+        list = append(list, s)
+        if d.consume(']') {
+            return list, nil
+        }
+        if !d.consume(',') {
+            return nil, errClaimsFallback
+        }
+    }
+}
+
+func (d *claimScanner) actor(depth int) (*Actor, error) {
+    if depth >= maxActorDepth {
+        return nil, errClaimsFallback
+    }
+    if d.literal("null") {
+        return nil, nil
+    }
+    a := &Actor{}
+    err := d.object(func(key string) error {
+        var err error
+        switch key {
+        case "sub":
+            a.Subject, err = d.str()
+        case "act":
+            if a.Actor != nil {
+                return errClaimsFallback
+            }
+            a.Actor, err = d.actor(depth + 1)
+        default:
+            if foldsToKey(key, actorKeys) {
+                return errClaimsFallback
+            }
+            err = d.skip()
+        }
+        return err
+    })
+    return a, err
+}
+
+// Parse an object, calling field with the scanner positioned at each value
+func (d *claimScanner) object(field func(key string) error) error {
+    if !d.consume('{') {
+        return errClaimsFallback
+    }
+    if d.consume('}') {
+        return nil
+    }
+    for {
+        key, err := d.str()
+        if err != nil {
+            return err
+        }
+        if !d.consume(':') {
+            return errClaimsFallback
+        }
+        if err := field(key); err != nil {
+            return err
+        }
+        if d.consume('}') {
+            return nil
+        }
+        if !d.consume(',') {
+            return errClaimsFallback
+        }
+    }
+}
+
+func (d *claimScanner) literal(lit string) bool {
+    d.ws()
+    if len(d.s)-d.i >= len(lit) && d.s[d.i:d.i+len(lit)] == lit {
+        d.i += len(lit)
+        return true
+    }
+    return false
+}
+
+// Skip a value of a claim we don't decode. Only scalars and flat string
+// arrays are skipped here; nested structures go to the fallback.
+func (d *claimScanner) skip() error {
+    d.ws()
+    if d.i >= len(d.s) {
+        return errClaimsFallback
+    }
+    switch c := d.s[d.i]; {
+    case c == '"':
+        _, err := d.str()
+        return err
+    case c == '[':
+        _, err := d.strs()
+        return err
+    case c == '-' || (c >= '0' && c <= '9'):
+        _, err := d.int()
+        return err
+    case d.literal("true"), d.literal("false"), d.literal("null"):
+        return nil
+    }
+    return errClaimsFallback
+}
+
+func (d *claimScanner) confirmation() (*Confirmation, error) {
+    if d.literal("null") {
+        return nil, nil
+    }
+    cnf := &Confirmation{}
+    err := d.object(func(key string) error {
+        var err error
+        switch key {
+        case "x5t#S256":
+            cnf.X5tS256, err = d.str()
+        default:
+            if foldsToKey(key, confirmationKeys) {
+                return errClaimsFallback
+            }
+            err = d.skip()
+        }
+        return err
+    })
+    return cnf, err
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+    "log"
+    "net"
+    "net/http"
+    "os"
+    "strings"
+
+    "auth-service/internal/autherr"
+)
+
+// ipFilter holds CIDR allow and deny lists. Deny entries always win; an empty
+// allow list permits every address that isn't denied.
+type ipFilter struct {
+    allow []*net.IPNet
+    deny  []*net.IPNet
+}
+
+var (
+    trustedProxies = mustParseCIDRs("TRUSTED_PROXIES")
+    adminIPFilter  = &ipFilter{
+        allow: mustParseCIDRs("ADMIN_ALLOWLIST"),
+        deny:  mustParseCIDRs("ADMIN_DENYLIST"),
+    }
+    tokenIPFilter = &ipFilter{
+        allow: mustParseCIDRs("TOKEN_ALLOWLIST"),
+        deny:  mustParseCIDRs("TOKEN_DENYLIST"),
+    }
+)
+
+// Parse a comma-separated CIDR list from the environment. Bare addresses are
+// accepted as single-host networks. A typo here would silently open or close
+// access, so invalid entries abort startup.
+func mustParseCIDRs(key string) []*net.IPNet {
+    nets, err := parseCIDRList(os.Getenv(key))
+    if err != nil {
+        log.Fatalf("❌ Invalid %s: %v", key, err)
+    }
+    return nets
+}
+
+func parseCIDRList(value string) ([]*net.IPNet, error) {
+    var nets []*net.IPNet
+    for _, entry := range strings.Split(value, ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+        if !strings.Contains(entry, "/") {
+            if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+                entry += "/32"
+            } else {
+                entry += "/128"
+            }
+        }
+        _, n, err := net.ParseCIDR(entry)
+        if err != nil {
+            return nil, err
+        }
+        nets = append(nets, n)
+    }
+    return nets, nil
+}
+
+func (f *ipFilter) permits(ip net.IP) bool {
+    if ip == nil {
+        return len(f.allow) == 0 && len(f.deny) == 0
+    }
+    if ipInNets(ip, f.deny) {
+        return false
+    }
+    return len(f.allow) == 0 || ipInNets(ip, f.allow)
+}
+
+// Wrap a handler so only addresses permitted by the filter reach it
+func restrictIPs(f *ipFilter, next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        ip := clientIP(r)
+        if !f.permits(ip) {
+            log.Printf("🚫 Blocked %s %s from %v", r.Method, r.URL.Path, ip)
+            autherr.Write(w, autherr.ErrForbidden.WithMessage("Source address not permitted"))
+            return
+        }
+        next(w, r)
+    }
+}
+
+// Resolve the real client address. Forwarding headers are only honoured when
+// the direct peer is a trusted proxy (e.g. the ingress controller); the
+// X-Forwarded-For chain is then walked right to left, skipping further
+// trusted hops, so a client can't spoof its address by prepending entries.
+// A client can also send its own X-Forwarded-For header that the proxy adds
+// another after rather than appending to, so all of them are read as one
+// chain.
+func clientIP(r *http.Request) net.IP {
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        host = r.RemoteAddr
+    }
+    peer := net.ParseIP(host)
+    if peer == nil || !ipInNets(peer, trustedProxies) {
+        return peer
+    }
+
+    if xff := strings.Join(r.Header.Values("X-Forwarded-For"), ","); xff != "" {
+        hops := strings.Split(xff, ",")
+        for i := len(hops) - 1; i >= 0; i-- {
+            ip := net.ParseIP(strings.TrimSpace(hops[i]))
+            if ip == nil {
+                break
+            }
+            if !ipInNets(ip, trustedProxies) || i == 0 {
+                return ip
+            }
+        }
+    }
+    if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+        return ip
+    }
+    return peer
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+    "time"
+)
+
+// Clock is the time source for issuing and validating tokens. Tests swap in
+// a fixed clock; production uses the system time.
+type Clock interface {
+    Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// fixedClock always reports the same instant
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time { return time.Time(c) }
+
+var (
+    clock Clock = systemClock{}
+
+    // Minikube VMs and laptop hosts drift apart, so a token minted by one
+    // pod is accepted by another whose clock is slightly behind or ahead
+    tokenLeeway = getEnvDuration("TOKEN_CLOCK_LEEWAY", 30*time.Second)
+)
@@ -0,0 +1,103 @@
+package main
+
+import (
+    "bytes"
+    "crypto/ed25519"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io"
+    "strings"
+    "time"
+)
+
+// Fetch /attest with a fresh nonce and check the statement: EdDSA signature
+// by the key in the header, that key's thumbprint against --pin, the nonce
+// echoed back and the statement not expired
+func attestVerify(args []string) error {
+    fs := flag.NewFlagSet("attest verify", flag.ExitOnError)
+    pin := fs.String("pin", "", "expected attestation key thumbprint (kid)")
+    fs.Parse(args)
+
+    raw := make([]byte, 16)
+    rand.Read(raw)
+    nonce := hex.EncodeToString(raw)
+    base := strings.TrimSuffix(getEnv("AUTHCTL_URL", "http://localhost:8080"), "/")
+    resp, err := httpClient.Get(base + "/attest?nonce=" + nonce)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    var body struct {
+        JWS string `json:"jws"`
+    }
+    if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil || resp.StatusCode != 200 {
+        return fmt.Errorf("GET /attest: %s", resp.Status)
+    }
+
+    parts := strings.Split(body.JWS, ".")
+    if len(parts) != 3 {
+        return fmt.Errorf("malformed JWS")
+    }
+    enc := base64.RawURLEncoding
+    var header struct {
+        Alg string            `json:"alg"`
+        Kid string            `json:"kid"`
+        JWK map[string]string `json:"jwk"`
+    }
+    if err := decodeSegment(parts[0], &header); err != nil {
+        return err
+    }
+    if header.Alg != "EdDSA" || header.JWK["kty"] != "OKP" || header.JWK["crv"] != "Ed25519" {
+        return fmt.Errorf("unexpected key type %s/%s", header.Alg, header.JWK["crv"])
+    }
+    pub, err := enc.DecodeString(header.JWK["x"])
+    if err != nil || len(pub) != ed25519.PublicKeySize {
+        return fmt.Errorf("invalid public key")
+    }
+    sum := sha256.Sum256([]byte(`{"crv":"Ed25519","kty":"OKP","x":"` + header.JWK["x"] + `"}`))
+    thumbprint := enc.EncodeToString(sum[:])
+    if thumbprint != header.Kid {
+        return fmt.Errorf("kid does not match the key's thumbprint")
+    }
+    if *pin != "" && thumbprint != *pin {
+        return fmt.Errorf("attestation key %s is not the pinned %s", thumbprint, *pin)
+    }
+    sig, err := enc.DecodeString(parts[2])
+    if err != nil || !ed25519.Verify(ed25519.PublicKey(pub), []byte(parts[0]+"."+parts[1]), sig) {
+        return fmt.Errorf("signature invalid")
+    }
+
+    var statement map[string]interface{}
+    if err := decodeSegment(parts[1], &statement); err != nil {
+        return err
+    }
+    if statement["nonce"] != nonce {
+        return fmt.Errorf("nonce not echoed; statement may be replayed")
+    }
+    if exp, _ := statement["exp"].(float64); time.Now().Unix() >= int64(exp) {
+        return fmt.Errorf("statement expired")
+    }
+
+    data, _ := json.Marshal(statement)
+    var out bytes.Buffer
+    json.Indent(&out, data, "", "  ")
+    fmt.Println(out.String())
+    fmt.Printf("signature valid, key %s\n", thumbprint)
+    if *pin == "" {
+        fmt.Println("warning: no --pin given, so the key itself is not verified")
+    }
+    return nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+    data, err := base64.RawURLEncoding.DecodeString(seg)
+    if err != nil {
+        return fmt.Errorf("malformed JWS: %w", err)
+    }
+    return json.Unmarshal(data, v)
+}
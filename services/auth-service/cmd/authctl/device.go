@@ -0,0 +1,95 @@
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "time"
+)
+
+const grantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+// Sign in as a user with the device flow: show the code to approve in a
+// browser, poll until it is approved and print the token on stdout, so
+// `export AUTHCTL_TOKEN=$(authctl token login)` works
+func tokenLogin(args []string) error {
+    fs := flag.NewFlagSet("token login", flag.ExitOnError)
+    aud := fs.String("aud", "", "audience (default: the service's default)")
+    fs.Parse(args)
+
+    var grant struct {
+        DeviceCode              string `json:"device_code"`
+        UserCode                string `json:"user_code"`
+        VerificationURI         string `json:"verification_uri"`
+        VerificationURIComplete string `json:"verification_uri_complete"`
+        ExpiresIn               int    `json:"expires_in"`
+        Interval                int    `json:"interval"`
+    }
+    form := url.Values{}
+    if *aud != "" {
+        form.Set("audience", *aud)
+    }
+    if status, err := postForm("/device/code", form, &grant); err != nil {
+        return err
+    } else if status != http.StatusOK {
+        return fmt.Errorf("POST /device/code: %d", status)
+    }
+
+    fmt.Fprintf(os.Stderr, "Open %s and enter the code %s\n", grant.VerificationURI, grant.UserCode)
+    fmt.Fprintf(os.Stderr, "(or open %s)\n", grant.VerificationURIComplete)
+
+    interval := time.Duration(grant.Interval) * time.Second
+    deadline := time.Now().Add(time.Duration(grant.ExpiresIn) * time.Second)
+    form = url.Values{"grant_type": {grantTypeDeviceCode}, "device_code": {grant.DeviceCode}}
+    for time.Now().Before(deadline) {
+        time.Sleep(interval)
+        var resp struct {
+            AccessToken string `json:"access_token"`
+            Error       struct {
+                Code    string `json:"code"`
+                Message string `json:"message"`
+            } `json:"error"`
+        }
+        status, err := postForm("/device/token", form, &resp)
+        if err != nil {
+            return err
+        }
+        if status == http.StatusOK {
+            fmt.Println(resp.AccessToken)
+            return nil
+        }
+        switch resp.Error.Code {
+        case "authorization_pending":
+        case "slow_down":
+            interval += 5 * time.Second
+        default:
+            return fmt.Errorf("device login failed: %s", resp.Error.Message)
+        }
+    }
+    return fmt.Errorf("device code expired before it was approved")
+}
+
+func postForm(path string, form url.Values, out interface{}) (int, error) {
+    base := strings.TrimSuffix(getEnv("AUTHCTL_URL", "http://localhost:8080"), "/")
+    req, err := http.NewRequest(http.MethodPost, base+path, strings.NewReader(form.Encode()))
+    if err != nil {
+        return 0, err
+    }
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    if tenant := os.Getenv("AUTHCTL_TENANT"); tenant != "" {
+        req.Header.Set("X-Tenant-ID", tenant)
+    }
+    resp, err := httpClient.Do(req)
+    if err != nil {
+        return 0, err
+    }
+    defer resp.Body.Close()
+    if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+        return resp.StatusCode, fmt.Errorf("POST %s: %s", path, resp.Status)
+    }
+    return resp.StatusCode, nil
+}
@@ -0,0 +1,110 @@
+// Command authctl mints and inspects auth-service tokens offline and drives
+// the admin API. It runs from a developer laptop against the minikube
+// NodePort or inside the pod via kubectl exec.
+package main
+
+import (
+    "fmt"
+    "os"
+    "strings"
+)
+
+const usage = `authctl - auth-service token and admin tool
+
+Usage:
+  authctl token generate --sub ID [--roles a,b] [--scopes x,y] [--aud A] [--tenant T] [--ttl 1h]
+  authctl token inspect [--secret S] TOKEN|-
+  authctl token login [--aud A]
+  authctl token revoke --user ID|--client C|--all [--before TIME]
+  authctl apikey create --name N --owner O [--roles a,b] [--scopes x,y] [--daily-quota N] [--monthly-quota N]
+  authctl apikey list
+  authctl apikey revoke ID
+  authctl api METHOD PATH [JSON_BODY]
+  authctl attest verify [--pin KID]
+
+Environment:
+  JWT_SECRET          master signing secret for token generate/inspect
+  AUTHCTL_URL         service base URL (default http://localhost:8080)
+  AUTHCTL_TOKEN       bearer token for admin calls, or
+  AUTHCTL_API_KEY     API key for admin calls, or
+  AUTH_SERVICE_TOKEN and INTERNAL_API_KEY  shared service credentials
+  AUTHCTL_SIGN=true   sign requests with INTERNAL_API_KEY instead of sending it
+  AUTHCTL_TENANT      tenant to address (X-Tenant-ID)
+`
+
+func main() {
+    if len(os.Args) < 3 && !(len(os.Args) == 2 && isHelp(os.Args[1])) {
+        fmt.Fprint(os.Stderr, usage)
+        os.Exit(2)
+    }
+    if isHelp(os.Args[1]) {
+        fmt.Print(usage)
+        return
+    }
+
+    var err error
+    switch cmd, sub, args := os.Args[1], os.Args[2], os.Args[3:]; cmd {
+    case "token":
+        switch sub {
+        case "generate":
+            err = tokenGenerate(args)
+        case "inspect":
+            err = tokenInspect(args)
+        case "login":
+            err = tokenLogin(args)
+        case "revoke":
+            err = tokenRevoke(args)
+        default:
+            err = fmt.Errorf("unknown token command %q", sub)
+        }
+    case "apikey":
+        switch sub {
+        case "create":
+            err = apiKeyCreate(args)
+        case "list":
+            err = apiCall("GET", "/admin/api-keys", "")
+        case "revoke":
+            if len(args) != 1 {
+                err = fmt.Errorf("usage: authctl apikey revoke ID")
+                break
+            }
+            err = apiCall("DELETE", "/admin/api-keys/"+args[0], "")
+        default:
+            err = fmt.Errorf("unknown apikey command %q", sub)
+        }
+    case "attest":
+        if sub != "verify" {
+            err = fmt.Errorf("unknown attest command %q", sub)
+            break
+        }
+        err = attestVerify(args)
+    case "api":
+        if len(args) < 1 || len(args) > 2 {
+            err = fmt.Errorf("usage: authctl api METHOD PATH [JSON_BODY]")
+            break
+        }
+        body := ""
+        if len(args) == 2 {
+            body = args[1]
+        }
+        err = apiCall(strings.ToUpper(sub), args[0], body)
+    default:
+        err = fmt.Errorf("unknown command %q", cmd)
+    }
+
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "authctl: %v\n", err)
+        os.Exit(1)
+    }
+}
+
+func isHelp(arg string) bool {
+    return arg == "-h" || arg == "--help" || arg == "help"
+}
+
+func getEnv(key, defaultValue string) string {
+    if value := os.Getenv(key); value != "" {
+        return value
+    }
+    return defaultValue
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+    "bytes"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+// The checked-in clients must match the spec; run make sdk when this fails
+func TestGeneratedClientsUpToDate(t *testing.T) {
+    root := filepath.Join("..", "..")
+    files, err := generate(
+        filepath.Join(root, "api", "openapi.json"),
+        filepath.Join(root, "pkg", "authclient", "client_gen.go"),
+        filepath.Join(root, "..", "api-service", "authClient.js"),
+    )
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, f := range files {
+        current, err := os.ReadFile(f.path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !bytes.Equal(current, f.content) {
+            t.Errorf("%s is out of date with api/openapi.json; run make sdk", f.path)
+        }
+    }
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+    "bytes"
+    "compress/gzip"
+    "crypto/sha256"
+    "encoding/hex"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// Bodies smaller than this go out uncompressed; gzip's framing outweighs the
+// savings on the small JSON replies most endpoints return
+var compressMinBytes = getEnvInt("COMPRESS_MIN_BYTES", 512)
+
+var gzipWriters = sync.Pool{
+    New: func() interface{} {
+        gz, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+        return gz
+    },
+}
+
+// Gzip responses for clients that accept it. zstd would compress the
+// dashboard's JSON a little better but needs a third-party encoder, and
+// every client polling us already speaks gzip.
+func compressResponses(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Add("Vary", "Accept-Encoding")
+        if r.Method == http.MethodHead || !acceptsEncoding(r.Header.Get("Accept-Encoding"), "gzip") {
+            next.ServeHTTP(w, r)
+            return
+        }
+        cw := &compressWriter{ResponseWriter: w, status: http.StatusOK}
+        defer cw.close()
+        next.ServeHTTP(cw, r)
+    })
+}
+
+// Report whether an Accept-Encoding header allows coding, either by name or
+// through a wildcard, with a non-zero q
+func acceptsEncoding(header, coding string) bool {
+    wildcard := false
+    for _, part := range strings.Split(header, ",") {
+        name, params, _ := strings.Cut(part, ";")
+        name = strings.TrimSpace(name)
+        accepted := true
+        if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+            if q, err := strconv.ParseFloat(v, 64); err == nil && q == 0 {
+                accepted = false
+            }
+        }
+        switch {
+        case strings.EqualFold(name, coding):
+            return accepted
+        case name == "*":
+            wildcard = accepted
+        }
+    }
+    return wildcard
+}
+
+// compressWriter holds back the first compressMinBytes of the body so it can
+// decide whether compressing is worthwhile before the headers go out
+type compressWriter struct {
+    http.ResponseWriter
+    status      int
+    wroteHeader bool
+    buf         []byte
+    gz          *gzip.Writer
+    passthrough bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+    if cw.wroteHeader {
+        return
+    }
+    cw.wroteHeader = true
+    cw.status = status
+    h := cw.Header()
+    if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified ||
+        h.Get("Content-Encoding") != "" || h.Get("Content-Type") == "text/event-stream" {
+        cw.start(false)
+    }
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+    if !cw.wroteHeader {
+        cw.WriteHeader(http.StatusOK)
+    }
+    switch {
+    case cw.gz != nil:
+        return cw.gz.Write(p)
+    case cw.passthrough:
+        return cw.ResponseWriter.Write(p)
+    }
+    cw.buf = append(cw.buf, p...)
+    if len(cw.buf) >= compressMinBytes {
+        cw.start(true)
+    }
+    return len(p), nil
+}
+
+// Commit to compressing or not, send the headers and anything buffered
+func (cw *compressWriter) start(compress bool) {
+    h := cw.Header()
+    if compress {
+        h.Set("Content-Encoding", "gzip")
+        h.Del("Content-Length")
+        // The compressed bytes differ from what the ETag was computed over
+        if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+            h.Set("ETag", "W/"+etag)
+        }
+        cw.ResponseWriter.WriteHeader(cw.status)
+        cw.gz = gzipWriters.Get().(*gzip.Writer)
+        cw.gz.Reset(cw.ResponseWriter)
+        cw.gz.Write(cw.buf)
+    } else {
+        cw.passthrough = true
+        cw.ResponseWriter.WriteHeader(cw.status)
+        cw.ResponseWriter.Write(cw.buf)
+    }
+    cw.buf = nil
+}
+
+func (cw *compressWriter) Flush() {
+    // Streaming handlers want bytes on the wire now, so stop buffering
+    if !cw.wroteHeader {
+        cw.WriteHeader(http.StatusOK)
+    }
+    if cw.gz == nil && !cw.passthrough {
+        cw.start(len(cw.buf) >= compressMinBytes)
+    }
+    if cw.gz != nil {
+        cw.gz.Flush()
+    }
+    if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+        f.Flush()
+    }
+}
+
+func (cw *compressWriter) close() {
+    if cw.gz == nil && !cw.passthrough {
+        if !cw.wroteHeader {
+            // Handler wrote nothing at all
+            cw.ResponseWriter.WriteHeader(cw.status)
+            return
+        }
+        cw.start(len(cw.buf) >= compressMinBytes)
+    }
+    if cw.gz != nil {
+        cw.gz.Close()
+        gzipWriters.Put(cw.gz)
+        cw.gz = nil
+    }
+}
+
+// Serve a stable GET endpoint with a content-derived ETag, answering
+// If-None-Match with 304 so pollers only download it when it changes
+func withETag(h http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet && r.Method != http.MethodHead {
+            h(w, r)
+            return
+        }
+        rec := &bufferedResponse{header: w.Header(), status: http.StatusOK}
+        h(rec, r)
+        if rec.status != http.StatusOK {
+            w.WriteHeader(rec.status)
+            w.Write(rec.body.Bytes())
+            return
+        }
+
+        sum := sha256.Sum256(rec.body.Bytes())
+        etag := `"` + hex.EncodeToString(sum[:12]) + `"`
+        w.Header().Set("ETag", etag)
+        if w.Header().Get("Cache-Control") == "" {
+            w.Header().Set("Cache-Control", "no-cache")
+        }
+        if etagMatches(r.Header.Get("If-None-Match"), etag) {
+            w.Header().Del("Content-Type")
+            w.WriteHeader(http.StatusNotModified)
+            return
+        }
+        w.Header().Set("Content-Length", strconv.Itoa(rec.body.Len()))
+        w.WriteHeader(http.StatusOK)
+        if r.Method == http.MethodGet {
+            w.Write(rec.body.Bytes())
+        }
+    }
+}
+
+// Weak comparison per RFC 9110 §13.1.2, so tags weakened by compression
+// still match
+func etagMatches(header, etag string) bool {
+    if header == "" {
+        return false
+    }
+    for _, candidate := range strings.Split(header, ",") {
+        candidate = strings.TrimSpace(candidate)
+        if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+            return true
+        }
+    }
+    return false
+}
+
+// bufferedResponse captures a handler's output so it can be hashed before
+// anything is sent. Headers go straight to the real response.
+type bufferedResponse struct {
+    header http.Header
+    status int
+    body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header         { return b.header }
+func (b *bufferedResponse) WriteHeader(status int)      { b.status = status }
+func (b *bufferedResponse) Write(p []byte) (int, error) { return b.body.Write(p) }
@@ -0,0 +1,304 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "os/signal"
+    "sync"
+    "syscall"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Runtime configuration that changes without a restart: log verbosity, the
+// rate limits below, and which policy and flags files are in force.
+// CONFIG_FILE (JSON, usually a mounted ConfigMap) overrides the environment;
+// a setting it leaves out falls back to the environment's value. SIGHUP or
+// POST /admin/config re-reads it together with the policy and flags files.
+// Everything is read and checked before anything changes, so an invalid
+// file leaves the running config untouched, and the config a reload
+// replaced is kept for POST /admin/config {"rollback": true}.
+var (
+    configFile = os.Getenv("CONFIG_FILE")
+    configs    = &configManager{}
+)
+
+var errNoPreviousConfig = autherr.ErrConflict.WithMessage("No earlier config to roll back to")
+
+// Limiters CONFIG_FILE may retune, by name
+var configurableLimiters = map[string]*rateLimiter{
+    "verification_resend": resendLimiter,
+    "device_verify":       deviceVerifyLimiter,
+    "magic_link":          magicLinkLimiter,
+}
+
+// RuntimeConfig is the format of CONFIG_FILE
+type RuntimeConfig struct {
+    LogLevel       string                     `json:"logLevel,omitempty"`
+    LogRoutes      map[string]string          `json:"logRoutes,omitempty"`
+    LogDebugSample *float64                   `json:"logDebugSample,omitempty"`
+    RateLimits     map[string]RateLimitConfig `json:"rateLimits,omitempty"`
+    PolicyFile     *string                    `json:"policyFile,omitempty"`
+    FlagsFile      *string                    `json:"flagsFile,omitempty"`
+}
+
+// RateLimitConfig is {"limit": 3, "window": "1h"}
+type RateLimitConfig struct {
+    Limit  int    `json:"limit"`
+    Window string `json:"window"`
+}
+
+// ConfigReload reports the outcome of the last reload or rollback
+type ConfigReload struct {
+    Trigger string    `json:"trigger"`
+    At      time.Time `json:"at"`
+    OK      bool      `json:"ok"`
+    Error   string    `json:"error,omitempty"`
+}
+
+// configState is everything a reload replaces
+type configState struct {
+    logging    *LoggingConfig
+    rateLimits map[string]rateLimit
+    policyFile string
+    policy     *Policy
+    policyMod  time.Time
+    flagsFile  string
+    flags      map[string]FlagState
+    flagsMod   time.Time
+}
+
+func captureConfig() *configState {
+    s := &configState{logging: loggingDefaults.Load(), rateLimits: map[string]rateLimit{}}
+    for name, l := range configurableLimiters {
+        s.rateLimits[name] = l.settings()
+    }
+    s.policyFile, s.policy, s.policyMod = policies.source()
+    s.flagsFile, s.flags, s.flagsMod = flags.source()
+    return s
+}
+
+func (s *configState) apply() {
+    setLoggingDefaults(s.logging)
+    for name, l := range s.rateLimits {
+        configurableLimiters[name].setSettings(l)
+    }
+    policies.use(s.policyFile, s.policy, s.policyMod)
+    flags.use(s.flagsFile, s.flags, s.flagsMod)
+}
+
+func (s *configState) describe() RuntimeConfig {
+    c := RuntimeConfig{
+        LogLevel:       s.logging.Level,
+        LogRoutes:      s.logging.Routes,
+        LogDebugSample: &s.logging.Sample,
+        RateLimits:     map[string]RateLimitConfig{},
+        PolicyFile:     &s.policyFile,
+        FlagsFile:      &s.flagsFile,
+    }
+    for name, l := range s.rateLimits {
+        c.RateLimits[name] = RateLimitConfig{Limit: l.limit, Window: l.window.String()}
+    }
+    return c
+}
+
+// Read CONFIG_FILE over the environment's settings and load the files it
+// names, without changing anything
+func prepareConfig(baseline *configState) (*configState, error) {
+    var rc RuntimeConfig
+    if configFile != "" {
+        data, err := os.ReadFile(configFile)
+        if err != nil {
+            return nil, err
+        }
+        dec := json.NewDecoder(bytes.NewReader(data))
+        dec.DisallowUnknownFields()
+        if err := dec.Decode(&rc); err != nil {
+            return nil, fmt.Errorf("invalid config %s: %w", configFile, err)
+        }
+    }
+
+    next := &configState{rateLimits: map[string]rateLimit{}}
+    lc := *baseline.logging
+    if rc.LogLevel != "" {
+        lc.Level = rc.LogLevel
+    }
+    if rc.LogRoutes != nil {
+        lc.Routes = rc.LogRoutes
+    }
+    if rc.LogDebugSample != nil {
+        lc.Sample = *rc.LogDebugSample
+    }
+    if err := lc.validate(); err != nil {
+        return nil, fmt.Errorf("invalid config %s: %s", configFile, autherr.From(err).Message)
+    }
+    next.logging = &lc
+
+    for name, l := range baseline.rateLimits {
+        next.rateLimits[name] = l
+    }
+    for name, rl := range rc.RateLimits {
+        if _, ok := configurableLimiters[name]; !ok {
+            return nil, fmt.Errorf("invalid config %s: unknown rate limit %q", configFile, name)
+        }
+        window, err := time.ParseDuration(rl.Window)
+        if err != nil || window <= 0 || rl.Limit <= 0 {
+            return nil, fmt.Errorf("invalid config %s: rate limit %s needs a positive limit and window", configFile, name)
+        }
+        next.rateLimits[name] = rateLimit{limit: rl.Limit, window: window}
+    }
+
+    var err error
+    next.policyFile = baseline.policyFile
+    if rc.PolicyFile != nil {
+        next.policyFile = *rc.PolicyFile
+    }
+    if next.policyFile != "" {
+        if next.policy, next.policyMod, err = readPolicy(next.policyFile); err != nil {
+            return nil, err
+        }
+    }
+    next.flagsFile = baseline.flagsFile
+    if rc.FlagsFile != nil {
+        next.flagsFile = *rc.FlagsFile
+    }
+    if next.flags, next.flagsMod, err = readFlags(next.flagsFile); err != nil {
+        return nil, err
+    }
+    return next, nil
+}
+
+type configManager struct {
+    mu       sync.Mutex
+    baseline *configState // from the environment, captured at startup
+    previous *configState // replaced by the last reload
+    last     *ConfigReload
+}
+
+// Capture the environment's settings, apply CONFIG_FILE and reload on
+// SIGHUP. Call after the policy and flags files have been loaded.
+func startConfig() error {
+    configs.baseline = captureConfig()
+    if configFile != "" {
+        if err := configs.reload("startup"); err != nil {
+            return err
+        }
+    }
+    go configs.watchSignals()
+    return nil
+}
+
+func (m *configManager) reload(trigger string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    next, err := prepareConfig(m.baseline)
+    m.last = &ConfigReload{Trigger: trigger, At: time.Now(), OK: err == nil}
+    if err != nil {
+        m.last.Error = err.Error()
+        log.Printf("❌ Config reload (%s) rejected, running config unchanged: %v", trigger, err)
+        return err
+    }
+    m.previous = captureConfig()
+    next.apply()
+    log.Printf("🔄 Config reloaded (%s): log level %s, policy %q, flags %q", trigger, next.logging.Level, next.policyFile, next.flagsFile)
+    return nil
+}
+
+// Put back the config the last reload replaced
+func (m *configManager) rollback(trigger string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    if m.previous == nil {
+        return errNoPreviousConfig
+    }
+    current := captureConfig()
+    m.previous.apply()
+    m.previous = current
+    m.last = &ConfigReload{Trigger: trigger, At: time.Now(), OK: true}
+    log.Printf("↩️  Config rolled back (%s)", trigger)
+    return nil
+}
+
+func (m *configManager) watchSignals() {
+    sig := make(chan os.Signal, 1)
+    signal.Notify(sig, syscall.SIGHUP)
+    for range sig {
+        details := map[string]string{"trigger": "SIGHUP", "source": configFile}
+        if err := m.reload("SIGHUP"); err != nil {
+            details["error"] = err.Error()
+            recordSystemAudit("config.reload_failed", details)
+            continue
+        }
+        recordSystemAudit("config.reloaded", details)
+    }
+}
+
+func (m *configManager) status() map[string]interface{} {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    return map[string]interface{}{
+        "source":            configFile,
+        "config":            captureConfig().describe(),
+        "lastReload":        m.last,
+        "rollbackAvailable": m.previous != nil,
+    }
+}
+
+// Config endpoint: GET shows the runtime config in force; POST re-reads
+// CONFIG_FILE and the files it names, or with {"rollback": true} restores
+// the config the last reload replaced. Admins only, whatever the policy says.
+func configHandler(w http.ResponseWriter, r *http.Request) {
+    if err := requireAdmin(r); err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+    case http.MethodPost:
+        var req struct {
+            Rollback bool `json:"rollback"`
+        }
+        if r.ContentLength != 0 {
+            if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+                autherr.Write(w, autherr.ErrInvalidRequest)
+                return
+            }
+        }
+        actor := "unknown"
+        if p := principalFromContext(r.Context()); p != nil {
+            actor = p.Subject
+        }
+        event := "config.reloaded"
+        var err error
+        if req.Rollback {
+            event, err = "config.rolled_back", configs.rollback("admin")
+        } else {
+            err = configs.reload("admin")
+        }
+        if errors.Is(err, errNoPreviousConfig) {
+            autherr.Write(w, err)
+            return
+        }
+        if err != nil {
+            recordAudit(r, "config.reload_failed", actor, map[string]string{"error": err.Error()})
+            autherr.Write(w, autherr.ErrInvalidRequest.WithMessage(err.Error()))
+            return
+        }
+        recordAudit(r, event, actor, map[string]string{"source": configFile})
+    default:
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    json.NewEncoder(w).Encode(configs.status())
+}
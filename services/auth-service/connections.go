@@ -0,0 +1,158 @@
+package main
+
+import (
+    "context"
+    "crypto/tls"
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "net/http"
+    "strings"
+    "sync/atomic"
+    "time"
+
+    "golang.org/x/net/http2"
+    "golang.org/x/net/http2/h2c"
+)
+
+// Connection handling for in-cluster traffic. Callers that speak HTTP/2
+// without TLS (h2c, by prior knowledge only) multiplex every request
+// over one connection instead of opening a pool of HTTP/1.1 ones; HTTP/1.1
+// callers get long keep-alives so connections survive between bursts. The
+// idle timeout sits above the ingress controller's upstream keepalive
+// timeout (60s) so the proxy, not us, closes idle connections and never
+// sends a request down one we're closing.
+var (
+    h2cEnabled         = getEnv("H2C_ENABLED", "true") == "true"
+    httpIdleTimeout    = getEnvDuration("HTTP_IDLE_TIMEOUT", 75*time.Second)
+    httpReadHeaderTime = getEnvDuration("HTTP_READ_HEADER_TIMEOUT", 10*time.Second)
+    http2MaxStreams    = uint32(getEnvInt("HTTP2_MAX_CONCURRENT_STREAMS", 250))
+    http2PingInterval  = getEnvDuration("HTTP2_PING_INTERVAL", 30*time.Second)
+    outboundH2CHosts   = parseH2CHosts(getEnv("OUTBOUND_H2C_HOSTS", ""))
+    serverConns        = &connStats{}
+)
+
+type connStats struct {
+    opened atomic.Int64
+    active atomic.Int64
+    http1  atomic.Int64
+    http2  atomic.Int64
+}
+
+// Apply timeouts, keep-alive and HTTP/2 settings to a server. HTTP/2 over
+// TLS is configured on the server itself; h2c needs the handler wrapped.
+func tuneServer(s *http.Server) {
+    s.ReadHeaderTimeout = httpReadHeaderTime
+    s.IdleTimeout = httpIdleTimeout
+    s.ConnState = serverConns.track
+    h2 := &http2.Server{
+        MaxConcurrentStreams: http2MaxStreams,
+        IdleTimeout:          httpIdleTimeout,
+    }
+    if s.TLSConfig != nil {
+        if err := http2.ConfigureServer(s, h2); err != nil {
+            log.Printf("⚠️  HTTP/2 setup for %s failed: %v", s.Addr, err)
+        }
+    }
+    handler := serverConns.countRequests(s.Handler)
+    if h2cEnabled && s.TLSConfig == nil {
+        handler = withoutH2CUpgrade(h2c.NewHandler(handler, h2))
+    }
+    s.Handler = handler
+}
+
+// A proxy that passes an "Upgrade: h2c" request on without understanding it
+// hands the client a raw HTTP/2 connection to us, past the proxy's routing
+// and access rules (h2c smuggling). The upgrade headers are dropped so such
+// a request is served as plain HTTP/1.1; prior-knowledge h2c is unaffected.
+func withoutH2CUpgrade(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if strings.Contains(strings.ToLower(strings.Join(r.Header.Values("Upgrade"), ",")), "h2c") {
+            r.Header.Del("Upgrade")
+            r.Header.Del("HTTP2-Settings")
+            r.Header.Del("Connection")
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// Count connections by state. An h2c connection is hijacked from the
+// HTTP/1 server after its upgrade, so it leaves the active count then.
+func (c *connStats) track(_ net.Conn, state http.ConnState) {
+    switch state {
+    case http.StateNew:
+        c.opened.Add(1)
+        c.active.Add(1)
+    case http.StateClosed, http.StateHijacked:
+        c.active.Add(-1)
+    }
+}
+
+func (c *connStats) countRequests(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.ProtoMajor == 2 {
+            c.http2.Add(1)
+        } else {
+            c.http1.Add(1)
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// OUTBOUND_H2C_HOSTS lists in-cluster host:port targets known to speak h2c,
+// e.g. "image-service:5000,api-service:3000"
+func parseH2CHosts(value string) map[string]bool {
+    hosts := map[string]bool{}
+    for _, h := range strings.Split(value, ",") {
+        if h = strings.TrimSpace(h); h != "" {
+            hosts[h] = true
+        }
+    }
+    return hosts
+}
+
+// h2cTransport sends plain-HTTP requests for h2c hosts over HTTP/2 and
+// everything else through the regular transport
+type h2cTransport struct {
+    h2   *http2.Transport
+    base http.RoundTripper
+}
+
+func newH2CTransport(base http.RoundTripper, dialer *net.Dialer) http.RoundTripper {
+    if len(outboundH2CHosts) == 0 {
+        return base
+    }
+    return &h2cTransport{
+        base: base,
+        h2: &http2.Transport{
+            AllowHTTP: true,
+            // h2c is HTTP/2 over a plain TCP connection
+            DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+                return dialer.DialContext(ctx, network, addr)
+            },
+            ReadIdleTimeout: http2PingInterval,
+            PingTimeout:     5 * time.Second,
+        },
+    }
+}
+
+func (t *h2cTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    if req.URL.Scheme == "http" && outboundH2CHosts[req.URL.Host] {
+        return t.h2.RoundTrip(req)
+    }
+    return t.base.RoundTrip(req)
+}
+
+func writeConnMetrics(w io.Writer) {
+    fmt.Fprintf(w, "# HELP auth_http_connections_total Client connections accepted\n")
+    fmt.Fprintf(w, "# TYPE auth_http_connections_total counter\n")
+    fmt.Fprintf(w, "auth_http_connections_total %d\n", serverConns.opened.Load())
+    fmt.Fprintf(w, "# HELP auth_http_connections_active HTTP/1 client connections open (h2c connections leave this count on upgrade)\n")
+    fmt.Fprintf(w, "# TYPE auth_http_connections_active gauge\n")
+    fmt.Fprintf(w, "auth_http_connections_active %d\n", serverConns.active.Load())
+    fmt.Fprintf(w, "# HELP auth_http_requests_total Requests served by protocol; divided by connections this is the reuse factor\n")
+    fmt.Fprintf(w, "# TYPE auth_http_requests_total counter\n")
+    fmt.Fprintf(w, "auth_http_requests_total{proto=\"http1\"} %d\n", serverConns.http1.Load())
+    fmt.Fprintf(w, "auth_http_requests_total{proto=\"http2\"} %d\n", serverConns.http2.Load())
+}
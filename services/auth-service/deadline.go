@@ -0,0 +1,178 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "strconv"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Every request runs under a deadline carried in its context, so storage
+// queries and outbound calls give up with it instead of holding a worker
+// after the caller has gone. Internal services may ask for a different
+// budget with X-Request-Timeout ("250ms", "2s" or plain milliseconds), up to
+// REQUEST_TIMEOUT_MAX; the remaining budget is passed on to the services we
+// call in the same header. A request that runs out gets a 504 if nothing has
+// been written yet.
+var (
+    requestTimeout    = getEnvDuration("REQUEST_TIMEOUT", 15*time.Second)
+    requestTimeoutMax = getEnvDuration("REQUEST_TIMEOUT_MAX", time.Minute)
+    requestsTimedOut  atomic.Int64
+)
+
+// Routes that hold the connection open on purpose
+var deadlineExempt = map[string]bool{
+    "/events": true,
+}
+
+// The budget for a request: the caller's hint when it is a trusted service,
+// the default otherwise
+func requestBudget(r *http.Request) time.Duration {
+    raw := r.Header.Get("X-Request-Timeout")
+    if raw == "" || !principalFromContext(r.Context()).HasRole("service") {
+        return requestTimeout
+    }
+    d, err := time.ParseDuration(raw)
+    if ms, msErr := strconv.ParseInt(raw, 10, 64); msErr == nil {
+        d, err = time.Duration(ms)*time.Millisecond, nil
+    }
+    if err != nil || d <= 0 {
+        return requestTimeout
+    }
+    if d > requestTimeoutMax {
+        return requestTimeoutMax
+    }
+    return d
+}
+
+func deadlineMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        budget := requestBudget(r)
+        if budget <= 0 || deadlineExempt[r.URL.Path] {
+            next.ServeHTTP(w, r)
+            return
+        }
+        ctx, cancel := context.WithTimeout(r.Context(), budget)
+        defer cancel()
+
+        tw := &timeoutWriter{w: w, header: make(http.Header)}
+        done := make(chan struct{})
+        panicked := make(chan interface{}, 1)
+        go func() {
+            defer func() {
+                if p := recover(); p != nil {
+                    panicked <- p
+                }
+                close(done)
+            }()
+            next.ServeHTTP(tw, r.WithContext(ctx))
+        }()
+
+        select {
+        case <-done:
+        case <-ctx.Done():
+        }
+        select {
+        case p := <-panicked:
+            // Re-raise on the serving goroutine so net/http logs it as usual
+            panic(p)
+        case <-done:
+            return
+        default:
+        }
+
+        tw.mu.Lock()
+        defer tw.mu.Unlock()
+        tw.timedOut = true
+        if r.Context().Err() != nil {
+            // The caller went away; there is no one to answer
+            return
+        }
+        requestsTimedOut.Add(1)
+        if tw.wroteHeader {
+            log.Printf("⏱️  %s %s ran past its %s budget after the response started", r.Method, r.URL.Path, budget)
+            return
+        }
+        log.Printf("⏱️  %s %s ran past its %s budget", r.Method, r.URL.Path, budget)
+        autherr.Write(w, autherr.ErrTimeout)
+    })
+}
+
+// timeoutWriter passes the response through until the deadline middleware
+// gives up on the handler, after which writes fail. Headers are kept apart
+// from the real ones so a late handler can't race the 504.
+type timeoutWriter struct {
+    w      http.ResponseWriter
+    header http.Header
+
+    mu          sync.Mutex
+    wroteHeader bool
+    timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+    return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+    tw.mu.Lock()
+    defer tw.mu.Unlock()
+    tw.writeHeaderLocked(status)
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(status int) {
+    if tw.timedOut || tw.wroteHeader {
+        return
+    }
+    tw.wroteHeader = true
+    dst := tw.w.Header()
+    for k, v := range tw.header {
+        dst[k] = v
+    }
+    tw.w.WriteHeader(status)
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+    tw.mu.Lock()
+    defer tw.mu.Unlock()
+    if tw.timedOut {
+        return 0, http.ErrHandlerTimeout
+    }
+    tw.writeHeaderLocked(http.StatusOK)
+    return tw.w.Write(p)
+}
+
+func (tw *timeoutWriter) Flush() {
+    tw.mu.Lock()
+    defer tw.mu.Unlock()
+    if f, ok := tw.w.(http.Flusher); ok && !tw.timedOut {
+        f.Flush()
+    }
+}
+
+// The remaining budget of ctx in X-Request-Timeout form, or "" without a
+// deadline
+func remainingBudget(ctx context.Context) string {
+    deadline, ok := ctx.Deadline()
+    if !ok {
+        return ""
+    }
+    ms := time.Until(deadline).Milliseconds()
+    if ms < 1 {
+        ms = 1
+    }
+    return strconv.FormatInt(ms, 10)
+}
+
+func writeDeadlineMetrics(w io.Writer) {
+    fmt.Fprintf(w, "# HELP auth_requests_timed_out_total Requests that ran past their deadline\n")
+    fmt.Fprintf(w, "# TYPE auth_requests_timed_out_total counter\n")
+    fmt.Fprintf(w, "auth_requests_timed_out_total %d\n", requestsTimedOut.Load())
+}
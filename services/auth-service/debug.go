@@ -0,0 +1,96 @@
+package main
+
+import (
+    "expvar"
+    "log"
+    "net"
+    "net/http"
+    "net/http/pprof"
+    "runtime"
+    "strings"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Debug listener address. Loopback by default so it is only reachable via
+// `kubectl port-forward deploy/auth-service 6060`; empty disables it.
+var debugAddr = getEnv("DEBUG_ADDR", "127.0.0.1:6060")
+
+func init() {
+    expvar.Publish("uptime_seconds", expvar.Func(func() interface{} {
+        return time.Since(startTime).Seconds()
+    }))
+    expvar.Publish("goroutines", expvar.Func(func() interface{} {
+        return runtime.NumGoroutine()
+    }))
+}
+
+// Profiling, expvar and goroutine dumps for operators
+func debugHandler() http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/debug/pprof/", pprof.Index)
+    mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+    mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+    mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+    mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+    mux.Handle("/debug/vars", expvar.Handler())
+    mux.Handle("/debug/goroutines", pprof.Handler("goroutine"))
+
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        // Port-forwarded requests arrive from loopback; anything else (e.g.
+        // DEBUG_ADDR widened to :6060) needs admin credentials
+        if !fromLoopback(r) {
+            p := principalFromRequest(r)
+            if p == nil {
+                autherr.Write(w, autherr.ErrUnauthenticated)
+                return
+            }
+            if !p.HasRole("admin") {
+                autherr.Write(w, autherr.ErrForbidden.WithMessage("Missing required role"))
+                return
+            }
+        }
+        // Goroutine dumps default to full stacks rather than the aggregated
+        // counts pprof serves
+        if r.URL.Path == "/debug/goroutines" && r.URL.Query().Get("debug") == "" {
+            q := r.URL.Query()
+            q.Set("debug", "2")
+            r.URL.RawQuery = q.Encode()
+        }
+        mux.ServeHTTP(w, r)
+    })
+}
+
+func fromLoopback(r *http.Request) bool {
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return false
+    }
+    ip := net.ParseIP(host)
+    return ip != nil && ip.IsLoopback()
+}
+
+// net/http/pprof and expvar register themselves on http.DefaultServeMux, which
+// is also the public mux, so their paths are hidden from the main listener
+func withoutDebugRoutes(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if strings.HasPrefix(r.URL.Path, "/debug/") {
+            autherr.Write(w, autherr.ErrNotFound)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+func serveDebug() {
+    server := &http.Server{
+        Addr:              debugAddr,
+        Handler:           debugHandler(),
+        ReadHeaderTimeout: 10 * time.Second,
+    }
+    log.Printf("🩺 Debug endpoints on %s", debugAddr)
+    if err := server.ListenAndServe(); err != nil {
+        log.Printf("⚠️  Debug listener stopped: %v", err)
+    }
+}
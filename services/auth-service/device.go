@@ -0,0 +1,300 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/json"
+    "errors"
+    "html/template"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// RFC 8628 device authorization grant, for CLI tools that can't take a
+// password safely. The tool gets a device code and a short user code, the
+// user approves the user code in a browser at /device, and the tool polls
+// /device/token until the approval comes through. Pending grants are kept in
+// storage, so the tool may poll any replica.
+const grantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+var (
+    deviceCodeTTL       = getEnvDuration("DEVICE_CODE_TTL", 10*time.Minute)
+    devicePollInterval  = getEnvDuration("DEVICE_POLL_INTERVAL", 5*time.Second)
+    deviceVerifyLimiter = newRateLimiter(
+        getEnvInt("DEVICE_VERIFY_LIMIT", 10),
+        getEnvDuration("DEVICE_VERIFY_WINDOW", 15*time.Minute),
+    )
+)
+
+// Polling outcomes, named as RFC 8628 names them
+var (
+    errAuthorizationPending = autherr.New(http.StatusBadRequest, "authorization_pending", "The user has not approved the request yet")
+    errSlowDown             = autherr.New(http.StatusBadRequest, "slow_down", "Polling too often; increase the interval by 5 seconds")
+    errAccessDenied         = autherr.New(http.StatusBadRequest, "access_denied", "The user denied the request")
+    errDeviceCodeExpired    = autherr.New(http.StatusBadRequest, "expired_token", "The device code has expired")
+)
+
+// No vowels, so user codes can't spell words, and no easily confused digits
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+func newUserCode() string {
+    b := make([]byte, 8)
+    rand.Read(b)
+    for i := range b {
+        b[i] = userCodeAlphabet[int(b[i])%len(userCodeAlphabet)]
+    }
+    return string(b[:4]) + "-" + string(b[4:])
+}
+
+// Accept user codes typed in lower case or without the dash
+func normalizeUserCode(code string) string {
+    code = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+    if len(code) != 8 {
+        return ""
+    }
+    return code[:4] + "-" + code[4:]
+}
+
+// Start a grant. The device code goes to the tool; storage only keeps its hash.
+func issueDeviceGrant(ctx context.Context, tenant, audience string) (string, *DeviceGrant, error) {
+    deviceCode := randomHex(32)
+    g := &DeviceGrant{
+        Hash:      oneTimeTokenHash(deviceCode),
+        UserCode:  newUserCode(),
+        Tenant:    tenant,
+        Audience:  audience,
+        Interval:  devicePollInterval,
+        ExpiresAt: clock.Now().Add(deviceCodeTTL),
+    }
+    if err := store.CreateDeviceGrant(ctx, g); err != nil {
+        return "", nil, err
+    }
+    return deviceCode, g, nil
+}
+
+// Poll a grant. An approved grant is deleted as its token is handed out, and
+// only the poll whose delete lands gets it.
+func pollDeviceGrant(ctx context.Context, deviceCode, tenant string) (*DeviceGrant, error) {
+    errUnknown := autherr.ErrInvalidRequest.WithMessage("Unknown device_code")
+    hash := oneTimeTokenHash(deviceCode)
+    g, err := store.GetDeviceGrant(ctx, hash)
+    if errors.Is(err, errDeviceNotFound) || (err == nil && g.Tenant != tenant) {
+        return nil, errUnknown
+    }
+    if err != nil {
+        return nil, err
+    }
+    now := clock.Now()
+    switch {
+    case now.After(g.ExpiresAt):
+        store.DeleteDeviceGrant(ctx, hash)
+        return nil, errDeviceCodeExpired
+    case g.Denied:
+        store.DeleteDeviceGrant(ctx, hash)
+        return nil, errAccessDenied
+    case g.UserID != "":
+        if err := store.DeleteDeviceGrant(ctx, hash); errors.Is(err, errDeviceNotFound) {
+            return nil, errUnknown
+        } else if err != nil {
+            return nil, err
+        }
+        return g, nil
+    }
+    interval, pollErr := g.Interval, errAuthorizationPending
+    if !g.LastPoll.IsZero() && now.Sub(g.LastPoll) < g.Interval {
+        interval, pollErr = g.Interval+5*time.Second, errSlowDown
+    }
+    if err := store.UpdateDeviceGrantPoll(ctx, hash, now, interval); err != nil {
+        return nil, err
+    }
+    return nil, pollErr
+}
+
+// Device authorization endpoint: POST audience=... (optional) starts a grant
+func deviceCodeHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+    if err := r.ParseForm(); err != nil {
+        autherr.Write(w, autherr.ErrInvalidRequest)
+        return
+    }
+    audience, err := loginAudience(r.PostForm.Get("audience"))
+    if err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    deviceCode, g, err := issueDeviceGrant(r.Context(), tenantFromContext(r.Context()), audience)
+    if err != nil {
+        log.Printf("❌ Device grant create failed: %v", err)
+        autherr.Write(w, autherr.ErrInternal)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "device_code":               deviceCode,
+        "user_code":                 g.UserCode,
+        "verification_uri":          publicBaseURL + "/device",
+        "verification_uri_complete": publicBaseURL + "/device?user_code=" + g.UserCode,
+        "expires_in":                int(deviceCodeTTL.Seconds()),
+        "interval":                  int(devicePollInterval.Seconds()),
+    })
+}
+
+// Device token endpoint: the tool polls with grant_type and device_code
+// until the user has decided
+func deviceTokenHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+    if err := r.ParseForm(); err != nil {
+        autherr.Write(w, autherr.ErrInvalidRequest)
+        return
+    }
+    if r.PostForm.Get("grant_type") != grantTypeDeviceCode {
+        autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("grant_type must be "+grantTypeDeviceCode))
+        return
+    }
+    g, err := pollDeviceGrant(r.Context(), r.PostForm.Get("device_code"), tenantFromContext(r.Context()))
+    if err != nil {
+        autherr.Write(w, err)
+        return
+    }
+
+    // The user may have been disabled between approving and the next poll
+    user, err := store.GetUser(r.Context(), g.UserID)
+    if err != nil || user.Status != UserStatusActive {
+        autherr.Write(w, errAccessDenied)
+        return
+    }
+    token, err := issueSessionToken(r, user, g.Audience, "device.token_issued", nil)
+    if err != nil {
+        autherr.Write(w, err)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "access_token": token,
+        "token_type":   "Bearer",
+        "expires_in":   int(tokenTTL.Seconds()),
+        "audience":     g.Audience,
+    })
+}
+
+var devicePage = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Device sign-in</title></head>
+<body style="font-family: sans-serif; max-width: 24em; margin: 4em auto">
+<h1>Device sign-in</h1>
+{{if .Message}}<p><strong>{{.Message}}</strong></p>{{end}}
+{{if not .Done}}
+<p>Enter the code shown by the device and sign in to approve it.</p>
+<form method="post">
+<p><label>Code<br><input name="user_code" value="{{.UserCode}}" autocomplete="off" required></label></p>
+<p><label>Email<br><input name="email" type="email" autocomplete="username" required></label></p>
+<p><label>Password<br><input name="password" type="password" autocomplete="current-password" required></label></p>
+<p><button name="action" value="approve">Approve</button> <button name="action" value="deny">Deny</button></p>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+type devicePageData struct {
+    UserCode string
+    Message  string
+    Done     bool
+}
+
+func renderDevicePage(w http.ResponseWriter, status int, data devicePageData) {
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    w.Header().Set("Cache-Control", "no-store")
+    w.Header().Set("X-Frame-Options", "DENY")
+    w.WriteHeader(status)
+    devicePage.Execute(w, data)
+}
+
+// Verification page: GET shows the form, POST checks the user's credentials
+// and approves or denies the grant with the entered user code
+func deviceVerifyHandler(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        renderDevicePage(w, http.StatusOK, devicePageData{UserCode: r.URL.Query().Get("user_code")})
+        return
+    case http.MethodPost:
+    default:
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+    if err := r.ParseForm(); err != nil {
+        renderDevicePage(w, http.StatusBadRequest, devicePageData{Message: "Invalid form"})
+        return
+    }
+    entered := r.PostForm.Get("user_code")
+    // User codes are short, so guessing them has to be slow
+    if ok, retryAfter := deviceVerifyLimiter.Allow(clientIP(r).String()); !ok {
+        w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+        renderDevicePage(w, http.StatusTooManyRequests, devicePageData{UserCode: entered, Message: "Too many attempts, try again later"})
+        return
+    }
+
+    attempt := LoginAttempt{
+        Tenant: tenantFromContext(r.Context()),
+        Email:  normalizeEmail(r.PostForm.Get("email")),
+        IP:     clientIP(r),
+        Time:   clock.Now(),
+    }
+    user, err := store.GetUserByEmail(r.Context(), attempt.Tenant, attempt.Email)
+    if err != nil || !checkPassword(user.PasswordHash, r.PostForm.Get("password")) {
+        riskScorer.Observe(attempt, false)
+        recordAudit(r, "login.failed", attempt.Email, map[string]string{"via": "device"})
+        renderDevicePage(w, http.StatusUnauthorized, devicePageData{UserCode: entered, Message: "Invalid email or password"})
+        return
+    }
+    if user.Status != UserStatusActive {
+        recordAudit(r, "login.failed", user.ID, map[string]string{"via": "device", "reason": user.Status})
+        renderDevicePage(w, http.StatusForbidden, devicePageData{UserCode: entered, Message: "This account can't sign in"})
+        return
+    }
+    attempt.UserID = user.ID
+    if err := assessLoginRisk(r, attempt); err != nil {
+        renderDevicePage(w, http.StatusForbidden, devicePageData{UserCode: entered, Message: "Sign-in blocked; use the regular login"})
+        return
+    }
+    riskScorer.Observe(attempt, true)
+
+    approve := r.PostForm.Get("action") != "deny"
+    userCode := normalizeUserCode(entered)
+    if userCode == "" {
+        renderDevicePage(w, http.StatusBadRequest, devicePageData{UserCode: entered, Message: "Unknown or expired code"})
+        return
+    }
+    if err := store.DecideDeviceGrant(r.Context(), attempt.Tenant, userCode, user.ID, approve, clock.Now()); err != nil {
+        if !errors.Is(err, errDeviceNotFound) {
+            log.Printf("❌ Device grant update failed: %v", err)
+            renderDevicePage(w, http.StatusInternalServerError, devicePageData{UserCode: entered, Message: "Something went wrong, try again"})
+            return
+        }
+        renderDevicePage(w, http.StatusBadRequest, devicePageData{UserCode: entered, Message: "Unknown or expired code"})
+        return
+    }
+    if !approve {
+        recordAudit(r, "device.denied", user.ID, map[string]string{"userCode": userCode})
+        renderDevicePage(w, http.StatusOK, devicePageData{Message: "Request denied. You can close this window.", Done: true})
+        return
+    }
+    log.Printf("📟 Device code %s approved by %s", userCode, user.ID)
+    recordAudit(r, "device.approved", user.ID, map[string]string{"userCode": userCode})
+    renderDevicePage(w, http.StatusOK, devicePageData{Message: "Device approved. You can return to it now.", Done: true})
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "net/http"
+    "net/url"
+    "sort"
+    "strconv"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Discovery of the sibling services from the Kubernetes API rather than
+// from hardcoded addresses. With DISCOVERY_ENABLED=true the service lists,
+// every DISCOVERY_INTERVAL, the Services in DISCOVERY_NAMESPACE matching
+// DISCOVERY_SELECTOR together with their EndpointSlices, then checks each
+// service's health by calling DISCOVERY_HEALTH_PATH on its ready endpoints
+// until one answers. The result is shown at /discovery and in /status,
+// exported as auth_peer_up, and feeds the "services" field of policy rules
+// (and so of ext_authz rules), which admits requests coming from the pods
+// behind the named services. Like leader election it talks to the API over
+// plain REST rather than through client-go.
+var (
+    discoveryEnabled = getEnv("DISCOVERY_ENABLED", "false") == "true"
+    discovery        = &serviceDiscovery{
+        namespace:  getEnv("DISCOVERY_NAMESPACE", serviceAccountNamespace()),
+        selector:   getEnv("DISCOVERY_SELECTOR", "app.kubernetes.io/part-of=minikube-complete-setup"),
+        api:        getEnv("DISCOVERY_API", inClusterAPI()),
+        interval:   getEnvDuration("DISCOVERY_INTERVAL", 15*time.Second),
+        healthPath: getEnv("DISCOVERY_HEALTH_PATH", "/health"),
+    }
+)
+
+// DiscoveredService is a peer service and the state of its endpoints
+type DiscoveredService struct {
+    Name        string               `json:"name"`
+    Namespace   string               `json:"namespace"`
+    ClusterIP   string               `json:"clusterIP,omitempty"`
+    Ports       []int                `json:"ports"`
+    Endpoints   []DiscoveredEndpoint `json:"endpoints"`
+    Healthy     bool                 `json:"healthy"`
+    HealthError string               `json:"healthError,omitempty"`
+    CheckedAt   time.Time            `json:"checkedAt"`
+}
+
+// DiscoveredEndpoint is one pod behind a service
+type DiscoveredEndpoint struct {
+    Address string `json:"address"`
+    Port    int    `json:"port"`
+    Ready   bool   `json:"ready"`
+    Pod     string `json:"pod,omitempty"`
+}
+
+// The parts of v1 ServiceList and discovery.k8s.io/v1 EndpointSliceList
+// discovery reads
+type serviceList struct {
+    Items []struct {
+        Metadata struct {
+            Name      string `json:"name"`
+            Namespace string `json:"namespace"`
+        } `json:"metadata"`
+        Spec struct {
+            ClusterIP string `json:"clusterIP"`
+            Ports     []struct {
+                Port int `json:"port"`
+            } `json:"ports"`
+        } `json:"spec"`
+    } `json:"items"`
+}
+
+type endpointSliceList struct {
+    Items []struct {
+        Metadata struct {
+            Labels map[string]string `json:"labels"`
+        } `json:"metadata"`
+        Endpoints []struct {
+            Addresses  []string `json:"addresses"`
+            Conditions struct {
+                Ready *bool `json:"ready"`
+            } `json:"conditions"`
+            TargetRef *struct {
+                Name string `json:"name"`
+            } `json:"targetRef"`
+        } `json:"endpoints"`
+        Ports []struct {
+            Port *int `json:"port"`
+        } `json:"ports"`
+    } `json:"items"`
+}
+
+type discoverySnapshot struct {
+    services    []DiscoveredService
+    addresses   map[string]map[string]bool // service name to endpoint IPs
+    refreshedAt time.Time
+}
+
+type serviceDiscovery struct {
+    namespace  string
+    selector   string
+    api        string
+    interval   time.Duration
+    healthPath string
+    client     *http.Client
+
+    current  atomic.Pointer[discoverySnapshot]
+    failures atomic.Int64
+
+    mu      sync.Mutex
+    lastErr string
+}
+
+func (d *serviceDiscovery) start() {
+    if !discoveryEnabled {
+        return
+    }
+    if d.api == "" || d.namespace == "" {
+        log.Fatalf("❌ DISCOVERY_ENABLED needs the Kubernetes API; set DISCOVERY_API and DISCOVERY_NAMESPACE outside a cluster")
+    }
+    client, err := apiServerClient()
+    if err != nil {
+        log.Fatalf("❌ Kubernetes API client: %v", err)
+    }
+    d.client = client
+    log.Printf("🔭 Discovering services in %s matching %q", d.namespace, d.selector)
+    go d.loop()
+}
+
+func (d *serviceDiscovery) loop() {
+    d.refreshAndLog()
+    for range time.Tick(d.interval) {
+        d.refreshAndLog()
+    }
+}
+
+// Keep what was found last time when the API can't be reached, and only
+// log when the error changes
+func (d *serviceDiscovery) refreshAndLog() {
+    ctx, cancel := context.WithTimeout(context.Background(), d.interval)
+    defer cancel()
+    err := d.refresh(ctx)
+
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    if err != nil {
+        d.failures.Add(1)
+        if err.Error() != d.lastErr {
+            log.Printf("⚠️  Service discovery failed: %v", err)
+        }
+        d.lastErr = err.Error()
+        return
+    }
+    if d.lastErr != "" {
+        log.Printf("🔭 Service discovery recovered")
+    }
+    d.lastErr = ""
+}
+
+func (d *serviceDiscovery) refresh(ctx context.Context) error {
+    base := d.api + "/api/v1/namespaces/" + url.PathEscape(d.namespace) + "/services"
+    var services serviceList
+    if err := d.list(ctx, base+"?labelSelector="+url.QueryEscape(d.selector), &services); err != nil {
+        return err
+    }
+    var slices endpointSliceList
+    if err := d.list(ctx, d.api+"/apis/discovery.k8s.io/v1/namespaces/"+url.PathEscape(d.namespace)+"/endpointslices", &slices); err != nil {
+        return err
+    }
+
+    found := make([]DiscoveredService, len(services.Items))
+    byName := map[string]*DiscoveredService{}
+    for i, item := range services.Items {
+        s := &found[i]
+        s.Name, s.Namespace, s.ClusterIP = item.Metadata.Name, item.Metadata.Namespace, item.Spec.ClusterIP
+        s.Ports = []int{}
+        for _, p := range item.Spec.Ports {
+            s.Ports = append(s.Ports, p.Port)
+        }
+        s.Endpoints = []DiscoveredEndpoint{}
+        byName[s.Name] = s
+    }
+    for _, slice := range slices.Items {
+        s := byName[slice.Metadata.Labels["kubernetes.io/service-name"]]
+        if s == nil || len(slice.Ports) == 0 || slice.Ports[0].Port == nil {
+            continue
+        }
+        for _, ep := range slice.Endpoints {
+            // An unset condition means ready
+            ready := ep.Conditions.Ready == nil || *ep.Conditions.Ready
+            pod := ""
+            if ep.TargetRef != nil {
+                pod = ep.TargetRef.Name
+            }
+            for _, addr := range ep.Addresses {
+                s.Endpoints = append(s.Endpoints, DiscoveredEndpoint{Address: addr, Port: *slice.Ports[0].Port, Ready: ready, Pod: pod})
+            }
+        }
+    }
+
+    var wg sync.WaitGroup
+    for i := range found {
+        wg.Add(1)
+        go func(s *DiscoveredService) {
+            defer wg.Done()
+            d.checkHealth(ctx, s)
+        }(&found[i])
+    }
+    wg.Wait()
+
+    sort.Slice(found, func(i, j int) bool { return found[i].Name < found[j].Name })
+    snapshot := &discoverySnapshot{services: found, addresses: map[string]map[string]bool{}, refreshedAt: time.Now()}
+    for _, s := range found {
+        ips := map[string]bool{}
+        for _, ep := range s.Endpoints {
+            if ep.Ready {
+                ips[ep.Address] = true
+            }
+        }
+        snapshot.addresses[s.Name] = ips
+    }
+    d.current.Store(snapshot)
+    return nil
+}
+
+func (d *serviceDiscovery) list(ctx context.Context, target string, out interface{}) error {
+    resp, err := apiServerDo(ctx, d.client, http.MethodGet, target, nil)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        return fmt.Errorf("GET %s: %s: %s", target, resp.Status, data)
+    }
+    return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// A service is healthy once any ready endpoint answers its health check
+func (d *serviceDiscovery) checkHealth(ctx context.Context, s *DiscoveredService) {
+    s.CheckedAt = time.Now()
+    s.HealthError = "no ready endpoints"
+    for _, ep := range s.Endpoints {
+        if !ep.Ready {
+            continue
+        }
+        err := d.probe(ctx, "http://"+net.JoinHostPort(ep.Address, strconv.Itoa(ep.Port))+d.healthPath)
+        if err == nil {
+            s.Healthy, s.HealthError = true, ""
+            return
+        }
+        s.HealthError = err.Error()
+    }
+}
+
+func (d *serviceDiscovery) probe(ctx context.Context, target string) error {
+    ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+    defer cancel()
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+    if err != nil {
+        return err
+    }
+    resp, err := outbound.Do(req)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("%s answered %s", target, resp.Status)
+    }
+    return nil
+}
+
+// Whether ip is a ready endpoint of one of the named services. With
+// discovery off or not yet done nothing matches, so rules fail closed.
+func (d *serviceDiscovery) contains(names []string, ip net.IP) bool {
+    snapshot := d.current.Load()
+    if snapshot == nil || ip == nil {
+        return false
+    }
+    for _, name := range names {
+        if snapshot.addresses[name][ip.String()] {
+            return true
+        }
+    }
+    return false
+}
+
+// Peer health for /status
+func (d *serviceDiscovery) summary() map[string]bool {
+    peers := map[string]bool{}
+    if snapshot := d.current.Load(); snapshot != nil {
+        for _, s := range snapshot.services {
+            peers[s.Name] = s.Healthy
+        }
+    }
+    return peers
+}
+
+// Discovery endpoint: the services found and their endpoints and health
+func discoveryHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+    response := map[string]interface{}{
+        "enabled":  discoveryEnabled,
+        "services": []DiscoveredService{},
+    }
+    if discoveryEnabled {
+        response["namespace"] = discovery.namespace
+        response["selector"] = discovery.selector
+        if snapshot := discovery.current.Load(); snapshot != nil {
+            response["services"] = snapshot.services
+            response["refreshedAt"] = snapshot.refreshedAt
+        }
+        discovery.mu.Lock()
+        if discovery.lastErr != "" {
+            response["lastError"] = discovery.lastErr
+        }
+        discovery.mu.Unlock()
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    json.NewEncoder(w).Encode(response)
+}
+
+func writeDiscoveryMetrics(w io.Writer) {
+    if !discoveryEnabled {
+        return
+    }
+    fmt.Fprintf(w, "# HELP auth_peer_up Whether a discovered service answers its health check\n")
+    fmt.Fprintf(w, "# TYPE auth_peer_up gauge\n")
+    if snapshot := discovery.current.Load(); snapshot != nil {
+        for _, s := range snapshot.services {
+            up := 0
+            if s.Healthy {
+                up = 1
+            }
+            fmt.Fprintf(w, "auth_peer_up{service=%q} %d\n", s.Name, up)
+        }
+    }
+    fmt.Fprintf(w, "# HELP auth_discovery_failures_total Service discovery refreshes that failed\n")
+    fmt.Fprintf(w, "# TYPE auth_discovery_failures_total counter\n")
+    fmt.Fprintf(w, "auth_discovery_failures_total %d\n", discovery.failures.Load())
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+    "encoding/json"
+    "mime"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "auth-service/internal/wire"
+)
+
+// Response encodings for the hot-path endpoints. Callers that validate on
+// every request can ask for Protocol Buffers (proto/auth.proto) or
+// MessagePack with the Accept header; anything else gets JSON.
+const (
+    encodingJSON     = "application/json"
+    encodingProtobuf = "application/x-protobuf"
+    encodingMsgpack  = "application/msgpack"
+)
+
+var encodingAliases = map[string]string{
+    "application/json":        encodingJSON,
+    "application/x-protobuf":  encodingProtobuf,
+    "application/protobuf":    encodingProtobuf,
+    "application/msgpack":     encodingMsgpack,
+    "application/x-msgpack":   encodingMsgpack,
+    "application/vnd.msgpack": encodingMsgpack,
+}
+
+// Pick the response encoding from Accept: the supported type with the
+// highest q, the earliest on a tie, JSON when none is listed
+func negotiateEncoding(r *http.Request) string {
+    best, bestQ := encodingJSON, 0.0
+    for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+        mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+        if err != nil {
+            continue
+        }
+        encoding, ok := encodingAliases[mediaType]
+        if !ok {
+            continue
+        }
+        q := 1.0
+        if v, ok := params["q"]; ok {
+            if q, err = strconv.ParseFloat(v, 64); err != nil {
+                continue
+            }
+        }
+        if q > bestQ {
+            best, bestQ = encoding, q
+        }
+    }
+    return best
+}
+
+// ValidateResponse is the body of /validate
+type ValidateResponse struct {
+    Valid          bool      `json:"valid"`
+    Service        string    `json:"service"`
+    Timestamp      time.Time `json:"timestamp"`
+    Message        string    `json:"message,omitempty"`
+    User           string    `json:"user,omitempty"`
+    Audience       string    `json:"audience,omitempty"`
+    ImpersonatedBy string    `json:"impersonatedBy,omitempty"`
+}
+
+func (v *ValidateResponse) appendProto(b []byte) []byte {
+    b = wire.ProtoBool(b, 1, v.Valid)
+    b = wire.ProtoString(b, 2, v.Service)
+    b = wire.ProtoTimestamp(b, 3, v.Timestamp)
+    b = wire.ProtoString(b, 4, v.Message)
+    b = wire.ProtoString(b, 5, v.User)
+    b = wire.ProtoString(b, 6, v.Audience)
+    return wire.ProtoString(b, 7, v.ImpersonatedBy)
+}
+
+// The MessagePack form is a map with the JSON keys; empty optional fields
+// are left out as in JSON
+func (v *ValidateResponse) appendMsgpack(b []byte) []byte {
+    optional := [...]struct{ key, value string }{
+        {"message", v.Message},
+        {"user", v.User},
+        {"audience", v.Audience},
+        {"impersonatedBy", v.ImpersonatedBy},
+    }
+    n := 3
+    for _, f := range optional {
+        if f.value != "" {
+            n++
+        }
+    }
+    b = wire.MsgpackMap(b, n)
+    b = wire.MsgpackBool(wire.MsgpackString(b, "valid"), v.Valid)
+    b = wire.MsgpackString(wire.MsgpackString(b, "service"), v.Service)
+    b = wire.MsgpackTime(wire.MsgpackString(b, "timestamp"), v.Timestamp)
+    for _, f := range optional {
+        if f.value != "" {
+            b = wire.MsgpackString(wire.MsgpackString(b, f.key), f.value)
+        }
+    }
+    return b
+}
+
+func writeValidateResponse(w http.ResponseWriter, r *http.Request, v *ValidateResponse) {
+    w.Header().Add("Vary", "Accept")
+    switch encoding := negotiateEncoding(r); encoding {
+    case encodingProtobuf:
+        w.Header().Set("Content-Type", encoding)
+        w.Write(v.appendProto(make([]byte, 0, 128)))
+    case encodingMsgpack:
+        w.Header().Set("Content-Type", encoding)
+        w.Write(v.appendMsgpack(make([]byte, 0, 160)))
+    default:
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(v)
+    }
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+var (
+    eventsHeartbeat      = getEnvDuration("EVENTS_HEARTBEAT", 15*time.Second)
+    eventsMaxSubscribers = getEnvInt("EVENTS_MAX_SUBSCRIBERS", 100)
+)
+
+// eventBroker fans audit events out to live /events subscribers. Delivery is
+// best effort: a subscriber that falls behind loses events rather than
+// slowing down the request that produced them.
+type eventBroker struct {
+    mu      sync.Mutex
+    subs    map[*eventSubscriber]struct{}
+    closed  bool
+    dropped atomic.Int64
+}
+
+type eventSubscriber struct {
+    ch     chan AuditEvent
+    tenant string
+    types  []string // exact types or "prefix.*"; empty means all
+}
+
+var events = &eventBroker{subs: make(map[*eventSubscriber]struct{})}
+
+func (b *eventBroker) subscribe(tenant string, types []string) (*eventSubscriber, bool) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if b.closed || len(b.subs) >= eventsMaxSubscribers {
+        return nil, false
+    }
+    s := &eventSubscriber{ch: make(chan AuditEvent, 64), tenant: tenant, types: types}
+    b.subs[s] = struct{}{}
+    return s, true
+}
+
+func (b *eventBroker) unsubscribe(s *eventSubscriber) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if _, ok := b.subs[s]; ok {
+        delete(b.subs, s)
+        close(s.ch)
+    }
+}
+
+func (b *eventBroker) publish(e AuditEvent) {
+    tenant := defaultTenant
+    if t := e.Details["tenant"]; t != "" {
+        tenant = t
+    }
+
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    for s := range b.subs {
+        if s.tenant != tenant || !s.wants(e.Type) {
+            continue
+        }
+        select {
+        case s.ch <- e:
+        default:
+            b.dropped.Add(1)
+        }
+    }
+}
+
+func (b *eventBroker) writeMetrics(w io.Writer) {
+    b.mu.Lock()
+    n := len(b.subs)
+    b.mu.Unlock()
+    fmt.Fprintf(w, "# HELP auth_event_subscribers Connected /events streams\n")
+    fmt.Fprintf(w, "# TYPE auth_event_subscribers gauge\n")
+    fmt.Fprintf(w, "auth_event_subscribers %d\n", n)
+    fmt.Fprintf(w, "# HELP auth_events_dropped_total Events not delivered to slow subscribers\n")
+    fmt.Fprintf(w, "# TYPE auth_events_dropped_total counter\n")
+    fmt.Fprintf(w, "auth_events_dropped_total %d\n", b.dropped.Load())
+}
+
+// End every stream so graceful shutdown isn't held up by idle subscribers
+func (b *eventBroker) close() {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    b.closed = true
+    for s := range b.subs {
+        delete(b.subs, s)
+        close(s.ch)
+    }
+}
+
+func (s *eventSubscriber) wants(eventType string) bool {
+    if len(s.types) == 0 {
+        return true
+    }
+    for _, t := range s.types {
+        if t == eventType || (strings.HasSuffix(t, ".*") && strings.HasPrefix(eventType, strings.TrimSuffix(t, "*"))) {
+            return true
+        }
+    }
+    return false
+}
+
+// Events endpoint: a Server-Sent Events stream of audit events for the
+// caller's tenant, optionally filtered with ?types=login.failed,apikey.*
+func eventsHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        autherr.Write(w, autherr.ErrInternal.WithMessage("Streaming unsupported"))
+        return
+    }
+
+    var types []string
+    for _, t := range strings.Split(r.URL.Query().Get("types"), ",") {
+        if t = strings.TrimSpace(t); t != "" {
+            types = append(types, t)
+        }
+    }
+    sub, ok := events.subscribe(tenantFromContext(r.Context()), types)
+    if !ok {
+        w.Header().Set("Retry-After", "30")
+        autherr.Write(w, autherr.ErrRateLimited.WithMessage("Too many event subscribers"))
+        return
+    }
+    defer events.unsubscribe(sub)
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-store")
+    w.Header().Set("X-Accel-Buffering", "no")
+    w.WriteHeader(http.StatusOK)
+    fmt.Fprintf(w, "retry: 5000\n\n")
+    flusher.Flush()
+
+    heartbeat := time.NewTicker(eventsHeartbeat)
+    defer heartbeat.Stop()
+    for {
+        select {
+        case <-r.Context().Done():
+            return
+        case <-heartbeat.C:
+            fmt.Fprintf(w, ": heartbeat\n\n")
+        case e, open := <-sub.ch:
+            if !open {
+                return
+            }
+            data, _ := json.Marshal(e)
+            fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+        }
+        flusher.Flush()
+    }
+}
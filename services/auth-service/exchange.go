@@ -0,0 +1,155 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "strings"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// RFC 8693 identifiers
+const (
+    grantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+    tokenTypeJWT           = "urn:ietf:params:oauth:token-type:jwt"
+    tokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+var exchangeTTL = getEnvDuration("TOKEN_EXCHANGE_TTL", 5*time.Minute)
+
+// Actor is the RFC 8693 "act" claim. Nested actors record the full
+// delegation chain, most recent delegate outermost.
+type Actor struct {
+    Subject string `json:"sub"`
+    Actor   *Actor `json:"act,omitempty"`
+}
+
+// Token exchange endpoint: a service holding a user's token trades it for a
+// narrower, audience-bound token to call another service on the user's
+// behalf. The calling service must authenticate itself and becomes the actor.
+// It can only exchange tokens addressed to it, for audiences it is allowed
+// to call, and the new token carries scopes but no roles: the roles were
+// granted for the subject token's audience, not the next one.
+func tokenExchangeHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+    caller := principalFromContext(r.Context())
+    if caller == nil {
+        autherr.Write(w, autherr.ErrUnauthenticated.WithMessage("The exchanging service must authenticate"))
+        return
+    }
+    if caller.Kind != "service" && caller.Kind != "workload" {
+        autherr.Write(w, autherr.ErrForbidden.WithMessage("Only services can exchange tokens"))
+        return
+    }
+    if err := r.ParseForm(); err != nil {
+        autherr.Write(w, autherr.ErrInvalidRequest)
+        return
+    }
+    if r.PostForm.Get("grant_type") != grantTypeTokenExchange {
+        autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("grant_type must be "+grantTypeTokenExchange))
+        return
+    }
+    switch r.PostForm.Get("subject_token_type") {
+    case tokenTypeJWT, tokenTypeAccessToken:
+    default:
+        autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("Unsupported subject_token_type"))
+        return
+    }
+    audience := r.PostForm.Get("audience")
+    if audience == "" {
+        autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("audience is required"))
+        return
+    }
+    audience, err := exchangeAudience(caller, audience)
+    if err != nil {
+        autherr.Write(w, err)
+        return
+    }
+
+    subject, err := verifyToken(r.Context(), r.PostForm.Get("subject_token"))
+    if err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    // Only the service a token was issued to may pass it on
+    if !containsString(acceptedAudiences(caller), subject.Audience) {
+        autherr.Write(w, errWrongAudience)
+        return
+    }
+    // Exchanged tokens carry no session, so impersonation couldn't be ended
+    if subject.ImpersonatedBy != "" {
+        autherr.Write(w, autherr.ErrForbidden.WithMessage("Impersonation tokens cannot be exchanged"))
+        return
+    }
+    if tokenTenant(subject) != tenantFromContext(r.Context()) {
+        autherr.Write(w, autherr.ErrInvalidToken.WithMessage("Subject token belongs to another tenant"))
+        return
+    }
+    // A bound subject token is checked against the client the calling
+    // service got it from, as on /validate
+    if err := checkBinding(subject, forwardedPresenter(r)); err != nil {
+        autherr.Write(w, err)
+        return
+    }
+
+    // The new token may only carry a subset of the subject token's scopes
+    scopes := subject.Scopes
+    if requested := strings.Fields(r.PostForm.Get("scope")); len(requested) > 0 {
+        for _, s := range requested {
+            if !containsString(subject.Scopes, s) {
+                autherr.Write(w, autherr.ErrInvalidScope.WithMessage("Scope "+s+" exceeds the subject token"))
+                return
+            }
+        }
+        scopes = requested
+    }
+
+    now := clock.Now()
+    expires := now.Add(exchangeTTL)
+    if subjectExpiry := time.Unix(subject.ExpiresAt, 0); subjectExpiry.Before(expires) {
+        expires = subjectExpiry
+    }
+    // A subject token only accepted thanks to clock leeway can't be extended
+    if !expires.After(now) {
+        autherr.Write(w, errTokenExpired)
+        return
+    }
+
+    claims := Claims{
+        ID:        randomHex(16),
+        Subject:   subject.Subject,
+        Email:     subject.Email,
+        Scopes:    scopes,
+        Audience:  audience,
+        Tenant:    subject.Tenant,
+        Actor:     &Actor{Subject: caller.Subject, Actor: subject.Actor},
+        IssuedAt:  now.Unix(),
+        NotBefore: now.Unix(),
+        ExpiresAt: expires.Unix(),
+    }
+    bindToken(r, &claims)
+    token, err := signToken(claims)
+    if err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    recordAudit(r, "token.exchanged", subject.Subject, map[string]string{
+        "actor":    caller.Subject,
+        "audience": audience,
+        "jti":      claims.ID,
+    })
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "access_token":      token,
+        "issued_token_type": tokenTypeJWT,
+        "token_type":        "Bearer",
+        "expires_in":        int(expires.Sub(now).Seconds()),
+        "scope":             strings.Join(scopes, " "),
+    })
+}
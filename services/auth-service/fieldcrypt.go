@@ -0,0 +1,375 @@
+package main
+
+import (
+    "context"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Field-level envelope encryption for personal data at rest: user emails
+// and session client IPs in SQL storage. Each value is sealed with its own
+// random data key, and the data key is wrapped with a master key from the
+// field-encryption-keys secret, one "id:base64-key" per line, active key
+// first. Rotating the master key only re-wraps data keys, so the
+// re-encryption job is cheap; keep a retired key listed until a pass under
+// the new active key (hourly, or on POST /admin/encryption) has finished
+// without errors.
+//
+// Encrypted emails can't be looked up by value, so users also carry a
+// blind index: an HMAC of the email under field-index-key, which must never
+// change. Values written before encryption was switched on are read as-is
+// and sealed by the next job run.
+var (
+    fieldReencryptInterval = getEnvDuration("FIELD_REENCRYPT_INTERVAL", time.Hour)
+    fieldReencryptBatch    = getEnvInt("FIELD_REENCRYPT_BATCH", 200)
+    fieldJob               = &fieldReencryptJob{}
+)
+
+const sealedPrefix = "enc1:"
+
+var errUnknownFieldKey = errors.New("field encrypted with a key that is not in field-encryption-keys")
+
+// fieldKeyring is the master keys and blind index key of one secret
+// generation
+type fieldKeyring struct {
+    active   string
+    keys     map[string]cipher.AEAD
+    indexKey []byte
+    raw      string // for change detection
+}
+
+func parseFieldKeyring(keys, indexKey string) (*fieldKeyring, error) {
+    if indexKey == "" {
+        return nil, fmt.Errorf("field-encryption-keys needs field-index-key")
+    }
+    k := &fieldKeyring{keys: map[string]cipher.AEAD{}, indexKey: []byte(indexKey), raw: keys + "\n" + indexKey}
+    for _, line := range strings.Split(keys, "\n") {
+        line = strings.TrimSpace(line)
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue
+        }
+        id, encoded, ok := strings.Cut(line, ":")
+        if !ok || id == "" {
+            return nil, fmt.Errorf("field-encryption-keys: expected id:base64-key")
+        }
+        key, err := base64.StdEncoding.DecodeString(encoded)
+        if err != nil || len(key) != 32 {
+            return nil, fmt.Errorf("field-encryption-keys: key %s must be 32 bytes of base64", id)
+        }
+        aead, err := newGCM(key)
+        if err != nil {
+            return nil, err
+        }
+        if k.active == "" {
+            k.active = id
+        }
+        k.keys[id] = aead
+    }
+    if k.active == "" {
+        return nil, fmt.Errorf("field-encryption-keys has no keys")
+    }
+    return k, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    return cipher.NewGCM(block)
+}
+
+// The keyring in force, nil when field encryption is off
+func fieldKeys() *fieldKeyring {
+    if set := secrets.get(); set != nil {
+        return set.fieldKeys
+    }
+    return nil
+}
+
+// Seal a value under the active master key. The binding, e.g.
+// "users.email:<id>", is authenticated so a sealed value can't be moved to
+// another row or column. Without a keyring values are stored in the clear.
+func sealField(binding, value string) (string, error) {
+    k := fieldKeys()
+    if k == nil || value == "" {
+        return value, nil
+    }
+    dataKey := make([]byte, 32)
+    rand.Read(dataKey)
+    aead, err := newGCM(dataKey)
+    if err != nil {
+        return "", err
+    }
+    return k.envelope(k.active, dataKey, gcmSeal(aead, []byte(value), binding)), nil
+}
+
+// Build enc1:<key id>:<wrapped data key>:<ciphertext>
+func (k *fieldKeyring) envelope(id string, dataKey, ciphertext []byte) string {
+    wrapped := gcmSeal(k.keys[id], dataKey, id)
+    enc := base64.RawStdEncoding
+    return sealedPrefix + id + ":" + enc.EncodeToString(wrapped) + ":" + enc.EncodeToString(ciphertext)
+}
+
+func gcmSeal(aead cipher.AEAD, plaintext []byte, binding string) []byte {
+    nonce := make([]byte, aead.NonceSize())
+    rand.Read(nonce)
+    return aead.Seal(nonce, nonce, plaintext, []byte(binding))
+}
+
+func gcmOpen(aead cipher.AEAD, sealed []byte, binding string) ([]byte, error) {
+    if len(sealed) < aead.NonceSize() {
+        return nil, errors.New("sealed value too short")
+    }
+    n := aead.NonceSize()
+    return aead.Open(nil, sealed[:n], sealed[n:], []byte(binding))
+}
+
+// Split a sealed value and unwrap its data key
+func (k *fieldKeyring) unwrap(value string) (id string, dataKey, ciphertext []byte, err error) {
+    parts := strings.Split(strings.TrimPrefix(value, sealedPrefix), ":")
+    if len(parts) != 3 {
+        return "", nil, nil, errors.New("malformed sealed value")
+    }
+    id = parts[0]
+    master := k.keys[id]
+    if master == nil {
+        return "", nil, nil, errUnknownFieldKey
+    }
+    enc := base64.RawStdEncoding
+    wrapped, err := enc.DecodeString(parts[1])
+    if err != nil {
+        return "", nil, nil, err
+    }
+    if ciphertext, err = enc.DecodeString(parts[2]); err != nil {
+        return "", nil, nil, err
+    }
+    if dataKey, err = gcmOpen(master, wrapped, id); err != nil {
+        return "", nil, nil, err
+    }
+    return id, dataKey, ciphertext, nil
+}
+
+// Open a value read from storage; values stored in the clear pass through
+func openField(binding, value string) (string, error) {
+    if !strings.HasPrefix(value, sealedPrefix) {
+        return value, nil
+    }
+    k := fieldKeys()
+    if k == nil {
+        return "", errUnknownFieldKey
+    }
+    _, dataKey, ciphertext, err := k.unwrap(value)
+    if err != nil {
+        return "", fmt.Errorf("open %s: %w", binding, err)
+    }
+    aead, err := newGCM(dataKey)
+    if err != nil {
+        return "", err
+    }
+    plaintext, err := gcmOpen(aead, ciphertext, binding)
+    if err != nil {
+        return "", fmt.Errorf("open %s: %w", binding, err)
+    }
+    return string(plaintext), nil
+}
+
+// Bring a stored value under the active key: clear values are sealed,
+// values under a retired key have their data key re-wrapped. It reports
+// whether the value changed.
+func resealField(binding, value string) (string, bool, error) {
+    k := fieldKeys()
+    if k == nil || value == "" {
+        return value, false, nil
+    }
+    if !strings.HasPrefix(value, sealedPrefix) {
+        sealed, err := sealField(binding, value)
+        return sealed, err == nil, err
+    }
+    id, dataKey, ciphertext, err := k.unwrap(value)
+    if err != nil || id == k.active {
+        return value, false, err
+    }
+    return k.envelope(k.active, dataKey, ciphertext), true, nil
+}
+
+// Blind index of an email for lookups, or "" when field encryption is off
+func emailIndex(tenant, email string) string {
+    k := fieldKeys()
+    if k == nil {
+        return ""
+    }
+    mac := hmac.New(sha256.New, k.indexKey)
+    mac.Write([]byte(tenant + "\x00" + email))
+    return hex.EncodeToString(mac.Sum(nil))
+}
+
+// fieldStore is implemented by storage that encrypts fields at rest
+type fieldStore interface {
+    // Reseal up to limit rows of table after the given ID, returning the
+    // last ID seen ("" when the table is done) and the rows changed
+    ResealFields(ctx context.Context, table, after string, limit int) (string, int, error)
+}
+
+var fieldTables = []string{"users", "sessions"}
+
+// FieldReencryptRun reports one pass of the re-encryption job
+type FieldReencryptRun struct {
+    Trigger    string     `json:"trigger"`
+    ActiveKey  string     `json:"activeKey"`
+    StartedAt  time.Time  `json:"startedAt"`
+    FinishedAt *time.Time `json:"finishedAt,omitempty"`
+    Resealed   int        `json:"resealed"`
+    Error      string     `json:"error,omitempty"`
+}
+
+type fieldReencryptJob struct {
+    store fieldStore // nil with storage that doesn't encrypt
+
+    mu      sync.Mutex
+    running bool
+    last    *FieldReencryptRun
+}
+
+// Schedule the job on the leader when storage supports it and a keyring is
+// set
+func startFieldReencryption() {
+    fs, ok := store.(fieldStore)
+    if !ok {
+        return
+    }
+    fieldJob.store = fs
+    if fieldKeys() == nil {
+        return
+    }
+    leader.run("field-reencryption", func(ctx context.Context) {
+        for {
+            if run := fieldJob.start("schedule"); run != nil {
+                fieldJob.execute(run)
+            }
+            select {
+            case <-time.After(fieldReencryptInterval):
+            case <-ctx.Done():
+                return
+            }
+        }
+    })
+}
+
+// Claim the job for a pass, or nil if one is already running
+func (j *fieldReencryptJob) start(trigger string) *FieldReencryptRun {
+    k := fieldKeys()
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    if j.running || j.store == nil || k == nil {
+        return nil
+    }
+    j.running = true
+    j.last = &FieldReencryptRun{Trigger: trigger, ActiveKey: k.active, StartedAt: time.Now()}
+    return j.last
+}
+
+func (j *fieldReencryptJob) execute(run *FieldReencryptRun) {
+    ctx := context.Background()
+    resealed := 0
+    var err error
+    for _, table := range fieldTables {
+        after := ""
+        for err == nil {
+            var n int
+            after, n, err = j.store.ResealFields(ctx, table, after, fieldReencryptBatch)
+            resealed += n
+            if after == "" {
+                break
+            }
+        }
+    }
+
+    j.mu.Lock()
+    now := time.Now()
+    run.FinishedAt, run.Resealed, j.running = &now, resealed, false
+    if err != nil {
+        run.Error = err.Error()
+    }
+    j.mu.Unlock()
+
+    if err != nil {
+        log.Printf("❌ Field re-encryption failed after %d values: %v", resealed, err)
+    } else if resealed > 0 {
+        log.Printf("🔐 Field re-encryption moved %d values to key %s", resealed, run.ActiveKey)
+    }
+    if resealed > 0 || err != nil {
+        details := map[string]string{"activeKey": run.ActiveKey, "resealed": fmt.Sprint(resealed), "trigger": run.Trigger}
+        if err != nil {
+            details["error"] = err.Error()
+        }
+        recordSystemAudit("encryption.resealed", details)
+    }
+}
+
+func (j *fieldReencryptJob) status() map[string]interface{} {
+    j.mu.Lock()
+    defer j.mu.Unlock()
+    status := map[string]interface{}{"enabled": false, "running": j.running, "lastRun": j.last}
+    if k := fieldKeys(); k != nil {
+        ids := make([]string, 0, len(k.keys))
+        for id := range k.keys {
+            ids = append(ids, id)
+        }
+        sort.Strings(ids)
+        status["enabled"] = j.store != nil
+        status["activeKey"] = k.active
+        status["keys"] = ids
+    }
+    return status
+}
+
+// Encryption endpoint: GET shows the keyring and the last re-encryption
+// pass; POST starts a pass now, e.g. right after a new master key is added
+func encryptionHandler(w http.ResponseWriter, r *http.Request) {
+    if err := requireAdmin(r); err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    status := http.StatusOK
+    switch r.Method {
+    case http.MethodGet:
+    case http.MethodPost:
+        if fieldKeys() == nil || fieldJob.store == nil {
+            autherr.Write(w, autherr.ErrNotConfigured.WithMessage("Field encryption is not configured"))
+            return
+        }
+        if run := fieldJob.start("manual"); run != nil {
+            actor := "unknown"
+            if p := principalFromContext(r.Context()); p != nil {
+                actor = p.Subject
+            }
+            recordAudit(r, "encryption.reseal_requested", actor, map[string]string{"activeKey": run.ActiveKey})
+            go fieldJob.execute(run)
+        }
+        status = http.StatusAccepted
+    default:
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(fieldJob.status())
+}
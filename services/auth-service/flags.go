@@ -0,0 +1,173 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Feature flags gating behaviour that is still being rolled out. Each flag
+// starts from its default, can be set by a FEATURE_<NAME> env var, and is
+// overridden by the mounted flags file, which is hot-reloaded.
+var knownFlags = map[string]struct {
+    Default     bool
+    Description string
+}{
+    "strict_validation":       {false, "/authenticate verifies the presented JWT instead of accepting any non-empty token"},
+    "mtls_enforcement":        {false, "Services must authenticate with a SPIFFE SVID; shared service credentials are not accepted as a principal"},
+    "signed_service_requests": {false, "Service credentials are only accepted on HMAC-signed requests with a fresh nonce, not as static headers"},
+    "risk_enforcement":        {false, "Suspicious logins are refused or sent to MFA instead of only being audited"},
+    "audience_enforcement":    {false, "Token validation refuses tokens without an aud claim instead of accepting them"},
+}
+
+var (
+    flagsInterval = getEnvDuration("FLAGS_RELOAD_INTERVAL", 10*time.Second)
+    flags         = &flagSet{file: os.Getenv("FLAGS_FILE")}
+)
+
+// FlagState is the resolved value of one flag
+type FlagState struct {
+    Enabled     bool   `json:"enabled"`
+    Source      string `json:"source"` // default, env or file
+    Description string `json:"description,omitempty"`
+}
+
+type flagSet struct {
+    current atomic.Pointer[map[string]FlagState]
+
+    mu      sync.Mutex
+    file    string
+    modTime time.Time
+}
+
+// Resolve every flag from defaults, environment and the parsed file
+func resolveFlags(file map[string]bool) map[string]FlagState {
+    resolved := make(map[string]FlagState, len(knownFlags))
+    for name, f := range knownFlags {
+        state := FlagState{Enabled: f.Default, Source: "default", Description: f.Description}
+        if v, err := strconv.ParseBool(os.Getenv("FEATURE_" + strings.ToUpper(name))); err == nil {
+            state.Enabled, state.Source = v, "env"
+        }
+        resolved[name] = state
+    }
+    for name, v := range file {
+        state := resolved[name]
+        state.Enabled, state.Source = v, "file"
+        resolved[name] = state
+    }
+    return resolved
+}
+
+// Read a flags file and resolve every flag against it without putting the
+// result in force; no file resolves defaults and environment alone
+func readFlags(file string) (map[string]FlagState, time.Time, error) {
+    if file == "" {
+        return resolveFlags(nil), time.Time{}, nil
+    }
+    info, err := os.Stat(file)
+    if err != nil {
+        return nil, time.Time{}, err
+    }
+    data, err := os.ReadFile(file)
+    if err != nil {
+        return nil, time.Time{}, err
+    }
+    var values map[string]bool
+    if err := json.Unmarshal(data, &values); err != nil {
+        return nil, time.Time{}, fmt.Errorf("invalid flags %s: %w", file, err)
+    }
+    for name := range values {
+        if _, ok := knownFlags[name]; !ok {
+            log.Printf("⚠️  Unknown feature flag %q in %s", name, file)
+        }
+    }
+    return resolveFlags(values), info.ModTime(), nil
+}
+
+// Load the flags file if it changed since the last load. A file that fails
+// to parse is rejected and the previous values stay in force.
+func (f *flagSet) reload() error {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    if f.file == "" {
+        if f.current.Load() == nil {
+            resolved := resolveFlags(nil)
+            f.current.Store(&resolved)
+        }
+        return nil
+    }
+    info, err := os.Stat(f.file)
+    if err != nil {
+        return err
+    }
+    if !info.ModTime().After(f.modTime) {
+        return nil
+    }
+    resolved, modTime, err := readFlags(f.file)
+    if err != nil {
+        return err
+    }
+    f.current.Store(&resolved)
+    f.modTime = modTime
+    log.Printf("🚩 Loaded feature flags from %s", f.file)
+    return nil
+}
+
+// Put flags read from file in force and follow that file from now on
+func (f *flagSet) use(file string, resolved map[string]FlagState, modTime time.Time) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    f.file, f.modTime = file, modTime
+    f.current.Store(&resolved)
+}
+
+// The flags file in force, the flags resolved from it and its modification
+// time
+func (f *flagSet) source() (string, map[string]FlagState, time.Time) {
+    f.mu.Lock()
+    defer f.mu.Unlock()
+    var resolved map[string]FlagState
+    if m := f.current.Load(); m != nil {
+        resolved = *m
+    }
+    return f.file, resolved, f.modTime
+}
+
+func (f *flagSet) watch() {
+    for range time.Tick(flagsInterval) {
+        if err := f.reload(); err != nil {
+            log.Printf("⚠️  Flag reload failed: %v", err)
+        }
+    }
+}
+
+// Enabled reports a flag's current value; before the first load, or for an
+// unknown name, it falls back to the registered default
+func (f *flagSet) Enabled(name string) bool {
+    if m := f.current.Load(); m != nil {
+        if state, ok := (*m)[name]; ok {
+            return state.Enabled
+        }
+    }
+    return knownFlags[name].Default
+}
+
+// Flags endpoint: shows every flag and where its value came from
+func flagsHandler(w http.ResponseWriter, r *http.Request) {
+    file, state, _ := flags.source()
+    if state == nil {
+        state = map[string]FlagState{}
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "source": file,
+        "flags":  state,
+    })
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+    "bytes"
+    "encoding/base64"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "testing"
+    "testing/quick"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Fuzz targets for everything that parses untrusted input. Under plain
+// go test only the seed corpus runs; explore further with e.g.
+//
+//	go test -run '^$' -fuzz FuzzParseToken -fuzztime 30s
+//
+// and commit any crasher that turns up under testdata/fuzz.
+
+func useTestStore(tb testing.TB) {
+    tb.Helper()
+    saved := store
+    store = newMemoryStorage()
+    tb.Cleanup(func() { store = saved })
+}
+
+func useTestFieldKeys(tb testing.TB, keys string) {
+    tb.Helper()
+    k, err := parseFieldKeyring(keys, "test-index-key")
+    if err != nil {
+        tb.Fatal(err)
+    }
+    secrets.mu.Lock()
+    secrets.current.fieldKeys = k
+    secrets.mu.Unlock()
+}
+
+func testFieldKey(b byte) string {
+    return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+// A rejected token is always a 401, never an internal error
+func FuzzParseToken(f *testing.F) {
+    useTestSecrets(f)
+    token, err := signToken(testClaims())
+    if err != nil {
+        f.Fatal(err)
+    }
+    header, payload, _ := strings.Cut(token, ".")
+    for _, seed := range []string{
+        token,
+        token[:len(token)-2] + "AA",
+        header + ".." + payload,
+        base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + payload + ".",
+        header + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"a","act":{"act":{"act":null}},"exp":1e99}`)) + ".sig",
+        "", ".", "..", "a.b.c", "a.b.c.d", "%%%.%%%.%%%",
+    } {
+        f.Add(seed)
+    }
+    f.Fuzz(func(t *testing.T, token string) {
+        claims, err := parseToken(token)
+        if err != nil {
+            if status := autherr.From(err).Status; status != http.StatusUnauthorized {
+                t.Fatalf("rejected with %d: %v", status, err)
+            }
+            return
+        }
+        // Only the seed is validly signed; it must survive a round trip
+        again, err := signToken(*claims)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if got, err := parseToken(again); err != nil || !reflect.DeepEqual(got, claims) {
+            t.Fatalf("re-signed claims differ: %+v, %v", got, err)
+        }
+    })
+}
+
+// The fast claims decoder either agrees with encoding/json or defers to it
+func FuzzDecodeClaims(f *testing.F) {
+    payload, _ := json.Marshal(testClaims())
+    for _, seed := range []string{
+        string(payload),
+        `{"sub":"a","iat":1,"exp":2}`,
+        `{"sub":"a","act":null,"exp":3}`,
+        `{"sub":"a","roles":["x",],"exp":3}`,
+        `{"sub":"a","sub":"b","exp":3}`,
+        `{"exp":-9223372036854775808}`,
+        `{"exp":9223372036854775808}`,
+        `{"act":{"act":{"act":{"sub":"x"}}}}`,
+        `{"cnf":{"x5t#S256":"a","jkt":1}}`,
+        `{"x":[[[[]]]],"y":{"z":{}}}`,
+        `{"sub":"a"} trailing`,
+        `{`, `}`, `[]`, `null`, ``,
+    } {
+        f.Add(seed)
+    }
+    f.Fuzz(func(t *testing.T, s string) {
+        var fast, slow Claims
+        if err := decodeClaims(s, &fast); err != nil {
+            return
+        }
+        if err := json.Unmarshal([]byte(s), &slow); err != nil {
+            t.Fatalf("decoded what encoding/json rejects (%v): %+v", err, fast)
+        }
+        if !reflect.DeepEqual(fast, slow) {
+            t.Fatalf("fast %+v\nslow %+v", fast, slow)
+        }
+    })
+}
+
+// Handlers that decode a JSON body answer malformed input with a 4xx
+func FuzzRequestBodies(f *testing.F) {
+    useTestSecrets(f)
+    useTestStore(f)
+    handlers := map[string]http.HandlerFunc{
+        "/register":            registerHandler,
+        "/login":               loginHandler,
+        "/verify-email":        verifyEmailHandler,
+        "/resend-verification": resendVerificationHandler,
+        "/device/code":         deviceCodeHandler,
+        "/device/token":        deviceTokenHandler,
+        "/login/magic-link":    magicLinkHandler,
+        "/token/exchange":      tokenExchangeHandler,
+        "/impersonate":         impersonateHandler,
+        "/validate/batch":      validateBatchHandler,
+        "/users":               usersHandler,
+        "/admin/revocations":   revocationsHandler,
+    }
+    admin := &Principal{Subject: "admin-1", Kind: "user", Tenant: defaultTenant, Roles: []string{"admin"}}
+    token, _ := signToken(testClaims())
+    for _, seed := range []string{
+        `{"email":"user@example.com","password":"password1"}`,
+        `{"email":["x"],"password":{}}`,
+        `{"userId":"user-1","all":true}`,
+        `{"before":"not a time","all":true}`,
+        `{"subject_token":"` + token + `","audience":"api-service"}`,
+        `{"tokens":["` + token + `","x",null]}`,
+        `{"tokens":` + strings.Repeat("[", 64) + `}`,
+        `{"email":"\u0000@\ud800","roles":[""]}`,
+        `null`, `[]`, `""`, `{`, ``,
+    } {
+        f.Add(seed)
+    }
+    f.Fuzz(func(t *testing.T, body string) {
+        for path, handler := range handlers {
+            req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+            req.Header.Set("Content-Type", "application/json")
+            req = req.WithContext(withPrincipal(req.Context(), admin))
+            rec := httptest.NewRecorder()
+            handler(rec, req)
+            if rec.Code >= 500 {
+                t.Fatalf("%s answered %d: %s", path, rec.Code, rec.Body)
+            }
+        }
+    })
+}
+
+// Only valid credentials in the headers resolve to a principal
+func FuzzPrincipalFromHeaders(f *testing.F) {
+    useTestSecrets(f)
+    useTestStore(f)
+    token, _ := signToken(testClaims())
+    f.Add("Bearer "+token, "", "", "", "")
+    f.Add("Bearer "+token[:len(token)-1], "", "", "", "")
+    f.Add("Bearer ", "", "", "", "")
+    f.Add("Basic dXNlcjpwYXNz", "", "", "", "")
+    f.Add("", "key-unknown", "", "", "")
+    f.Add("", "", "test-service-token", "test-api-key", "")
+    f.Add("", "", "test-service-token", "", "zz")
+    f.Add("", "", "test-service-token", "", strings.Repeat("ab", 32))
+    f.Fuzz(func(t *testing.T, authorization, apiKey, serviceToken, internalKey, signature string) {
+        req := httptest.NewRequest(http.MethodGet, "/validate", nil)
+        for name, value := range map[string]string{
+            "Authorization":      authorization,
+            "X-API-Key":          apiKey,
+            "X-Service-Token":    serviceToken,
+            "X-Internal-API-Key": internalKey,
+            "X-Auth-Signature":   signature,
+            "X-Auth-Timestamp":   "1700000000",
+            "X-Auth-Nonce":       "0123456789abcdef",
+        } {
+            if value != "" {
+                req.Header.Set(name, value)
+            }
+        }
+        p := principalFromHeaders(req, []string{"image-service"})
+        if p == nil {
+            return
+        }
+        switch {
+        case strings.HasPrefix(authorization, "Bearer "):
+            if _, err := parseToken(strings.TrimPrefix(authorization, "Bearer ")); err != nil {
+                t.Fatalf("principal %+v from a token that doesn't verify: %v", p, err)
+            }
+        case apiKey != "":
+            t.Fatalf("principal %+v from unknown API key %q", p, apiKey)
+        case serviceToken != "test-service-token" || signature == "" && internalKey != "test-api-key":
+            t.Fatalf("principal %+v from wrong service credentials", p)
+        }
+    })
+}
+
+// A sealed value opens to itself under the same binding and to nothing
+// under another, even once its key is retired
+func TestFieldSealRoundTripProperty(t *testing.T) {
+    useTestSecrets(t)
+    useTestFieldKeys(t, "k1:"+testFieldKey(1))
+    roundTrip := func(binding, value string) bool {
+        useTestFieldKeys(t, "k1:"+testFieldKey(1))
+        sealed, err := sealField(binding, value)
+        if err != nil {
+            return false
+        }
+        if value != "" && (sealed == value || !strings.HasPrefix(sealed, sealedPrefix+"k1:")) {
+            return false
+        }
+        if opened, err := openField(binding, sealed); err != nil || opened != value {
+            return false
+        }
+        if value != "" {
+            if _, err := openField(binding+"x", sealed); err == nil {
+                return false
+            }
+        }
+
+        // Rotate: k2 active, k1 retired
+        useTestFieldKeys(t, "k2:"+testFieldKey(2)+"\nk1:"+testFieldKey(1))
+        resealed, changed, err := resealField(binding, sealed)
+        if err != nil || changed != (value != "") {
+            return false
+        }
+        opened, err := openField(binding, resealed)
+        return err == nil && opened == value
+    }
+    if err := quick.Check(roundTrip, nil); err != nil {
+        t.Fatal(err)
+    }
+}
+
+// Whatever the claims, a signed token parses back to them
+func TestTokenRoundTripProperty(t *testing.T) {
+    useTestSecrets(t)
+    now := time.Unix(1700000000, 0)
+    useTestClock(t, now)
+    roundTrip := func(id, subject, email, audience string, roles, scopes []string, actor string, ttl uint16) bool {
+        want := Claims{
+            ID:        id,
+            Subject:   subject,
+            Email:     email,
+            Roles:     roles,
+            Scopes:    scopes,
+            Audience:  audience,
+            IssuedAt:  now.Unix(),
+            ExpiresAt: now.Add(time.Duration(ttl)*time.Second + time.Second).Unix(),
+        }
+        if actor != "" {
+            want.Actor = &Actor{Subject: actor}
+        }
+        // Empty lists are omitted from the token
+        if len(want.Roles) == 0 {
+            want.Roles = nil
+        }
+        if len(want.Scopes) == 0 {
+            want.Scopes = nil
+        }
+        token, err := signToken(want)
+        if err != nil {
+            return false
+        }
+        got, err := parseToken(token)
+        return err == nil && reflect.DeepEqual(*got, want)
+    }
+    if err := quick.Check(roundTrip, nil); err != nil {
+        t.Fatal(err)
+    }
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Impersonation lets support staff act as a user to reproduce a problem.
+// The token carries the user's identity plus an impersonated_by claim naming
+// the admin, every audit event raised with it records that admin, and it is
+// backed by a session so DELETE /impersonate/{jti} revokes it at once rather
+// than when it expires. Impersonation sessions are told apart from login
+// sessions by their ID prefix. Admins can't be impersonated, and an
+// impersonation token can't start another impersonation or be exchanged.
+var (
+    impersonationTTL    = getEnvDuration("IMPERSONATION_TTL", 15*time.Minute)
+    impersonationMaxTTL = getEnvDuration("IMPERSONATION_MAX_TTL", time.Hour)
+)
+
+const impersonationPrefix = "imp-"
+
+var errImpersonationRevoked = autherr.ErrInvalidToken.WithMessage("Impersonation has ended")
+
+// Verify a token and, for impersonation tokens, that their session is still
+// open. Plain tokens stay stateless; only the short-lived, rare
+// impersonation tokens cost a storage lookup. Callers go through
+// verifyToken, which caches the result.
+func verifyTokenUncached(ctx context.Context, token string) (*Claims, error) {
+    claims, err := parseToken(token)
+    if err != nil {
+        return nil, err
+    }
+    if claims.ImpersonatedBy == "" {
+        return claims, nil
+    }
+    if !strings.HasPrefix(claims.ID, impersonationPrefix) {
+        return nil, errImpersonationRevoked
+    }
+    session, err := store.GetSession(ctx, claims.ID)
+    if errors.Is(err, errSessionNotFound) {
+        return nil, errImpersonationRevoked
+    }
+    if err != nil {
+        log.Printf("❌ Impersonation session lookup failed: %v", err)
+        return nil, autherr.ErrInternal
+    }
+    if session.UserID != claims.Subject {
+        return nil, errImpersonationRevoked
+    }
+    return claims, nil
+}
+
+// Admins, or callers with the users:impersonate scope, may impersonate
+func authorizeImpersonation(r *http.Request) (*Principal, error) {
+    p := principalFromContext(r.Context())
+    if p == nil {
+        return nil, autherr.ErrUnauthenticated
+    }
+    if p.ImpersonatedBy != "" {
+        return nil, autherr.ErrForbidden.WithMessage("Impersonation tokens cannot impersonate")
+    }
+    if !p.HasRole("admin") && !p.HasScope("users:impersonate") {
+        return nil, autherr.ErrForbidden.WithMessage("Missing users:impersonate scope")
+    }
+    return p, nil
+}
+
+// Impersonation endpoint: POST {"userId": "...", "reason": "...", "ttl": "10m"}
+// issues a token for the user; DELETE /impersonate/{jti} ends one early
+func impersonateHandler(w http.ResponseWriter, r *http.Request) {
+    admin, err := authorizeImpersonation(r)
+    if err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    switch r.Method {
+    case http.MethodPost:
+        if r.URL.Path != "/impersonate" {
+            autherr.Write(w, autherr.ErrNotFound)
+            return
+        }
+        startImpersonation(w, r, admin)
+    case http.MethodDelete:
+        id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/impersonate"), "/")
+        if !strings.HasPrefix(id, impersonationPrefix) || strings.Contains(id, "/") {
+            autherr.Write(w, errSessionNotFound)
+            return
+        }
+        endImpersonation(w, r, admin, id)
+    default:
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+    }
+}
+
+func startImpersonation(w http.ResponseWriter, r *http.Request, admin *Principal) {
+    var req struct {
+        UserID string `json:"userId"`
+        Reason string `json:"reason"`
+        TTL    string `json:"ttl"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == "" {
+        autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("userId is required"))
+        return
+    }
+    // The reason is what makes the audit trail useful after the fact
+    if strings.TrimSpace(req.Reason) == "" {
+        autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("reason is required"))
+        return
+    }
+    ttl := impersonationTTL
+    if req.TTL != "" {
+        d, err := time.ParseDuration(req.TTL)
+        if err != nil || d <= 0 || d > impersonationMaxTTL {
+            autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("ttl must be a duration up to "+impersonationMaxTTL.String()))
+            return
+        }
+        ttl = d
+    }
+
+    user, err := store.GetUser(r.Context(), req.UserID)
+    if err == nil && user.Tenant != tenantFromContext(r.Context()) {
+        err = errUserNotFound
+    }
+    if err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    if containsString(user.Roles, "admin") {
+        autherr.Write(w, autherr.ErrForbidden.WithMessage("Admins cannot be impersonated"))
+        return
+    }
+    if user.Status != UserStatusActive {
+        autherr.Write(w, autherr.ErrForbidden.WithMessage("Only active users can be impersonated"))
+        return
+    }
+
+    now := clock.Now()
+    session := &Session{
+        ID:        impersonationPrefix + randomHex(16),
+        UserID:    user.ID,
+        ClientIP:  clientIP(r).String(),
+        CreatedAt: now,
+        ExpiresAt: now.Add(ttl),
+    }
+    if err := store.CreateSession(r.Context(), session); err != nil {
+        log.Printf("❌ Session create failed: %v", err)
+        autherr.Write(w, autherr.ErrInternal)
+        return
+    }
+    claims := Claims{
+        ID:             session.ID,
+        Subject:        user.ID,
+        Email:          user.Email,
+        Roles:          user.Roles,
+        Audience:       tokenAudiences[0],
+        Tenant:         claimTenant(user.Tenant),
+        ImpersonatedBy: admin.Subject,
+        IssuedAt:       now.Unix(),
+        NotBefore:      now.Unix(),
+        ExpiresAt:      session.ExpiresAt.Unix(),
+    }
+    bindToken(r, &claims)
+    token, err := signToken(claims)
+    if err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    log.Printf("🎭 %s is impersonating %s until %s: %s", admin.Subject, user.ID, session.ExpiresAt.Format(time.RFC3339), req.Reason)
+    recordAudit(r, "impersonation.started", user.ID, map[string]string{
+        "impersonatedBy": admin.Subject,
+        "reason":         req.Reason,
+        "jti":            session.ID,
+        "expiresAt":      session.ExpiresAt.Format(time.RFC3339),
+    })
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "token":          token,
+        "tokenType":      "Bearer",
+        "expiresIn":      int(ttl.Seconds()),
+        "jti":            session.ID,
+        "impersonatedBy": admin.Subject,
+    })
+}
+
+func endImpersonation(w http.ResponseWriter, r *http.Request, admin *Principal, id string) {
+    session, err := store.GetSession(r.Context(), id)
+    if err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    if user, err := store.GetUser(r.Context(), session.UserID); err != nil || user.Tenant != tenantFromContext(r.Context()) {
+        autherr.Write(w, errSessionNotFound)
+        return
+    }
+    if err := store.DeleteSession(r.Context(), id); err != nil {
+        log.Printf("❌ Session delete failed: %v", err)
+        autherr.Write(w, autherr.ErrInternal)
+        return
+    }
+    log.Printf("🎭 Impersonation %s of %s ended by %s", id, session.UserID, admin.Subject)
+    recordAudit(r, "impersonation.ended", session.UserID, map[string]string{
+        "endedBy": admin.Subject,
+        "jti":     id,
+    })
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,85 @@
+// Package autherr defines the auth service's error taxonomy. Every failure
+// surfaced to a caller carries a stable machine-readable code and a fixed
+// HTTP status, so downstream services can branch on err.code instead of
+// parsing messages.
+package autherr
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "net/http"
+)
+
+// Error is a typed API error. Two errors are considered equal by errors.Is
+// when their codes match, so a message-specific variant created with
+// WithMessage still matches the sentinel it was derived from.
+type Error struct {
+    Status  int    `json:"-"`
+    Code    string `json:"code"`
+    Message string `json:"message"`
+}
+
+func New(status int, code, message string) *Error {
+    return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+    return e.Code + ": " + e.Message
+}
+
+func (e *Error) Is(target error) bool {
+    t, ok := target.(*Error)
+    return ok && t.Code == e.Code
+}
+
+// WithMessage returns a copy of e with a more specific message
+func (e *Error) WithMessage(message string) *Error {
+    return &Error{Status: e.Status, Code: e.Code, Message: message}
+}
+
+var (
+    ErrInvalidRequest      = New(http.StatusBadRequest, "invalid_request", "Request is malformed or missing required fields")
+    ErrUnauthenticated     = New(http.StatusUnauthorized, "unauthenticated", "Authentication required")
+    ErrInvalidCredentials  = New(http.StatusUnauthorized, "invalid_credentials", "Invalid credentials")
+    ErrInvalidToken        = New(http.StatusUnauthorized, "invalid_token", "Token is invalid")
+    ErrExpiredToken        = New(http.StatusUnauthorized, "token_expired", "Token has expired")
+    ErrMFARequired         = New(http.StatusUnauthorized, "mfa_required", "Additional verification is required to sign in")
+    ErrInvalidScope        = New(http.StatusBadRequest, "invalid_scope", "Requested scope is not allowed")
+    ErrForbidden           = New(http.StatusForbidden, "forbidden", "Access denied")
+    ErrUnverified          = New(http.StatusForbidden, "email_not_verified", "Email address not verified")
+    ErrAccountDisabled     = New(http.StatusForbidden, "account_disabled", "Account is disabled")
+    ErrNotFound            = New(http.StatusNotFound, "not_found", "Resource not found")
+    ErrMethodNotAllowed    = New(http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+    ErrConflict            = New(http.StatusConflict, "conflict", "Resource already exists")
+    ErrLocked              = New(http.StatusLocked, "account_locked", "Account is locked")
+    ErrRateLimited         = New(http.StatusTooManyRequests, "rate_limited", "Too many requests")
+    ErrInternal            = New(http.StatusInternalServerError, "internal_error", "Internal server error")
+    ErrNotConfigured       = New(http.StatusServiceUnavailable, "not_configured", "Service is not configured")
+    ErrUpstreamUnavailable = New(http.StatusServiceUnavailable, "upstream_unavailable", "Upstream service unavailable")
+    ErrMaintenance         = New(http.StatusServiceUnavailable, "maintenance", "Service is in maintenance mode")
+    ErrOverloaded          = New(http.StatusServiceUnavailable, "overloaded", "Service is overloaded, retry later")
+    ErrTimeout             = New(http.StatusGatewayTimeout, "timeout", "Request did not complete in time")
+)
+
+// From converts any error into an *Error, treating unknown errors as
+// internal and an expired context deadline as a timeout
+func From(err error) *Error {
+    var e *Error
+    if errors.As(err, &e) {
+        return e
+    }
+    if errors.Is(err, context.DeadlineExceeded) {
+        return ErrTimeout
+    }
+    return ErrInternal
+}
+
+// Write renders err as {"error": {"code": ..., "message": ...}}
+func Write(w http.ResponseWriter, err error) {
+    e := From(err)
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("X-Content-Type-Options", "nosniff")
+    w.WriteHeader(e.Status)
+    json.NewEncoder(w).Encode(map[string]*Error{"error": e})
+}
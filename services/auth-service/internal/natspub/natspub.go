@@ -0,0 +1,124 @@
+// Package natspub is a publish-only client for the NATS text protocol. It
+// covers what the outbox relay needs (CONNECT with optional credentials,
+// PUB, and PING/PONG to confirm the server has everything written so far)
+// without pulling in the full client library.
+package natspub
+
+import (
+    "bufio"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Conn is one connection to a NATS server. It is not safe for concurrent use.
+type Conn struct {
+    conn    net.Conn
+    r       *bufio.Reader
+    w       *bufio.Writer
+    timeout time.Duration
+}
+
+// Dial connects to a nats://[user:pass@]host:port or nats://token@host:port
+// URL and completes the handshake
+func Dial(rawURL, name string, timeout time.Duration) (*Conn, error) {
+    u, err := url.Parse(rawURL)
+    if err != nil || u.Host == "" {
+        return nil, fmt.Errorf("natspub: invalid URL %q", rawURL)
+    }
+    host := u.Host
+    if u.Port() == "" {
+        host = net.JoinHostPort(u.Hostname(), "4222")
+    }
+    nc, err := net.DialTimeout("tcp", host, timeout)
+    if err != nil {
+        return nil, err
+    }
+    c := &Conn{conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc), timeout: timeout}
+
+    // The server speaks first with INFO
+    nc.SetDeadline(time.Now().Add(timeout))
+    line, err := c.readLine()
+    if err != nil {
+        nc.Close()
+        return nil, err
+    }
+    if !strings.HasPrefix(line, "INFO ") {
+        nc.Close()
+        return nil, fmt.Errorf("natspub: unexpected greeting %q", line)
+    }
+
+    opts := map[string]interface{}{"verbose": false, "pedantic": false, "name": name, "lang": "go", "protocol": 0}
+    if u.User != nil {
+        if pass, ok := u.User.Password(); ok {
+            opts["user"], opts["pass"] = u.User.Username(), pass
+        } else {
+            opts["auth_token"] = u.User.Username()
+        }
+    }
+    connect, _ := json.Marshal(opts)
+    fmt.Fprintf(c.w, "CONNECT %s\r\n", connect)
+    if err := c.Flush(); err != nil {
+        nc.Close()
+        return nil, err
+    }
+    return c, nil
+}
+
+// Publish buffers a message; call Flush to send it and confirm receipt
+func (c *Conn) Publish(subject string, data []byte) error {
+    if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+        return fmt.Errorf("natspub: invalid subject %q", subject)
+    }
+    c.w.WriteString("PUB " + subject + " " + strconv.Itoa(len(data)) + "\r\n")
+    c.w.Write(data)
+    _, err := c.w.WriteString("\r\n")
+    return err
+}
+
+// Flush writes everything buffered and waits for the server's PONG, which
+// it only sends after processing every preceding message
+func (c *Conn) Flush() error {
+    c.conn.SetDeadline(time.Now().Add(c.timeout))
+    defer c.conn.SetDeadline(time.Time{})
+
+    c.w.WriteString("PING\r\n")
+    if err := c.w.Flush(); err != nil {
+        return err
+    }
+    for {
+        line, err := c.readLine()
+        if err != nil {
+            return err
+        }
+        switch {
+        case line == "PONG":
+            return nil
+        case line == "PING":
+            c.w.WriteString("PONG\r\n")
+            if err := c.w.Flush(); err != nil {
+                return err
+            }
+        case strings.HasPrefix(line, "-ERR"):
+            return errors.New("natspub: server error: " + strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+        }
+        // +OK and async INFO updates are ignored
+    }
+}
+
+func (c *Conn) Close() error {
+    return c.conn.Close()
+}
+
+func (c *Conn) readLine() (string, error) {
+    line, err := c.r.ReadString('\n')
+    if err != nil {
+        return "", err
+    }
+    return strings.TrimRight(line, "\r\n"), nil
+}
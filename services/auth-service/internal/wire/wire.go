@@ -0,0 +1,111 @@
+// Package wire appends Protocol Buffers and MessagePack encodings of flat
+// response messages to a byte slice. It covers the handful of scalar types
+// the service's responses use without pulling in either library or code
+// generation; the message layouts are defined in proto/auth.proto.
+package wire
+
+import (
+    "encoding/binary"
+    "math"
+    "time"
+)
+
+// Protocol Buffers wire types
+const (
+    protoVarint = 0
+    protoBytes  = 2
+)
+
+func appendVarint(b []byte, v uint64) []byte {
+    for v >= 0x80 {
+        b = append(b, byte(v)|0x80)
+        v >>= 7
+    }
+    return append(b, byte(v))
+}
+
+func appendTag(b []byte, field, wireType int) []byte {
+    return appendVarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+// ProtoBool appends a bool field. As in proto3, false is the default and
+// is left out.
+func ProtoBool(b []byte, field int, v bool) []byte {
+    if !v {
+        return b
+    }
+    return append(appendTag(b, field, protoVarint), 1)
+}
+
+// ProtoInt64 appends an int64 field, leaving out zero
+func ProtoInt64(b []byte, field int, v int64) []byte {
+    if v == 0 {
+        return b
+    }
+    return appendVarint(appendTag(b, field, protoVarint), uint64(v))
+}
+
+// ProtoString appends a string field, leaving out ""
+func ProtoString(b []byte, field int, s string) []byte {
+    if s == "" {
+        return b
+    }
+    b = appendVarint(appendTag(b, field, protoBytes), uint64(len(s)))
+    return append(b, s...)
+}
+
+// ProtoMessage appends an embedded message field already encoded in msg
+func ProtoMessage(b []byte, field int, msg []byte) []byte {
+    b = appendVarint(appendTag(b, field, protoBytes), uint64(len(msg)))
+    return append(b, msg...)
+}
+
+// ProtoTimestamp appends a google.protobuf.Timestamp field
+func ProtoTimestamp(b []byte, field int, t time.Time) []byte {
+    var ts [24]byte
+    msg := ProtoInt64(ts[:0], 1, t.Unix())
+    msg = ProtoInt64(msg, 2, int64(t.Nanosecond()))
+    return ProtoMessage(b, field, msg)
+}
+
+// MsgpackMap appends the header of a map with n entries
+func MsgpackMap(b []byte, n int) []byte {
+    switch {
+    case n < 16:
+        return append(b, 0x80|byte(n))
+    case n <= math.MaxUint16:
+        return binary.BigEndian.AppendUint16(append(b, 0xde), uint16(n))
+    default:
+        return binary.BigEndian.AppendUint32(append(b, 0xdf), uint32(n))
+    }
+}
+
+// MsgpackString appends a str
+func MsgpackString(b []byte, s string) []byte {
+    switch n := len(s); {
+    case n < 32:
+        b = append(b, 0xa0|byte(n))
+    case n <= math.MaxUint8:
+        b = append(b, 0xd9, byte(n))
+    case n <= math.MaxUint16:
+        b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+    default:
+        b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+    }
+    return append(b, s...)
+}
+
+// MsgpackBool appends true or false
+func MsgpackBool(b []byte, v bool) []byte {
+    if v {
+        return append(b, 0xc3)
+    }
+    return append(b, 0xc2)
+}
+
+// MsgpackTime appends the timestamp extension type (-1) in its 96-bit form
+func MsgpackTime(b []byte, t time.Time) []byte {
+    b = append(b, 0xc7, 12, 0xff)
+    b = binary.BigEndian.AppendUint32(b, uint32(t.Nanosecond()))
+    return binary.BigEndian.AppendUint64(b, uint64(t.Unix()))
+}
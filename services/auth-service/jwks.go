@@ -0,0 +1,136 @@
+package main
+
+import (
+    "crypto/ed25519"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "net/http"
+
+    "auth-service/internal/autherr"
+)
+
+// With a token-signing-key secret (PKCS#8 PEM Ed25519) tokens are signed
+// with EdDSA instead of HS256, and the public keys are served as a JWKS at
+// /.well-known/jwks.json so downstream services can verify tokens locally
+// (see pkg/tokenverify) instead of calling /validate on every request. As
+// with the HMAC secret, each tenant signs with its own key derived from the
+// master, so a token never verifies for another tenant. HS256 tokens issued
+// before the key was added keep verifying here until they expire.
+
+// edKey is one tenant's Ed25519 signing key
+type edKey struct {
+    priv   ed25519.PrivateKey
+    kid    string            // RFC 7638 thumbprint
+    jwk    map[string]string // public JWK
+    header string            // encoded JOSE header naming the key
+}
+
+func newEdKey(priv ed25519.PrivateKey) *edKey {
+    jwk, kid := attestationJWK(priv.Public().(ed25519.PublicKey))
+    jwk["kid"], jwk["alg"], jwk["use"] = kid, "EdDSA", "sig"
+    header, _ := json.Marshal(map[string]string{"alg": "EdDSA", "typ": "JWT", "kid": kid})
+    return &edKey{priv: priv, kid: kid, jwk: jwk, header: base64.RawURLEncoding.EncodeToString(header)}
+}
+
+// The default tenant signs with the master key; other tenants with a key
+// seeded from HMAC(master seed, tenant)
+func tenantEdKeys(master ed25519.PrivateKey) map[string]*edKey {
+    keys := make(map[string]*edKey, len(tenants))
+    for tenant := range tenants {
+        priv := master
+        if tenant != defaultTenant {
+            mac := hmac.New(sha256.New, master.Seed())
+            mac.Write([]byte("tenant:" + tenant))
+            priv = ed25519.NewKeyFromSeed(mac.Sum(nil))
+        }
+        keys[tenant] = newEdKey(priv)
+    }
+    return keys
+}
+
+func signEdDSA(key *edKey, claims Claims) (string, error) {
+    payload, err := json.Marshal(claims)
+    if err != nil {
+        return "", err
+    }
+    signed := key.header + "." + base64.RawURLEncoding.EncodeToString(payload)
+    sig := ed25519.Sign(key.priv, []byte(signed))
+    return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// Verify an EdDSA token already split at its dots. Unlike HS256 tokens these
+// are decoded with encoding/json: services holding them are expected to
+// verify locally against the JWKS, so this path isn't hot.
+func parseEdDSAToken(token string, dot1, dot2 int) (*Claims, error) {
+    enc := base64.RawURLEncoding
+    headerJSON, err := enc.DecodeString(token[:dot1])
+    if err != nil {
+        return nil, errMalformedToken
+    }
+    var header struct {
+        Alg string `json:"alg"`
+        Kid string `json:"kid"`
+    }
+    if err := json.Unmarshal(headerJSON, &header); err != nil || header.Alg != "EdDSA" || header.Kid == "" {
+        return nil, errMalformedToken
+    }
+    sig, err := enc.DecodeString(token[dot2+1:])
+    if err != nil || len(sig) != ed25519.SignatureSize {
+        return nil, errBadSignature
+    }
+    payload, err := enc.DecodeString(token[dot1+1 : dot2])
+    if err != nil {
+        return nil, errMalformedToken
+    }
+    claims := &Claims{}
+    if err := json.Unmarshal(payload, claims); err != nil {
+        return nil, errMalformedToken
+    }
+
+    current, previous := secrets.accepted()
+    tenant := tokenTenant(claims)
+    verified := false
+    for _, set := range [2]*secretSet{current, previous} {
+        if set == nil {
+            continue
+        }
+        if key := set.tokenKeys[tenant]; key != nil && key.kid == header.Kid {
+            verified = ed25519.Verify(key.priv.Public().(ed25519.PublicKey), []byte(token[:dot2]), sig)
+            break
+        }
+    }
+    if !verified {
+        return nil, errBadSignature
+    }
+    if err := checkTokenTimes(claims); err != nil {
+        return nil, err
+    }
+    return claims, nil
+}
+
+// JWKS endpoint: the public token keys of the request's tenant, including
+// the previous generation while its rotation grace period lasts. Served
+// with an ETag so verifiers revalidate rather than refetch.
+func jwksHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet && r.Method != http.MethodHead {
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+    tenant := tenantFromContext(r.Context())
+    keys := []map[string]string{}
+    current, previous := secrets.accepted()
+    for _, set := range [2]*secretSet{current, previous} {
+        if set == nil {
+            continue
+        }
+        if key := set.tokenKeys[tenant]; key != nil && (len(keys) == 0 || keys[0]["kid"] != key.kid) {
+            keys = append(keys, key.jwk)
+        }
+    }
+    w.Header().Set("Content-Type", "application/jwk-set+json")
+    // Verifiers refetch on an unknown kid, so a short max-age is enough
+    w.Header().Set("Cache-Control", "public, max-age=300")
+    json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+// Leader election for the background jobs that should run on one replica
+// only: the outbox relay (publishing and pruning), the scheduled field
+// re-encryption pass and the retention purge. With LEADER_ELECTION=true the replicas compete for a
+// coordination.k8s.io Lease, following client-go's leaderelection rules: the
+// holder renews every LEADER_RETRY_PERIOD, gives up if it can't renew within
+// LEADER_RENEW_DEADLINE, and the others take over once the lease has gone
+// LEADER_LEASE_DURATION without a renewal they have seen. Expiry is judged
+// by when a replica last saw the lease change, not by the timestamps in it,
+// so clock skew between nodes doesn't matter.
+//
+// Only a ready replica (see readinessProblems) campaigns or renews, and a
+// leader that stops being ready, e.g. because it is draining for shutdown,
+// releases the lease so another takes over at once instead of after it
+// expires. Without LEADER_ELECTION every replica runs the jobs, which is
+// right for a single replica or local runs.
+var (
+    leaderElection = getEnv("LEADER_ELECTION", "false") == "true"
+    leader         = &leaderElector{
+        lease:         getEnv("LEADER_ELECTION_LEASE", "auth-service-leader"),
+        namespace:     getEnv("LEADER_ELECTION_NAMESPACE", serviceAccountNamespace()),
+        identity:      getEnv("POD_NAME", hostname()),
+        api:           getEnv("LEADER_ELECTION_API", inClusterAPI()),
+        leaseDuration: getEnvDuration("LEADER_LEASE_DURATION", 15*time.Second),
+        renewDeadline: getEnvDuration("LEADER_RENEW_DEADLINE", 10*time.Second),
+        retryPeriod:   getEnvDuration("LEADER_RETRY_PERIOD", 2*time.Second),
+    }
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Lease is the part of a coordination.k8s.io/v1 Lease the election uses
+type Lease struct {
+    APIVersion string        `json:"apiVersion"`
+    Kind       string        `json:"kind"`
+    Metadata   LeaseMetadata `json:"metadata"`
+    Spec       LeaseSpec     `json:"spec"`
+}
+
+type LeaseMetadata struct {
+    Name            string `json:"name"`
+    Namespace       string `json:"namespace,omitempty"`
+    ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type LeaseSpec struct {
+    HolderIdentity       string `json:"holderIdentity"`
+    LeaseDurationSeconds int    `json:"leaseDurationSeconds"`
+    AcquireTime          string `json:"acquireTime,omitempty"`
+    RenewTime            string `json:"renewTime,omitempty"`
+    LeaseTransitions     int    `json:"leaseTransitions"`
+}
+
+// Kubernetes MicroTime
+const microTime = "2006-01-02T15:04:05.000000Z07:00"
+
+var errLeaseConflict = errors.New("lease was updated by another replica")
+
+// leaderJob runs while this replica leads, until ctx is cancelled
+type leaderJob struct {
+    name string
+    run  func(ctx context.Context)
+}
+
+type leaderElector struct {
+    lease         string
+    namespace     string
+    identity      string
+    api           string
+    leaseDuration time.Duration
+    renewDeadline time.Duration
+    retryPeriod   time.Duration
+    client        *http.Client
+
+    mu           sync.Mutex
+    jobs         []leaderJob
+    cancel       context.CancelFunc // stops the jobs; nil while not leading
+    done         chan struct{}      // closed once the last term's jobs return
+    leading      bool
+    holder       string
+    since        time.Time
+    lastRenew    time.Time
+    observed     LeaseSpec
+    observedTime time.Time
+    lastErr      string
+
+    transitions atomic.Int64
+}
+
+// Run job on the leader only, starting it whenever this replica becomes
+// leader and cancelling it when leadership is lost. Register before start.
+func (e *leaderElector) run(name string, job func(ctx context.Context)) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    e.jobs = append(e.jobs, leaderJob{name: name, run: job})
+}
+
+func (e *leaderElector) start() {
+    if !leaderElection {
+        log.Printf("👑 Leader election off; running background jobs here")
+        e.setLeading(true)
+        return
+    }
+    if e.api == "" || e.namespace == "" {
+        log.Fatalf("❌ LEADER_ELECTION needs the Kubernetes API; set LEADER_ELECTION_API and LEADER_ELECTION_NAMESPACE outside a cluster")
+    }
+    if e.renewDeadline >= e.leaseDuration || e.retryPeriod >= e.renewDeadline {
+        log.Fatalf("❌ Leader election needs LEADER_RETRY_PERIOD < LEADER_RENEW_DEADLINE < LEADER_LEASE_DURATION")
+    }
+    client, err := apiServerClient()
+    if err != nil {
+        log.Fatalf("❌ Kubernetes API client: %v", err)
+    }
+    e.client = client
+    log.Printf("🗳️  Competing for lease %s/%s as %s", e.namespace, e.lease, e.identity)
+    go e.loop()
+}
+
+func (e *leaderElector) loop() {
+    for range time.Tick(e.retryPeriod) {
+        e.tryAcquireOrRenew()
+    }
+}
+
+func (e *leaderElector) tryAcquireOrRenew() {
+    ready := len(readinessProblems(context.Background())) == 0
+    ctx, cancel := context.WithTimeout(context.Background(), e.retryPeriod)
+    defer cancel()
+    now := time.Now()
+
+    lease, err := e.get(ctx)
+    if err != nil {
+        e.failed(now, err)
+        return
+    }
+    if lease == nil {
+        if !ready {
+            return
+        }
+        lease = &Lease{
+            APIVersion: "coordination.k8s.io/v1",
+            Kind:       "Lease",
+            Metadata:   LeaseMetadata{Name: e.lease, Namespace: e.namespace},
+            Spec:       e.claim(now, 0),
+        }
+        if err := e.write(ctx, http.MethodPost, lease); err != nil {
+            e.failed(now, err)
+            return
+        }
+        e.won(now, lease.Spec.HolderIdentity)
+        return
+    }
+
+    holder := lease.Spec.HolderIdentity
+    e.mu.Lock()
+    if holder != e.observed.HolderIdentity || lease.Spec.RenewTime != e.observed.RenewTime {
+        e.observed, e.observedTime = lease.Spec, now
+    }
+    e.holder = holder
+    expired := holder == "" || now.After(e.observedTime.Add(e.leaseDuration))
+    e.mu.Unlock()
+
+    switch {
+    case holder == e.identity && !ready:
+        // Step aside rather than keep jobs on a replica that is going away
+        lease.Spec.HolderIdentity = ""
+        lease.Spec.LeaseDurationSeconds = 1
+        if err := e.write(ctx, http.MethodPut, lease); err != nil {
+            log.Printf("⚠️  Releasing lease %s failed: %v", e.lease, err)
+        }
+        e.lost("not ready")
+    case holder == e.identity:
+        lease.Spec.RenewTime = now.UTC().Format(microTime)
+        lease.Spec.LeaseDurationSeconds = int(e.leaseDuration.Seconds())
+        if err := e.write(ctx, http.MethodPut, lease); err != nil {
+            e.failed(now, err)
+            return
+        }
+        e.won(now, e.identity)
+    case expired && ready:
+        lease.Spec = e.claim(now, lease.Spec.LeaseTransitions+1)
+        if err := e.write(ctx, http.MethodPut, lease); err != nil {
+            e.failed(now, err)
+            return
+        }
+        e.won(now, e.identity)
+    default:
+        e.lost("held by " + holder)
+    }
+}
+
+func (e *leaderElector) claim(now time.Time, transitions int) LeaseSpec {
+    stamp := now.UTC().Format(microTime)
+    return LeaseSpec{
+        HolderIdentity:       e.identity,
+        LeaseDurationSeconds: int(e.leaseDuration.Seconds()),
+        AcquireTime:          stamp,
+        RenewTime:            stamp,
+        LeaseTransitions:     transitions,
+    }
+}
+
+func (e *leaderElector) won(now time.Time, holder string) {
+    e.mu.Lock()
+    e.holder, e.lastRenew, e.lastErr = holder, now, ""
+    e.mu.Unlock()
+    e.setLeading(true)
+}
+
+func (e *leaderElector) lost(reason string) {
+    e.mu.Lock()
+    was := e.leading
+    e.mu.Unlock()
+    if was {
+        log.Printf("👑 Lost leadership of %s: %s", e.lease, reason)
+    }
+    e.setLeading(false)
+}
+
+// A failed API call only costs leadership once renewals have failed for the
+// whole renew deadline, or at once if another replica took the lease
+func (e *leaderElector) failed(now time.Time, err error) {
+    e.mu.Lock()
+    repeated := e.lastErr == err.Error()
+    e.lastErr = err.Error()
+    overdue := e.leading && now.Sub(e.lastRenew) > e.renewDeadline
+    e.mu.Unlock()
+    if errors.Is(err, errLeaseConflict) || overdue {
+        e.lost(err.Error())
+        return
+    }
+    if !repeated {
+        log.Printf("⚠️  Lease %s: %v", e.lease, err)
+    }
+}
+
+// Start or stop the jobs on a change of leadership
+func (e *leaderElector) setLeading(leading bool) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    if leading == e.leading {
+        return
+    }
+    e.leading = leading
+    if !leading {
+        e.cancel()
+        e.cancel = nil
+        return
+    }
+    e.since = time.Now()
+    e.transitions.Add(1)
+    if leaderElection {
+        log.Printf("👑 Became leader of %s; starting %d background jobs", e.lease, len(e.jobs))
+    }
+    ctx, cancel := context.WithCancel(context.Background())
+    e.cancel = cancel
+    // Jobs from an earlier term finish before this term's start, so a quick
+    // loss and regain never runs two of the same job
+    previous, done := e.done, make(chan struct{})
+    e.done = done
+    jobs := e.jobs
+    go func() {
+        if previous != nil {
+            <-previous
+        }
+        var wg sync.WaitGroup
+        for _, job := range jobs {
+            wg.Add(1)
+            go func(job leaderJob) {
+                defer wg.Done()
+                job.run(ctx)
+            }(job)
+        }
+        wg.Wait()
+        close(done)
+    }()
+}
+
+func (e *leaderElector) isLeader() bool {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    return e.leading
+}
+
+func (e *leaderElector) url() string {
+    return fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases", e.api, e.namespace)
+}
+
+// The lease, or nil if it doesn't exist yet
+func (e *leaderElector) get(ctx context.Context) (*Lease, error) {
+    resp, err := e.do(ctx, http.MethodGet, e.url()+"/"+e.lease, nil)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode == http.StatusNotFound {
+        return nil, nil
+    }
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("get lease: %s", resp.Status)
+    }
+    var lease Lease
+    if err := json.NewDecoder(resp.Body).Decode(&lease); err != nil {
+        return nil, fmt.Errorf("get lease: %w", err)
+    }
+    return &lease, nil
+}
+
+// Create (POST) or update (PUT) the lease. Updates carry the
+// resourceVersion read, so a write racing another replica's fails with a
+// conflict instead of overwriting it.
+func (e *leaderElector) write(ctx context.Context, method string, lease *Lease) error {
+    body, err := json.Marshal(lease)
+    if err != nil {
+        return err
+    }
+    target := e.url()
+    if method == http.MethodPut {
+        target += "/" + e.lease
+    }
+    resp, err := e.do(ctx, method, target, body)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    switch {
+    case resp.StatusCode == http.StatusConflict:
+        return errLeaseConflict
+    case resp.StatusCode >= 300:
+        return fmt.Errorf("%s lease: %s", strings.ToLower(method), resp.Status)
+    }
+    io.Copy(io.Discard, resp.Body)
+    return nil
+}
+
+func (e *leaderElector) do(ctx context.Context, method, target string, body []byte) (*http.Response, error) {
+    return apiServerDo(ctx, e.client, method, target, body)
+}
+
+// Call the Kubernetes API with the pod's service account token
+func apiServerDo(ctx context.Context, client *http.Client, method, target string, body []byte) (*http.Response, error) {
+    req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("Accept", "application/json")
+    // Projected service account tokens are rotated, so read it every time
+    if token, err := os.ReadFile(serviceAccountDir + "/token"); err == nil {
+        req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+    }
+    return client.Do(req)
+}
+
+// Client for the API server, trusting the cluster CA when there is one
+func apiServerClient() (*http.Client, error) {
+    transport := &http.Transport{
+        Proxy:               http.ProxyFromEnvironment,
+        TLSHandshakeTimeout: 5 * time.Second,
+    }
+    if ca, err := os.ReadFile(serviceAccountDir + "/ca.crt"); err == nil {
+        pool := x509.NewCertPool()
+        if !pool.AppendCertsFromPEM(ca) {
+            return nil, errors.New("invalid service account ca.crt")
+        }
+        transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+    }
+    return &http.Client{Transport: transport, Timeout: 5 * time.Second}, nil
+}
+
+func inClusterAPI() string {
+    host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+    if host == "" || port == "" {
+        return ""
+    }
+    return "https://" + net.JoinHostPort(host, port)
+}
+
+func serviceAccountNamespace() string {
+    data, _ := os.ReadFile(serviceAccountDir + "/namespace")
+    return strings.TrimSpace(string(data))
+}
+
+func hostname() string {
+    name, _ := os.Hostname()
+    return name
+}
+
+func (e *leaderElector) status() map[string]interface{} {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    jobs := make([]string, len(e.jobs))
+    for i, job := range e.jobs {
+        jobs[i] = job.name
+    }
+    status := map[string]interface{}{
+        "enabled":  leaderElection,
+        "identity": e.identity,
+        "leader":   e.leading,
+        "jobs":     jobs,
+    }
+    if leaderElection {
+        status["lease"] = e.namespace + "/" + e.lease
+        status["holder"] = e.holder
+        if e.lastErr != "" {
+            status["lastError"] = e.lastErr
+        }
+    }
+    if e.leading {
+        status["since"] = e.since
+    }
+    return status
+}
+
+func writeLeaderMetrics(w io.Writer) {
+    leading := 0
+    if leader.isLeader() {
+        leading = 1
+    }
+    fmt.Fprintf(w, "# HELP auth_leader Whether this replica runs the singleton background jobs\n")
+    fmt.Fprintf(w, "# TYPE auth_leader gauge\n")
+    fmt.Fprintf(w, "auth_leader{lease=%q,identity=%q} %d\n", leader.lease, leader.identity, leading)
+    fmt.Fprintf(w, "# HELP auth_leader_acquired_total Times this replica became leader\n")
+    fmt.Fprintf(w, "# TYPE auth_leader_acquired_total counter\n")
+    fmt.Fprintf(w, "auth_leader_acquired_total %d\n", leader.transitions.Load())
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Every list endpoint takes the same query parameters:
+//
+//	?limit=N       page size, up to the endpoint's maximum
+//	?sort=field    one of the endpoint's sortable fields, "-field" descending
+//	?cursor=...    nextCursor from the previous page
+//	?<filter>=...  the filters the endpoint documents
+//
+// and answers {"<items>": [...], "count": N, "nextCursor": "..."}, with
+// nextCursor only when another page follows. Unknown parameters are
+// rejected rather than ignored, so a misspelt filter doesn't quietly return
+// everything. A cursor carries the last item's sort key and ID, which keeps
+// pages stable while items are added, and is refused if the sort or filters
+// change between pages.
+
+// listSpec is what one endpoint supports
+type listSpec struct {
+    defaultLimit int
+    maxLimit     int
+    sorts        []string // sortable fields
+    defaultSort  string   // e.g. "id" or "-time"
+    filters      []string
+}
+
+// listQuery is a parsed, validated list request
+type listQuery struct {
+    Limit   int
+    Sort    string
+    Desc    bool
+    Filters map[string]string
+    After   *listCursor // nil on the first page
+}
+
+// listCursor is the position after the last item of a page
+type listCursor struct {
+    Sort    string `json:"s"` // as requested, "-" prefix included
+    Filters string `json:"f"` // fingerprint of the filters
+    Key     string `json:"k"` // the last item's sort field
+    ID      string `json:"i"` // the last item's ID, the tiebreak
+}
+
+var (
+    errInvalidCursor = autherr.ErrInvalidRequest.WithMessage("Invalid cursor")
+    errStaleCursor   = autherr.ErrInvalidRequest.WithMessage("Cursor was issued for a different sort or filter")
+)
+
+func parseListQuery(r *http.Request, spec listSpec) (*listQuery, error) {
+    q := r.URL.Query()
+    for name := range q {
+        if name != "limit" && name != "cursor" && name != "sort" && !containsString(spec.filters, name) {
+            return nil, autherr.ErrInvalidRequest.WithMessage(fmt.Sprintf("Unknown query parameter %q", name))
+        }
+    }
+
+    lq := &listQuery{Limit: spec.defaultLimit, Filters: map[string]string{}}
+    if v := q.Get("limit"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 || n > spec.maxLimit {
+            return nil, autherr.ErrInvalidRequest.WithMessage(fmt.Sprintf("limit must be between 1 and %d", spec.maxLimit))
+        }
+        lq.Limit = n
+    }
+
+    sortBy := q.Get("sort")
+    if sortBy == "" {
+        sortBy = spec.defaultSort
+    }
+    lq.Sort, lq.Desc = strings.TrimPrefix(sortBy, "-"), strings.HasPrefix(sortBy, "-")
+    if !containsString(spec.sorts, lq.Sort) {
+        return nil, autherr.ErrInvalidRequest.WithMessage("sort must be one of " + strings.Join(spec.sorts, ", ") + ", optionally prefixed with -")
+    }
+
+    for _, name := range spec.filters {
+        if v := q.Get(name); v != "" {
+            lq.Filters[name] = v
+        }
+    }
+
+    if c := q.Get("cursor"); c != "" {
+        data, err := base64.RawURLEncoding.DecodeString(c)
+        var cursor listCursor
+        if err != nil || json.Unmarshal(data, &cursor) != nil || cursor.ID == "" {
+            return nil, errInvalidCursor
+        }
+        if cursor.Sort != sortBy || cursor.Filters != lq.fingerprint() {
+            return nil, errStaleCursor
+        }
+        lq.After = &cursor
+    }
+    return lq, nil
+}
+
+// Stable digest of the filters, so a cursor can't be replayed against others
+func (lq *listQuery) fingerprint() string {
+    values := url.Values{}
+    for name, v := range lq.Filters {
+        values.Set(name, v)
+    }
+    sum := sha256.Sum256([]byte(values.Encode()))
+    return hex.EncodeToString(sum[:8])
+}
+
+// The cursor for the page after an item with this sort key and ID
+func (lq *listQuery) cursor(key, id string) string {
+    sortBy := lq.Sort
+    if lq.Desc {
+        sortBy = "-" + sortBy
+    }
+    data, _ := json.Marshal(listCursor{Sort: sortBy, Filters: lq.fingerprint(), Key: key, ID: id})
+    return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Times as sort keys: fixed width, so they order as strings
+func sortKeyTime(t time.Time) string {
+    return t.UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
+
+func parseSortKeyTime(key string) (time.Time, error) {
+    t, err := time.Parse("2006-01-02T15:04:05.000000000Z", key)
+    if err != nil {
+        return time.Time{}, errInvalidCursor
+    }
+    return t, nil
+}
+
+// Sort, page and cursor a collection small enough to load whole. key gives
+// an item's value for a sort field, id its unique ID.
+func pageOf[T any](items []T, lq *listQuery, key func(item T, field string) string, id func(T) string) ([]T, string) {
+    less := func(a, b T) bool {
+        ka, kb := key(a, lq.Sort), key(b, lq.Sort)
+        if ka != kb {
+            return (ka < kb) != lq.Desc
+        }
+        return (id(a) < id(b)) != lq.Desc
+    }
+    sort.Slice(items, func(i, j int) bool { return less(items[i], items[j]) })
+
+    start := 0
+    if lq.After != nil {
+        start = sort.Search(len(items), func(i int) bool {
+            k, itemID := key(items[i], lq.Sort), id(items[i])
+            if k == lq.After.Key {
+                k, afterKey := itemID, lq.After.ID
+                if lq.Desc {
+                    return k < afterKey
+                }
+                return k > afterKey
+            }
+            if lq.Desc {
+                return k < lq.After.Key
+            }
+            return k > lq.After.Key
+        })
+    }
+    items = items[start:]
+    if len(items) <= lq.Limit {
+        return items, ""
+    }
+    items = items[:lq.Limit]
+    last := items[lq.Limit-1]
+    return items, lq.cursor(key(last, lq.Sort), id(last))
+}
+
+func writeListPage(w http.ResponseWriter, name string, items interface{}, count int, next string) {
+    response := map[string]interface{}{
+        name:    items,
+        "count": count,
+    }
+    if next != "" {
+        response["nextCursor"] = next
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(response)
+}
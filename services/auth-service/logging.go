@@ -0,0 +1,248 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "math/rand"
+    "net/http"
+    "sort"
+    "strings"
+    "sync/atomic"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Log verbosity. Operational messages always go out; debug messages (one
+// line per request plus the reasons credentials were rejected) only for
+// routes whose level is debug, and then only for the sampled fraction, so
+// debug can be switched on under load. /admin/logging changes the settings
+// at runtime, optionally for a limited time after which LOG_LEVEL,
+// LOG_ROUTES and LOG_DEBUG_SAMPLE apply again.
+const (
+    logLevelInfo  = "info"
+    logLevelDebug = "debug"
+)
+
+// LoggingConfig is the verbosity in force
+type LoggingConfig struct {
+    Level  string            `json:"level"`
+    Routes map[string]string `json:"routes,omitempty"` // path or "prefix*" to level; longest match wins
+    Sample float64           `json:"sample"`           // fraction of debug messages written
+    Until  *time.Time        `json:"until,omitempty"`  // when the defaults come back
+}
+
+// The defaults come from the environment and can be replaced by a config
+// reload
+var (
+    loggingDefaults atomic.Pointer[LoggingConfig]
+    logging         atomic.Pointer[LoggingConfig]
+)
+
+func init() {
+    defaults := &LoggingConfig{
+        Level:  getEnv("LOG_LEVEL", logLevelInfo),
+        Routes: parseLogRoutes(getEnv("LOG_ROUTES", "")),
+        Sample: getEnvFloat("LOG_DEBUG_SAMPLE", 1),
+    }
+    loggingDefaults.Store(defaults)
+    logging.Store(defaults)
+}
+
+// LOG_ROUTES is "/login=debug,/admin/*=debug"
+func parseLogRoutes(value string) map[string]string {
+    routes := map[string]string{}
+    for _, entry := range strings.Split(value, ",") {
+        route, level, ok := strings.Cut(strings.TrimSpace(entry), "=")
+        if ok && route != "" {
+            routes[route] = level
+        }
+    }
+    return routes
+}
+
+func validLogLevel(level string) bool {
+    return level == logLevelInfo || level == logLevelDebug
+}
+
+func (c *LoggingConfig) validate() error {
+    if !validLogLevel(c.Level) {
+        return autherr.ErrInvalidRequest.WithMessage("level must be info or debug")
+    }
+    for route, level := range c.Routes {
+        if !validLogLevel(level) {
+            return autherr.ErrInvalidRequest.WithMessage("Route " + route + " has an unknown level")
+        }
+    }
+    if c.Sample < 0 || c.Sample > 1 {
+        return autherr.ErrInvalidRequest.WithMessage("sample must be between 0 and 1")
+    }
+    return nil
+}
+
+// Replace the defaults, carrying the settings in force along unless a
+// timed change is active
+func setLoggingDefaults(c *LoggingConfig) {
+    old := loggingDefaults.Swap(c)
+    logging.CompareAndSwap(old, c)
+}
+
+// Settings in force, reverting to the defaults once a timed change lapses
+func currentLogging() *LoggingConfig {
+    c := logging.Load()
+    if c.Until != nil && time.Now().After(*c.Until) {
+        defaults := loggingDefaults.Load()
+        logging.CompareAndSwap(c, defaults)
+        return defaults
+    }
+    return c
+}
+
+// Level for a path: the longest matching route override, else the global level
+func (c *LoggingConfig) levelFor(path string) string {
+    level, best := c.Level, -1
+    for route, l := range c.Routes {
+        prefix, wildcard := strings.CutSuffix(route, "*")
+        if (path == route || (wildcard && strings.HasPrefix(path, prefix))) && len(route) > best {
+            level, best = l, len(route)
+        }
+    }
+    return level
+}
+
+// Write a debug message for the request if its route is at debug level and
+// the message falls in the sample
+func debugf(r *http.Request, format string, args ...interface{}) {
+    c := currentLogging()
+    if c.levelFor(r.URL.Path) != logLevelDebug {
+        return
+    }
+    if c.Sample < 1 && rand.Float64() >= c.Sample {
+        return
+    }
+    log.Printf("🔍 "+format, args...)
+}
+
+type statusWriter struct {
+    http.ResponseWriter
+    status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+    sw.status = status
+    sw.ResponseWriter.WriteHeader(status)
+}
+
+// The event stream needs flushes to reach the client
+func (sw *statusWriter) Flush() {
+    if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+        f.Flush()
+    }
+}
+
+// Log every request at debug level. Checking the level first keeps the
+// wrapper off the path entirely while a route is at info.
+func requestLogger(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if currentLogging().levelFor(r.URL.Path) != logLevelDebug {
+            next.ServeHTTP(w, r)
+            return
+        }
+        start := time.Now()
+        sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+        next.ServeHTTP(sw, r)
+        caller := "anonymous"
+        if p := principalFromContext(r.Context()); p != nil {
+            caller = p.Subject
+        }
+        debugf(r, "%s %s %d %s from %s as %s", r.Method, r.URL.Path, sw.status,
+            time.Since(start).Round(time.Microsecond), clientIP(r), caller)
+    })
+}
+
+// Logging endpoint: GET shows the settings in force, POST
+// {"level": "debug", "routes": {"/login": "debug"}, "sample": 0.1, "ttl": "15m"}
+// replaces them; {"reset": true} restores the defaults
+func loggingHandler(w http.ResponseWriter, r *http.Request) {
+    if err := requireAdmin(r); err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+    case http.MethodPost:
+        var req struct {
+            Level  string            `json:"level"`
+            Routes map[string]string `json:"routes"`
+            Sample *float64          `json:"sample"`
+            TTL    string            `json:"ttl"`
+            Reset  bool              `json:"reset"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            autherr.Write(w, autherr.ErrInvalidRequest)
+            return
+        }
+        c := *loggingDefaults.Load()
+        if !req.Reset {
+            if req.Level != "" {
+                c.Level = req.Level
+            }
+            if req.Routes != nil {
+                c.Routes = req.Routes
+            }
+            if req.Sample != nil {
+                c.Sample = *req.Sample
+            }
+            if req.TTL != "" {
+                ttl, err := time.ParseDuration(req.TTL)
+                if err != nil || ttl <= 0 {
+                    autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("ttl must be a duration such as 15m"))
+                    return
+                }
+                until := time.Now().Add(ttl)
+                c.Until = &until
+            }
+        }
+        if err := c.validate(); err != nil {
+            autherr.Write(w, err)
+            return
+        }
+        if req.Reset {
+            // Follow the defaults again, including later config reloads
+            logging.Store(loggingDefaults.Load())
+        } else {
+            logging.Store(&c)
+        }
+
+        routes := make([]string, 0, len(c.Routes))
+        for route, level := range c.Routes {
+            routes = append(routes, route+"="+level)
+        }
+        sort.Strings(routes)
+        details := map[string]string{
+            "level":  c.Level,
+            "routes": strings.Join(routes, ","),
+            "sample": fmt.Sprint(c.Sample),
+        }
+        if c.Until != nil {
+            details["until"] = c.Until.Format(time.RFC3339)
+        }
+        actor := "unknown"
+        if p := principalFromContext(r.Context()); p != nil {
+            actor = p.Subject
+        }
+        log.Printf("📝 Logging set to %s (routes %v, sample %v) by %s", c.Level, routes, c.Sample, actor)
+        recordAudit(r, "logging.changed", actor, details)
+    default:
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "logging":  currentLogging(),
+        "defaults": loggingDefaults.Load(),
+    })
+}
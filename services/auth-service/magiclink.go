@@ -0,0 +1,246 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "html/template"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Password-less login: /login/magic-link sends a single-use link to the
+// account's address, and confirming it within MAGIC_LINK_TTL at
+// /login/callback yields a session and token as /login does. The link
+// carries a random nonce and an HMAC of it under the tenant's JWT key, so
+// rotating that key voids outstanding links. Opening the link only shows a
+// confirmation page; the nonce is used up by the POST that page sends, so
+// mail scanners fetching the link don't burn it. Links go by email, or to
+// MAGIC_LINK_WEBHOOK_URL as JSON when a front end wants to deliver them
+// itself. Outstanding links are one-time tokens in storage.
+var (
+    magicLinkTTL     = getEnvDuration("MAGIC_LINK_TTL", 15*time.Minute)
+    magicLinkWebhook = os.Getenv("MAGIC_LINK_WEBHOOK_URL")
+    magicLinkLimiter = newRateLimiter(
+        getEnvInt("MAGIC_LINK_LIMIT", 3),
+        getEnvDuration("MAGIC_LINK_WINDOW", 15*time.Minute),
+    )
+)
+
+const magicLinkKind = "magic_link"
+
+var errInvalidMagicLink = autherr.ErrInvalidToken.WithMessage("Invalid or expired login link")
+
+// HMAC of a link nonce under the tenant's JWT key, "" when the tenant has none
+func magicLinkSignature(tenant, nonce string) string {
+    key := secrets.get().signingKeys[tenant]
+    if key == nil {
+        return ""
+    }
+    mac := key.getMAC()
+    defer key.putMAC(mac)
+    mac.Write([]byte("magic-link\x00" + tenant + "\x00" + nonce))
+    return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Split a link token into its nonce, which is only returned when the
+// signature checks out for the request's tenant
+func magicLinkNonce(r *http.Request, token string) (string, bool) {
+    nonce, signature, _ := strings.Cut(token, ".")
+    expected := magicLinkSignature(tenantFromContext(r.Context()), nonce)
+    if nonce == "" || expected == "" || !hmac.Equal([]byte(signature), []byte(expected)) {
+        return "", false
+    }
+    return nonce, true
+}
+
+// Email the link, or post it to the webhook when one is configured
+func sendMagicLink(ctx context.Context, user *User, url string, expires time.Time) error {
+    if magicLinkWebhook == "" {
+        body := fmt.Sprintf("Open the link below within %s to sign in. If you didn't ask for it, ignore this email.\n\n%s\n", magicLinkTTL, url)
+        return sendMail(ctx, user.Email, "Your sign-in link", body)
+    }
+    payload, _ := json.Marshal(map[string]interface{}{
+        "type":      "login.magic_link",
+        "tenant":    user.Tenant,
+        "userId":    user.ID,
+        "email":     user.Email,
+        "link":      url,
+        "expiresAt": expires,
+    })
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, magicLinkWebhook, bytes.NewReader(payload))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    resp, err := outbound.Do(req)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("magic link webhook returned %s", resp.Status)
+    }
+    return nil
+}
+
+// Magic link endpoint: rate limited per address; responds identically
+// whether or not the account exists so it can't be used to enumerate users
+func magicLinkHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+    tenant := tenantFromContext(r.Context())
+    if secrets.get().signingKeys[tenant] == nil {
+        autherr.Write(w, autherr.ErrNotConfigured.WithMessage("JWT secret not configured"))
+        return
+    }
+
+    var req struct {
+        Email    string `json:"email"`
+        Audience string `json:"audience"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !strings.Contains(req.Email, "@") {
+        autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("A valid email is required"))
+        return
+    }
+    audience, err := loginAudience(req.Audience)
+    if err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    email := normalizeEmail(req.Email)
+    if ok, retryAfter := magicLinkLimiter.Allow(tenant + "/" + email); !ok {
+        w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+        autherr.Write(w, autherr.ErrRateLimited.WithMessage("Too many login links requested"))
+        return
+    }
+
+    if user, err := store.GetUserByEmail(r.Context(), tenant, email); err == nil && user.Status == UserStatusActive {
+        nonce, link, err := issueOneTimeToken(r.Context(), magicLinkKind, tenant, user.ID, magicLinkTTL, map[string]string{"audience": audience})
+        if err != nil {
+            log.Printf("❌ Magic link create failed: %v", err)
+            autherr.Write(w, autherr.ErrInternal)
+            return
+        }
+        url := fmt.Sprintf("%s/login/callback?token=%s.%s", publicBaseURL, nonce, magicLinkSignature(tenant, nonce))
+        if err := sendMagicLink(r.Context(), user, url, link.ExpiresAt); err != nil {
+            log.Printf("⚠️  Magic link delivery failed: %v", err)
+        } else {
+            recordAudit(r, "login.magic_link_sent", user.ID, map[string]string{"audience": audience})
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusAccepted)
+    json.NewEncoder(w).Encode(map[string]string{
+        "message": "If the account exists, a sign-in link has been sent",
+    })
+}
+
+var magicLinkPage = template.Must(template.New("magic-link").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body style="font-family: sans-serif; max-width: 24em; margin: 4em auto">
+<h1>Sign in</h1>
+<p>Continue to sign in with the link from your email.</p>
+<form method="post">
+<input type="hidden" name="token" value="{{.}}">
+<p><button>Sign in</button></p>
+</form>
+</body>
+</html>
+`))
+
+// Callback endpoint: GET checks the link and asks to confirm, POST with the
+// token consumes the link and signs the user in
+func magicLinkCallbackHandler(w http.ResponseWriter, r *http.Request) {
+    var token string
+    switch r.Method {
+    case http.MethodGet:
+        token = r.URL.Query().Get("token")
+    case http.MethodPost:
+        if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+            token = r.PostFormValue("token")
+        } else {
+            var req map[string]string
+            if json.NewDecoder(r.Body).Decode(&req) == nil {
+                token = req["token"]
+            }
+        }
+    default:
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+
+    nonce, ok := magicLinkNonce(r, token)
+    if !ok {
+        autherr.Write(w, errInvalidMagicLink)
+        return
+    }
+    if r.Method == http.MethodGet {
+        if link, ok := findOneTimeToken(r.Context(), magicLinkKind, nonce); !ok || link.Tenant != tenantFromContext(r.Context()) {
+            autherr.Write(w, errInvalidMagicLink)
+            return
+        }
+        w.Header().Set("Content-Type", "text/html; charset=utf-8")
+        w.Header().Set("Cache-Control", "no-store")
+        w.Header().Set("X-Frame-Options", "DENY")
+        magicLinkPage.Execute(w, token)
+        return
+    }
+    link, ok := consumeOneTimeToken(r.Context(), magicLinkKind, nonce)
+    if !ok || link.Tenant != tenantFromContext(r.Context()) {
+        autherr.Write(w, errInvalidMagicLink)
+        return
+    }
+    audience := link.Data["audience"]
+
+    user, err := store.GetUser(r.Context(), link.UserID)
+    if err != nil {
+        autherr.Write(w, errInvalidMagicLink)
+        return
+    }
+    if user.Status != UserStatusActive {
+        recordAudit(r, "login.failed", user.ID, map[string]string{"reason": "disabled", "method": "magic_link"})
+        autherr.Write(w, autherr.ErrAccountDisabled)
+        return
+    }
+    attempt := LoginAttempt{
+        Tenant: user.Tenant,
+        Email:  user.Email,
+        UserID: user.ID,
+        IP:     clientIP(r),
+        Time:   clock.Now(),
+    }
+    if err := assessLoginRisk(r, attempt); err != nil {
+        autherr.Write(w, err)
+        return
+    }
+
+    signed, err := issueSessionToken(r, user, audience, "login.succeeded", map[string]string{"method": "magic_link"})
+    if err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    riskScorer.Observe(attempt, true)
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "token":     signed,
+        "tokenType": "Bearer",
+        "expiresIn": int(tokenTTL.Seconds()),
+        "audience":  audience,
+    })
+}
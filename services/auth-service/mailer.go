@@ -0,0 +1,93 @@
+package main
+
+import (
+    "context"
+    "crypto/tls"
+    "fmt"
+    "log"
+    "net"
+    "net/smtp"
+    "os"
+    "strings"
+)
+
+var (
+    smtpHost     = os.Getenv("SMTP_HOST")
+    smtpPort     = getEnv("SMTP_PORT", "587")
+    smtpUsername = os.Getenv("SMTP_USERNAME")
+    smtpPassword = os.Getenv("SMTP_PASSWORD")
+    mailFrom     = getEnv("MAIL_FROM", "no-reply@auth-service.local")
+)
+
+// Deliver a plain-text email. Without SMTP_HOST the message is only logged,
+// which is enough to pick verification links out of `kubectl logs`. The
+// conversation with the relay is bounded by ctx's deadline.
+func sendMail(ctx context.Context, to, subject, body string) error {
+    if smtpHost == "" {
+        log.Printf("📧 Mail to %s: %s\n%s", to, subject, body)
+        return nil
+    }
+
+    var auth smtp.Auth
+    if smtpUsername != "" {
+        auth = smtp.PlainAuth("", smtpUsername, smtpPassword, smtpHost)
+    }
+    msg := strings.Join([]string{
+        "From: " + mailFrom,
+        "To: " + to,
+        "Subject: " + subject,
+        "Content-Type: text/plain; charset=UTF-8",
+        "",
+        body,
+    }, "\r\n")
+    if err := deliverMail(ctx, auth, to, []byte(msg)); err != nil {
+        return fmt.Errorf("send mail to %s: %w", to, err)
+    }
+    return nil
+}
+
+// smtp.SendMail with a context: the same STARTTLS and AUTH steps over a
+// connection that closes at the deadline
+func deliverMail(ctx context.Context, auth smtp.Auth, to string, msg []byte) error {
+    var d net.Dialer
+    conn, err := d.DialContext(ctx, "tcp", smtpHost+":"+smtpPort)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    if deadline, ok := ctx.Deadline(); ok {
+        conn.SetDeadline(deadline)
+    }
+    c, err := smtp.NewClient(conn, smtpHost)
+    if err != nil {
+        return err
+    }
+    defer c.Close()
+    if ok, _ := c.Extension("STARTTLS"); ok {
+        if err := c.StartTLS(&tls.Config{ServerName: smtpHost}); err != nil {
+            return err
+        }
+    }
+    if auth != nil {
+        if err := c.Auth(auth); err != nil {
+            return err
+        }
+    }
+    if err := c.Mail(mailFrom); err != nil {
+        return err
+    }
+    if err := c.Rcpt(to); err != nil {
+        return err
+    }
+    wc, err := c.Data()
+    if err != nil {
+        return err
+    }
+    if _, err := wc.Write(msg); err != nil {
+        return err
+    }
+    if err := wc.Close(); err != nil {
+        return err
+    }
+    return c.Quit()
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+    "context"
     "crypto/hmac"
     "crypto/sha256"
+    "crypto/tls"
     "encoding/hex"
     "encoding/json"
     "fmt"
@@ -10,7 +12,10 @@ import (
     "net/http"
     "os"
     "runtime"
+    "strconv"
     "time"
+
+    "auth-service/internal/autherr"
 )
 
 type HealthResponse struct {
@@ -35,14 +40,6 @@ var (
     dbPassword      = os.Getenv("DB_PASSWORD")
 )
 
-func init() {
-    log.Println("🔐 Auth Service Configuration:")
-    log.Printf("  JWT Secret: %v", jwtSecret != "")
-    log.Printf("  Internal API Key: %v", internalAPIKey != "")
-    log.Printf("  Auth Service Token: %v", authServiceToken != "")
-    log.Printf("  Database Credentials: %v", dbUser != "" && dbPassword != "")
-}
-
 // Health check handler with enhanced metrics
 func healthHandler(w http.ResponseWriter, r *http.Request) {
     var memStats runtime.MemStats
@@ -53,7 +50,8 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
     response := map[string]interface{}{
         "status":    "healthy",
         "service":   "security-core",
-        "version":   getEnv("VERSION", "1.0.0"),
+        "version":   build.Version,
+        "build":     build,
         "timestamp": time.Now().Format(time.RFC3339),
         "uptime":    uptime,
         "system": map[string]interface{}{
@@ -81,33 +79,65 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 
 // Validate endpoint with token verification
 func validateHandler(w http.ResponseWriter, r *http.Request) {
-    serviceToken := r.Header.Get("X-Service-Token")
-    apiKey := r.Header.Get("X-Internal-API-Key")
-    
-    valid := serviceToken == authServiceToken && apiKey == internalAPIKey
+    // A signed request's nonce was spent when the policy middleware resolved
+    // the caller, so rely on that result rather than verifying it again
+    valid := false
+    if isSignedRequest(r) {
+        p := principalFromContext(r.Context())
+        valid = p != nil && p.Subject == "internal-service"
+    } else {
+        valid = serviceCredentialsValid(r)
+    }
     
-    response := map[string]interface{}{
-        "valid":     valid,
-        "service":   "auth-service",
-        "timestamp": time.Now(),
+    response := &ValidateResponse{
+        Valid:     valid,
+        Service:   "auth-service",
+        Timestamp: time.Now(),
     }
     
-    if valid {
-        response["user"] = "authenticated-user"
-        response["message"] = "Valid service credentials"
-    } else {
-        response["message"] = "Invalid service credentials"
+    // A user token in X-Subject-Token is checked too, and must have been
+    // issued for the calling service
+    subjectToken := r.Header.Get("X-Subject-Token")
+    switch {
+    case !valid:
+        response.Message = "Invalid service credentials"
+    case subjectToken != "":
+        claims, err := verifyToken(r.Context(), subjectToken)
+        if err == nil && tokenTenant(claims) != tenantFromContext(r.Context()) {
+            err = autherr.ErrInvalidToken.WithMessage("Token belongs to another tenant")
+        }
+        if err == nil {
+            err = checkBinding(claims, forwardedPresenter(r))
+        }
+        if err == nil {
+            caller := principalFromContext(r.Context())
+            if caller == nil {
+                caller = &Principal{Subject: "internal-service", Kind: "service"}
+            }
+            err = checkAudience(claims, caller)
+        }
+        if err != nil {
+            response.Valid = false
+            response.Message = autherr.From(err).Message
+            break
+        }
+        response.User = claims.Subject
+        response.Audience = claims.Audience
+        response.ImpersonatedBy = claims.ImpersonatedBy
+        response.Message = "Valid token"
+    default:
+        response.User = "authenticated-user"
+        response.Message = "Valid service credentials"
     }
     
-    w.Header().Set("Content-Type", "application/json")
-    json.NewEncoder(w).Encode(response)
+    writeValidateResponse(w, r, response)
 }
 
 // Authenticate endpoint
 func authenticateHandler(w http.ResponseWriter, r *http.Request) {
     serviceToken := r.Header.Get("X-Service-Token")
-    if serviceToken != authServiceToken {
-        http.Error(w, "Unauthorized", http.StatusForbidden)
+    if !secrets.matches(func(s *secretSet) string { return s.authServiceToken }, serviceToken) {
+        autherr.Write(w, autherr.ErrForbidden.WithMessage("Invalid service token"))
         return
     }
     
@@ -119,7 +149,16 @@ func authenticateHandler(w http.ResponseWriter, r *http.Request) {
         Timestamp: time.Now(),
     }
     
-    if response.Valid {
+    if response.Valid && flags.Enabled("strict_validation") {
+        claims, err := verifyToken(r.Context(), request["token"])
+        if err == nil {
+            err = checkBinding(claims, forwardedPresenter(r))
+        }
+        response.Valid = err == nil
+        if err == nil {
+            response.User = claims.Subject
+        }
+    } else if response.Valid {
         response.User = "user-123"
     }
     
@@ -129,12 +168,13 @@ func authenticateHandler(w http.ResponseWriter, r *http.Request) {
 
 // Generate token using secret
 func generateTokenHandler(w http.ResponseWriter, r *http.Request) {
-    if jwtSecret == "" {
-        http.Error(w, "JWT secret not configured", http.StatusInternalServerError)
+    secret := secrets.get().jwtSecret
+    if secret == "" {
+        autherr.Write(w, autherr.ErrNotConfigured.WithMessage("JWT secret not configured"))
         return
     }
     
-    h := hmac.New(sha256.New, []byte(jwtSecret))
+    h := hmac.New(sha256.New, []byte(secret))
     h.Write([]byte(fmt.Sprintf("user-%d", time.Now().Unix())))
     token := hex.EncodeToString(h.Sum(nil))
     
@@ -150,9 +190,12 @@ func generateTokenHandler(w http.ResponseWriter, r *http.Request) {
 // Status endpoint
 func statusHandler(w http.ResponseWriter, r *http.Request) {
     response := map[string]interface{}{
-        "operational": true,
-        "timestamp":   time.Now(),
-        "auth_count":  100, // Mock metric
+        "operational":  true,
+        "timestamp":    time.Now(),
+        "auth_count":   100, // Mock metric
+        "leader":       leader.status(),
+        "peers":        discovery.summary(),
+        "loadShedding": shedder.status(),
     }
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(response)
@@ -167,20 +210,75 @@ func metricsHandler(w http.ResponseWriter, r *http.Request) {
     fmt.Fprintf(w, "# HELP auth_success_total Successful authentications\n")
     fmt.Fprintf(w, "# TYPE auth_success_total counter\n")
     fmt.Fprintf(w, "auth_success_total 95\n")
+    outbound.writeMetrics(w)
+    events.writeMetrics(w)
+    writeRiskMetrics(w)
+    writeQuotaMetrics(w)
+    outbox.writeMetrics(w)
+    writeChaosMetrics(w)
+    writeConnMetrics(w)
+    writeExtAuthzMetrics(w)
+    writeDeadlineMetrics(w)
+    writeSLOMetrics(w)
+    writeValidationCacheMetrics(w)
+    writeLeaderMetrics(w)
+    writeBuildMetrics(w)
+    writeRecordingMetrics(w)
+    writeRevocationMetrics(w)
+    writeDiscoveryMetrics(w)
+    writeShedMetrics(w)
+    writeRetentionMetrics(w)
 }
 
 // Root handler
 func rootHandler(w http.ResponseWriter, r *http.Request) {
     response := map[string]interface{}{
         "service": "auth-service",
-        "version": "1.0.0",
+        "version": build.Version,
         "endpoints": []string{
             "/health",
+            "/build-info",
+            "/readyz",
+            "/selftest",
             "/validate",
+            "/validate/batch",
             "/authenticate",
             "/generate-token",
             "/status",
             "/metrics",
+            "/slo",
+            "/register",
+            "/verify-email",
+            "/resend-verification",
+            "/login",
+            "/device/code",
+            "/device/token",
+            "/device",
+            "/login/magic-link",
+            "/login/callback",
+            "/login/providers",
+            "/policy",
+            "/audit",
+            "/admin/db/status",
+            "/admin/maintenance",
+            "/admin/api-keys",
+            "/admin/logging",
+            "/admin/config",
+            "/admin/encryption",
+            "/admin/dashboard",
+            "/admin/revocations",
+            "/admin/ui",
+            "/token/exchange",
+            "/impersonate",
+            "/flags",
+            "/events",
+            "/discovery",
+            "/users",
+            "/quota",
+            "/attest",
+            "/.well-known/jwks.json",
+            "/openapi.json",
+            "/ext-authz",
         },
     }
     w.Header().Set("Content-Type", "application/json")
@@ -195,20 +293,191 @@ func getEnv(key, defaultValue string) string {
     return defaultValue
 }
 
+func getEnvInt(key string, defaultValue int) int {
+    if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+        return value
+    }
+    return defaultValue
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+    if value, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+        return value
+    }
+    return defaultValue
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+    if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+        return value
+    }
+    return defaultValue
+}
+
 func main() {
+    if len(os.Args) > 1 && os.Args[1] == "migrate" {
+        os.Exit(runMigrateCommand(os.Args[2:]))
+    }
+    if len(os.Args) > 1 && (os.Args[1] == "check" || os.Args[1] == "--check") {
+        os.Exit(runCheckCommand())
+    }
+
+    logStartupBanner()
     port := getEnv("PORT", "8080")
+
+    if err := secrets.load(); err != nil {
+        log.Fatalf("❌ Secret load failed: %v", err)
+    }
+    if secrets.dir != "" {
+        go secrets.watch()
+    }
     
     // Register handlers
-    http.HandleFunc("/", rootHandler)
+    http.HandleFunc("/", withETag(rootHandler))
     http.HandleFunc("/health", healthHandler)
+    http.HandleFunc("/build-info", buildInfoHandler)
+    http.HandleFunc("/readyz", readyzHandler)
+    http.HandleFunc("/selftest", selftestHandler)
     http.HandleFunc("/validate", validateHandler)
+    http.HandleFunc("/validate/batch", validateBatchHandler)
     http.HandleFunc("/authenticate", authenticateHandler)
-    http.HandleFunc("/generate-token", generateTokenHandler)
+    http.HandleFunc("/generate-token", restrictIPs(tokenIPFilter, generateTokenHandler))
     http.HandleFunc("/status", statusHandler)
     http.HandleFunc("/metrics", metricsHandler)
+    http.HandleFunc("/slo", sloHandler)
+    http.HandleFunc("/register", registerHandler)
+    http.HandleFunc("/verify-email", verifyEmailHandler)
+    http.HandleFunc("/resend-verification", resendVerificationHandler)
+    http.HandleFunc("/login", loginHandler)
+    http.HandleFunc("/device/code", deviceCodeHandler)
+    http.HandleFunc("/device/token", deviceTokenHandler)
+    http.HandleFunc("/device", deviceVerifyHandler)
+    http.HandleFunc("/login/magic-link", magicLinkHandler)
+    http.HandleFunc("/login/callback", magicLinkCallbackHandler)
+    http.HandleFunc("/login/providers", loginProvidersHandler)
+    for name, p := range identityProviders {
+        http.HandleFunc("/login/"+name, socialLoginHandler(p))
+        http.HandleFunc("/login/"+name+"/callback", socialCallbackHandler(p))
+        newAuthPaths["/login/"+name] = true
+        newAuthPaths["/login/"+name+"/callback"] = true
+        log.Printf("🔗 %s sign-in enabled", name)
+    }
+    http.HandleFunc("/policy", restrictIPs(adminIPFilter, withETag(policyHandler)))
+    http.HandleFunc("/audit", restrictIPs(adminIPFilter, auditHandler))
+    http.HandleFunc("/admin/db/status", restrictIPs(adminIPFilter, dbStatusHandler))
+    http.HandleFunc("/admin/maintenance", restrictIPs(adminIPFilter, maintenanceHandler))
+    http.HandleFunc("/admin/api-keys", restrictIPs(adminIPFilter, apiKeysHandler))
+    http.HandleFunc("/admin/api-keys/", restrictIPs(adminIPFilter, apiKeysHandler))
+    http.HandleFunc("/admin/logging", restrictIPs(adminIPFilter, loggingHandler))
+    http.HandleFunc("/admin/config", restrictIPs(adminIPFilter, configHandler))
+    http.HandleFunc("/admin/encryption", restrictIPs(adminIPFilter, encryptionHandler))
+    http.HandleFunc("/admin/dashboard", restrictIPs(adminIPFilter, adminDashboardHandler))
+    http.HandleFunc("/admin/revocations", restrictIPs(adminIPFilter, revocationsHandler))
+    adminUI := restrictIPs(adminIPFilter, adminUIHandler())
+    http.HandleFunc("/admin/ui", adminUI)
+    http.HandleFunc("/admin/ui/", adminUI)
+    if chaosEnabled {
+        log.Printf("🐒 Chaos endpoints enabled")
+        http.HandleFunc("/admin/chaos", restrictIPs(adminIPFilter, chaosHandler))
+    }
+    if recordingEnabled {
+        log.Printf("🎥 Traffic recording endpoint enabled")
+        http.HandleFunc("/admin/recording", restrictIPs(adminIPFilter, recordingHandler))
+    }
+    http.HandleFunc("/token/exchange", tokenExchangeHandler)
+    http.HandleFunc("/impersonate", impersonateHandler)
+    http.HandleFunc("/impersonate/", impersonateHandler)
+    http.HandleFunc("/flags", restrictIPs(adminIPFilter, withETag(flagsHandler)))
+    http.HandleFunc("/events", restrictIPs(adminIPFilter, eventsHandler))
+    http.HandleFunc("/discovery", restrictIPs(adminIPFilter, discoveryHandler))
+    http.HandleFunc("/users", usersHandler)
+    http.HandleFunc("/users/", userHandler)
+    http.HandleFunc("/quota", quotaHandler)
+    http.HandleFunc("/attest", attestHandler)
+    http.HandleFunc("/.well-known/jwks.json", withETag(jwksHandler))
+    http.HandleFunc("/openapi.json", withETag(openAPIHandler))
+    http.HandleFunc("/ext-authz", extAuthzHandler)
+    http.HandleFunc("/ext-authz/", extAuthzHandler)
+
+    var err error
+    if store, err = openStorage(context.Background()); err != nil {
+        log.Fatalf("❌ Storage init failed: %v", err)
+    }
+    defer store.Close()
+    if err := revocations.reload(context.Background()); err != nil {
+        log.Fatalf("❌ Revocation load failed: %v", err)
+    }
+    go revocations.watch()
+    startOutboxRelay()
+    startFieldReencryption()
+    startRetention()
+    leader.start()
+    discovery.start()
+    shedder.start()
+    runSelftests(context.Background())
+
+    if err := policies.reload(); err != nil {
+        log.Fatalf("❌ Policy load failed: %v", err)
+    }
+    go policies.watch()
+    if err := extAuthzPolicies.reload(); err != nil {
+        log.Fatalf("❌ ext_authz policy load failed: %v", err)
+    }
+    if extAuthzPolicies.file != "" {
+        go extAuthzPolicies.watch()
+    }
+    if err := flags.reload(); err != nil {
+        log.Fatalf("❌ Feature flag load failed: %v", err)
+    }
+    go flags.watch()
+    if err := startConfig(); err != nil {
+        log.Fatalf("❌ Config load failed: %v", err)
+    }
     
-    log.Printf("🚀 Auth Service starting on port %s", port)
-    if err := http.ListenAndServe(":"+port, nil); err != nil {
+    handler := shedLoad(compressResponses(recordTraffic(withoutDebugRoutes(tenantMiddleware(policyMiddleware(sloMiddleware(deadlineMiddleware(requestLogger(quotaMiddleware(maintenanceMiddleware(chaosMiddleware(http.DefaultServeMux))))))))))))
+    if debugAddr != "" {
+        go serveDebug()
+    }
+    server := &http.Server{
+        Addr:    ":" + port,
+        Handler: handler,
+    }
+    if secrets.get().cert != nil {
+        // Certificates come from the secret manager so rotations apply live
+        server.TLSConfig = &tls.Config{
+            MinVersion:     tls.VersionTLS12,
+            GetCertificate: secrets.getCertificate,
+        }
+    }
+    tuneServer(server)
+    server.RegisterOnShutdown(events.close)
+    servers := []*http.Server{server}
+    if spiffeEnabled {
+        if err := spiffe.load(); err != nil {
+            log.Fatalf("❌ SVID load failed: %v", err)
+        }
+        go spiffe.watch()
+        mtls := &http.Server{Addr: ":" + spiffePort, Handler: handler, TLSConfig: spiffe.tlsConfig()}
+        tuneServer(mtls)
+        servers = append(servers, mtls)
+        go func() {
+            log.Printf("🪪 SPIFFE mTLS listener on port %s", spiffePort)
+            if err := mtls.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
+                log.Fatal(err)
+            }
+        }()
+    }
+    drained := drainOnSignal(servers...)
+
+    if server.TLSConfig != nil {
+        log.Printf("🚀 Auth Service starting on port %s (TLS)", port)
+        err = server.ListenAndServeTLS("", "")
+    } else {
+        log.Printf("🚀 Auth Service starting on port %s (h2c %v)", port, h2cEnabled)
+        err = server.ListenAndServe()
+    }
+    if err != http.ErrServerClosed {
         log.Fatal(err)
     }
+    <-drained
 }
\ No newline at end of file
@@ -0,0 +1,191 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "net/http"
+    "os"
+    "os/signal"
+    "strconv"
+    "sync/atomic"
+    "syscall"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+var (
+    // How long to keep serving after SIGTERM while Kubernetes notices the
+    // failing readiness probe and removes the pod from the Service
+    drainDelay      = getEnvDuration("DRAIN_DELAY", 5*time.Second)
+    shutdownTimeout = getEnvDuration("SHUTDOWN_TIMEOUT", 20*time.Second)
+)
+
+// Maintenance mode stops the pod from taking new sign-ins while tokens
+// already issued keep validating. It is per pod, which is what a rolling
+// drain wants; enable it on every replica for a full DB migration.
+type maintenanceState struct {
+    Enabled    bool          `json:"enabled"`
+    Reason     string        `json:"reason,omitempty"`
+    Since      *time.Time    `json:"since,omitempty"`
+    RetryAfter time.Duration `json:"-"`
+}
+
+var maintenance atomic.Pointer[maintenanceState]
+
+func init() {
+    maintenance.Store(&maintenanceState{})
+}
+
+func enterMaintenance(reason string, retryAfter time.Duration) {
+    now := time.Now()
+    maintenance.Store(&maintenanceState{Enabled: true, Reason: reason, Since: &now, RetryAfter: retryAfter})
+    log.Printf("🚧 Maintenance mode on: %s", reason)
+}
+
+// Endpoints that start a new authentication. Everything else, including
+// bearer-token checks for existing sessions, keeps working.
+var newAuthPaths = map[string]bool{
+    "/login":               true,
+    "/register":            true,
+    "/verify-email":        true,
+    "/resend-verification": true,
+    "/generate-token":      true,
+    "/token/exchange":      true,
+    "/device/code":         true,
+    "/device/token":        true,
+    "/device":              true,
+    "/login/magic-link":    true,
+    "/login/callback":      true,
+}
+
+func maintenanceMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if m := maintenance.Load(); m.Enabled && newAuthPaths[r.URL.Path] {
+            w.Header().Set("Retry-After", strconv.Itoa(int(m.RetryAfter.Seconds())))
+            autherr.Write(w, autherr.ErrMaintenance)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// Readiness endpoint: fails during maintenance, when storage is unreachable,
+// when a startup self-test failed or when a chaos experiment says so, and
+// Kubernetes stops routing new traffic here
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+    status := http.StatusOK
+    response := map[string]interface{}{"ready": true, "build": build}
+    if problems := readinessProblems(r.Context()); len(problems) > 0 {
+        status = http.StatusServiceUnavailable
+        response["ready"] = false
+        for check, problem := range problems {
+            response[check] = problem
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(response)
+}
+
+// Why this replica shouldn't take traffic (or lead background jobs), by
+// check; empty when it's ready
+func readinessProblems(ctx context.Context) map[string]interface{} {
+    problems := map[string]interface{}{}
+    if m := maintenance.Load(); m.Enabled {
+        problems["maintenance"] = m
+    }
+    if failed := selftestFailures(); len(failed) > 0 {
+        problems["selftests"] = failed
+    }
+    if chaosNotReady() {
+        problems["chaos"] = "readiness failure injected"
+    }
+    ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+    defer cancel()
+    if err := store.Ping(ctx); err != nil {
+        problems["storage"] = err.Error()
+    }
+    return problems
+}
+
+// Maintenance endpoint: GET shows the current state, POST
+// {"enabled": true, "reason": "...", "retryAfter": "2m"} switches it
+func maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+    if err := requireAdmin(r); err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    switch r.Method {
+    case http.MethodGet:
+    case http.MethodPost:
+        var req struct {
+            Enabled    bool   `json:"enabled"`
+            Reason     string `json:"reason"`
+            RetryAfter string `json:"retryAfter"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            autherr.Write(w, autherr.ErrInvalidRequest)
+            return
+        }
+        retryAfter := 60 * time.Second
+        if req.RetryAfter != "" {
+            d, err := time.ParseDuration(req.RetryAfter)
+            if err != nil || d < 0 {
+                autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("retryAfter must be a duration such as 90s"))
+                return
+            }
+            retryAfter = d
+        }
+
+        actor := "unknown"
+        if p := principalFromContext(r.Context()); p != nil {
+            actor = p.Subject
+        }
+        if req.Enabled {
+            enterMaintenance(req.Reason, retryAfter)
+            recordAudit(r, "maintenance.enabled", actor, map[string]string{"reason": req.Reason})
+        } else {
+            maintenance.Store(&maintenanceState{})
+            log.Printf("✅ Maintenance mode off")
+            recordAudit(r, "maintenance.disabled", actor, nil)
+        }
+    default:
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+
+    m := maintenance.Load()
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "maintenance":       m,
+        "retryAfterSeconds": int(m.RetryAfter.Seconds()),
+    })
+}
+
+// On SIGTERM, fail readiness, keep serving for drainDelay while endpoints
+// update, then let in-flight requests finish before exiting
+func drainOnSignal(servers ...*http.Server) <-chan struct{} {
+    done := make(chan struct{})
+    sig := make(chan os.Signal, 1)
+    signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
+    go func() {
+        <-sig
+        enterMaintenance("shutting down", drainDelay)
+        time.Sleep(drainDelay)
+
+        ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+        defer cancel()
+        for _, s := range servers {
+            if err := s.Shutdown(ctx); err != nil {
+                log.Printf("⚠️  Shutdown of %s incomplete: %v", s.Addr, err)
+            }
+        }
+        log.Printf("👋 Drained, exiting")
+        close(done)
+    }()
+    return done
+}
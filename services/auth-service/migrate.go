@@ -0,0 +1,255 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "embed"
+    "encoding/json"
+    "fmt"
+    "io/fs"
+    "log"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Versioned schema changes, applied in order. Files are named
+// NNNN_description.sql and must never be edited once released; add a new
+// file instead.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+var migrateOnStartup = getEnv("MIGRATE_ON_STARTUP", "true") == "true"
+
+// Arbitrary key for the Postgres advisory lock that stops two replicas
+// migrating at the same time
+const migrationLockID = 7_464_118
+
+const schemaMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    INTEGER PRIMARY KEY,
+    name       TEXT NOT NULL,
+    applied_at TIMESTAMP NOT NULL
+)`
+
+type migration struct {
+    Version int    `json:"version"`
+    Name    string `json:"name"`
+    sql     string
+}
+
+type appliedMigration struct {
+    Version   int       `json:"version"`
+    Name      string    `json:"name"`
+    AppliedAt time.Time `json:"appliedAt"`
+}
+
+func loadMigrations() ([]migration, error) {
+    entries, err := fs.ReadDir(migrationFiles, "migrations")
+    if err != nil {
+        return nil, err
+    }
+    var migrations []migration
+    for _, e := range entries {
+        name := strings.TrimSuffix(e.Name(), ".sql")
+        prefix, _, ok := strings.Cut(name, "_")
+        version, err := strconv.Atoi(prefix)
+        if !ok || err != nil {
+            return nil, fmt.Errorf("migration %s: expected NNNN_description.sql", e.Name())
+        }
+        data, err := migrationFiles.ReadFile("migrations/" + e.Name())
+        if err != nil {
+            return nil, err
+        }
+        migrations = append(migrations, migration{Version: version, Name: name, sql: string(data)})
+    }
+    sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+    for i := 1; i < len(migrations); i++ {
+        if migrations[i].Version == migrations[i-1].Version {
+            return nil, fmt.Errorf("duplicate migration version %d", migrations[i].Version)
+        }
+    }
+    return migrations, nil
+}
+
+// Apply every pending migration, each in its own transaction, returning the
+// ones that ran
+func (s *sqlStorage) migrate(ctx context.Context) ([]migration, error) {
+    migrations, err := loadMigrations()
+    if err != nil {
+        return nil, err
+    }
+
+    conn, err := s.db.Conn(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer conn.Close()
+
+    if s.dialect == dialectPostgres {
+        if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+            return nil, err
+        }
+        defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+    }
+
+    if _, err := conn.ExecContext(ctx, schemaMigrationsTable); err != nil {
+        return nil, err
+    }
+
+    var current int
+    if err := conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+        return nil, err
+    }
+
+    var applied []migration
+    for _, m := range migrations {
+        if m.Version <= current {
+            continue
+        }
+        if err := s.applyMigration(ctx, conn, m); err != nil {
+            return applied, fmt.Errorf("migration %s: %w", m.Name, err)
+        }
+        log.Printf("🗄️  Applied migration %s", m.Name)
+        applied = append(applied, m)
+    }
+    return applied, nil
+}
+
+func (s *sqlStorage) applyMigration(ctx context.Context, conn *sql.Conn, m migration) error {
+    tx, err := conn.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+        return err
+    }
+    if _, err := tx.ExecContext(ctx, s.rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"),
+        m.Version, m.Name, time.Now().UTC()); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+func (s *sqlStorage) appliedMigrations(ctx context.Context) ([]appliedMigration, error) {
+    if _, err := s.db.ExecContext(ctx, schemaMigrationsTable); err != nil {
+        return nil, err
+    }
+    rows, err := s.db.QueryContext(ctx, "SELECT version, name, applied_at FROM schema_migrations ORDER BY version")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    applied := []appliedMigration{}
+    for rows.Next() {
+        var m appliedMigration
+        if err := rows.Scan(&m.Version, &m.Name, &m.AppliedAt); err != nil {
+            return nil, err
+        }
+        applied = append(applied, m)
+    }
+    return applied, rows.Err()
+}
+
+// Schema status shared by the admin endpoint and `auth-service migrate status`
+func schemaStatus(ctx context.Context) (map[string]interface{}, error) {
+    status := map[string]interface{}{"driver": storageDriver}
+    s, ok := store.(*sqlStorage)
+    if !ok {
+        status["managed"] = false
+        return status, nil
+    }
+
+    known, err := loadMigrations()
+    if err != nil {
+        return nil, err
+    }
+    applied, err := s.appliedMigrations(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    current := 0
+    if len(applied) > 0 {
+        current = applied[len(applied)-1].Version
+    }
+    pending := []migration{}
+    for _, m := range known {
+        if m.Version > current {
+            pending = append(pending, m)
+        }
+    }
+    latest := 0
+    if len(known) > 0 {
+        latest = known[len(known)-1].Version
+    }
+
+    status["managed"] = true
+    status["currentVersion"] = current
+    status["latestVersion"] = latest
+    status["upToDate"] = current >= latest
+    status["applied"] = applied
+    status["pending"] = pending
+    return status, nil
+}
+
+// DB status endpoint: current schema version and pending migrations
+func dbStatusHandler(w http.ResponseWriter, r *http.Request) {
+    if err := requireAdmin(r); err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    status, err := schemaStatus(r.Context())
+    if err != nil {
+        log.Printf("❌ Schema status failed: %v", err)
+        autherr.Write(w, autherr.ErrInternal)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(status)
+}
+
+// `auth-service migrate [status]`: run pending migrations (or report on them)
+// and exit, for use from a Job or kubectl exec
+func runMigrateCommand(args []string) int {
+    ctx := context.Background()
+    migrateOnStartup = false
+
+    var err error
+    if store, err = openStorage(ctx); err != nil {
+        log.Printf("❌ Storage init failed: %v", err)
+        return 1
+    }
+    defer store.Close()
+
+    s, ok := store.(*sqlStorage)
+    if !ok {
+        log.Printf("ℹ️  STORAGE_DRIVER=%s has no schema to migrate", storageDriver)
+        return 0
+    }
+
+    if len(args) == 0 || args[0] != "status" {
+        applied, err := s.migrate(ctx)
+        if err != nil {
+            log.Printf("❌ %v", err)
+            return 1
+        }
+        log.Printf("✅ %d migration(s) applied", len(applied))
+    }
+
+    status, err := schemaStatus(ctx)
+    if err != nil {
+        log.Printf("❌ %v", err)
+        return 1
+    }
+    out, _ := json.MarshalIndent(status, "", "  ")
+    fmt.Println(string(out))
+    return 0
+}
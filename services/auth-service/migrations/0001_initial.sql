@@ -0,0 +1,42 @@
+-- Core tables: accounts, API keys, login sessions and the audit trail.
+-- Types are restricted to those SQLite and Postgres both understand.
+
+CREATE TABLE IF NOT EXISTS users (
+    id            TEXT PRIMARY KEY,
+    email         TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    status        TEXT NOT NULL,
+    roles         TEXT NOT NULL DEFAULT '',
+    created_at    TIMESTAMP NOT NULL,
+    verified_at   TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id         TEXT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    owner      TEXT NOT NULL,
+    key_hash   TEXT NOT NULL UNIQUE,
+    roles      TEXT NOT NULL DEFAULT '',
+    scopes     TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    revoked    BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id         TEXT PRIMARY KEY,
+    user_id    TEXT NOT NULL,
+    client_ip  TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS audit_events (
+    id         TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    type       TEXT NOT NULL,
+    subject    TEXT NOT NULL DEFAULT '',
+    ip         TEXT NOT NULL DEFAULT '',
+    details    TEXT NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_created_at ON audit_events (created_at);
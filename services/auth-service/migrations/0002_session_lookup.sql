@@ -0,0 +1,3 @@
+-- Sessions are listed and purged per user.
+
+CREATE INDEX IF NOT EXISTS sessions_user_id ON sessions (user_id);
@@ -0,0 +1,24 @@
+-- Accounts and API keys belong to a tenant, and emails are unique per
+-- tenant. SQLite cannot drop the old UNIQUE (email) constraint in place, so
+-- the users table is rebuilt. Existing rows join the default tenant.
+
+CREATE TABLE users_by_tenant (
+    id            TEXT PRIMARY KEY,
+    tenant        TEXT NOT NULL DEFAULT 'default',
+    email         TEXT NOT NULL,
+    password_hash TEXT NOT NULL,
+    status        TEXT NOT NULL,
+    roles         TEXT NOT NULL DEFAULT '',
+    created_at    TIMESTAMP NOT NULL,
+    verified_at   TIMESTAMP,
+    UNIQUE (tenant, email)
+);
+
+INSERT INTO users_by_tenant (id, email, password_hash, status, roles, created_at, verified_at)
+    SELECT id, email, password_hash, status, roles, created_at, verified_at FROM users;
+
+DROP TABLE users;
+
+ALTER TABLE users_by_tenant RENAME TO users;
+
+ALTER TABLE api_keys ADD COLUMN tenant TEXT NOT NULL DEFAULT 'default';
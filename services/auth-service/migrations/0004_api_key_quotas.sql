@@ -0,0 +1,14 @@
+-- Per-key request quotas, where 0 falls back to the service-wide default,
+-- and the usage counters checked against them: one row per key and period
+-- ("d:2026-01-31" or "m:2026-01").
+
+ALTER TABLE api_keys ADD COLUMN daily_quota INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE api_keys ADD COLUMN monthly_quota INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE api_key_usage (
+    key_id TEXT NOT NULL,
+    period TEXT NOT NULL,
+    count  BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (key_id, period)
+);
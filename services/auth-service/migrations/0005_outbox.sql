@@ -0,0 +1,16 @@
+-- Transactional outbox: domain events are written here in the same
+-- transaction as their audit record and relayed to NATS afterwards. A relay
+-- claims rows by setting claimed_until so replicas don't publish the same
+-- row twice; a crashed relay's claim simply lapses.
+
+CREATE TABLE outbox (
+    id            TEXT PRIMARY KEY,
+    subject       TEXT NOT NULL,
+    payload       TEXT NOT NULL,
+    created_at    TIMESTAMP NOT NULL,
+    claimed_until TIMESTAMP,
+    sent_at       TIMESTAMP,
+    attempts      INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX outbox_pending ON outbox (sent_at, created_at);
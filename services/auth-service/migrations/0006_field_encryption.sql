@@ -0,0 +1,8 @@
+-- Emails may be stored encrypted, which the UNIQUE (tenant, email)
+-- constraint can't see through, so encrypted rows carry a blind index
+-- (keyed HMAC of the email) that is looked up and kept unique instead.
+-- Rows written in the clear leave it NULL.
+
+ALTER TABLE users ADD COLUMN email_index TEXT;
+
+CREATE UNIQUE INDEX users_email_index ON users (tenant, email_index);
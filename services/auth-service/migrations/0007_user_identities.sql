@@ -0,0 +1,14 @@
+-- Accounts at external identity providers (GitHub, Google) linked to local
+-- users, keyed by the provider's stable subject rather than the email so a
+-- changed address at the provider still signs in to the same user.
+
+CREATE TABLE user_identities (
+    tenant     TEXT NOT NULL,
+    provider   TEXT NOT NULL,
+    subject    TEXT NOT NULL,
+    user_id    TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (tenant, provider, subject)
+);
+
+CREATE INDEX user_identities_user ON user_identities (user_id);
@@ -0,0 +1,14 @@
+-- Bulk token revocation: every token of a tenant, a user or a client issued
+-- at or before not_before (Unix seconds, compared with the iat claim) is
+-- rejected. One row per target; revoking again only moves the cutoff later.
+
+CREATE TABLE token_revocations (
+    tenant     TEXT NOT NULL,
+    kind       TEXT NOT NULL,
+    target     TEXT NOT NULL,
+    not_before BIGINT NOT NULL,
+    revoked_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (tenant, kind, target)
+);
+
+CREATE INDEX token_revocations_not_before ON token_revocations (not_before);
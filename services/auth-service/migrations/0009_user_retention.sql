@@ -0,0 +1,9 @@
+-- Deleted accounts are kept, marked with when they were deleted, until the
+-- retention job purges them. The job also drops sessions some time after
+-- they expire, which the expires_at index serves.
+
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX users_deleted_at ON users (deleted_at);
+
+CREATE INDEX sessions_expires_at ON sessions (expires_at);
@@ -0,0 +1,19 @@
+-- Single-use tokens handed out by email or redirect (email verification
+-- and the like), kept here rather than in a pod's memory so any replica can
+-- redeem them. Only the SHA-256 of a token is stored; data holds the flow's
+-- own fields as JSON. Issuing a token for a user replaces their earlier ones
+-- of that kind, which the user index serves.
+
+CREATE TABLE one_time_tokens (
+    kind       TEXT NOT NULL,
+    hash       TEXT NOT NULL,
+    tenant     TEXT NOT NULL,
+    user_id    TEXT NOT NULL,
+    data       TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (kind, hash)
+);
+
+CREATE INDEX one_time_tokens_user ON one_time_tokens (kind, user_id);
+
+CREATE INDEX one_time_tokens_expires_at ON one_time_tokens (expires_at);
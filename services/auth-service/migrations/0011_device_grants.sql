@@ -0,0 +1,20 @@
+-- Pending device authorization grants (RFC 8628), shared by the replicas so
+-- the tool can poll any of them. A grant is keyed by the SHA-256 of its
+-- device code and found by its user code when the user approves it; it is
+-- deleted once its token is handed out. poll_interval is in seconds.
+
+CREATE TABLE device_grants (
+    hash          TEXT PRIMARY KEY,
+    user_code     TEXT NOT NULL,
+    tenant        TEXT NOT NULL,
+    audience      TEXT NOT NULL,
+    poll_interval BIGINT NOT NULL,
+    last_poll     TIMESTAMP,
+    denied        BOOLEAN NOT NULL DEFAULT FALSE,
+    user_id       TEXT NOT NULL DEFAULT '',
+    expires_at    TIMESTAMP NOT NULL
+);
+
+CREATE INDEX device_grants_user_code ON device_grants (tenant, user_code);
+
+CREATE INDEX device_grants_expires_at ON device_grants (expires_at);
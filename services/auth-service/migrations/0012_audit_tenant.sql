@@ -0,0 +1,10 @@
+-- Audit events belong to the tenant they happened in, and each tenant's
+-- admins only see their own. Earlier events recorded a non-default tenant
+-- only inside details, which can't be read portably here, so those are left
+-- with an empty tenant and listed to no one rather than to the wrong tenant.
+
+ALTER TABLE audit_events ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+
+UPDATE audit_events SET tenant = 'default' WHERE details NOT LIKE '%"tenant":%';
+
+CREATE INDEX audit_events_tenant ON audit_events (tenant, created_at);
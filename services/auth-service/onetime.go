@@ -0,0 +1,66 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "log"
+    "time"
+)
+
+// Single-use tokens go through storage so that whichever replica the user
+// lands on can redeem them. The token itself only ever leaves in the link or
+// redirect; storage keeps its SHA-256.
+
+func oneTimeTokenHash(token string) string {
+    sum := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(sum[:])
+}
+
+// Issue a token of kind, replacing any earlier ones of that kind for the user
+func issueOneTimeToken(ctx context.Context, kind, tenant, userID string, ttl time.Duration, data map[string]string) (string, *OneTimeToken, error) {
+    if userID != "" {
+        if err := store.DeleteOneTimeTokens(ctx, kind, userID); err != nil {
+            return "", nil, err
+        }
+    }
+    token := randomHex(32)
+    t := &OneTimeToken{
+        Kind:      kind,
+        Hash:      oneTimeTokenHash(token),
+        Tenant:    tenant,
+        UserID:    userID,
+        Data:      data,
+        ExpiresAt: clock.Now().Add(ttl),
+    }
+    if err := store.CreateOneTimeToken(ctx, t); err != nil {
+        return "", nil, err
+    }
+    return token, t, nil
+}
+
+// Look a live token up without using it
+func findOneTimeToken(ctx context.Context, kind, token string) (*OneTimeToken, bool) {
+    t, err := store.GetOneTimeToken(ctx, kind, oneTimeTokenHash(token))
+    return liveOneTimeToken(t, err)
+}
+
+// Use up a token, returning it when it was live
+func consumeOneTimeToken(ctx context.Context, kind, token string) (*OneTimeToken, bool) {
+    t, err := store.ConsumeOneTimeToken(ctx, kind, oneTimeTokenHash(token))
+    return liveOneTimeToken(t, err)
+}
+
+func liveOneTimeToken(t *OneTimeToken, err error) (*OneTimeToken, bool) {
+    if err != nil {
+        if !errors.Is(err, errTokenNotFound) {
+            log.Printf("⚠️  One-time token lookup failed: %v", err)
+        }
+        return nil, false
+    }
+    if clock.Now().After(t.ExpiresAt) {
+        return nil, false
+    }
+    return t, true
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+    _ "embed"
+    "net/http"
+
+    "auth-service/internal/autherr"
+)
+
+// The OpenAPI spec the clients in pkg/authclient and services/api-service
+// are generated from, served at /openapi.json so other tooling can read the
+// contract from the running service
+//
+//go:embed api/openapi.json
+var openAPISpec []byte
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet && r.Method != http.MethodHead {
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(openAPISpec)
+}
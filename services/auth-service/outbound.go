@@ -0,0 +1,268 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "math/rand"
+    "net"
+    "net/http"
+    "net/http/httptrace"
+    "sort"
+    "sync"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Shared client for every outbound call (webhooks, callbacks, downstream
+// health checks). Nothing in the service should use http.DefaultClient.
+var outbound = newOutboundClient()
+
+var errCircuitOpen = autherr.ErrUpstreamUnavailable.WithMessage("Circuit breaker open")
+
+const (
+    circuitClosed = iota
+    circuitOpen
+    circuitHalfOpen
+)
+
+// circuitBreaker opens after threshold consecutive failures, rejects calls
+// for the cooldown period, then lets a single probe through (half-open)
+type circuitBreaker struct {
+    mu        sync.Mutex
+    state     int
+    failures  int
+    openedAt  time.Time
+    threshold int
+    cooldown  time.Duration
+}
+
+func (b *circuitBreaker) allow() bool {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    switch b.state {
+    case circuitOpen:
+        if time.Since(b.openedAt) < b.cooldown {
+            return false
+        }
+        b.state = circuitHalfOpen
+        return true
+    case circuitHalfOpen:
+        // Probe already in flight
+        return false
+    }
+    return true
+}
+
+func (b *circuitBreaker) record(ok bool) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if ok {
+        b.state = circuitClosed
+        b.failures = 0
+        return
+    }
+    b.failures++
+    if b.state == circuitHalfOpen || b.failures >= b.threshold {
+        b.state = circuitOpen
+        b.openedAt = time.Now()
+    }
+}
+
+func (b *circuitBreaker) currentState() int {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    return b.state
+}
+
+type outboundStats struct {
+    requests       int64
+    failures       int64
+    retries        int64
+    shortCircuited int64
+    connsNew       int64
+    connsReused    int64
+}
+
+type outboundClient struct {
+    client     *http.Client
+    maxRetries int
+    backoff    time.Duration
+    threshold  int
+    cooldown   time.Duration
+
+    mu       sync.Mutex
+    breakers map[string]*circuitBreaker
+    stats    map[string]*outboundStats
+}
+
+func newOutboundClient() *outboundClient {
+    dialer := &net.Dialer{
+        Timeout:   3 * time.Second,
+        KeepAlive: 30 * time.Second,
+    }
+    transport := &http.Transport{
+        Proxy:       http.ProxyFromEnvironment,
+        DialContext: dialer.DialContext,
+        // A custom dialer turns off HTTP/2 over TLS unless asked for
+        ForceAttemptHTTP2:     true,
+        MaxIdleConns:          100,
+        MaxIdleConnsPerHost:   getEnvInt("OUTBOUND_MAX_IDLE_PER_HOST", 20),
+        IdleConnTimeout:       90 * time.Second,
+        TLSHandshakeTimeout:   5 * time.Second,
+        ResponseHeaderTimeout: 5 * time.Second,
+        ExpectContinueTimeout: time.Second,
+    }
+    return &outboundClient{
+        client: &http.Client{
+            Transport: newH2CTransport(transport, dialer),
+            Timeout:   getEnvDuration("OUTBOUND_TIMEOUT", 5*time.Second),
+        },
+        maxRetries: getEnvInt("OUTBOUND_MAX_RETRIES", 2),
+        backoff:    getEnvDuration("OUTBOUND_RETRY_BACKOFF", 100*time.Millisecond),
+        threshold:  getEnvInt("OUTBOUND_BREAKER_THRESHOLD", 5),
+        cooldown:   getEnvDuration("OUTBOUND_BREAKER_COOLDOWN", 30*time.Second),
+        breakers:   make(map[string]*circuitBreaker),
+        stats:      make(map[string]*outboundStats),
+    }
+}
+
+func (c *outboundClient) target(host string) (*circuitBreaker, *outboundStats) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    b, ok := c.breakers[host]
+    if !ok {
+        b = &circuitBreaker{threshold: c.threshold, cooldown: c.cooldown}
+        c.breakers[host] = b
+        c.stats[host] = &outboundStats{}
+    }
+    return b, c.stats[host]
+}
+
+func (c *outboundClient) count(field *int64) {
+    c.mu.Lock()
+    *field++
+    c.mu.Unlock()
+}
+
+// Do sends req through the target's circuit breaker. Idempotent requests (and
+// requests whose body can be replayed via GetBody) are retried on transport
+// errors and 502/503/504 with exponential backoff and full jitter.
+func (c *outboundClient) Do(req *http.Request) (*http.Response, error) {
+    breaker, stats := c.target(req.URL.Host)
+    retryable := isIdempotent(req.Method) && (req.Body == nil || req.GetBody != nil)
+    // Pass our remaining budget on unless the caller chose one
+    propagateBudget := req.Header.Get("X-Request-Timeout") == ""
+
+    for attempt := 0; ; attempt++ {
+        if !breaker.allow() {
+            c.count(&stats.shortCircuited)
+            return nil, errCircuitOpen
+        }
+        c.count(&stats.requests)
+        if budget := remainingBudget(req.Context()); propagateBudget && budget != "" {
+            req.Header.Set("X-Request-Timeout", budget)
+        }
+
+        resp, err := c.client.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+            GotConn: func(info httptrace.GotConnInfo) {
+                if info.Reused {
+                    c.count(&stats.connsReused)
+                } else {
+                    c.count(&stats.connsNew)
+                }
+            },
+        })))
+        failed := err != nil || resp.StatusCode >= 500
+        breaker.record(!failed)
+        if failed {
+            c.count(&stats.failures)
+        }
+
+        retry := retryable && attempt < c.maxRetries && req.Context().Err() == nil &&
+            (err != nil || resp.StatusCode == http.StatusBadGateway ||
+                resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout)
+        if !retry {
+            return resp, err
+        }
+        if resp != nil {
+            io.Copy(io.Discard, resp.Body)
+            resp.Body.Close()
+        }
+        if req.GetBody != nil {
+            body, err := req.GetBody()
+            if err != nil {
+                return nil, err
+            }
+            req.Body = body
+        }
+        c.count(&stats.retries)
+
+        var sleep time.Duration
+        if c.backoff > 0 {
+            sleep = time.Duration(rand.Int63n(int64(c.backoff << attempt)))
+        }
+        select {
+        case <-time.After(sleep):
+        case <-req.Context().Done():
+            return nil, req.Context().Err()
+        }
+    }
+}
+
+func isIdempotent(method string) bool {
+    switch method {
+    case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+        return true
+    }
+    return false
+}
+
+// Prometheus exposition for /metrics
+func (c *outboundClient) writeMetrics(w io.Writer) {
+    c.mu.Lock()
+    hosts := make([]string, 0, len(c.stats))
+    snapshot := make(map[string]outboundStats, len(c.stats))
+    for host, s := range c.stats {
+        hosts = append(hosts, host)
+        snapshot[host] = *s
+    }
+    c.mu.Unlock()
+    sort.Strings(hosts)
+
+    fmt.Fprintf(w, "# HELP auth_outbound_requests_total Outbound HTTP attempts by target\n")
+    fmt.Fprintf(w, "# TYPE auth_outbound_requests_total counter\n")
+    for _, h := range hosts {
+        fmt.Fprintf(w, "auth_outbound_requests_total{target=%q} %d\n", h, snapshot[h].requests)
+    }
+    fmt.Fprintf(w, "# HELP auth_outbound_failures_total Outbound attempts that errored or returned 5xx\n")
+    fmt.Fprintf(w, "# TYPE auth_outbound_failures_total counter\n")
+    for _, h := range hosts {
+        fmt.Fprintf(w, "auth_outbound_failures_total{target=%q} %d\n", h, snapshot[h].failures)
+    }
+    fmt.Fprintf(w, "# HELP auth_outbound_retries_total Outbound retries by target\n")
+    fmt.Fprintf(w, "# TYPE auth_outbound_retries_total counter\n")
+    for _, h := range hosts {
+        fmt.Fprintf(w, "auth_outbound_retries_total{target=%q} %d\n", h, snapshot[h].retries)
+    }
+    fmt.Fprintf(w, "# HELP auth_outbound_short_circuited_total Calls rejected by an open circuit breaker\n")
+    fmt.Fprintf(w, "# TYPE auth_outbound_short_circuited_total counter\n")
+    for _, h := range hosts {
+        fmt.Fprintf(w, "auth_outbound_short_circuited_total{target=%q} %d\n", h, snapshot[h].shortCircuited)
+    }
+    fmt.Fprintf(w, "# HELP auth_outbound_connections_total Connections used by outbound attempts, new or reused from the pool\n")
+    fmt.Fprintf(w, "# TYPE auth_outbound_connections_total counter\n")
+    for _, h := range hosts {
+        fmt.Fprintf(w, "auth_outbound_connections_total{target=%q,reused=\"false\"} %d\n", h, snapshot[h].connsNew)
+        fmt.Fprintf(w, "auth_outbound_connections_total{target=%q,reused=\"true\"} %d\n", h, snapshot[h].connsReused)
+    }
+    fmt.Fprintf(w, "# HELP auth_outbound_circuit_state Circuit breaker state (0=closed, 1=open, 2=half-open)\n")
+    fmt.Fprintf(w, "# TYPE auth_outbound_circuit_state gauge\n")
+    for _, h := range hosts {
+        b, _ := c.target(h)
+        fmt.Fprintf(w, "auth_outbound_circuit_state{target=%q} %d\n", h, b.currentState())
+    }
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "sync/atomic"
+    "time"
+
+    "auth-service/internal/natspub"
+)
+
+// Domain events are published to NATS through a transactional outbox: the
+// message is stored in the same transaction as the audit record and a relay
+// goroutine publishes it afterwards, so a broker outage delays events
+// instead of losing them. Delivery is at least once; consumers dedupe on
+// the event ID. Subjects are <NATS_SUBJECT_PREFIX>.<tenant>.<event type>.
+//
+// The outbox needs SQL storage; with the memory driver nothing is published.
+var (
+    natsURL            = os.Getenv("NATS_URL")
+    natsSubjectPrefix  = getEnv("NATS_SUBJECT_PREFIX", "auth")
+    outboxPollInterval = getEnvDuration("OUTBOX_POLL_INTERVAL", time.Second)
+    outboxRetention    = getEnvDuration("OUTBOX_RETENTION", 24*time.Hour)
+    outbox             = &outboxRelay{}
+)
+
+// Audit events that other services care about, and the domain event each
+// is published as
+var domainEvents = map[string]string{
+    "user.registered": "user.created",
+    "user.created":    "user.created",
+    "user.verified":   "user.verified",
+    "user.updated":    "user.updated",
+    "user.deleted":    "user.deleted",
+    "user.purged":     "user.purged",
+    "login.succeeded": "token.issued",
+    "token.exchanged": "token.issued",
+    "login.failed":    "login.failed",
+    "apikey.created":  "apikey.created",
+    "apikey.revoked":  "apikey.revoked",
+    "tokens.revoked":  "tokens.revoked",
+}
+
+// OutboxMessage is one queued publish
+type OutboxMessage struct {
+    ID        string
+    Subject   string
+    Payload   []byte
+    CreatedAt time.Time
+}
+
+// DomainEvent is the published payload
+type DomainEvent struct {
+    ID      string            `json:"id"`
+    Type    string            `json:"type"`
+    Source  string            `json:"source"` // the audit event type
+    Time    time.Time         `json:"time"`
+    Tenant  string            `json:"tenant"`
+    Subject string            `json:"subject,omitempty"`
+    Details map[string]string `json:"details,omitempty"`
+}
+
+// outboxStore is implemented by storage that can queue messages in the
+// same transaction as the audit record
+type outboxStore interface {
+    AppendAuditOutbox(ctx context.Context, e *AuditEvent, m *OutboxMessage) error
+    ClaimOutbox(ctx context.Context, limit int, until time.Time) ([]OutboxMessage, error)
+    MarkOutboxSent(ctx context.Context, ids []string) error
+    ReleaseOutbox(ctx context.Context, ids []string) error
+    PruneOutbox(ctx context.Context, before time.Time) (int64, error)
+    OutboxPending(ctx context.Context) (int64, error)
+}
+
+type outboxRelay struct {
+    store     outboxStore // nil when publishing is off
+    conn      *natspub.Conn
+    published atomic.Int64
+    failures  atomic.Int64
+}
+
+// Store an audit event, queueing its domain event when publishing is on
+func appendAudit(ctx context.Context, e *AuditEvent) error {
+    if outbox.store != nil {
+        if m := outboxMessage(e); m != nil {
+            return outbox.store.AppendAuditOutbox(ctx, e, m)
+        }
+    }
+    return store.AppendAudit(ctx, e)
+}
+
+func outboxMessage(e *AuditEvent) *OutboxMessage {
+    eventType, ok := domainEvents[e.Type]
+    if !ok {
+        return nil
+    }
+    tenant := defaultTenant
+    var details map[string]string
+    for k, v := range e.Details {
+        if k == "tenant" {
+            tenant = v
+            continue
+        }
+        if details == nil {
+            details = map[string]string{}
+        }
+        details[k] = v
+    }
+    payload, err := json.Marshal(DomainEvent{
+        ID:      e.ID,
+        Type:    eventType,
+        Source:  e.Type,
+        Time:    e.Time,
+        Tenant:  tenant,
+        Subject: e.Subject,
+        Details: details,
+    })
+    if err != nil {
+        return nil
+    }
+    return &OutboxMessage{
+        ID:        e.ID,
+        Subject:   natsSubjectPrefix + "." + tenant + "." + eventType,
+        Payload:   payload,
+        CreatedAt: e.Time,
+    }
+}
+
+// Turn publishing on if NATS is configured and storage supports it
+func startOutboxRelay() {
+    if natsURL == "" {
+        return
+    }
+    s, ok := store.(outboxStore)
+    if !ok {
+        log.Printf("⚠️  NATS_URL is set but STORAGE_DRIVER=%s has no outbox; events will not be published", storageDriver)
+        return
+    }
+    outbox.store = s
+    log.Printf("📤 Publishing domain events to %s under %s.*", natsURL, natsSubjectPrefix)
+    leader.run("outbox-relay", outbox.run)
+}
+
+// Relay until ctx is done, i.e. until this replica stops leading
+func (o *outboxRelay) run(ctx context.Context) {
+    defer func() {
+        if o.conn != nil {
+            o.conn.Close()
+            o.conn = nil
+        }
+    }()
+    backoff := time.Second
+    lastPrune := time.Time{}
+    for {
+        wait := outboxPollInterval
+        if err := o.drain(ctx); err != nil && ctx.Err() == nil {
+            o.failures.Add(1)
+            log.Printf("⚠️  Outbox relay: %v; retrying in %s", err, backoff)
+            if o.conn != nil {
+                o.conn.Close()
+                o.conn = nil
+            }
+            wait = backoff
+            backoff = min(backoff*2, 30*time.Second)
+        } else {
+            backoff = time.Second
+        }
+
+        if time.Since(lastPrune) > time.Hour {
+            lastPrune = time.Now()
+            if n, err := o.store.PruneOutbox(ctx, time.Now().Add(-outboxRetention)); err != nil {
+                log.Printf("⚠️  Outbox prune failed: %v", err)
+            } else if n > 0 {
+                log.Printf("🧹 Pruned %d published outbox messages", n)
+            }
+        }
+        select {
+        case <-time.After(wait):
+        case <-ctx.Done():
+            return
+        }
+    }
+}
+
+// Publish everything pending, a batch at a time. A batch is only marked sent
+// once the server has acknowledged it with a PONG.
+func (o *outboxRelay) drain(ctx context.Context) error {
+    const batch = 100
+    for {
+        msgs, err := o.store.ClaimOutbox(ctx, batch, time.Now().Add(30*time.Second))
+        if err != nil {
+            return fmt.Errorf("claim: %w", err)
+        }
+        if len(msgs) == 0 {
+            return nil
+        }
+        ids := make([]string, len(msgs))
+        for i, m := range msgs {
+            ids[i] = m.ID
+        }
+        if err := o.publish(msgs); err != nil {
+            if err := o.store.ReleaseOutbox(ctx, ids); err != nil {
+                log.Printf("⚠️  Outbox release failed: %v", err)
+            }
+            return err
+        }
+        if err := o.store.MarkOutboxSent(ctx, ids); err != nil {
+            return fmt.Errorf("mark sent: %w", err)
+        }
+        o.published.Add(int64(len(msgs)))
+        if len(msgs) < batch {
+            return nil
+        }
+    }
+}
+
+func (o *outboxRelay) publish(msgs []OutboxMessage) error {
+    if o.conn == nil {
+        conn, err := natspub.Dial(natsURL, "auth-service", 5*time.Second)
+        if err != nil {
+            return fmt.Errorf("connect: %w", err)
+        }
+        o.conn = conn
+    }
+    for _, m := range msgs {
+        if err := o.conn.Publish(m.Subject, m.Payload); err != nil {
+            return fmt.Errorf("publish: %w", err)
+        }
+    }
+    if err := o.conn.Flush(); err != nil {
+        return fmt.Errorf("flush: %w", err)
+    }
+    return nil
+}
+
+func (o *outboxRelay) writeMetrics(w io.Writer) {
+    if o.store == nil {
+        return
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+    defer cancel()
+    fmt.Fprintf(w, "# HELP auth_outbox_published_total Domain events published to NATS\n")
+    fmt.Fprintf(w, "# TYPE auth_outbox_published_total counter\n")
+    fmt.Fprintf(w, "auth_outbox_published_total %d\n", o.published.Load())
+    fmt.Fprintf(w, "# HELP auth_outbox_failures_total Outbox relay attempts that failed\n")
+    fmt.Fprintf(w, "# TYPE auth_outbox_failures_total counter\n")
+    fmt.Fprintf(w, "auth_outbox_failures_total %d\n", o.failures.Load())
+    if n, err := o.store.OutboxPending(ctx); err == nil {
+        fmt.Fprintf(w, "# HELP auth_outbox_pending Domain events waiting to be published\n")
+        fmt.Fprintf(w, "# TYPE auth_outbox_pending gauge\n")
+        fmt.Fprintf(w, "auth_outbox_pending %d\n", n)
+    }
+}
@@ -0,0 +1,348 @@
+// Code generated by cmd/sdkgen from api/openapi.json. DO NOT EDIT.
+
+// Package authclient is a typed client for the auth-service API (version 1.0.0).
+// Failed calls return an *autherr.Error carrying the response's status and
+// error code, so callers can branch with errors.Is against the autherr
+// sentinels.
+package authclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"auth-service/internal/autherr"
+)
+
+// AuthResponse mirrors the AuthResponse schema
+type AuthResponse struct {
+	Timestamp time.Time `json:"timestamp"`
+	User      string    `json:"user,omitempty"`
+	Valid     bool      `json:"valid"`
+}
+
+// AuthenticateRequest mirrors the AuthenticateRequest schema
+type AuthenticateRequest struct {
+	Token string `json:"token"`
+}
+
+// BatchItem mirrors the BatchItem schema
+type BatchItem struct {
+	APIKey               string `json:"apiKey,omitempty"`
+	ClientCertThumbprint string `json:"clientCertThumbprint,omitempty"`
+	ClientIP             string `json:"clientIp,omitempty"`
+	ID                   string `json:"id"`
+	Token                string `json:"token,omitempty"`
+}
+
+// BatchRequest mirrors the BatchRequest schema
+type BatchRequest struct {
+	Items []BatchItem `json:"items"`
+}
+
+// BatchResponse mirrors the BatchResponse schema
+type BatchResponse struct {
+	Count     int           `json:"count"`
+	Results   []BatchResult `json:"results"`
+	Timestamp time.Time     `json:"timestamp"`
+	Valid     int           `json:"valid"` // How many results are valid
+}
+
+// BatchResult mirrors the BatchResult schema
+type BatchResult struct {
+	Error     *ErrorDetail `json:"error,omitempty"`
+	ExpiresAt int64        `json:"expiresAt,omitempty"`
+	ID        string       `json:"id"`
+	Principal *Principal   `json:"principal,omitempty"`
+	Valid     bool         `json:"valid"`
+}
+
+// BuildInfo mirrors the BuildInfo schema
+type BuildInfo struct {
+	BuildTime string `json:"buildTime,omitempty"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"goVersion"`
+	Modified  bool   `json:"modified,omitempty"` // Built from a checkout with uncommitted changes
+	Version   string `json:"version"`
+}
+
+// ErrorDetail mirrors the ErrorDetail schema
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// HealthResponse mirrors the HealthResponse schema
+type HealthResponse struct {
+	Build     *BuildInfo `json:"build,omitempty"`
+	Service   string     `json:"service"`
+	Status    string     `json:"status"`
+	Timestamp time.Time  `json:"timestamp"`
+	Uptime    float64    `json:"uptime"` // Seconds since start
+	Version   string     `json:"version"`
+}
+
+// LoginRequest mirrors the LoginRequest schema
+type LoginRequest struct {
+	Audience string `json:"audience,omitempty"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// MagicLinkCallbackRequest mirrors the MagicLinkCallbackRequest schema
+type MagicLinkCallbackRequest struct {
+	Token string `json:"token"`
+}
+
+// MagicLinkRequest mirrors the MagicLinkRequest schema
+type MagicLinkRequest struct {
+	Audience string `json:"audience,omitempty"`
+	Email    string `json:"email"`
+}
+
+// MessageResponse mirrors the MessageResponse schema
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+// Principal mirrors the Principal schema
+type Principal struct {
+	ImpersonatedBy string   `json:"impersonatedBy,omitempty"`
+	Kind           string   `json:"kind"` // user, service or workload
+	Roles          []string `json:"roles,omitempty"`
+	Scopes         []string `json:"scopes,omitempty"`
+	Subject        string   `json:"subject"`
+	Tenant         string   `json:"tenant,omitempty"`
+}
+
+// QuotaResponse mirrors the QuotaResponse schema
+type QuotaResponse struct {
+	Daily   *QuotaWindow `json:"daily"`
+	KeyID   string       `json:"keyId"`
+	Monthly *QuotaWindow `json:"monthly"`
+}
+
+// QuotaWindow mirrors the QuotaWindow schema
+type QuotaWindow struct {
+	Limit     int64     `json:"limit"` // 0 when unlimited
+	Remaining int64     `json:"remaining"`
+	ResetsAt  time.Time `json:"resetsAt"`
+	Used      int64     `json:"used"`
+}
+
+// RegisterRequest mirrors the RegisterRequest schema
+type RegisterRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// RegisterResponse mirrors the RegisterResponse schema
+type RegisterResponse struct {
+	Email   string `json:"email"`
+	ID      string `json:"id"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+// TokenResponse mirrors the TokenResponse schema
+type TokenResponse struct {
+	Audience  string `json:"audience"`
+	ExpiresIn int    `json:"expiresIn"` // Seconds
+	Token     string `json:"token"`
+	TokenType string `json:"tokenType"`
+}
+
+// ValidateResponse mirrors the ValidateResponse schema
+type ValidateResponse struct {
+	Audience       string    `json:"audience,omitempty"`
+	ImpersonatedBy string    `json:"impersonatedBy,omitempty"`
+	Message        string    `json:"message,omitempty"`
+	Service        string    `json:"service"`
+	Timestamp      time.Time `json:"timestamp"`
+	User           string    `json:"user,omitempty"`
+	Valid          bool      `json:"valid"`
+}
+
+// Client calls the auth service. Credentials left empty are not sent.
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+
+	// Sent as X-API-Key
+	APIKey string
+	// Sent as X-Internal-API-Key
+	InternalAPIKey string
+	// Sent as X-Service-Token
+	ServiceToken string
+	// Sent as X-Tenant-ID
+	Tenant string
+}
+
+// New returns a client for the service at baseURL
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), HTTPClient: &http.Client{Timeout: 15 * time.Second}}
+}
+
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	}
+	if c.InternalAPIKey != "" {
+		req.Header.Set("X-Internal-API-Key", c.InternalAPIKey)
+	}
+	if c.ServiceToken != "" {
+		req.Header.Set("X-Service-Token", c.ServiceToken)
+	}
+	if c.Tenant != "" {
+		req.Header.Set("X-Tenant-ID", c.Tenant)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error *autherr.Error `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&e) != nil || e.Error == nil {
+			return &autherr.Error{Status: resp.StatusCode, Code: "http_error", Message: resp.Status}
+		}
+		e.Error.Status = resp.StatusCode
+		return e.Error
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Authenticate calls POST /authenticate: check a user token on behalf of a service
+func (c *Client) Authenticate(ctx context.Context, req *AuthenticateRequest) (*AuthResponse, error) {
+	header := http.Header{}
+	var out AuthResponse
+	if err := c.do(ctx, "POST", "/authenticate", header, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// BuildInfo calls GET /build-info: version, commit and build time of the running binary
+func (c *Client) BuildInfo(ctx context.Context) (*BuildInfo, error) {
+	header := http.Header{}
+	var out BuildInfo
+	if err := c.do(ctx, "GET", "/build-info", header, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Health calls GET /health: liveness and build information
+func (c *Client) Health(ctx context.Context) (*HealthResponse, error) {
+	header := http.Header{}
+	var out HealthResponse
+	if err := c.do(ctx, "GET", "/health", header, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Login calls POST /login: exchange credentials for a signed token
+func (c *Client) Login(ctx context.Context, req *LoginRequest) (*TokenResponse, error) {
+	header := http.Header{}
+	var out TokenResponse
+	if err := c.do(ctx, "POST", "/login", header, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// MagicLinkCallback calls POST /login/callback: exchange a sign-in link's token for a signed token
+func (c *Client) MagicLinkCallback(ctx context.Context, req *MagicLinkCallbackRequest) (*TokenResponse, error) {
+	header := http.Header{}
+	var out TokenResponse
+	if err := c.do(ctx, "POST", "/login/callback", header, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// RequestMagicLink calls POST /login/magic-link: send a single-use sign-in link to the account's address
+func (c *Client) RequestMagicLink(ctx context.Context, req *MagicLinkRequest) (*MessageResponse, error) {
+	header := http.Header{}
+	var out MessageResponse
+	if err := c.do(ctx, "POST", "/login/magic-link", header, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Quota calls GET /quota: the calling API key's daily and monthly usage
+func (c *Client) Quota(ctx context.Context) (*QuotaResponse, error) {
+	header := http.Header{}
+	var out QuotaResponse
+	if err := c.do(ctx, "GET", "/quota", header, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Register calls POST /register: create a pending account and mail a verification link
+func (c *Client) Register(ctx context.Context, req *RegisterRequest) (*RegisterResponse, error) {
+	header := http.Header{}
+	var out RegisterResponse
+	if err := c.do(ctx, "POST", "/register", header, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Validate calls GET /validate: check the caller's service credentials and, when given, a user token issued for the caller
+func (c *Client) Validate(ctx context.Context, subjectToken string, clientIp string, clientCertThumbprint string) (*ValidateResponse, error) {
+	header := http.Header{}
+	if subjectToken != "" {
+		header.Set("X-Subject-Token", subjectToken)
+	}
+	if clientIp != "" {
+		header.Set("X-Client-IP", clientIp)
+	}
+	if clientCertThumbprint != "" {
+		header.Set("X-Client-Cert-Thumbprint", clientCertThumbprint)
+	}
+	var out ValidateResponse
+	if err := c.do(ctx, "GET", "/validate", header, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ValidateBatch calls POST /validate/batch: check up to VALIDATE_BATCH_MAX tokens or API keys in one round trip
+func (c *Client) ValidateBatch(ctx context.Context, req *BatchRequest) (*BatchResponse, error) {
+	header := http.Header{}
+	var out BatchResponse
+	if err := c.do(ctx, "POST", "/validate/batch", header, req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -0,0 +1,360 @@
+// Package tokenverify verifies auth-service tokens locally against the
+// service's JWKS, so a downstream service needs no network hop to /validate
+// per request. Keys are cached in memory and refreshed in the background,
+// backing off while the auth service is unreachable; a token signed with a
+// key not yet in the cache triggers an early refresh, which is how rotations
+// are picked up.
+//
+// Only EdDSA tokens can be verified this way. HS256 tokens (issued while the
+// auth service has no token-signing-key) still need /validate.
+package tokenverify
+
+import (
+    "context"
+    "crypto/ed25519"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+var (
+    ErrMissingToken   = autherr.ErrUnauthenticated.WithMessage("Bearer token is required")
+    ErrMalformedToken = autherr.ErrInvalidToken.WithMessage("Malformed token")
+    ErrUnsupportedAlg = autherr.ErrInvalidToken.WithMessage("Token cannot be verified locally; use /validate")
+    ErrUnknownKey     = autherr.ErrInvalidToken.WithMessage("Token signed with an unknown key")
+    ErrBadSignature   = autherr.ErrInvalidToken.WithMessage("Invalid token signature")
+    ErrExpired        = autherr.ErrExpiredToken
+    ErrNotYetValid    = autherr.ErrInvalidToken.WithMessage("Token is not valid yet")
+    ErrWrongAudience  = autherr.ErrInvalidToken.WithMessage("Token was not issued for this service")
+    ErrWrongTenant    = autherr.ErrInvalidToken.WithMessage("Token belongs to another tenant")
+    ErrWrongClient    = autherr.ErrInvalidToken.WithMessage("Token is bound to a different client")
+)
+
+// Claims mirrors the auth service's token claims
+type Claims struct {
+    ID        string   `json:"jti,omitempty"`
+    Subject   string   `json:"sub"`
+    Email     string   `json:"email,omitempty"`
+    Roles     []string `json:"roles,omitempty"`
+    Scopes    []string `json:"scopes,omitempty"`
+    Audience  string   `json:"aud,omitempty"`
+    Tenant    string   `json:"tid,omitempty"`
+    Actor     *Actor   `json:"act,omitempty"`
+    IssuedAt  int64    `json:"iat"`
+    NotBefore int64    `json:"nbf,omitempty"`
+    ExpiresAt int64    `json:"exp"`
+
+    // Admin acting as the subject. Impersonation can be ended early, which
+    // only the auth service knows about, so services that care should
+    // confirm such tokens with /validate.
+    ImpersonatedBy string `json:"impersonated_by,omitempty"`
+
+    // Client the token is bound to: the SHA-256 thumbprint of its mTLS
+    // certificate and its network. VerifyRequest checks them against the
+    // request's own connection; behind a proxy, confirm bound tokens with
+    // /validate, passing the client's details.
+    Confirmation *Confirmation `json:"cnf,omitempty"`
+    BoundCIDR    string        `json:"cidr,omitempty"`
+}
+
+// Confirmation is the cnf claim of a certificate-bound token
+type Confirmation struct {
+    X5tS256 string `json:"x5t#S256,omitempty"`
+}
+
+// Actor is the delegation chain of an exchanged token
+type Actor struct {
+    Subject string `json:"sub"`
+    Actor   *Actor `json:"act,omitempty"`
+}
+
+// Config for a Verifier. Only JWKSURL is required.
+type Config struct {
+    JWKSURL string // e.g. http://auth-service:8080/.well-known/jwks.json
+
+    // Audience the service accepts; tokens for any other audience are
+    // refused. Empty skips the check.
+    Audience string
+    // Tenant whose keys to fetch; empty is the default tenant
+    Tenant string
+
+    RefreshInterval time.Duration // default 5m
+    MinRefresh      time.Duration // least time between unknown-kid refreshes, default 10s
+    Leeway          time.Duration // allowed clock drift for exp and nbf, default 30s
+    Client          *http.Client  // default has a 5s timeout
+}
+
+// Verifier holds the key cache. It is safe for concurrent use.
+type Verifier struct {
+    cfg Config
+
+    mu          sync.RWMutex
+    keys        map[string]ed25519.PublicKey
+    fetchedAt   time.Time
+    lastAttempt time.Time
+    lastErr     error
+
+    refresh chan struct{}
+}
+
+func New(cfg Config) *Verifier {
+    if cfg.RefreshInterval <= 0 {
+        cfg.RefreshInterval = 5 * time.Minute
+    }
+    if cfg.MinRefresh <= 0 {
+        cfg.MinRefresh = 10 * time.Second
+    }
+    if cfg.Leeway <= 0 {
+        cfg.Leeway = 30 * time.Second
+    }
+    if cfg.Client == nil {
+        cfg.Client = &http.Client{Timeout: 5 * time.Second}
+    }
+    return &Verifier{cfg: cfg, refresh: make(chan struct{}, 1)}
+}
+
+// Run fetches the JWKS and keeps it fresh until ctx is done. Failed fetches
+// are retried with exponential backoff, capped at the refresh interval;
+// cached keys stay in use meanwhile.
+func (v *Verifier) Run(ctx context.Context) {
+    backoff := time.Second
+    for {
+        wait := v.cfg.RefreshInterval
+        if err := v.Refresh(ctx); err != nil {
+            wait = backoff
+            backoff = min(backoff*2, v.cfg.RefreshInterval)
+        } else {
+            backoff = time.Second
+        }
+        timer := time.NewTimer(wait)
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return
+        case <-timer.C:
+        case <-v.refresh:
+            timer.Stop()
+        }
+    }
+}
+
+// Refresh fetches the JWKS now and replaces the cached keys
+func (v *Verifier) Refresh(ctx context.Context) error {
+    v.mu.Lock()
+    v.lastAttempt = time.Now()
+    v.mu.Unlock()
+
+    keys, err := v.fetch(ctx)
+    v.mu.Lock()
+    defer v.mu.Unlock()
+    v.lastErr = err
+    if err != nil {
+        return err
+    }
+    v.keys, v.fetchedAt = keys, time.Now()
+    return nil
+}
+
+func (v *Verifier) fetch(ctx context.Context) (map[string]ed25519.PublicKey, error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.cfg.JWKSURL, nil)
+    if err != nil {
+        return nil, err
+    }
+    if v.cfg.Tenant != "" {
+        req.Header.Set("X-Tenant-ID", v.cfg.Tenant)
+    }
+    resp, err := v.cfg.Client.Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("tokenverify: JWKS fetch returned %s", resp.Status)
+    }
+    var set struct {
+        Keys []struct {
+            Kty string `json:"kty"`
+            Crv string `json:"crv"`
+            X   string `json:"x"`
+            Kid string `json:"kid"`
+        } `json:"keys"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+        return nil, fmt.Errorf("tokenverify: invalid JWKS: %w", err)
+    }
+    keys := make(map[string]ed25519.PublicKey, len(set.Keys))
+    for _, k := range set.Keys {
+        if k.Kty != "OKP" || k.Crv != "Ed25519" || k.Kid == "" {
+            continue
+        }
+        x, err := base64.RawURLEncoding.DecodeString(k.X)
+        if err != nil || len(x) != ed25519.PublicKeySize {
+            continue
+        }
+        keys[k.Kid] = ed25519.PublicKey(x)
+    }
+    return keys, nil
+}
+
+// Ready reports whether keys have been fetched at least once, for readiness
+// probes
+func (v *Verifier) Ready() bool {
+    v.mu.RLock()
+    defer v.mu.RUnlock()
+    return v.keys != nil
+}
+
+// Ask Run for an early refresh, at most once per MinRefresh
+func (v *Verifier) requestRefresh() {
+    v.mu.RLock()
+    recent := time.Since(v.lastAttempt) < v.cfg.MinRefresh
+    v.mu.RUnlock()
+    if recent {
+        return
+    }
+    select {
+    case v.refresh <- struct{}{}:
+    default:
+    }
+}
+
+// Verify a token's signature, times, tenant and audience
+func (v *Verifier) Verify(token string) (*Claims, error) {
+    parts := strings.Split(token, ".")
+    if len(parts) != 3 {
+        return nil, ErrMalformedToken
+    }
+    enc := base64.RawURLEncoding
+    headerJSON, err := enc.DecodeString(parts[0])
+    if err != nil {
+        return nil, ErrMalformedToken
+    }
+    var header struct {
+        Alg string `json:"alg"`
+        Kid string `json:"kid"`
+    }
+    if err := json.Unmarshal(headerJSON, &header); err != nil {
+        return nil, ErrMalformedToken
+    }
+    if header.Alg != "EdDSA" {
+        return nil, ErrUnsupportedAlg
+    }
+
+    v.mu.RLock()
+    key := v.keys[header.Kid]
+    v.mu.RUnlock()
+    if key == nil {
+        v.requestRefresh()
+        return nil, ErrUnknownKey
+    }
+    sig, err := enc.DecodeString(parts[2])
+    if err != nil || !ed25519.Verify(key, []byte(parts[0]+"."+parts[1]), sig) {
+        return nil, ErrBadSignature
+    }
+    payload, err := enc.DecodeString(parts[1])
+    if err != nil {
+        return nil, ErrMalformedToken
+    }
+    claims := &Claims{}
+    if err := json.Unmarshal(payload, claims); err != nil {
+        return nil, ErrMalformedToken
+    }
+
+    now := time.Now()
+    if now.Add(-v.cfg.Leeway).Unix() >= claims.ExpiresAt {
+        return nil, ErrExpired
+    }
+    if claims.NotBefore != 0 && now.Add(v.cfg.Leeway).Unix() < claims.NotBefore {
+        return nil, ErrNotYetValid
+    }
+    // The default tenant's tokens carry no tid
+    tenant := v.cfg.Tenant
+    if tenant == "default" {
+        tenant = ""
+    }
+    if claims.Tenant != tenant {
+        return nil, ErrWrongTenant
+    }
+    if v.cfg.Audience != "" && claims.Audience != v.cfg.Audience {
+        return nil, ErrWrongAudience
+    }
+    return claims, nil
+}
+
+// VerifyRequest verifies the request's bearer token, and that a bound token
+// came from the client it is bound to
+func (v *Verifier) VerifyRequest(r *http.Request) (*Claims, error) {
+    auth := r.Header.Get("Authorization")
+    token, ok := strings.CutPrefix(auth, "Bearer ")
+    if !ok || token == "" {
+        return nil, ErrMissingToken
+    }
+    claims, err := v.Verify(token)
+    if err != nil {
+        return nil, err
+    }
+    if claims.Confirmation != nil && claims.Confirmation.X5tS256 != "" {
+        if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+            return nil, ErrWrongClient
+        }
+        sum := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+        if subtle.ConstantTimeCompare([]byte(claims.Confirmation.X5tS256), []byte(base64.RawURLEncoding.EncodeToString(sum[:]))) != 1 {
+            return nil, ErrWrongClient
+        }
+    }
+    if claims.BoundCIDR != "" {
+        host, _, err := net.SplitHostPort(r.RemoteAddr)
+        if err != nil {
+            host = r.RemoteAddr
+        }
+        _, network, err := net.ParseCIDR(claims.BoundCIDR)
+        if ip := net.ParseIP(host); err != nil || ip == nil || !network.Contains(ip) {
+            return nil, ErrWrongClient
+        }
+    }
+    return claims, nil
+}
+
+// Middleware rejects requests without a valid bearer token, answering in
+// the auth service's error format, and passes the claims on in the context
+func (v *Verifier) Middleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        claims, err := v.VerifyRequest(r)
+        if err != nil {
+            autherr.Write(w, err)
+            return
+        }
+        next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims)))
+    })
+}
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the claims Middleware verified, or nil
+func ClaimsFromContext(ctx context.Context) *Claims {
+    c, _ := ctx.Value(claimsKey{}).(*Claims)
+    return c
+}
+
+// Status summarises the cache for debugging endpoints
+func (v *Verifier) Status() map[string]interface{} {
+    v.mu.RLock()
+    defer v.mu.RUnlock()
+    status := map[string]interface{}{
+        "keys":      len(v.keys),
+        "fetchedAt": v.fetchedAt,
+    }
+    if v.lastErr != nil {
+        status["lastError"] = v.lastErr.Error()
+    }
+    return status
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// PolicyRule maps a route to the roles, scopes and source networks allowed to
+// call it. Path matches exactly, or as a prefix when it ends in "*".
+type PolicyRule struct {
+    Path    string   `json:"path"`
+    Methods []string `json:"methods,omitempty"`
+    Public  bool     `json:"public,omitempty"`
+    Roles   []string `json:"roles,omitempty"`  // any of
+    Scopes  []string `json:"scopes,omitempty"` // all of
+    CIDRs   []string `json:"cidrs,omitempty"`
+
+    // Discovered services whose pods may call, alongside CIDRs; see discovery.go
+    Services []string `json:"services,omitempty"`
+
+    nets []*net.IPNet
+}
+
+// Policy is evaluated top to bottom; the first matching rule wins
+type Policy struct {
+    Rules    []PolicyRule `json:"rules"`
+    loadedAt time.Time
+}
+
+// Rules for the service's own routes that apply when the loaded policy has
+// none for a route, or no policy is loaded at all, so the operational
+// endpoints never fail open
+var defaultPolicyRules = []PolicyRule{
+    {Path: "/admin/ui*", Public: true}, // the page itself; its API calls are checked
+    {Path: "/admin/*", Roles: []string{"admin"}},
+    {Path: "/audit", Roles: []string{"admin"}},
+    {Path: "/policy", Roles: []string{"admin"}},
+    {Path: "/discovery", Roles: []string{"admin"}},
+    {Path: "/events", Roles: []string{"admin", "service"}},
+    {Path: "/flags", Roles: []string{"admin", "service"}},
+}
+
+var (
+    policyFile     = os.Getenv("POLICY_FILE")
+    policyInterval = getEnvDuration("POLICY_RELOAD_INTERVAL", 10*time.Second)
+    policies       = &policyEngine{file: policyFile, defaults: defaultPolicyRules}
+)
+
+type policyEngine struct {
+    current  atomic.Pointer[Policy]
+    defaults []PolicyRule // consulted when no loaded rule matches
+
+    mu      sync.Mutex
+    file    string
+    modTime time.Time
+}
+
+func parsePolicy(data []byte) (*Policy, error) {
+    var p Policy
+    if err := json.Unmarshal(data, &p); err != nil {
+        return nil, err
+    }
+    for i := range p.Rules {
+        rule := &p.Rules[i]
+        if rule.Path == "" {
+            return nil, fmt.Errorf("rule %d: path is required", i)
+        }
+        for j, m := range rule.Methods {
+            rule.Methods[j] = strings.ToUpper(m)
+        }
+        for _, cidr := range rule.CIDRs {
+            _, n, err := net.ParseCIDR(cidr)
+            if err != nil {
+                return nil, fmt.Errorf("rule %d: %w", i, err)
+            }
+            rule.nets = append(rule.nets, n)
+        }
+    }
+    return &p, nil
+}
+
+// Read and parse a policy file without putting it in force
+func readPolicy(file string) (*Policy, time.Time, error) {
+    info, err := os.Stat(file)
+    if err != nil {
+        return nil, time.Time{}, err
+    }
+    data, err := os.ReadFile(file)
+    if err != nil {
+        return nil, time.Time{}, err
+    }
+    p, err := parsePolicy(data)
+    if err != nil {
+        return nil, time.Time{}, fmt.Errorf("invalid policy %s: %w", file, err)
+    }
+    p.loadedAt = time.Now()
+    return p, info.ModTime(), nil
+}
+
+// Load the policy file if it changed since the last load. A file that fails
+// to parse is rejected and the previous policy stays in force.
+func (e *policyEngine) reload() error {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    if e.file == "" {
+        return nil
+    }
+    info, err := os.Stat(e.file)
+    if err != nil {
+        return err
+    }
+    if !info.ModTime().After(e.modTime) {
+        return nil
+    }
+    p, modTime, err := readPolicy(e.file)
+    if err != nil {
+        return err
+    }
+    e.current.Store(p)
+    e.modTime = modTime
+    log.Printf("📜 Loaded %d policy rules from %s", len(p.Rules), e.file)
+    return nil
+}
+
+// Put a policy read from file in force and follow that file from now on;
+// an empty file drops the policy
+func (e *policyEngine) use(file string, p *Policy, modTime time.Time) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    e.file, e.modTime = file, modTime
+    e.current.Store(p)
+}
+
+// The policy file in force and when it was last modified
+func (e *policyEngine) source() (string, *Policy, time.Time) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    return e.file, e.current.Load(), e.modTime
+}
+
+// Poll the policy file; ConfigMap updates swap the mounted symlink, which
+// shows up as a new modification time. With no file it waits for a config
+// reload to name one.
+func (e *policyEngine) watch() {
+    for range time.Tick(policyInterval) {
+        if err := e.reload(); err != nil {
+            log.Printf("⚠️  Policy reload failed: %v", err)
+        }
+    }
+}
+
+// Evaluate the request against the loaded policy, then the default rules; a
+// nil result means allowed
+func (e *policyEngine) evaluate(r *http.Request, p *Principal) error {
+    rule := e.match(r)
+    if rule == nil {
+        return nil
+    }
+    if len(rule.nets) > 0 || len(rule.Services) > 0 {
+        ip := clientIP(r)
+        if !ipInNets(ip, rule.nets) && !discovery.contains(rule.Services, ip) {
+            return autherr.ErrForbidden.WithMessage("Source address not permitted")
+        }
+    }
+    if rule.Public {
+        return nil
+    }
+    if len(rule.Roles) == 0 && len(rule.Scopes) == 0 {
+        return nil
+    }
+    if p == nil {
+        return autherr.ErrUnauthenticated
+    }
+    if len(rule.Roles) > 0 {
+        allowed := false
+        for _, role := range rule.Roles {
+            allowed = allowed || p.HasRole(role)
+        }
+        if !allowed {
+            return autherr.ErrForbidden.WithMessage("Missing required role")
+        }
+    }
+    for _, scope := range rule.Scopes {
+        if !p.HasScope(scope) {
+            return autherr.ErrForbidden.WithMessage("Missing scope " + scope)
+        }
+    }
+    return nil
+}
+
+// The first rule matching the request, falling back to the default rules,
+// nil when none does
+func (e *policyEngine) match(r *http.Request) *PolicyRule {
+    if policy := e.current.Load(); policy != nil {
+        for i := range policy.Rules {
+            if rule := &policy.Rules[i]; rule.matches(r) {
+                return rule
+            }
+        }
+    }
+    for i := range e.defaults {
+        if rule := &e.defaults[i]; rule.matches(r) {
+            return rule
+        }
+    }
+    return nil
+}
+
+func (rule *PolicyRule) matches(r *http.Request) bool {
+    if len(rule.Methods) > 0 && !containsString(rule.Methods, r.Method) {
+        return false
+    }
+    if strings.HasSuffix(rule.Path, "*") {
+        return strings.HasPrefix(r.URL.Path, strings.TrimSuffix(rule.Path, "*"))
+    }
+    return r.URL.Path == rule.Path
+}
+
+func ipInNets(ip net.IP, nets []*net.IPNet) bool {
+    if ip == nil {
+        return false
+    }
+    for _, n := range nets {
+        if n.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+// Resolve the caller and enforce the loaded policy before any handler runs
+func policyMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        p := principalFromRequest(r)
+        if err := policies.evaluate(r, p); err != nil {
+            log.Printf("🚫 Policy denied %s %s: %v", r.Method, r.URL.Path, err)
+            autherr.Write(w, err)
+            return
+        }
+        next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), p)))
+    })
+}
+
+// Policy endpoint: shows the rules currently in force
+func policyHandler(w http.ResponseWriter, r *http.Request) {
+    file, _, _ := policies.source()
+    response := map[string]interface{}{
+        "source":   file,
+        "loaded":   false,
+        "defaults": policies.defaults,
+    }
+    if p := policies.current.Load(); p != nil {
+        response["loaded"] = true
+        response["loadedAt"] = p.loadedAt
+        response["rules"] = p.Rules
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "net/http"
+    "strings"
+
+    "auth-service/internal/autherr"
+)
+
+// Principal is the authenticated caller behind a request
+type Principal struct {
+    Subject string   `json:"subject"`
+    Kind    string   `json:"kind"` // "user", "service" or "workload"
+    Tenant  string   `json:"tenant,omitempty"`
+    Roles   []string `json:"roles,omitempty"`
+    Scopes  []string `json:"scopes,omitempty"`
+
+    // Set when an admin is acting as this user
+    ImpersonatedBy string `json:"impersonatedBy,omitempty"`
+
+    apiKey *APIKey // set when the caller authenticated with an API key
+}
+
+func (p *Principal) HasRole(role string) bool {
+    return p != nil && containsString(p.Roles, role)
+}
+
+func (p *Principal) HasScope(scope string) bool {
+    return p != nil && containsString(p.Scopes, scope)
+}
+
+type principalKey struct{}
+
+func withPrincipal(ctx context.Context, p *Principal) context.Context {
+    return context.WithValue(ctx, principalKey{}, p)
+}
+
+// Principal resolved by the policy middleware, nil for anonymous requests
+func principalFromContext(ctx context.Context) *Principal {
+    p, _ := ctx.Value(principalKey{}).(*Principal)
+    return p
+}
+
+// Resolve the caller from a SPIFFE client certificate, a bearer token, an API
+// key or the shared service credentials
+func principalFromRequest(r *http.Request) *Principal {
+    if p := spiffePrincipal(r); p != nil {
+        return p
+    }
+    return principalFromHeaders(r, selfAudiences)
+}
+
+// Resolve the caller from credentials in the headers alone, ignoring the
+// connection's own identity. A bearer token must be addressed to one of
+// audiences; nil accepts any.
+func principalFromHeaders(r *http.Request, audiences []string) *Principal {
+    if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+        claims, err := verifyToken(r.Context(), strings.TrimPrefix(auth, "Bearer "))
+        if err != nil {
+            debugf(r, "Bearer token rejected on %s: %v", r.URL.Path, err)
+            return nil
+        }
+        // A token is only good for the tenant it was issued by
+        if tokenTenant(claims) != tenantFromContext(r.Context()) {
+            debugf(r, "Bearer token for tenant %s rejected on %s", tokenTenant(claims), r.URL.Path)
+            return nil
+        }
+        if err := checkBinding(claims, requestPresenter(r)); err != nil {
+            debugf(r, "Bearer token rejected on %s: %v", r.URL.Path, err)
+            return nil
+        }
+        if audiences != nil {
+            if err := checkAudienceIn(claims, audiences); err != nil {
+                debugf(r, "Bearer token for %q rejected on %s", claims.Audience, r.URL.Path)
+                return nil
+            }
+        }
+        return tokenPrincipal(claims)
+    }
+
+    if key := r.Header.Get("X-API-Key"); key != "" {
+        k, err := store.GetAPIKeyByHash(r.Context(), hashAPIKey(key))
+        if err != nil || k.Revoked || k.Tenant != tenantFromContext(r.Context()) {
+            debugf(r, "API key rejected on %s", r.URL.Path)
+            return nil
+        }
+        return apiKeyPrincipal(k)
+    }
+
+    // Once mTLS is enforced, services must identify with their SVID
+    if flags.Enabled("mtls_enforcement") {
+        return nil
+    }
+    if serviceCredentialsValid(r) {
+        return &Principal{
+            Subject: "internal-service",
+            Kind:    "service",
+            Roles:   []string{"service"},
+        }
+    }
+    return nil
+}
+
+func tokenPrincipal(claims *Claims) *Principal {
+    return &Principal{
+        Subject: claims.Subject,
+        Kind:    "user",
+        Tenant:  tokenTenant(claims),
+        Roles:   claims.Roles,
+        Scopes:  claims.Scopes,
+
+        ImpersonatedBy: claims.ImpersonatedBy,
+    }
+}
+
+func apiKeyPrincipal(k *APIKey) *Principal {
+    return &Principal{
+        Subject: k.Owner,
+        Kind:    "service",
+        Tenant:  k.Tenant,
+        Roles:   k.Roles,
+        Scopes:  k.Scopes,
+        apiKey:  k,
+    }
+}
+
+// Admin endpoints check the caller themselves rather than trusting the IP
+// filter and policy in front of them to be configured
+func requireAdmin(r *http.Request) error {
+    p := principalFromContext(r.Context())
+    if p == nil {
+        return autherr.ErrUnauthenticated
+    }
+    if !p.HasRole("admin") {
+        return autherr.ErrForbidden.WithMessage("Requires the admin role")
+    }
+    return nil
+}
+
+// API keys are stored as the hex SHA-256 of the presented secret
+func hashAPIKey(key string) string {
+    sum := sha256.Sum256([]byte(key))
+    return hex.EncodeToString(sum[:])
+}
+
+func containsString(list []string, s string) bool {
+    for _, v := range list {
+        if v == s {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "strconv"
+    "sync/atomic"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Quotas cap how many requests an API key may make per UTC day and month.
+// Unlike the in-memory rate limiters the counts live in storage, so they are
+// shared by every replica and survive restarts. 0 means unlimited.
+var (
+    defaultDailyQuota   = int64(getEnvInt("QUOTA_DAILY", 0))
+    defaultMonthlyQuota = int64(getEnvInt("QUOTA_MONTHLY", 0))
+    quotaExceeded       atomic.Int64
+)
+
+// QuotaWindow is the state of one quota period for a key
+type QuotaWindow struct {
+    Limit     int64     `json:"limit"` // 0 when unlimited
+    Used      int64     `json:"used"`
+    Remaining int64     `json:"remaining"`
+    ResetsAt  time.Time `json:"resetsAt"`
+}
+
+func (q QuotaWindow) exceeded() bool {
+    return q.Limit > 0 && q.Used > q.Limit
+}
+
+// Periods a request at now counts against, and when each ends
+func quotaPeriods(now time.Time) (day, month string, dayEnd, monthEnd time.Time) {
+    now = now.UTC()
+    y, m, d := now.Date()
+    return "d:" + now.Format("2006-01-02"), "m:" + now.Format("2006-01"),
+        time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC), time.Date(y, m+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func keyQuotas(k *APIKey) (daily, monthly int64) {
+    daily, monthly = k.DailyQuota, k.MonthlyQuota
+    if daily == 0 {
+        daily = defaultDailyQuota
+    }
+    if monthly == 0 {
+        monthly = defaultMonthlyQuota
+    }
+    return daily, monthly
+}
+
+// Read (or, when count is set, add one request to) a key's usage
+func keyUsage(ctx context.Context, k *APIKey, count bool) (daily, monthly QuotaWindow, err error) {
+    day, month, dayEnd, monthEnd := quotaPeriods(clock.Now())
+    var used []int64
+    if count {
+        used, err = store.AddAPIKeyUsage(ctx, k.ID, day, month)
+    } else {
+        used, err = store.GetAPIKeyUsage(ctx, k.ID, day, month)
+    }
+    if err != nil {
+        return daily, monthly, err
+    }
+    dailyLimit, monthlyLimit := keyQuotas(k)
+    return quotaWindow(dailyLimit, used[0], dayEnd), quotaWindow(monthlyLimit, used[1], monthEnd), nil
+}
+
+func quotaWindow(limit, used int64, resets time.Time) QuotaWindow {
+    q := QuotaWindow{Limit: limit, Used: used, ResetsAt: resets}
+    if limit > 0 && used < limit {
+        q.Remaining = limit - used
+    }
+    return q
+}
+
+// Count every request made with an API key against its quotas, reporting the
+// tighter window in X-RateLimit-* headers and refusing requests over quota.
+// /quota itself is free so consumers can always check their budget.
+func quotaMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        p := principalFromContext(r.Context())
+        if p == nil || p.apiKey == nil || r.URL.Path == "/quota" {
+            next.ServeHTTP(w, r)
+            return
+        }
+        daily, monthly, err := keyUsage(r.Context(), p.apiKey, true)
+        if err != nil {
+            // Accounting trouble shouldn't take the API down with it
+            log.Printf("⚠️  Quota accounting failed for %s: %v", p.apiKey.ID, err)
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        window := daily
+        if monthly.Limit > 0 && (window.Limit == 0 || monthly.Remaining < window.Remaining || monthly.exceeded()) {
+            window = monthly
+        }
+        if window.Limit > 0 {
+            w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(window.Limit, 10))
+            w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(window.Remaining, 10))
+            w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(window.ResetsAt.Unix(), 10))
+        }
+        if daily.exceeded() || monthly.exceeded() {
+            quotaExceeded.Add(1)
+            w.Header().Set("Retry-After", strconv.Itoa(int(window.ResetsAt.Sub(clock.Now()).Seconds())+1))
+            autherr.Write(w, autherr.ErrRateLimited.WithMessage("API key quota exceeded"))
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// Quota endpoint: the calling API key's daily and monthly usage
+func quotaHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+    p := principalFromContext(r.Context())
+    if p == nil || p.apiKey == nil {
+        autherr.Write(w, autherr.ErrUnauthenticated.WithMessage("Quotas apply to API keys; send X-API-Key"))
+        return
+    }
+    daily, monthly, err := keyUsage(r.Context(), p.apiKey, false)
+    if err != nil {
+        log.Printf("❌ Quota lookup failed for %s: %v", p.apiKey.ID, err)
+        autherr.Write(w, autherr.ErrInternal)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "keyId":   p.apiKey.ID,
+        "daily":   daily,
+        "monthly": monthly,
+    })
+}
+
+func writeQuotaMetrics(w io.Writer) {
+    fmt.Fprintf(w, "# HELP auth_quota_exceeded_total Requests refused because an API key was over quota\n")
+    fmt.Fprintf(w, "# TYPE auth_quota_exceeded_total counter\n")
+    fmt.Fprintf(w, "auth_quota_exceeded_total %d\n", quotaExceeded.Load())
+}
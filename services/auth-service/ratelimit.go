@@ -0,0 +1,75 @@
+package main
+
+import (
+    "sync"
+    "time"
+)
+
+// Fixed-window limiter keyed by an arbitrary string (email, IP, API key)
+type rateLimiter struct {
+    mu      sync.Mutex
+    limit   int
+    window  time.Duration
+    buckets map[string]*rateBucket
+}
+
+type rateBucket struct {
+    count int
+    reset time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+    return &rateLimiter{
+        limit:   limit,
+        window:  window,
+        buckets: make(map[string]*rateBucket),
+    }
+}
+
+// Allow records an attempt for key; when the limit is exceeded it returns
+// false along with the time remaining until the window resets.
+func (l *rateLimiter) Allow(key string) (bool, time.Duration) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    now := time.Now()
+    b, ok := l.buckets[key]
+    if !ok || now.After(b.reset) {
+        b = &rateBucket{reset: now.Add(l.window)}
+        l.buckets[key] = b
+        l.prune(now)
+    }
+    if b.count >= l.limit {
+        return false, b.reset.Sub(now)
+    }
+    b.count++
+    return true, 0
+}
+
+// Drop expired buckets so the map doesn't grow without bound
+func (l *rateLimiter) prune(now time.Time) {
+    for key, b := range l.buckets {
+        if now.After(b.reset) {
+            delete(l.buckets, key)
+        }
+    }
+}
+
+// A limiter's settings
+type rateLimit struct {
+    limit  int
+    window time.Duration
+}
+
+func (l *rateLimiter) settings() rateLimit {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return rateLimit{limit: l.limit, window: l.window}
+}
+
+// Change the limit and window; windows already open keep their reset time
+func (l *rateLimiter) setSettings(s rateLimit) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    l.limit, l.window = s.limit, s.window
+}
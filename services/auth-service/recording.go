@@ -0,0 +1,554 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "mime"
+    "net/http"
+    "net/url"
+    "regexp"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Traffic recording for contract tests: while a recording runs, each API
+// request and its response are kept, with credentials redacted, in a ring
+// buffer that /admin/recording returns as JSON or as a HAR file. Like chaos
+// it is per pod and stops by itself, and the endpoint doesn't exist unless
+// RECORDING_ENABLED=true.
+var (
+    recordingEnabled     = getEnv("RECORDING_ENABLED", "false") == "true"
+    recordingCapacity    = getEnvInt("RECORDING_CAPACITY", 200)
+    recordingMaxBody     = getEnvInt("RECORDING_MAX_BODY", 16384)
+    recordingMaxDuration = getEnvDuration("RECORDING_MAX_DURATION", time.Hour)
+    recording            = &trafficRecorder{}
+)
+
+const redacted = "[REDACTED]"
+
+// RecordingState is the recording in force
+type RecordingState struct {
+    Routes []string  `json:"routes,omitempty"` // path or "prefix*"; empty means every API route
+    Until  time.Time `json:"until"`
+}
+
+// RecordedMessage is one side of an exchange. JSON bodies are kept decoded
+// so they diff cleanly; a body over RECORDING_MAX_BODY is dropped rather
+// than kept half redacted.
+type RecordedMessage struct {
+    Headers   map[string]string `json:"headers,omitempty"`
+    Body      interface{}       `json:"body,omitempty"`
+    BodySize  int64             `json:"bodySize"`
+    Truncated bool              `json:"truncated,omitempty"`
+}
+
+// RecordedRequest is what the client sent
+type RecordedRequest struct {
+    Method string     `json:"method"`
+    URL    string     `json:"url"`
+    Path   string     `json:"path"`
+    Query  url.Values `json:"query,omitempty"`
+    RecordedMessage
+}
+
+// RecordedResponse is what the service answered
+type RecordedResponse struct {
+    Status int `json:"status"`
+    RecordedMessage
+}
+
+// RecordedExchange is a request/response pair
+type RecordedExchange struct {
+    ID         int64            `json:"id"`
+    Tenant     string           `json:"tenant"`
+    Time       time.Time        `json:"time"`
+    DurationMs float64          `json:"durationMs"`
+    Request    RecordedRequest  `json:"request"`
+    Response   RecordedResponse `json:"response"`
+}
+
+type trafficRecorder struct {
+    current  atomic.Pointer[RecordingState]
+    recorded atomic.Int64
+
+    mu      sync.Mutex
+    entries []RecordedExchange // ring of recordingCapacity
+    next    int
+    seq     int64
+}
+
+// Probes, metrics, the event stream and the recording endpoint itself are
+// never recorded
+var recordingExempt = map[string]bool{
+    "/health":          true,
+    "/readyz":          true,
+    "/metrics":         true,
+    "/events":          true,
+    "/admin/recording": true,
+}
+
+// The recording in force, nil when none is
+func (t *trafficRecorder) active() *RecordingState {
+    s := t.current.Load()
+    if s == nil || time.Now().After(s.Until) {
+        return nil
+    }
+    return s
+}
+
+// Start a recording with an empty buffer
+func (t *trafficRecorder) start(s *RecordingState) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.entries = nil
+    t.next = 0
+    t.current.Store(s)
+    log.Printf("🎥 Recording traffic until %s (routes %v)", s.Until.Format(time.RFC3339), s.Routes)
+}
+
+// Stop recording; what was captured stays available
+func (t *trafficRecorder) stop() {
+    t.current.Store(nil)
+}
+
+func (t *trafficRecorder) add(e RecordedExchange) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    t.seq++
+    e.ID = t.seq
+    if len(t.entries) < recordingCapacity {
+        t.entries = append(t.entries, e)
+    } else {
+        t.entries[t.next] = e
+        t.next = (t.next + 1) % recordingCapacity
+    }
+    t.recorded.Add(1)
+}
+
+// The tenant's exchanges, oldest first
+func (t *trafficRecorder) list(tenant string) []RecordedExchange {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    list := []RecordedExchange{}
+    for i := range t.entries {
+        e := t.entries[(t.next+i)%len(t.entries)]
+        if e.Tenant == tenant {
+            list = append(list, e)
+        }
+    }
+    return list
+}
+
+func (s *RecordingState) appliesTo(path string) bool {
+    if recordingExempt[path] {
+        return false
+    }
+    if len(s.Routes) == 0 {
+        return true
+    }
+    for _, route := range s.Routes {
+        if prefix, ok := strings.CutSuffix(route, "*"); path == route || (ok && strings.HasPrefix(path, prefix)) {
+            return true
+        }
+    }
+    return false
+}
+
+// Captures the status and the first recordingMaxBody bytes of the response
+type recordingWriter struct {
+    http.ResponseWriter
+    status int
+    body   bytes.Buffer
+    size   int64
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+    if rw.status == 0 {
+        rw.status = status
+    }
+    rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+    if rw.status == 0 {
+        rw.status = http.StatusOK
+    }
+    if room := recordingMaxBody + 1 - rw.body.Len(); room > 0 {
+        rw.body.Write(p[:min(len(p), room)])
+    }
+    rw.size += int64(len(p))
+    return rw.ResponseWriter.Write(p)
+}
+
+func (rw *recordingWriter) Flush() {
+    if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+        f.Flush()
+    }
+}
+
+// Record requests on the routes being recorded. With no recording running
+// the wrapper stays off the path.
+func recordTraffic(next http.Handler) http.Handler {
+    if !recordingEnabled {
+        return next
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        s := recording.active()
+        if s == nil || !s.appliesTo(r.URL.Path) {
+            next.ServeHTTP(w, r)
+            return
+        }
+        start := time.Now()
+        // Read the body up front so requests rejected before the handler
+        // reads it are recorded too, then hand it on unchanged
+        captured, _ := io.ReadAll(io.LimitReader(r.Body, int64(recordingMaxBody)+1))
+        r.Body = struct {
+            io.Reader
+            io.Closer
+        }{io.MultiReader(bytes.NewReader(captured), r.Body), r.Body}
+        reqSize := int64(len(captured))
+        if len(captured) > recordingMaxBody {
+            reqSize = r.ContentLength
+        }
+
+        rw := &recordingWriter{ResponseWriter: w}
+        next.ServeHTTP(rw, r)
+        if rw.status == 0 {
+            rw.status = http.StatusOK
+        }
+
+        tenant, err := resolveTenant(r)
+        if err != nil {
+            tenant = defaultTenant
+        }
+        scheme := "http"
+        if r.TLS != nil {
+            scheme = "https"
+        }
+        query := redactValues(r.URL.Query())
+        u := url.URL{Scheme: scheme, Host: r.Host, Path: r.URL.Path, RawQuery: query.Encode()}
+        recording.add(RecordedExchange{
+            Tenant:     tenant,
+            Time:       start,
+            DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+            Request: RecordedRequest{
+                Method:          r.Method,
+                URL:             u.String(),
+                Path:            r.URL.Path,
+                Query:           query,
+                RecordedMessage: recordedMessage(r.Header, captured, reqSize),
+            },
+            Response: RecordedResponse{
+                Status:          rw.status,
+                RecordedMessage: recordedMessage(w.Header(), rw.body.Bytes(), rw.size),
+            },
+        })
+    })
+}
+
+func recordedMessage(header http.Header, body []byte, size int64) RecordedMessage {
+    m := RecordedMessage{Headers: redactHeaders(header), BodySize: size}
+    if len(body) > recordingMaxBody {
+        m.Truncated = true
+        return m
+    }
+    if len(body) > 0 {
+        m.Body = redactBody(header.Get("Content-Type"), body)
+    }
+    return m
+}
+
+// Credentials are recognised by name: headers, query parameters and JSON or
+// form fields called e.g. password, client_secret, device_code or anything
+// ending in token or apiKey, plus the OAuth code parameter (but not the code
+// of a JSON error, which tests want to see). Whatever else looks like a JWT
+// goes too.
+var (
+    sensitiveNames    = map[string]bool{"authorization": true, "proxyauthorization": true, "cookie": true, "setcookie": true, "xforwardedclientcert": true, "devicecode": true, "usercode": true, "codeverifier": true}
+    sensitiveSuffixes = []string{"token", "secret", "password", "apikey", "signature"}
+    jwtRe             = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+)
+
+func sensitiveName(name string) bool {
+    name = strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(name))
+    if sensitiveNames[name] {
+        return true
+    }
+    for _, suffix := range sensitiveSuffixes {
+        if strings.HasSuffix(name, suffix) {
+            return true
+        }
+    }
+    return false
+}
+
+func redactHeaders(header http.Header) map[string]string {
+    out := make(map[string]string, len(header))
+    for name, values := range header {
+        value := strings.Join(values, ", ")
+        switch {
+        case strings.EqualFold(name, "Authorization"):
+            // The scheme is part of the contract; the credential isn't
+            if scheme, _, ok := strings.Cut(value, " "); ok {
+                value = scheme + " " + redacted
+            } else {
+                value = redacted
+            }
+        case strings.EqualFold(name, "Location"):
+            value = redactURL(value)
+        case sensitiveName(name):
+            value = redacted
+        default:
+            value = jwtRe.ReplaceAllString(value, redacted)
+        }
+        out[name] = value
+    }
+    return out
+}
+
+func redactValues(values url.Values) url.Values {
+    out := make(url.Values, len(values))
+    for name, list := range values {
+        redactedList := make([]string, len(list))
+        for i, v := range list {
+            if sensitiveName(name) || name == "code" {
+                redactedList[i] = redacted
+            } else {
+                redactedList[i] = jwtRe.ReplaceAllString(v, redacted)
+            }
+        }
+        out[name] = redactedList
+    }
+    return out
+}
+
+// Redirects carry codes and tokens in their query
+func redactURL(value string) string {
+    u, err := url.Parse(value)
+    if err != nil {
+        return redacted
+    }
+    u.RawQuery = redactValues(u.Query()).Encode()
+    return u.String()
+}
+
+// JSON comes back decoded, forms re-encoded and text as is; anything else
+// is left out. Handlers decode JSON whatever the Content-Type says (curl -d
+// labels it a form), so any body that parses as JSON is treated as JSON.
+func redactBody(contentType string, body []byte) interface{} {
+    var v interface{}
+    dec := json.NewDecoder(bytes.NewReader(body))
+    dec.UseNumber()
+    if dec.Decode(&v) == nil && !dec.More() {
+        return redactJSON(v)
+    }
+    mediaType, _, _ := mime.ParseMediaType(contentType)
+    switch {
+    case mediaType == "application/x-www-form-urlencoded":
+        values, err := url.ParseQuery(string(body))
+        if err != nil {
+            return nil
+        }
+        return redactValues(values).Encode()
+    case strings.HasPrefix(mediaType, "text/"):
+        return jwtRe.ReplaceAllString(string(body), redacted)
+    }
+    return nil
+}
+
+func redactJSON(v interface{}) interface{} {
+    switch v := v.(type) {
+    case map[string]interface{}:
+        for key, value := range v {
+            // Only string values: flags such as serviceToken: true stay
+            if _, ok := value.(string); ok && sensitiveName(key) {
+                v[key] = redacted
+                continue
+            }
+            v[key] = redactJSON(value)
+        }
+    case []interface{}:
+        for i := range v {
+            v[i] = redactJSON(v[i])
+        }
+    case string:
+        return jwtRe.ReplaceAllString(v, redacted)
+    }
+    return v
+}
+
+// HAR 1.2 (http://www.softwareishard.com/blog/har-12-spec/), the subset
+// browsers and HAR-to-test converters read
+type harNameValue struct {
+    Name  string `json:"name"`
+    Value string `json:"value"`
+}
+
+func harHeaders(headers map[string]string) []harNameValue {
+    list := make([]harNameValue, 0, len(headers))
+    for name, value := range headers {
+        list = append(list, harNameValue{Name: name, Value: value})
+    }
+    return list
+}
+
+func harText(body interface{}) string {
+    switch b := body.(type) {
+    case nil:
+        return ""
+    case string:
+        return b
+    default:
+        text, _ := json.Marshal(b)
+        return string(text)
+    }
+}
+
+func toHAR(entries []RecordedExchange) map[string]interface{} {
+    harEntries := make([]map[string]interface{}, 0, len(entries))
+    for _, e := range entries {
+        query := []harNameValue{}
+        for name, values := range e.Request.Query {
+            for _, v := range values {
+                query = append(query, harNameValue{Name: name, Value: v})
+            }
+        }
+        request := map[string]interface{}{
+            "method":      e.Request.Method,
+            "url":         e.Request.URL,
+            "httpVersion": "HTTP/1.1",
+            "headers":     harHeaders(e.Request.Headers),
+            "queryString": query,
+            "cookies":     []harNameValue{},
+            "headersSize": -1,
+            "bodySize":    e.Request.BodySize,
+        }
+        if e.Request.Body != nil {
+            request["postData"] = map[string]interface{}{
+                "mimeType": e.Request.Headers["Content-Type"],
+                "text":     harText(e.Request.Body),
+            }
+        }
+        harEntries = append(harEntries, map[string]interface{}{
+            "startedDateTime": e.Time.Format(time.RFC3339Nano),
+            "time":            e.DurationMs,
+            "request":         request,
+            "response": map[string]interface{}{
+                "status":      e.Response.Status,
+                "statusText":  http.StatusText(e.Response.Status),
+                "httpVersion": "HTTP/1.1",
+                "headers":     harHeaders(e.Response.Headers),
+                "cookies":     []harNameValue{},
+                "content": map[string]interface{}{
+                    "size":     e.Response.BodySize,
+                    "mimeType": e.Response.Headers["Content-Type"],
+                    "text":     harText(e.Response.Body),
+                },
+                "redirectURL": e.Response.Headers["Location"],
+                "headersSize": -1,
+                "bodySize":    e.Response.BodySize,
+            },
+            "cache":   map[string]interface{}{},
+            "timings": map[string]interface{}{"send": 0, "wait": e.DurationMs, "receive": 0},
+            "comment": fmt.Sprintf("exchange %d", e.ID),
+        })
+    }
+    return map[string]interface{}{
+        "log": map[string]interface{}{
+            "version": "1.2",
+            "creator": map[string]string{"name": "auth-service", "version": build.Version},
+            "entries": harEntries,
+        },
+    }
+}
+
+// Recording endpoint: GET returns the tenant's recorded exchanges, oldest
+// first (?format=har for a HAR file), POST {"routes": ["/login"],
+// "duration": "15m"} starts a recording with an empty buffer, DELETE stops
+// it and keeps what was captured
+func recordingHandler(w http.ResponseWriter, r *http.Request) {
+    if err := requireAdmin(r); err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    actor := "unknown"
+    if p := principalFromContext(r.Context()); p != nil {
+        actor = p.Subject
+    }
+    switch r.Method {
+    case http.MethodGet:
+        if r.URL.Query().Get("format") == "har" {
+            w.Header().Set("Content-Type", "application/json")
+            w.Header().Set("Cache-Control", "no-store")
+            w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="auth-service-%s.har"`, time.Now().UTC().Format("20060102T150405Z")))
+            json.NewEncoder(w).Encode(toHAR(recording.list(tenantFromContext(r.Context()))))
+            return
+        }
+    case http.MethodPost:
+        var req struct {
+            Routes   []string `json:"routes"`
+            Duration string   `json:"duration"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            autherr.Write(w, autherr.ErrInvalidRequest)
+            return
+        }
+        duration, err := time.ParseDuration(req.Duration)
+        if err != nil || duration <= 0 || duration > recordingMaxDuration {
+            autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("duration must be a duration up to "+recordingMaxDuration.String()))
+            return
+        }
+        s := &RecordingState{Routes: req.Routes, Until: time.Now().Add(duration)}
+        recording.start(s)
+        recordAudit(r, "recording.started", actor, map[string]string{
+            "routes": strings.Join(s.Routes, ","),
+            "until":  s.Until.Format(time.RFC3339),
+        })
+    case http.MethodDelete:
+        if recording.active() != nil {
+            recording.stop()
+            log.Printf("🎥 Recording stopped by %s", actor)
+            recordAudit(r, "recording.stopped", actor, nil)
+        }
+    default:
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+
+    response := map[string]interface{}{
+        "active":    false,
+        "capacity":  recordingCapacity,
+        "recorded":  recording.recorded.Load(),
+        "exchanges": recording.list(tenantFromContext(r.Context())),
+    }
+    if s := recording.active(); s != nil {
+        response["active"] = true
+        response["recording"] = s
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    json.NewEncoder(w).Encode(response)
+}
+
+func writeRecordingMetrics(w io.Writer) {
+    if !recordingEnabled {
+        return
+    }
+    active := 0
+    if recording.active() != nil {
+        active = 1
+    }
+    fmt.Fprintf(w, "# HELP auth_recording_active Whether traffic is being recorded on this pod\n")
+    fmt.Fprintf(w, "# TYPE auth_recording_active gauge\n")
+    fmt.Fprintf(w, "auth_recording_active %d\n", active)
+    fmt.Fprintf(w, "# HELP auth_recorded_exchanges_total Request/response pairs recorded\n")
+    fmt.Fprintf(w, "# TYPE auth_recorded_exchanges_total counter\n")
+    fmt.Fprintf(w, "auth_recorded_exchanges_total %d\n", recording.recorded.Load())
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "sort"
+    "sync/atomic"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Data retention. Deleting a user only marks the account deleted: it can no
+// longer sign in, its tokens are revoked, and for USER_RETENTION it can
+// still be restored by setting its status back to active. Its email stays
+// taken meanwhile. A background job on the leader then, every
+// RETENTION_INTERVAL, purges the users deleted longer ago than that, the
+// sessions that expired more than SESSION_RETENTION ago, expired one-time
+// tokens and device grants, and the audit events older than AUDIT_RETENTION
+// (0 keeps them forever). Purging a user removes
+// the account, its linked identities and sessions, and strips its audit
+// events down to type, time and user ID. Administrators can also export a
+// user's data as a JSON archive or purge the user at once, for access and
+// erasure requests.
+var (
+    userRetention     = getEnvDuration("USER_RETENTION", 30*24*time.Hour)
+    sessionRetention  = getEnvDuration("SESSION_RETENTION", 7*24*time.Hour)
+    auditRetention    = getEnvDuration("AUDIT_RETENTION", 365*24*time.Hour)
+    retentionInterval = getEnvDuration("RETENTION_INTERVAL", time.Hour)
+)
+
+const retentionBatch = 100
+
+var purged struct {
+    users, sessions, audit atomic.Int64
+}
+
+// UserArchive is everything held about a user
+type UserArchive struct {
+    ExportedAt  time.Time    `json:"exportedAt"`
+    User        *User        `json:"user"`
+    Identities  []Identity   `json:"identities"`
+    Sessions    []Session    `json:"sessions"`
+    APIKeys     []APIKey     `json:"apiKeys"`
+    AuditEvents []AuditEvent `json:"auditEvents"`
+}
+
+func startRetention() {
+    leader.run("retention", func(ctx context.Context) {
+        for {
+            runRetention(ctx)
+            select {
+            case <-time.After(retentionInterval):
+            case <-ctx.Done():
+                return
+            }
+        }
+    })
+}
+
+func runRetention(ctx context.Context) {
+    now := clock.Now()
+    for {
+        users, err := store.ListDeletedUsers(ctx, now.Add(-userRetention), retentionBatch)
+        if err != nil {
+            log.Printf("⚠️  Retention: listing deleted users failed: %v", err)
+            break
+        }
+        for i := range users {
+            if err := purgeUser(ctx, &users[i]); err != nil {
+                log.Printf("⚠️  Retention: purging %s failed: %v", users[i].ID, err)
+                return
+            }
+            recordSystemAudit("user.purged", map[string]string{"userId": users[i].ID, "tenant": users[i].Tenant, "trigger": "retention"})
+        }
+        if len(users) < retentionBatch {
+            break
+        }
+    }
+    if n, err := store.PruneSessions(ctx, now.Add(-sessionRetention)); err != nil {
+        log.Printf("⚠️  Retention: session prune failed: %v", err)
+    } else if n > 0 {
+        purged.sessions.Add(n)
+        log.Printf("🧹 Pruned %d expired sessions", n)
+    }
+    if n, err := store.PruneOneTimeTokens(ctx, now); err != nil {
+        log.Printf("⚠️  Retention: one-time token prune failed: %v", err)
+    } else if n > 0 {
+        log.Printf("🧹 Pruned %d expired one-time tokens", n)
+    }
+    if n, err := store.PruneDeviceGrants(ctx, now); err != nil {
+        log.Printf("⚠️  Retention: device grant prune failed: %v", err)
+    } else if n > 0 {
+        log.Printf("🧹 Pruned %d expired device grants", n)
+    }
+    if auditRetention > 0 {
+        if n, err := store.PruneAudit(ctx, now.Add(-auditRetention)); err != nil {
+            log.Printf("⚠️  Retention: audit prune failed: %v", err)
+        } else if n > 0 {
+            purged.audit.Add(n)
+            log.Printf("🧹 Pruned %d audit events older than %s", n, auditRetention)
+        }
+    }
+}
+
+// Mark the user deleted and revoke their tokens; already deleted users are
+// left as they are
+func softDeleteUser(ctx context.Context, u *User) error {
+    if u.Status == UserStatusDeleted {
+        return nil
+    }
+    u.Status = UserStatusDeleted
+    u.DeletedAt = clock.Now()
+    if err := store.UpdateUser(ctx, u); err != nil {
+        return err
+    }
+    return revokeUserTokens(ctx, u)
+}
+
+func purgeUser(ctx context.Context, u *User) error {
+    if err := store.PurgeUser(ctx, u); err != nil {
+        return err
+    }
+    purged.users.Add(1)
+    log.Printf("🧹 Purged user %s", u.ID)
+    return revokeUserTokens(ctx, u)
+}
+
+func revokeUserTokens(ctx context.Context, u *User) error {
+    now := clock.Now()
+    if err := store.RevokeTokens(ctx, &Revocation{Tenant: u.Tenant, Kind: revokeUser, Target: u.ID, NotBefore: now, RevokedAt: now}); err != nil {
+        return err
+    }
+    return revocations.reload(ctx)
+}
+
+func exportUser(ctx context.Context, u *User) (*UserArchive, error) {
+    archive := &UserArchive{ExportedAt: clock.Now(), User: u, APIKeys: []APIKey{}, AuditEvents: []AuditEvent{}}
+    var err error
+    if archive.Identities, err = store.ListIdentities(ctx, u.ID); err != nil {
+        return nil, err
+    }
+    if archive.Sessions, err = store.ListSessions(ctx, SessionFilter{Tenant: u.Tenant, UserID: u.ID, Limit: 10000}); err != nil {
+        return nil, err
+    }
+    keys, err := store.ListAPIKeys(ctx)
+    if err != nil {
+        return nil, err
+    }
+    for _, k := range keys {
+        if k.Tenant == u.Tenant && (k.Owner == u.ID || k.Owner == u.Email) {
+            archive.APIKeys = append(archive.APIKeys, k)
+        }
+    }
+    // Failed logins are recorded under the address rather than the ID; the
+    // same address may belong to a user of another tenant
+    for _, subject := range []string{u.ID, u.Email} {
+        if subject == "" {
+            continue
+        }
+        f := AuditFilter{Tenant: u.Tenant, Subject: subject, Limit: 500}
+        for {
+            page, err := store.ListAudit(ctx, f)
+            if err != nil {
+                return nil, err
+            }
+            archive.AuditEvents = append(archive.AuditEvents, page...)
+            if len(page) < f.Limit {
+                break
+            }
+            f.After, f.AfterTime = page[len(page)-1].ID, page[len(page)-1].Time
+        }
+    }
+    sort.Slice(archive.AuditEvents, func(i, j int) bool { return archive.AuditEvents[i].Time.Before(archive.AuditEvents[j].Time) })
+    return archive, nil
+}
+
+// GET /users/{id}/export downloads the user's archive; POST
+// /users/{id}/purge erases the user at once, deleted or not. Both need the
+// admin role.
+func userDataHandler(w http.ResponseWriter, r *http.Request, user *User, action string) {
+    if p := principalFromContext(r.Context()); p == nil || !p.HasRole("admin") {
+        autherr.Write(w, autherr.ErrForbidden.WithMessage("Exporting or purging user data needs the admin role"))
+        return
+    }
+    switch {
+    case action == "export" && r.Method == http.MethodGet:
+        archive, err := exportUser(r.Context(), user)
+        if err != nil {
+            log.Printf("❌ User export failed: %v", err)
+            autherr.Write(w, autherr.ErrInternal)
+            return
+        }
+        recordAudit(r, "user.exported", user.ID, nil)
+        w.Header().Set("Content-Type", "application/json")
+        w.Header().Set("Cache-Control", "no-store")
+        w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-export.json"`, user.ID))
+        json.NewEncoder(w).Encode(archive)
+    case action == "purge" && r.Method == http.MethodPost:
+        if err := purgeUser(r.Context(), user); err != nil {
+            log.Printf("❌ User purge failed: %v", err)
+            autherr.Write(w, autherr.ErrInternal)
+            return
+        }
+        recordAudit(r, "user.purged", user.ID, map[string]string{"trigger": "request"})
+        w.WriteHeader(http.StatusNoContent)
+    case action == "export" || action == "purge":
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+    default:
+        autherr.Write(w, autherr.ErrNotFound)
+    }
+}
+
+func writeRetentionMetrics(w io.Writer) {
+    fmt.Fprintf(w, "# HELP auth_retention_purged_total Records removed by the retention job or on request\n")
+    fmt.Fprintf(w, "# TYPE auth_retention_purged_total counter\n")
+    fmt.Fprintf(w, "auth_retention_purged_total{kind=\"users\"} %d\n", purged.users.Load())
+    fmt.Fprintf(w, "auth_retention_purged_total{kind=\"sessions\"} %d\n", purged.sessions.Load())
+    fmt.Fprintf(w, "auth_retention_purged_total{kind=\"audit\"} %d\n", purged.audit.Load())
+}
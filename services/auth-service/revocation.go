@@ -0,0 +1,228 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "sort"
+    "sync/atomic"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Bulk token revocation. Tokens stay stateless, so rather than listing
+// revoked tokens the service keeps cutoffs: every token of a tenant, a user
+// or a client issued at or before the cutoff is rejected. A client is the
+// audience a token was issued for or a service that exchanged it. Each
+// replica holds the cutoffs in memory, reloading them from storage every
+// REVOCATION_REFRESH, so the check costs a few map lookups; it runs after
+// the validation cache, which means cached tokens are refused too. Cutoffs
+// older than the longest token lifetime can't match a live token and are
+// no longer loaded. Services verifying tokens offline with pkg/tokenverify
+// don't see revocations.
+const (
+    revokeAll    = "all"
+    revokeUser   = "user"
+    revokeClient = "client"
+)
+
+var revocationRefresh = getEnvDuration("REVOCATION_REFRESH", 10*time.Second)
+
+var errTokenRevoked = autherr.ErrInvalidToken.WithMessage("Token has been revoked")
+
+type revocationKey struct {
+    tenant, kind, target string
+}
+
+type revocationSet struct {
+    list    []Revocation
+    cutoffs map[revocationKey]int64 // Unix seconds
+}
+
+type revocationList struct {
+    current  atomic.Pointer[revocationSet]
+    rejected atomic.Int64
+}
+
+var revocations = &revocationList{}
+
+// The longest a token can live, and so how long a cutoff can matter
+func revocationHorizon() time.Duration {
+    horizon := tokenTTL
+    for _, ttl := range []time.Duration{exchangeTTL, impersonationMaxTTL} {
+        if ttl > horizon {
+            horizon = ttl
+        }
+    }
+    return horizon + tokenLeeway
+}
+
+func (l *revocationList) reload(ctx context.Context) error {
+    list, err := store.ListRevocations(ctx, clock.Now().Add(-revocationHorizon()))
+    if err != nil {
+        return err
+    }
+    set := &revocationSet{list: list, cutoffs: make(map[revocationKey]int64, len(list))}
+    for _, rev := range list {
+        set.cutoffs[revocationKey{rev.Tenant, rev.Kind, rev.Target}] = rev.NotBefore.Unix()
+    }
+    l.current.Store(set)
+    return nil
+}
+
+func (l *revocationList) watch() {
+    for range time.Tick(revocationRefresh) {
+        if err := l.reload(context.Background()); err != nil {
+            log.Printf("⚠️  Revocation reload failed: %v", err)
+        }
+    }
+}
+
+// Reject a verified token that a revocation covers
+func (l *revocationList) check(claims *Claims) error {
+    set := l.current.Load()
+    if set == nil || len(set.cutoffs) == 0 {
+        return nil
+    }
+    tenant := tokenTenant(claims)
+    revoked := func(kind, target string) bool {
+        cutoff, ok := set.cutoffs[revocationKey{tenant, kind, target}]
+        return ok && claims.IssuedAt <= cutoff
+    }
+    hit := revoked(revokeAll, "") || revoked(revokeUser, claims.Subject) ||
+        (claims.Audience != "" && revoked(revokeClient, claims.Audience))
+    for a := claims.Actor; a != nil && !hit; a = a.Actor {
+        hit = revoked(revokeClient, a.Subject)
+    }
+    if hit {
+        l.rejected.Add(1)
+        return errTokenRevoked
+    }
+    return nil
+}
+
+// The tenant's revocations, most recent first
+func (l *revocationList) forTenant(tenant string) []Revocation {
+    list := []Revocation{}
+    if set := l.current.Load(); set != nil {
+        for _, rev := range set.list {
+            if rev.Tenant == tenant {
+                list = append(list, rev)
+            }
+        }
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].RevokedAt.After(list[j].RevokedAt) })
+    return list
+}
+
+// Revocation endpoint: GET lists the tenant's revocations in force, POST
+// {"userId": "..."}, {"client": "api-service"} or {"all": true} revokes the
+// matching tokens, optionally only those issued before
+// {"before": "2024-05-01T12:00:00Z"} rather than now
+func revocationsHandler(w http.ResponseWriter, r *http.Request) {
+    if err := requireAdmin(r); err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    tenant := tenantFromContext(r.Context())
+    switch r.Method {
+    case http.MethodGet:
+    case http.MethodPost:
+        var req struct {
+            UserID string `json:"userId"`
+            Client string `json:"client"`
+            All    bool   `json:"all"`
+            Before string `json:"before"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            autherr.Write(w, autherr.ErrInvalidRequest)
+            return
+        }
+        now := clock.Now()
+        rev := &Revocation{Tenant: tenant, NotBefore: now, RevokedAt: now}
+        targets := 0
+        if req.UserID != "" {
+            rev.Kind, rev.Target = revokeUser, req.UserID
+            targets++
+        }
+        if req.Client != "" {
+            rev.Kind, rev.Target = revokeClient, req.Client
+            targets++
+        }
+        if req.All {
+            rev.Kind = revokeAll
+            targets++
+        }
+        if targets != 1 {
+            autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("Exactly one of userId, client or all is required"))
+            return
+        }
+        if req.Before != "" {
+            before, err := time.Parse(time.RFC3339, req.Before)
+            if err != nil || before.After(now) {
+                autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("before must be an RFC 3339 time no later than now"))
+                return
+            }
+            rev.NotBefore = before
+        }
+        if rev.Kind == revokeUser {
+            user, err := store.GetUser(r.Context(), rev.Target)
+            if err == nil && user.Tenant != tenant {
+                err = errUserNotFound
+            }
+            if err != nil {
+                autherr.Write(w, err)
+                return
+            }
+        }
+        if err := store.RevokeTokens(r.Context(), rev); err != nil {
+            log.Printf("❌ Revoking tokens failed: %v", err)
+            autherr.Write(w, autherr.ErrInternal)
+            return
+        }
+        // Take effect here at once rather than at the next refresh
+        if err := revocations.reload(r.Context()); err != nil {
+            log.Printf("⚠️  Revocation reload failed: %v", err)
+        }
+        actor := "unknown"
+        if p := principalFromContext(r.Context()); p != nil {
+            actor = p.Subject
+        }
+        scope := "the tenant"
+        if rev.Target != "" {
+            scope = rev.Kind + " " + rev.Target
+        }
+        log.Printf("🚫 Tokens of %s issued up to %s revoked by %s", scope, rev.NotBefore.Format(time.RFC3339), actor)
+        recordAudit(r, "tokens.revoked", actor, map[string]string{
+            "kind":   rev.Kind,
+            "target": rev.Target,
+            "before": rev.NotBefore.Format(time.RFC3339),
+        })
+    default:
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "revocations": revocations.forTenant(tenant),
+    })
+}
+
+func writeRevocationMetrics(w io.Writer) {
+    n := 0
+    if set := revocations.current.Load(); set != nil {
+        n = len(set.list)
+    }
+    fmt.Fprintf(w, "# HELP auth_token_revocations Revocation cutoffs in force\n")
+    fmt.Fprintf(w, "# TYPE auth_token_revocations gauge\n")
+    fmt.Fprintf(w, "auth_token_revocations %d\n", n)
+    fmt.Fprintf(w, "# HELP auth_revoked_tokens_rejected_total Verified tokens refused by a revocation\n")
+    fmt.Fprintf(w, "# TYPE auth_revoked_tokens_rejected_total counter\n")
+    fmt.Fprintf(w, "auth_revoked_tokens_rejected_total %d\n", revocations.rejected.Load())
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "fmt"
+    "io"
+    "log"
+    "math"
+    "net"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Risk actions, in increasing severity
+const (
+    RiskAllow = "allow"
+    RiskMFA   = "mfa"
+    RiskDeny  = "deny"
+)
+
+// LoginAttempt is what a RiskScorer sees of one authentication
+type LoginAttempt struct {
+    Tenant string
+    UserID string // empty when the email matched no account
+    Email  string
+    IP     net.IP
+    Time   time.Time
+}
+
+// RiskAssessment is a scorer's verdict; Score runs 0-100
+type RiskAssessment struct {
+    Score   int      `json:"score"`
+    Reasons []string `json:"reasons,omitempty"`
+    Action  string   `json:"action"`
+}
+
+// RiskScorer is consulted before a login succeeds and told the outcome of
+// every attempt so it can build up history. Replace riskScorer to plug in an
+// external service.
+type RiskScorer interface {
+    Assess(ctx context.Context, a LoginAttempt) RiskAssessment
+    Observe(a LoginAttempt, succeeded bool)
+}
+
+var (
+    riskMFAThreshold  = getEnvInt("RISK_MFA_THRESHOLD", 50)
+    riskDenyThreshold = getEnvInt("RISK_DENY_THRESHOLD", 90)
+    riskScorer        RiskScorer = newHeuristicRiskScorer(os.Getenv("GEOIP_DB"))
+    riskOutcomes      = map[string]*atomic.Int64{RiskAllow: {}, RiskMFA: {}, RiskDeny: {}}
+)
+
+// Score a login that passed the password check. Every verdict is counted and
+// any non-zero score is audited; MFA and deny verdicts only stop the login
+// once the risk_enforcement flag is on.
+func assessLoginRisk(r *http.Request, attempt LoginAttempt) error {
+    a := riskScorer.Assess(r.Context(), attempt)
+    if counter, ok := riskOutcomes[a.Action]; ok {
+        counter.Add(1)
+    }
+    if a.Score == 0 && a.Action == RiskAllow {
+        return nil
+    }
+    enforced := flags.Enabled("risk_enforcement")
+    recordAudit(r, "login.risk", attempt.UserID, map[string]string{
+        "score":    strconv.Itoa(a.Score),
+        "reasons":  strings.Join(a.Reasons, ","),
+        "action":   a.Action,
+        "enforced": strconv.FormatBool(enforced),
+        "ip":       attempt.IP.String(),
+    })
+    if !enforced {
+        return nil
+    }
+    switch a.Action {
+    case RiskDeny:
+        return autherr.ErrForbidden.WithMessage("Login blocked as suspicious")
+    case RiskMFA:
+        return autherr.ErrMFARequired
+    }
+    return nil
+}
+
+func riskAction(score int) string {
+    switch {
+    case score >= riskDenyThreshold:
+        return RiskDeny
+    case score >= riskMFAThreshold:
+        return RiskMFA
+    }
+    return RiskAllow
+}
+
+// heuristicRiskScorer is the default scorer. It keeps a short per-user login
+// history in memory (per pod) and scores three signals:
+//
+//   - new_ip: the user has logged in before but never from this address
+//   - rapid_attempts: many recent failures for the account or the address
+//   - impossible_travel: the GeoIP distance from the previous login needs
+//     a speed no airliner manages
+type heuristicRiskScorer struct {
+    mu       sync.Mutex
+    history  map[string][]loginRecord // user ID -> recent successful logins
+    failures map[string][]time.Time   // "email:" / "ip:" key -> recent failures
+    geo      []geoRange
+}
+
+type loginRecord struct {
+    ip   string
+    time time.Time
+    loc  *geoLocation
+}
+
+type geoLocation struct {
+    lat, lon float64
+}
+
+type geoRange struct {
+    network *net.IPNet
+    loc     geoLocation
+}
+
+const (
+    riskHistoryLen    = 10
+    riskFailureWindow = 5 * time.Minute
+    riskFailureLimit  = 5
+    maxTravelSpeedKmh = 1000.0
+    earthRadiusKm     = 6371.0
+)
+
+func newHeuristicRiskScorer(geoIPFile string) *heuristicRiskScorer {
+    s := &heuristicRiskScorer{
+        history:  make(map[string][]loginRecord),
+        failures: make(map[string][]time.Time),
+    }
+    if geoIPFile != "" {
+        f, err := os.Open(geoIPFile)
+        if err == nil {
+            s.geo, err = parseGeoIP(f)
+            f.Close()
+        }
+        if err != nil {
+            log.Printf("⚠️  GeoIP database %s not loaded, impossible-travel checks disabled: %v", geoIPFile, err)
+        } else {
+            log.Printf("🌍 Loaded %d GeoIP ranges from %s", len(s.geo), geoIPFile)
+        }
+    }
+    return s
+}
+
+// The GeoIP database is a CSV of network,latitude,longitude (extra columns
+// ignored), e.g. exported from GeoLite2-City-Blocks. Lines starting with # and
+// a header row are skipped.
+func parseGeoIP(r io.Reader) ([]geoRange, error) {
+    var ranges []geoRange
+    scanner := bufio.NewScanner(r)
+    for line := 1; scanner.Scan(); line++ {
+        text := strings.TrimSpace(scanner.Text())
+        if text == "" || strings.HasPrefix(text, "#") {
+            continue
+        }
+        fields := strings.Split(text, ",")
+        if len(fields) < 3 {
+            return nil, fmt.Errorf("line %d: expected network,latitude,longitude", line)
+        }
+        _, network, err := net.ParseCIDR(strings.TrimSpace(fields[0]))
+        if err != nil {
+            if line == 1 {
+                continue // header
+            }
+            return nil, fmt.Errorf("line %d: %w", line, err)
+        }
+        lat, err1 := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+        lon, err2 := strconv.ParseFloat(strings.TrimSpace(fields[2]), 64)
+        if err1 != nil || err2 != nil {
+            return nil, fmt.Errorf("line %d: invalid coordinates", line)
+        }
+        ranges = append(ranges, geoRange{network: network, loc: geoLocation{lat, lon}})
+    }
+    return ranges, scanner.Err()
+}
+
+// Most specific range containing ip
+func (s *heuristicRiskScorer) locate(ip net.IP) *geoLocation {
+    var best *geoRange
+    bestBits := -1
+    for i := range s.geo {
+        if s.geo[i].network.Contains(ip) {
+            if bits, _ := s.geo[i].network.Mask.Size(); bits > bestBits {
+                best, bestBits = &s.geo[i], bits
+            }
+        }
+    }
+    if best == nil {
+        return nil
+    }
+    return &best.loc
+}
+
+func (s *heuristicRiskScorer) Assess(ctx context.Context, a LoginAttempt) RiskAssessment {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    var score int
+    var reasons []string
+    ip := a.IP.String()
+
+    history := s.history[a.UserID]
+    if len(history) > 0 {
+        seen := false
+        for _, h := range history {
+            seen = seen || h.ip == ip
+        }
+        if !seen {
+            score += 30
+            reasons = append(reasons, "new_ip")
+        }
+
+        last := history[len(history)-1]
+        if loc := s.locate(a.IP); loc != nil && last.loc != nil && last.ip != ip {
+            km := distanceKm(*last.loc, *loc)
+            hours := a.Time.Sub(last.time).Hours()
+            if km > 100 && (hours <= 0 || km/hours > maxTravelSpeedKmh) {
+                score += 60
+                reasons = append(reasons, "impossible_travel")
+            }
+        }
+    }
+
+    if s.recentFailures("email:"+a.Tenant+"/"+a.Email, a.Time) >= riskFailureLimit ||
+        s.recentFailures("ip:"+ip, a.Time) >= riskFailureLimit*2 {
+        score += 50
+        reasons = append(reasons, "rapid_attempts")
+    }
+
+    if score > 100 {
+        score = 100
+    }
+    return RiskAssessment{Score: score, Reasons: reasons, Action: riskAction(score)}
+}
+
+func (s *heuristicRiskScorer) Observe(a LoginAttempt, succeeded bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if !succeeded {
+        for _, key := range []string{"email:" + a.Tenant + "/" + a.Email, "ip:" + a.IP.String()} {
+            s.failures[key] = append(s.pruned(key, a.Time), a.Time)
+        }
+        return
+    }
+    delete(s.failures, "email:"+a.Tenant+"/"+a.Email)
+    history := append(s.history[a.UserID], loginRecord{ip: a.IP.String(), time: a.Time, loc: s.locate(a.IP)})
+    if len(history) > riskHistoryLen {
+        history = history[len(history)-riskHistoryLen:]
+    }
+    s.history[a.UserID] = history
+}
+
+func (s *heuristicRiskScorer) recentFailures(key string, now time.Time) int {
+    return len(s.pruned(key, now))
+}
+
+// Drop failures older than the window, forgetting the key once none are left
+func (s *heuristicRiskScorer) pruned(key string, now time.Time) []time.Time {
+    times := s.failures[key]
+    i := 0
+    for i < len(times) && now.Sub(times[i]) > riskFailureWindow {
+        i++
+    }
+    times = times[i:]
+    if len(times) == 0 {
+        delete(s.failures, key)
+        return nil
+    }
+    s.failures[key] = times
+    return times
+}
+
+// Great-circle distance by the haversine formula
+func distanceKm(a, b geoLocation) float64 {
+    rad := math.Pi / 180
+    dLat := (b.lat - a.lat) * rad
+    dLon := (b.lon - a.lon) * rad
+    h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(a.lat*rad)*math.Cos(b.lat*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+    return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
+
+func writeRiskMetrics(w io.Writer) {
+    fmt.Fprintf(w, "# HELP auth_risk_assessments_total Login risk assessments by resulting action\n")
+    fmt.Fprintf(w, "# TYPE auth_risk_assessments_total counter\n")
+    for _, action := range []string{RiskAllow, RiskMFA, RiskDeny} {
+        fmt.Fprintf(w, "auth_risk_assessments_total{action=%q} %d\n", action, riskOutcomes[action].Load())
+    }
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+    "crypto/ed25519"
+    "crypto/hmac"
+    "crypto/sha256"
+    "crypto/subtle"
+    "crypto/tls"
+    "crypto/x509"
+    "hash"
+    "log"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+
+    "auth-service/internal/tenantkey"
+)
+
+// secretSet is one generation of the service's credentials
+type secretSet struct {
+    jwtSecret        string
+    internalAPIKey   string
+    authServiceToken string
+    cert             *tls.Certificate
+    attestationKey   ed25519.PrivateKey
+    loadedAt         time.Time
+
+    // JWT signing keys by tenant; a tenant without one cannot sign or verify
+    signingKeys map[string]*hmacKey
+
+    // Ed25519 token signing keys by tenant, derived from tokenSigningKey;
+    // nil unless the token-signing-key secret exists
+    tokenSigningKey ed25519.PrivateKey
+    tokenKeys       map[string]*edKey
+
+    // Master keys for field encryption; nil unless field-encryption-keys
+    // exists
+    fieldKeys *fieldKeyring
+
+    // OAuth client secrets of the enabled identity providers, by name
+    providerSecrets map[string]string
+}
+
+// hmacKey is one JWT signing key with a pool of keyed HMAC states; hmac.New
+// precomputes the inner and outer pads, so reusing them skips that work on
+// every token
+type hmacKey struct {
+    secret string
+    macs   sync.Pool
+}
+
+func (k *hmacKey) getMAC() hash.Hash {
+    if h, ok := k.macs.Get().(hash.Hash); ok {
+        h.Reset()
+        return h
+    }
+    return hmac.New(sha256.New, []byte(k.secret))
+}
+
+func (k *hmacKey) putMAC(h hash.Hash) {
+    k.macs.Put(h)
+}
+
+// Build the per-tenant signing keys. A tenant can be given its own key as
+// jwt-secret.<tenant> in the Secret volume; otherwise its key is derived from
+// the master secret. Either way a token never verifies for another tenant.
+func tenantSigningKeys(master string, explicit func(tenant string) string) map[string]*hmacKey {
+    keys := make(map[string]*hmacKey, len(tenants))
+    for tenant := range tenants {
+        secret := ""
+        if tenant != defaultTenant {
+            secret = explicit(tenant)
+        }
+        if secret == "" {
+            secret = tenantkey.Derive(master, tenant)
+        }
+        if secret != "" {
+            keys[tenant] = &hmacKey{secret: secret}
+        }
+    }
+    return keys
+}
+
+// secretManager keeps the live credentials and, for a grace period after a
+// rotation, the previous generation, so tokens signed and headers issued just
+// before the Secret changed keep verifying while callers pick up new values.
+type secretManager struct {
+    dir      string
+    interval time.Duration
+    grace    time.Duration
+
+    mu            sync.RWMutex
+    current       *secretSet
+    previous      *secretSet
+    previousUntil time.Time
+}
+
+var secrets = &secretManager{
+    dir:      os.Getenv("SECRETS_DIR"),
+    interval: getEnvDuration("SECRETS_RELOAD_INTERVAL", 30*time.Second),
+    grace:    getEnvDuration("SECRET_ROTATION_GRACE", 10*time.Minute),
+}
+
+// Read the mounted Secret volume, falling back to the environment for any key
+// that has no file. Keys match those in the service-auth Secret.
+func (m *secretManager) read() (*secretSet, error) {
+    set := &secretSet{
+        jwtSecret:        m.file("jwt-secret", jwtSecret),
+        internalAPIKey:   m.file("internal-api-key", internalAPIKey),
+        authServiceToken: m.file("auth-service-token", authServiceToken),
+        loadedAt:         time.Now(),
+    }
+    set.signingKeys = tenantSigningKeys(set.jwtSecret, func(tenant string) string {
+        return m.file("jwt-secret."+tenant, "")
+    })
+    set.providerSecrets = make(map[string]string, len(identityProviders))
+    for name := range identityProviders {
+        set.providerSecrets[name] = m.file(name+"-client-secret", os.Getenv(strings.ToUpper(name)+"_CLIENT_SECRET"))
+    }
+    if m.dir != "" {
+        certFile, keyFile := filepath.Join(m.dir, "tls.crt"), filepath.Join(m.dir, "tls.key")
+        if _, err := os.Stat(certFile); err == nil {
+            cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+            if err != nil {
+                return nil, err
+            }
+            set.cert = &cert
+        }
+        if data, err := os.ReadFile(filepath.Join(m.dir, "attestation-key")); err == nil {
+            if set.attestationKey, err = parseEd25519Key("attestation-key", data); err != nil {
+                return nil, err
+            }
+        }
+        if data, err := os.ReadFile(filepath.Join(m.dir, "token-signing-key")); err == nil {
+            if set.tokenSigningKey, err = parseEd25519Key("token-signing-key", data); err != nil {
+                return nil, err
+            }
+            set.tokenKeys = tenantEdKeys(set.tokenSigningKey)
+        }
+        if keys := m.file("field-encryption-keys", ""); keys != "" {
+            var err error
+            if set.fieldKeys, err = parseFieldKeyring(keys, m.file("field-index-key", "")); err != nil {
+                return nil, err
+            }
+        }
+    }
+    return set, nil
+}
+
+func (m *secretManager) file(name, fallback string) string {
+    if m.dir == "" {
+        return fallback
+    }
+    data, err := os.ReadFile(filepath.Join(m.dir, name))
+    if err != nil {
+        return fallback
+    }
+    return strings.TrimSpace(string(data))
+}
+
+func (m *secretManager) load() error {
+    set, err := m.read()
+    if err != nil {
+        return err
+    }
+    m.mu.Lock()
+    m.current = set
+    m.mu.Unlock()
+    return nil
+}
+
+// Re-read the volume and swap in the new generation if anything changed. A
+// half-written update (e.g. a cert without its key) is rejected and the
+// current generation stays live.
+func (m *secretManager) reload() error {
+    set, err := m.read()
+    if err != nil {
+        return err
+    }
+
+    m.mu.Lock()
+    if m.current != nil && sameSecrets(m.current, set) {
+        m.mu.Unlock()
+        return nil
+    }
+    m.previous = m.current
+    m.previousUntil = time.Now().Add(m.grace)
+    m.current = set
+    until := m.previousUntil.Format(time.RFC3339)
+    m.mu.Unlock()
+
+    log.Printf("🔑 Secrets rotated from %s; previous values accepted until %s", m.dir, until)
+    recordSystemAudit("secrets.rotated", map[string]string{"previousAcceptedUntil": until})
+    return nil
+}
+
+func sameSecrets(a, b *secretSet) bool {
+    if a.jwtSecret != b.jwtSecret || a.internalAPIKey != b.internalAPIKey || a.authServiceToken != b.authServiceToken {
+        return false
+    }
+    if len(a.signingKeys) != len(b.signingKeys) {
+        return false
+    }
+    for tenant, k := range a.signingKeys {
+        if other := b.signingKeys[tenant]; other == nil || other.secret != k.secret {
+            return false
+        }
+    }
+    for name, secret := range a.providerSecrets {
+        if b.providerSecrets[name] != secret {
+            return false
+        }
+    }
+    if !a.attestationKey.Equal(b.attestationKey) || !a.tokenSigningKey.Equal(b.tokenSigningKey) {
+        return false
+    }
+    if (a.fieldKeys == nil) != (b.fieldKeys == nil) || (a.fieldKeys != nil && a.fieldKeys.raw != b.fieldKeys.raw) {
+        return false
+    }
+    if (a.cert == nil) != (b.cert == nil) {
+        return false
+    }
+    return a.cert == nil || string(a.cert.Certificate[0]) == string(b.cert.Certificate[0])
+}
+
+// Kubernetes updates Secret volumes by swapping a symlink, which inotify-style
+// watchers easily miss; polling the content is simpler and just as prompt
+// given the kubelet's own sync delay
+func (m *secretManager) watch() {
+    for range time.Tick(m.interval) {
+        if err := m.reload(); err != nil {
+            log.Printf("⚠️  Secret reload failed: %v", err)
+        }
+    }
+}
+
+func (m *secretManager) get() *secretSet {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    return m.current
+}
+
+// Generations a presented credential may match: the current one, and the
+// previous one while its grace period lasts (nil otherwise). Returned as a
+// pair rather than a slice to keep token verification allocation-free.
+func (m *secretManager) accepted() (current, previous *secretSet) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    if m.previous != nil && time.Now().Before(m.previousUntil) {
+        return m.current, m.previous
+    }
+    return m.current, nil
+}
+
+// Constant-time match of a presented value against any accepted generation
+func (m *secretManager) matches(field func(*secretSet) string, presented string) bool {
+    match := 0
+    current, previous := m.accepted()
+    for _, set := range [2]*secretSet{current, previous} {
+        if set == nil {
+            continue
+        }
+        if expected := field(set); expected != "" {
+            match |= subtle.ConstantTimeCompare([]byte(presented), []byte(expected))
+        }
+    }
+    return match == 1
+}
+
+func (m *secretManager) validServiceCredentials(serviceToken, apiKey string) bool {
+    return m.matches(func(s *secretSet) string { return s.authServiceToken }, serviceToken) &&
+        m.matches(func(s *secretSet) string { return s.internalAPIKey }, apiKey)
+}
+
+// Which credentials are loaded for a tenant, for the admin dashboard; never
+// their values
+func (m *secretManager) status(tenant string) map[string]interface{} {
+    m.mu.RLock()
+    current, previous, previousUntil := m.current, m.previous, m.previousUntil
+    m.mu.RUnlock()
+
+    source := m.dir
+    if source == "" {
+        source = "environment"
+    }
+    status := map[string]interface{}{
+        "source":          source,
+        "loadedAt":        current.loadedAt,
+        "tokenAlgorithm":  "none",
+        "serviceToken":    current.authServiceToken != "",
+        "internalApiKey":  current.internalAPIKey != "",
+        "attestationKey":  current.attestationKey != nil,
+        "fieldEncryption": current.fieldKeys != nil,
+    }
+    if key := current.tokenKeys[tenant]; key != nil {
+        status["tokenAlgorithm"], status["tokenKeyId"] = "EdDSA", key.kid
+    } else if current.signingKeys[tenant] != nil {
+        status["tokenAlgorithm"] = "HS256"
+    }
+    if previous != nil && time.Now().Before(previousUntil) {
+        status["previousAcceptedUntil"] = previousUntil
+    }
+    if current.cert != nil {
+        if leaf, err := x509.ParseCertificate(current.cert.Certificate[0]); err == nil {
+            status["tlsCertificate"] = map[string]interface{}{
+                "subject":  leaf.Subject.String(),
+                "notAfter": leaf.NotAfter,
+            }
+        }
+    }
+    providers := map[string]bool{}
+    for name, secret := range current.providerSecrets {
+        providers[name] = secret != ""
+    }
+    status["identityProviders"] = providers
+    return status
+}
+
+// tls.Config hook so rotated certificates are served without a restart
+func (m *secretManager) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+    return m.get().cert, nil
+}
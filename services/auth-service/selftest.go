@@ -0,0 +1,186 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+    "sync/atomic"
+    "time"
+)
+
+// Self-tests run once at startup against the live configuration: a token
+// signs and verifies, a password hashes and compares, storage takes a write
+// and reads it back, sealed fields open, and the clock is plausible. A
+// failure keeps the pod unready, so a misconfiguration such as an empty JWT
+// secret shows up at rollout instead of on the first login.
+var selftests atomic.Pointer[SelftestReport]
+
+// Clocks before this are unset (a VM booted without RTC sync)
+var selftestClockFloor = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// SelftestReport is the outcome of a self-test run
+type SelftestReport struct {
+    OK     bool          `json:"ok"`
+    RanAt  time.Time     `json:"ranAt"`
+    Checks []CheckResult `json:"checks"`
+}
+
+func runSelftests(ctx context.Context) *SelftestReport {
+    report := &SelftestReport{OK: true, RanAt: time.Now()}
+    run := func(name string, fn func(ctx context.Context) (string, error)) {
+        ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+        defer cancel()
+        start := time.Now()
+        detail, err := fn(ctx)
+        result := CheckResult{Name: name, OK: err == nil, Detail: detail, DurationMs: time.Since(start).Milliseconds()}
+        if err != nil {
+            result.Detail = err.Error()
+            report.OK = false
+        }
+        report.Checks = append(report.Checks, result)
+    }
+
+    run("token", selftestToken)
+    run("password", selftestPassword)
+    run("storage", selftestStorage)
+    if fieldKeys() != nil {
+        run("field encryption", selftestFieldEncryption)
+    }
+    run("clock", selftestClock)
+
+    selftests.Store(report)
+    for _, c := range report.Checks {
+        if !c.OK {
+            log.Printf("❌ Self-test %s failed: %s", c.Name, c.Detail)
+        }
+    }
+    if report.OK {
+        log.Printf("✅ Self-tests passed (%d checks)", len(report.Checks))
+    }
+    return report
+}
+
+func selftestToken(context.Context) (string, error) {
+    now := clock.Now()
+    token, err := signToken(Claims{
+        ID:        "selftest-" + randomHex(8),
+        Subject:   "selftest",
+        Tenant:    claimTenant(defaultTenant),
+        IssuedAt:  now.Unix(),
+        ExpiresAt: now.Add(time.Minute).Unix(),
+    })
+    if err != nil {
+        return "", fmt.Errorf("sign: %w", err)
+    }
+    claims, err := parseToken(token)
+    if err != nil {
+        return "", fmt.Errorf("verify: %w", err)
+    }
+    if claims.Subject != "selftest" {
+        return "", errors.New("verified token has the wrong subject")
+    }
+    // Flip the first signature character; the last one may only carry padding bits
+    i := strings.LastIndexByte(token, '.') + 1
+    flipped := byte('A')
+    if token[i] == 'A' {
+        flipped = 'B'
+    }
+    if _, err := parseToken(token[:i] + string(flipped) + token[i+1:]); err == nil {
+        return "", errors.New("token with a broken signature verified")
+    }
+    return "signed and verified", nil
+}
+
+func selftestPassword(context.Context) (string, error) {
+    hash := hashPassword("selftest-password")
+    if !checkPassword(hash, "selftest-password") {
+        return "", errors.New("hash does not match its password")
+    }
+    if checkPassword(hash, "selftest-wrong") {
+        return "", errors.New("hash matches a different password")
+    }
+    return "hashed and compared", nil
+}
+
+// Round-trip a throwaway session, which exercises the write path, the read
+// path and field encryption of the client IP
+func selftestStorage(ctx context.Context) (string, error) {
+    now := time.Now()
+    session := &Session{
+        ID:        "selftest-" + randomHex(8),
+        UserID:    "selftest",
+        ClientIP:  "127.0.0.1",
+        CreatedAt: now,
+        ExpiresAt: now.Add(time.Minute),
+    }
+    if err := store.CreateSession(ctx, session); err != nil {
+        return "", fmt.Errorf("write: %w", err)
+    }
+    defer store.DeleteSession(context.Background(), session.ID)
+    got, err := store.GetSession(ctx, session.ID)
+    if err != nil {
+        return "", fmt.Errorf("read: %w", err)
+    }
+    if got.UserID != session.UserID || got.ClientIP != session.ClientIP {
+        return "", errors.New("read back a different session")
+    }
+    return storageDriver, nil
+}
+
+func selftestFieldEncryption(context.Context) (string, error) {
+    sealed, err := sealField("selftest", "selftest-value")
+    if err != nil {
+        return "", err
+    }
+    opened, err := openField("selftest", sealed)
+    if err != nil {
+        return "", err
+    }
+    if opened != "selftest-value" {
+        return "", errors.New("opened a different value")
+    }
+    return "active key " + fieldKeys().active, nil
+}
+
+func selftestClock(context.Context) (string, error) {
+    now := clock.Now()
+    if now.Before(selftestClockFloor) {
+        return "", fmt.Errorf("clock reads %s", now.Format(time.RFC3339))
+    }
+    if now.Before(startTime) {
+        return "", errors.New("clock is behind the process start time")
+    }
+    return now.UTC().Format(time.RFC3339), nil
+}
+
+// Names of the failed self-tests, for readyzHandler
+func selftestFailures() []string {
+    report := selftests.Load()
+    if report == nil {
+        return nil
+    }
+    var failed []string
+    for _, c := range report.Checks {
+        if !c.OK {
+            failed = append(failed, c.Name)
+        }
+    }
+    return failed
+}
+
+// Self-test endpoint: the report from startup
+func selftestHandler(w http.ResponseWriter, r *http.Request) {
+    report := selftests.Load()
+    status := http.StatusOK
+    if report == nil || !report.OK {
+        status = http.StatusServiceUnavailable
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(report)
+}
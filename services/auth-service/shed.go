@@ -0,0 +1,271 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "runtime"
+    "strconv"
+    "strings"
+    "sync/atomic"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Load shedding. Rather than letting the pod run out of memory and be
+// OOM-killed with every request it holds, the service turns some requests
+// away with a 503 while it is under pressure. Every SHED_SAMPLE_INTERVAL it
+// samples the memory in use (the figures /health reports) and the number of
+// goroutines; the requests in flight are counted as they arrive. Past a soft
+// threshold low-priority requests are shed: sign-ups, logins and everything
+// else that starts new work. Past a hard threshold so are the high-priority
+// ones that keep existing sessions working (token validation, ext_authz and
+// the JWKS). Probes, metrics and admin routes are always served so the pod
+// can be watched and operated while it sheds. The memory limit is the
+// container's cgroup limit unless SHED_MEMORY_LIMIT_MB sets one; without
+// either memory isn't considered.
+var (
+    shedEnabled = getEnv("SHED_ENABLED", "true") == "true"
+    shedder     = &loadShedder{
+        interval:      getEnvDuration("SHED_SAMPLE_INTERVAL", time.Second),
+        memoryLimit:   uint64(getEnvInt("SHED_MEMORY_LIMIT_MB", 0)) << 20,
+        memorySoft:    getEnvFloat("SHED_MEMORY_SOFT", 0.80),
+        memoryHard:    getEnvFloat("SHED_MEMORY_HARD", 0.90),
+        maxGoroutines: getEnvInt("SHED_MAX_GOROUTINES", 10000),
+        maxInflight:   int64(getEnvInt("SHED_MAX_INFLIGHT", 500)),
+        retryAfter:    getEnvDuration("SHED_RETRY_AFTER", 2*time.Second),
+    }
+)
+
+// Pressure levels, and the priority a request needs to be served at each
+const (
+    pressureNormal = iota
+    pressureElevated
+    pressureCritical
+)
+
+const (
+    priorityLow = iota
+    priorityHigh
+    priorityCritical
+)
+
+var priorityNames = [...]string{"low", "high", "critical"}
+
+var shedReasons = [...]string{"memory", "goroutines", "inflight"}
+
+// Served even at critical pressure
+var highPriorityPaths = map[string]bool{
+    "/validate":              true,
+    "/validate/batch":        true,
+    "/authenticate":          true,
+    "/.well-known/jwks.json": true,
+    "/ext-authz":             true,
+}
+
+// Never shed
+var criticalPaths = map[string]bool{
+    "/health":     true,
+    "/readyz":     true,
+    "/metrics":    true,
+    "/build-info": true,
+    "/status":     true,
+}
+
+func shedPriority(path string) int {
+    switch {
+    case criticalPaths[path] || strings.HasPrefix(path, "/admin/"):
+        return priorityCritical
+    case highPriorityPaths[path] || strings.HasPrefix(path, "/ext-authz/"):
+        return priorityHigh
+    }
+    return priorityLow
+}
+
+type shedSample struct {
+    memory     uint64
+    goroutines int
+    level      int
+    reason     int
+}
+
+type loadShedder struct {
+    interval      time.Duration
+    memoryLimit   uint64
+    memorySoft    float64
+    memoryHard    float64
+    maxGoroutines int
+    maxInflight   int64
+    retryAfter    time.Duration
+
+    sampled  atomic.Pointer[shedSample]
+    inflight atomic.Int64
+    shed     [len(priorityNames)][len(shedReasons)]atomic.Int64
+}
+
+func (s *loadShedder) start() {
+    if !shedEnabled {
+        return
+    }
+    if s.memoryLimit == 0 {
+        s.memoryLimit = cgroupMemoryLimit()
+    }
+    if s.memoryLimit > 0 {
+        log.Printf("🛡️  Load shedding above %.0f%%/%.0f%% of %d MB, %d goroutines or %d requests in flight",
+            s.memorySoft*100, s.memoryHard*100, s.memoryLimit>>20, s.maxGoroutines, s.maxInflight)
+    } else {
+        log.Printf("🛡️  Load shedding above %d goroutines or %d requests in flight (no memory limit found)", s.maxGoroutines, s.maxInflight)
+    }
+    s.sample()
+    go func() {
+        for range time.Tick(s.interval) {
+            s.sample()
+        }
+    }()
+}
+
+// ReadMemStats briefly stops the world, so it runs on a timer rather than
+// per request
+func (s *loadShedder) sample() {
+    var memStats runtime.MemStats
+    runtime.ReadMemStats(&memStats)
+    next := &shedSample{memory: memStats.Sys - memStats.HeapReleased, goroutines: runtime.NumGoroutine()}
+    if s.memoryLimit > 0 {
+        used := float64(next.memory) / float64(s.memoryLimit)
+        next.raise(used >= s.memorySoft, used >= s.memoryHard, 0)
+    }
+    next.raise(next.goroutines >= s.maxGoroutines, next.goroutines >= 2*s.maxGoroutines, 1)
+
+    // Log when the level changes, counting the requests in flight now
+    was, is := pressureNormal, s.level(next, s.inflight.Load())
+    if prev := s.sampled.Swap(next); prev != nil {
+        was = s.level(prev, s.inflight.Load())
+    }
+    if was != is {
+        if is == pressureNormal {
+            log.Printf("🛡️  Load shedding stopped")
+        } else {
+            log.Printf("⚠️  Load shedding %s requests: %d MB in use, %d goroutines, %d requests in flight",
+                priorityNames[is-1]+"-priority", next.memory>>20, next.goroutines, s.inflight.Load())
+        }
+    }
+}
+
+func (p *shedSample) raise(soft, hard bool, reason int) {
+    level := pressureNormal
+    if hard {
+        level = pressureCritical
+    } else if soft {
+        level = pressureElevated
+    }
+    if level > p.level {
+        p.level, p.reason = level, reason
+    }
+}
+
+func (s *loadShedder) level(p *shedSample, inflight int64) int {
+    level, _ := s.pressure(p, inflight)
+    return level
+}
+
+// The pressure given a sample and the requests in flight, and which signal
+// set it
+func (s *loadShedder) pressure(p *shedSample, inflight int64) (int, int) {
+    current := shedSample{}
+    if p != nil {
+        current = *p
+    }
+    current.raise(inflight > s.maxInflight, inflight > 2*s.maxInflight, 2)
+    return current.level, current.reason
+}
+
+func shedLoad(next http.Handler) http.Handler {
+    if !shedEnabled {
+        return next
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        inflight := shedder.inflight.Add(1)
+        defer shedder.inflight.Add(-1)
+        priority := shedPriority(r.URL.Path)
+        if priority != priorityCritical {
+            // Low priority goes at elevated pressure, high at critical
+            if level, reason := shedder.pressure(shedder.sampled.Load(), inflight); level > priority {
+                shedder.shed[priority][reason].Add(1)
+                w.Header().Set("Retry-After", strconv.Itoa(int(shedder.retryAfter.Seconds())))
+                autherr.Write(w, autherr.ErrOverloaded)
+                return
+            }
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// The container's memory limit from cgroup v2 or v1, or 0 if unlimited
+func cgroupMemoryLimit() uint64 {
+    for _, path := range []string{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory/memory.limit_in_bytes"} {
+        data, err := os.ReadFile(path)
+        if err != nil {
+            continue
+        }
+        limit, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+        // "max" on v2; v1 reports unlimited as a huge page-aligned number
+        if err != nil || limit >= 1<<60 {
+            return 0
+        }
+        return limit
+    }
+    return 0
+}
+
+// Shedding state for /status
+func (s *loadShedder) status() map[string]interface{} {
+    status := map[string]interface{}{"enabled": shedEnabled}
+    if p := s.sampled.Load(); shedEnabled && p != nil {
+        inflight := s.inflight.Load()
+        level, _ := s.pressure(p, inflight)
+        status["level"] = level
+        status["memoryMB"] = p.memory >> 20
+        status["memoryLimitMB"] = s.memoryLimit >> 20
+        status["goroutines"] = p.goroutines
+        status["inflight"] = inflight
+    }
+    return status
+}
+
+func writeShedMetrics(w io.Writer) {
+    if !shedEnabled {
+        return
+    }
+    p := shedder.sampled.Load()
+    inflight := shedder.inflight.Load()
+    level, _ := shedder.pressure(p, inflight)
+    fmt.Fprintf(w, "# HELP auth_load_shedding_level Pressure level: 0 normal, 1 shedding low priority, 2 shedding high priority\n")
+    fmt.Fprintf(w, "# TYPE auth_load_shedding_level gauge\n")
+    fmt.Fprintf(w, "auth_load_shedding_level %d\n", level)
+    fmt.Fprintf(w, "# HELP auth_inflight_requests Requests being served\n")
+    fmt.Fprintf(w, "# TYPE auth_inflight_requests gauge\n")
+    fmt.Fprintf(w, "auth_inflight_requests %d\n", inflight)
+    if p != nil {
+        fmt.Fprintf(w, "# HELP auth_memory_in_use_bytes Memory held from the OS at the last sample\n")
+        fmt.Fprintf(w, "# TYPE auth_memory_in_use_bytes gauge\n")
+        fmt.Fprintf(w, "auth_memory_in_use_bytes %d\n", p.memory)
+        fmt.Fprintf(w, "# HELP auth_goroutines Goroutines at the last sample\n")
+        fmt.Fprintf(w, "# TYPE auth_goroutines gauge\n")
+        fmt.Fprintf(w, "auth_goroutines %d\n", p.goroutines)
+    }
+    if shedder.memoryLimit > 0 {
+        fmt.Fprintf(w, "# HELP auth_memory_limit_bytes Memory limit load shedding works against\n")
+        fmt.Fprintf(w, "# TYPE auth_memory_limit_bytes gauge\n")
+        fmt.Fprintf(w, "auth_memory_limit_bytes %d\n", shedder.memoryLimit)
+    }
+    fmt.Fprintf(w, "# HELP auth_shed_requests_total Requests turned away under load, by priority and the signal that triggered it\n")
+    fmt.Fprintf(w, "# TYPE auth_shed_requests_total counter\n")
+    for priority := priorityLow; priority < priorityCritical; priority++ {
+        for reason, name := range shedReasons {
+            fmt.Fprintf(w, "auth_shed_requests_total{priority=%q,reason=%q} %d\n", priorityNames[priority], name, shedder.shed[priority][reason].Load())
+        }
+    }
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Service level objectives, tracked in-process so burn rates are available
+// without recording rules. SLO_TARGETS lists name=path:objective[:latency]
+// entries; a request to path is good when it doesn't fail with a 5xx and,
+// with a latency given, finishes within it. Path "*" covers every route.
+// Outcomes are counted per minute for the last three days, enough for the
+// multi-window burn-rate pairs (1h/5m and 6h/30m for paging, 1d/2h and
+// 3d/6h for tickets) the alert rules in kubernetes/monitoring use.
+//
+// Each replica reports its own traffic; alert on the max across pods.
+var sloTrackers = parseSLOs(getEnv("SLO_TARGETS", "validate=/validate:99.9:50ms,login=/login:99.5:500ms,availability=*:99.9"))
+
+const sloBuckets = 3 * 24 * 60
+
+// Burn-rate windows, shortest first
+var sloWindows = []struct {
+    name string
+    d    time.Duration
+}{
+    {"5m", 5 * time.Minute},
+    {"30m", 30 * time.Minute},
+    {"1h", time.Hour},
+    {"2h", 2 * time.Hour},
+    {"6h", 6 * time.Hour},
+    {"1d", 24 * time.Hour},
+    {"3d", 72 * time.Hour},
+}
+
+type sloBucket struct {
+    minute int64
+    good   int64
+    total  int64
+}
+
+type sloTracker struct {
+    name      string
+    path      string
+    objective float64 // percent
+    latency   time.Duration
+
+    mu      sync.Mutex
+    buckets [sloBuckets]sloBucket
+    good    int64
+    bad     int64
+}
+
+func parseSLOs(value string) []*sloTracker {
+    var trackers []*sloTracker
+    for _, entry := range strings.Split(value, ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+        name, spec, _ := strings.Cut(entry, "=")
+        parts := strings.Split(spec, ":")
+        if name == "" || len(parts) < 2 || len(parts) > 3 {
+            log.Fatalf("❌ Invalid SLO %q in SLO_TARGETS; expected name=path:objective[:latency]", entry)
+        }
+        objective, err := strconv.ParseFloat(parts[1], 64)
+        if err != nil || objective <= 0 || objective >= 100 {
+            log.Fatalf("❌ Invalid objective in SLO %q; expected a percentage below 100", entry)
+        }
+        t := &sloTracker{name: name, path: parts[0], objective: objective}
+        if len(parts) == 3 {
+            if t.latency, err = time.ParseDuration(parts[2]); err != nil {
+                log.Fatalf("❌ Invalid latency in SLO %q: %v", entry, err)
+            }
+        }
+        trackers = append(trackers, t)
+    }
+    return trackers
+}
+
+func (t *sloTracker) covers(path string) bool {
+    return t.path == "*" || t.path == path
+}
+
+func (t *sloTracker) record(now time.Time, good bool) {
+    minute := now.Unix() / 60
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    b := &t.buckets[minute%sloBuckets]
+    if b.minute != minute {
+        *b = sloBucket{minute: minute}
+    }
+    b.total++
+    if good {
+        b.good++
+        t.good++
+    } else {
+        t.bad++
+    }
+}
+
+// Good and total requests in the window ending now
+func (t *sloTracker) window(now time.Time, d time.Duration) (good, total int64) {
+    minute := now.Unix() / 60
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    for i := int64(0); i < int64(d/time.Minute); i++ {
+        if b := t.buckets[(minute-i)%sloBuckets]; b.minute == minute-i {
+            good += b.good
+            total += b.total
+        }
+    }
+    return good, total
+}
+
+// How fast the error budget is being spent: 1 uses it up exactly over the
+// SLO period, 14.4 spends 2% of a 30-day budget in an hour
+func (t *sloTracker) burnRate(good, total int64) float64 {
+    if total == 0 {
+        return 0
+    }
+    errorRate := float64(total-good) / float64(total)
+    return errorRate / (1 - t.objective/100)
+}
+
+func sloMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var trackers []*sloTracker
+        for _, t := range sloTrackers {
+            if t.covers(r.URL.Path) {
+                trackers = append(trackers, t)
+            }
+        }
+        if len(trackers) == 0 {
+            next.ServeHTTP(w, r)
+            return
+        }
+        start := time.Now()
+        sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+        next.ServeHTTP(sw, r)
+        elapsed := time.Since(start)
+        for _, t := range trackers {
+            t.record(start, sw.status < 500 && (t.latency == 0 || elapsed <= t.latency))
+        }
+    })
+}
+
+// SLOWindow is one burn-rate window of an SLO
+type SLOWindow struct {
+    Window   string  `json:"window"`
+    Total    int64   `json:"total"`
+    Good     int64   `json:"good"`
+    SLI      float64 `json:"sli"` // percent good, 100 with no traffic
+    BurnRate float64 `json:"burnRate"`
+}
+
+// SLOStatus is the state of one SLO
+type SLOStatus struct {
+    Name               string      `json:"name"`
+    Path               string      `json:"path"`
+    Objective          float64     `json:"objective"`
+    LatencyThresholdMs int64       `json:"latencyThresholdMs,omitempty"`
+    Windows            []SLOWindow `json:"windows"`
+    FastBurn           bool        `json:"fastBurn"` // 1h and 5m above 14.4
+    SlowBurn           bool        `json:"slowBurn"` // 6h and 30m above 6
+}
+
+func (t *sloTracker) status(now time.Time) SLOStatus {
+    s := SLOStatus{Name: t.name, Path: t.path, Objective: t.objective, LatencyThresholdMs: t.latency.Milliseconds()}
+    rates := map[string]float64{}
+    for _, w := range sloWindows {
+        good, total := t.window(now, w.d)
+        sli := 100.0
+        if total > 0 {
+            sli = float64(good) / float64(total) * 100
+        }
+        rates[w.name] = t.burnRate(good, total)
+        s.Windows = append(s.Windows, SLOWindow{Window: w.name, Total: total, Good: good, SLI: sli, BurnRate: rates[w.name]})
+    }
+    s.FastBurn = rates["1h"] > 14.4 && rates["5m"] > 14.4
+    s.SlowBurn = rates["6h"] > 6 && rates["30m"] > 6
+    return s
+}
+
+// SLO endpoint: objectives with their SLIs and burn rates per window
+func sloHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+    now := time.Now()
+    statuses := make([]SLOStatus, 0, len(sloTrackers))
+    for _, t := range sloTrackers {
+        statuses = append(statuses, t.status(now))
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "slos":      statuses,
+        "timestamp": now,
+    })
+}
+
+func writeSLOMetrics(w io.Writer) {
+    now := time.Now()
+    fmt.Fprintf(w, "# HELP auth_slo_objective SLO target, percent of good requests\n")
+    fmt.Fprintf(w, "# TYPE auth_slo_objective gauge\n")
+    for _, t := range sloTrackers {
+        fmt.Fprintf(w, "auth_slo_objective{slo=%q} %g\n", t.name, t.objective)
+    }
+    fmt.Fprintf(w, "# HELP auth_slo_requests_total Requests covered by an SLO by outcome\n")
+    fmt.Fprintf(w, "# TYPE auth_slo_requests_total counter\n")
+    for _, t := range sloTrackers {
+        t.mu.Lock()
+        good, bad := t.good, t.bad
+        t.mu.Unlock()
+        fmt.Fprintf(w, "auth_slo_requests_total{slo=%q,outcome=\"good\"} %d\n", t.name, good)
+        fmt.Fprintf(w, "auth_slo_requests_total{slo=%q,outcome=\"bad\"} %d\n", t.name, bad)
+    }
+    fmt.Fprintf(w, "# HELP auth_slo_burn_rate Error budget burn rate over a sliding window\n")
+    fmt.Fprintf(w, "# TYPE auth_slo_burn_rate gauge\n")
+    for _, t := range sloTrackers {
+        for _, win := range sloWindows {
+            good, total := t.window(now, win.d)
+            fmt.Fprintf(w, "auth_slo_burn_rate{slo=%q,window=%q} %g\n", t.name, win.name, t.burnRate(good, total))
+        }
+    }
+}
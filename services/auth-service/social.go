@@ -0,0 +1,496 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/url"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// "Login with GitHub/Google": /login/<provider> sends the browser to the
+// provider's consent page with a single-use state and a PKCE challenge, and
+// /login/<provider>/callback exchanges the returned code, reads the verified
+// email and signs the user in as /login does. A provider is enabled by
+// <PROVIDER>_CLIENT_ID; its client secret is <provider>-client-secret in the
+// Secret volume or <PROVIDER>_CLIENT_SECRET. The endpoints can be pointed
+// elsewhere (GitHub Enterprise, a local mock) with <PROVIDER>_AUTH_URL,
+// _TOKEN_URL and _USERINFO_URL.
+//
+// Provider accounts are linked to local users by the provider's subject.
+// On first sign-in the account is linked to the user with the same verified
+// email, or a new active user without a password is created. Front ends
+// listed in SOCIAL_LOGIN_REDIRECTS may pass redirect_uri to receive the
+// token in the URL fragment instead of as JSON. Logins waiting for the
+// provider's callback are one-time tokens in storage, keyed by state, so the
+// callback may land on any replica.
+var (
+    identityProviders = configuredProviders(
+        &identityProvider{
+            name:        "github",
+            authURL:     "https://github.com/login/oauth/authorize",
+            tokenURL:    "https://github.com/login/oauth/access_token",
+            userInfoURL: "https://api.github.com/user",
+            scopes:      "read:user user:email",
+            profile:     githubProfile,
+        },
+        &identityProvider{
+            name:        "google",
+            authURL:     "https://accounts.google.com/o/oauth2/v2/auth",
+            tokenURL:    "https://oauth2.googleapis.com/token",
+            userInfoURL: "https://openidconnect.googleapis.com/v1/userinfo",
+            scopes:      "openid email profile",
+            profile:     oidcProfile,
+        },
+    )
+    socialLoginTTL       = getEnvDuration("SOCIAL_LOGIN_TTL", 10*time.Minute)
+    socialLoginRedirects = strings.Split(os.Getenv("SOCIAL_LOGIN_REDIRECTS"), ",")
+)
+
+// Binds a login's state to the browser that started it
+const socialStateCookie = "auth_login_state"
+
+var (
+    errInvalidLoginState = autherr.ErrInvalidRequest.WithMessage("Invalid or expired login state")
+    errNoVerifiedEmail   = autherr.ErrUnverified.WithMessage("The provider account has no verified email address")
+    errProviderFailed    = autherr.ErrUpstreamUnavailable.WithMessage("Identity provider sign-in failed")
+)
+
+// identityProvider is one OAuth2 "login with" integration
+type identityProvider struct {
+    name        string
+    clientID    string
+    authURL     string
+    tokenURL    string
+    userInfoURL string
+    scopes      string
+
+    // Reads the signed-in account with an access token
+    profile func(ctx context.Context, p *identityProvider, accessToken string) (*providerProfile, error)
+}
+
+// providerProfile is what a provider tells us about the account
+type providerProfile struct {
+    subject       string
+    email         string
+    emailVerified bool
+}
+
+func configuredProviders(all ...*identityProvider) map[string]*identityProvider {
+    providers := make(map[string]*identityProvider)
+    for _, p := range all {
+        prefix := strings.ToUpper(p.name) + "_"
+        if p.clientID = os.Getenv(prefix + "CLIENT_ID"); p.clientID == "" {
+            continue
+        }
+        p.authURL = getEnv(prefix+"AUTH_URL", p.authURL)
+        p.tokenURL = getEnv(prefix+"TOKEN_URL", p.tokenURL)
+        p.userInfoURL = getEnv(prefix+"USERINFO_URL", p.userInfoURL)
+        providers[p.name] = p
+    }
+    return providers
+}
+
+func (p *identityProvider) redirectURI() string {
+    return publicBaseURL + "/login/" + p.name + "/callback"
+}
+
+func (p *identityProvider) clientSecret() string {
+    return secrets.get().providerSecrets[p.name]
+}
+
+const socialLoginKind = "social_login"
+
+type socialLogin struct {
+    provider string
+    tenant   string
+    audience string
+    verifier string // PKCE code verifier
+    returnTo string
+}
+
+// Store a login until the provider's callback, returning its state
+func issueSocialLogin(ctx context.Context, login socialLogin) (string, error) {
+    state, _, err := issueOneTimeToken(ctx, socialLoginKind, login.tenant, "", socialLoginTTL, map[string]string{
+        "provider": login.provider,
+        "audience": login.audience,
+        "verifier": login.verifier,
+        "returnTo": login.returnTo,
+    })
+    return state, err
+}
+
+func consumeSocialLogin(ctx context.Context, state string) (socialLogin, bool) {
+    t, ok := consumeOneTimeToken(ctx, socialLoginKind, state)
+    if !ok {
+        return socialLogin{}, false
+    }
+    return socialLogin{
+        provider: t.Data["provider"],
+        tenant:   t.Tenant,
+        audience: t.Data["audience"],
+        verifier: t.Data["verifier"],
+        returnTo: t.Data["returnTo"],
+    }, true
+}
+
+// PKCE (RFC 7636) verifier and its S256 challenge
+func pkcePair() (verifier, challenge string) {
+    verifier = base64.RawURLEncoding.EncodeToString([]byte(randomHex(32)))
+    sum := sha256.Sum256([]byte(verifier))
+    return verifier, base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func allowedReturnTo(returnTo string) bool {
+    for _, allowed := range socialLoginRedirects {
+        if allowed = strings.TrimSpace(allowed); allowed != "" && allowed == returnTo {
+            return true
+        }
+    }
+    return false
+}
+
+// Trade the authorization code for an access token
+func (p *identityProvider) exchange(ctx context.Context, code, verifier string) (string, error) {
+    form := url.Values{
+        "grant_type":    {"authorization_code"},
+        "code":          {code},
+        "redirect_uri":  {p.redirectURI()},
+        "client_id":     {p.clientID},
+        "client_secret": {p.clientSecret()},
+        "code_verifier": {verifier},
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+    if err != nil {
+        return "", err
+    }
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    req.Header.Set("Accept", "application/json")
+    resp, err := outbound.Do(req)
+    if err != nil {
+        return "", err
+    }
+    defer resp.Body.Close()
+
+    // GitHub reports a bad code with a 200 and an error field
+    var body struct {
+        AccessToken      string `json:"access_token"`
+        Error            string `json:"error"`
+        ErrorDescription string `json:"error_description"`
+    }
+    if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+        return "", fmt.Errorf("%s token endpoint returned %s", p.name, resp.Status)
+    }
+    if body.Error != "" || body.AccessToken == "" {
+        return "", fmt.Errorf("%s token endpoint returned %s: %s %s", p.name, resp.Status, body.Error, body.ErrorDescription)
+    }
+    return body.AccessToken, nil
+}
+
+func (p *identityProvider) getJSON(ctx context.Context, endpoint, accessToken string, v interface{}) error {
+    req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Authorization", "Bearer "+accessToken)
+    req.Header.Set("Accept", "application/json")
+    resp, err := outbound.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("%s %s returned %s", p.name, endpoint, resp.Status)
+    }
+    return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// GitHub's profile email is whatever the user made public, so the verified
+// primary address comes from /user/emails
+func githubProfile(ctx context.Context, p *identityProvider, accessToken string) (*providerProfile, error) {
+    var user struct {
+        ID int64 `json:"id"`
+    }
+    if err := p.getJSON(ctx, p.userInfoURL, accessToken, &user); err != nil {
+        return nil, err
+    }
+    if user.ID == 0 {
+        return nil, fmt.Errorf("github profile has no id")
+    }
+    var emails []struct {
+        Email    string `json:"email"`
+        Primary  bool   `json:"primary"`
+        Verified bool   `json:"verified"`
+    }
+    if err := p.getJSON(ctx, p.userInfoURL+"/emails", accessToken, &emails); err != nil {
+        return nil, err
+    }
+    profile := &providerProfile{subject: strconv.FormatInt(user.ID, 10)}
+    for _, e := range emails {
+        if e.Primary && e.Verified {
+            profile.email, profile.emailVerified = e.Email, true
+        }
+    }
+    return profile, nil
+}
+
+// OpenID Connect userinfo, as Google serves it
+func oidcProfile(ctx context.Context, p *identityProvider, accessToken string) (*providerProfile, error) {
+    var info struct {
+        Subject       string `json:"sub"`
+        Email         string `json:"email"`
+        EmailVerified bool   `json:"email_verified"`
+    }
+    if err := p.getJSON(ctx, p.userInfoURL, accessToken, &info); err != nil {
+        return nil, err
+    }
+    if info.Subject == "" {
+        return nil, fmt.Errorf("%s userinfo has no subject", p.name)
+    }
+    return &providerProfile{subject: info.Subject, email: info.Email, emailVerified: info.EmailVerified}, nil
+}
+
+// The local user for a provider account, linking or creating one on first
+// sign-in. linked reports a new link, created a new user.
+func linkedUser(ctx context.Context, tenant, provider string, profile *providerProfile) (user *User, linked, created bool, err error) {
+    identity, err := store.GetIdentity(ctx, tenant, provider, profile.subject)
+    if err == nil {
+        user, err = store.GetUser(ctx, identity.UserID)
+        return user, false, false, err
+    }
+    if !errors.Is(err, errIdentityNotFound) {
+        return nil, false, false, err
+    }
+    if !profile.emailVerified || !strings.Contains(profile.email, "@") {
+        return nil, false, false, errNoVerifiedEmail
+    }
+
+    now := time.Now()
+    user, err = store.GetUserByEmail(ctx, tenant, normalizeEmail(profile.email))
+    switch {
+    case errors.Is(err, errUserNotFound):
+        user = &User{
+            ID:         "user-" + randomHex(8),
+            Tenant:     tenant,
+            Email:      normalizeEmail(profile.email),
+            Status:     UserStatusActive,
+            Roles:      []string{"user"},
+            CreatedAt:  now,
+            VerifiedAt: now,
+        }
+        if err := store.CreateUser(ctx, user); err != nil {
+            return nil, false, false, err
+        }
+        created = true
+    case err != nil:
+        return nil, false, false, err
+    case user.Status == UserStatusPending:
+        // The provider has proven the address. Whoever registered it here
+        // never did, so their password is dropped rather than activated.
+        user.Status = UserStatusActive
+        user.VerifiedAt = now
+        user.PasswordHash = ""
+        if err := store.UpdateUser(ctx, user); err != nil {
+            return nil, false, false, err
+        }
+    }
+
+    err = store.LinkIdentity(ctx, &Identity{Tenant: tenant, Provider: provider, Subject: profile.subject, UserID: user.ID, CreatedAt: now})
+    if errors.Is(err, errIdentityLinked) {
+        // A concurrent callback for the same account got there first
+        return linkedUser(ctx, tenant, provider, profile)
+    }
+    if err != nil {
+        return nil, false, false, err
+    }
+    return user, true, created, nil
+}
+
+// Providers endpoint: which "login with" buttons a front end should show
+func loginProvidersHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+    list := []map[string]string{}
+    for _, name := range []string{"github", "google"} {
+        if p := identityProviders[name]; p != nil {
+            list = append(list, map[string]string{"name": p.name, "loginUrl": publicBaseURL + "/login/" + p.name})
+        }
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{"providers": list})
+}
+
+// Start endpoint: redirects to the provider's consent page
+func socialLoginHandler(p *identityProvider) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            autherr.Write(w, autherr.ErrMethodNotAllowed)
+            return
+        }
+        tenant := tenantFromContext(r.Context())
+        if secrets.get().signingKeys[tenant] == nil {
+            autherr.Write(w, autherr.ErrNotConfigured.WithMessage("JWT secret not configured"))
+            return
+        }
+        if p.clientSecret() == "" {
+            autherr.Write(w, autherr.ErrNotConfigured.WithMessage(p.name+" client secret not configured"))
+            return
+        }
+        audience, err := loginAudience(r.URL.Query().Get("audience"))
+        if err != nil {
+            autherr.Write(w, err)
+            return
+        }
+        returnTo := r.URL.Query().Get("redirect_uri")
+        if returnTo != "" && !allowedReturnTo(returnTo) {
+            autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("redirect_uri is not allowed"))
+            return
+        }
+
+        verifier, challenge := pkcePair()
+        state, err := issueSocialLogin(r.Context(), socialLogin{provider: p.name, tenant: tenant, audience: audience, verifier: verifier, returnTo: returnTo})
+        if err != nil {
+            log.Printf("❌ Social login state create failed: %v", err)
+            autherr.Write(w, autherr.ErrInternal)
+            return
+        }
+        http.SetCookie(w, &http.Cookie{
+            Name:     socialStateCookie,
+            Value:    state,
+            Path:     "/",
+            MaxAge:   int(socialLoginTTL.Seconds()),
+            HttpOnly: true,
+            Secure:   strings.HasPrefix(publicBaseURL, "https://"),
+            SameSite: http.SameSiteLaxMode,
+        })
+        consent := url.Values{
+            "response_type":         {"code"},
+            "client_id":             {p.clientID},
+            "redirect_uri":          {p.redirectURI()},
+            "scope":                 {p.scopes},
+            "state":                 {state},
+            "code_challenge":        {challenge},
+            "code_challenge_method": {"S256"},
+        }
+        w.Header().Set("Cache-Control", "no-store")
+        http.Redirect(w, r, p.authURL+"?"+consent.Encode(), http.StatusFound)
+    }
+}
+
+// Callback endpoint: the provider sends the browser back here with a code
+func socialCallbackHandler(p *identityProvider) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if r.Method != http.MethodGet {
+            autherr.Write(w, autherr.ErrMethodNotAllowed)
+            return
+        }
+        query := r.URL.Query()
+        state := query.Get("state")
+        cookie, err := r.Cookie(socialStateCookie)
+        if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+            autherr.Write(w, errInvalidLoginState)
+            return
+        }
+        http.SetCookie(w, &http.Cookie{Name: socialStateCookie, Path: "/", MaxAge: -1})
+        login, ok := consumeSocialLogin(r.Context(), state)
+        if !ok || login.provider != p.name {
+            autherr.Write(w, errInvalidLoginState)
+            return
+        }
+        // The callback URL is shared by every tenant; the login belongs to
+        // the one it started in
+        r = r.WithContext(context.WithValue(r.Context(), tenantKey{}, login.tenant))
+
+        if reason := query.Get("error"); reason != "" {
+            recordAudit(r, "login.failed", "", map[string]string{"reason": "provider_" + reason, "method": p.name})
+            autherr.Write(w, autherr.ErrUnauthenticated.WithMessage("Sign-in was cancelled at "+p.name))
+            return
+        }
+        accessToken, err := p.exchange(r.Context(), query.Get("code"), login.verifier)
+        if err != nil {
+            log.Printf("⚠️  %s code exchange failed: %v", p.name, err)
+            autherr.Write(w, errProviderFailed)
+            return
+        }
+        profile, err := p.profile(r.Context(), p, accessToken)
+        if err != nil {
+            log.Printf("⚠️  %s profile lookup failed: %v", p.name, err)
+            autherr.Write(w, errProviderFailed)
+            return
+        }
+
+        user, linked, created, err := linkedUser(r.Context(), login.tenant, p.name, profile)
+        if err != nil {
+            if errors.Is(err, errNoVerifiedEmail) {
+                recordAudit(r, "login.failed", "", map[string]string{"reason": "no_verified_email", "method": p.name})
+            } else if autherr.From(err).Status == http.StatusInternalServerError {
+                log.Printf("❌ %s account link failed: %v", p.name, err)
+            }
+            autherr.Write(w, err)
+            return
+        }
+        if created {
+            recordAudit(r, "user.registered", user.ID, map[string]string{"email": user.Email, "method": p.name})
+        }
+        if linked {
+            recordAudit(r, "user.identity_linked", user.ID, map[string]string{"provider": p.name, "subject": profile.subject})
+        }
+        if user.Status != UserStatusActive {
+            recordAudit(r, "login.failed", user.ID, map[string]string{"reason": "disabled", "method": p.name})
+            autherr.Write(w, autherr.ErrAccountDisabled)
+            return
+        }
+        attempt := LoginAttempt{
+            Tenant: user.Tenant,
+            Email:  user.Email,
+            UserID: user.ID,
+            IP:     clientIP(r),
+            Time:   clock.Now(),
+        }
+        if err := assessLoginRisk(r, attempt); err != nil {
+            autherr.Write(w, err)
+            return
+        }
+
+        signed, err := issueSessionToken(r, user, login.audience, "login.succeeded", map[string]string{"method": p.name})
+        if err != nil {
+            autherr.Write(w, err)
+            return
+        }
+        riskScorer.Observe(attempt, true)
+
+        w.Header().Set("Cache-Control", "no-store")
+        if login.returnTo != "" {
+            // In the fragment so the token stays out of server and proxy logs
+            fragment := url.Values{
+                "access_token": {signed},
+                "token_type":   {"Bearer"},
+                "expires_in":   {strconv.Itoa(int(tokenTTL.Seconds()))},
+                "audience":     {login.audience},
+            }
+            http.Redirect(w, r, login.returnTo+"#"+fragment.Encode(), http.StatusSeeOther)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]interface{}{
+            "token":     signed,
+            "tokenType": "Bearer",
+            "expiresIn": int(tokenTTL.Seconds()),
+            "audience":  login.audience,
+        })
+    }
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Optional SPIFFE workload identity. The SVID, its key and the trust bundle
+// are fetched from the SPIRE Workload API by a spiffe-helper sidecar, which
+// writes them to a shared volume and rewrites them on every rotation; the
+// service serves mTLS with that SVID on SPIFFE_PORT and authenticates peers
+// by the SPIFFE ID in their client certificate.
+var (
+    spiffeEnabled     = getEnv("SPIFFE_ENABLED", "false") == "true"
+    spiffePort        = getEnv("SPIFFE_PORT", "8443")
+    spiffeTrustDomain = getEnv("SPIFFE_TRUST_DOMAIN", "minikube.local")
+    spiffeRoles       = parseSPIFFERoleMap(os.Getenv("SPIFFE_ROLE_MAP"))
+    spiffe            = &spiffeSource{
+        dir:      getEnv("SPIFFE_SVID_DIR", "/run/spiffe"),
+        interval: getEnvDuration("SPIFFE_RELOAD_INTERVAL", 15*time.Second),
+    }
+)
+
+type spiffeSource struct {
+    dir      string
+    interval time.Duration
+
+    mu      sync.RWMutex
+    cert    *tls.Certificate
+    bundle  *x509.CertPool
+    id      string
+    modTime time.Time
+}
+
+// Load svid.pem, svid_key.pem and bundle.pem (spiffe-helper's default names)
+func (s *spiffeSource) load() error {
+    certFile := filepath.Join(s.dir, "svid.pem")
+    info, err := os.Stat(certFile)
+    if err != nil {
+        return err
+    }
+    s.mu.RLock()
+    unchanged := !info.ModTime().After(s.modTime)
+    s.mu.RUnlock()
+    if unchanged {
+        return nil
+    }
+
+    cert, err := tls.LoadX509KeyPair(certFile, filepath.Join(s.dir, "svid_key.pem"))
+    if err != nil {
+        return err
+    }
+    leaf, err := x509.ParseCertificate(cert.Certificate[0])
+    if err != nil {
+        return err
+    }
+    id, err := spiffeIDFromCert(leaf)
+    if err != nil {
+        return err
+    }
+    bundlePEM, err := os.ReadFile(filepath.Join(s.dir, "bundle.pem"))
+    if err != nil {
+        return err
+    }
+    bundle := x509.NewCertPool()
+    if !bundle.AppendCertsFromPEM(bundlePEM) {
+        return errors.New("bundle.pem contains no certificates")
+    }
+
+    s.mu.Lock()
+    s.cert, s.bundle, s.id, s.modTime = &cert, bundle, id, info.ModTime()
+    s.mu.Unlock()
+    log.Printf("🪪 Loaded SVID %s (expires %s)", id, leaf.NotAfter.Format(time.RFC3339))
+    return nil
+}
+
+func (s *spiffeSource) watch() {
+    for range time.Tick(s.interval) {
+        if err := s.load(); err != nil {
+            log.Printf("⚠️  SVID reload failed: %v", err)
+        }
+    }
+}
+
+func (s *spiffeSource) tlsConfig() *tls.Config {
+    return &tls.Config{
+        MinVersion: tls.VersionTLS12,
+        // Chain verification happens in verifyPeer against the SPIFFE bundle
+        // rather than the system roots
+        ClientAuth: tls.RequireAnyClientCert,
+        GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+            s.mu.RLock()
+            defer s.mu.RUnlock()
+            return s.cert, nil
+        },
+        VerifyPeerCertificate: s.verifyPeer,
+    }
+}
+
+// Verify a peer's X.509-SVID: chains to our trust bundle and carries a SPIFFE
+// ID in our trust domain
+func (s *spiffeSource) verifyPeer(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+    if len(rawCerts) == 0 {
+        return errors.New("no client certificate")
+    }
+    certs := make([]*x509.Certificate, len(rawCerts))
+    for i, raw := range rawCerts {
+        cert, err := x509.ParseCertificate(raw)
+        if err != nil {
+            return err
+        }
+        certs[i] = cert
+    }
+    intermediates := x509.NewCertPool()
+    for _, c := range certs[1:] {
+        intermediates.AddCert(c)
+    }
+
+    s.mu.RLock()
+    bundle := s.bundle
+    s.mu.RUnlock()
+
+    if _, err := certs[0].Verify(x509.VerifyOptions{
+        Roots:         bundle,
+        Intermediates: intermediates,
+        KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+    }); err != nil {
+        return fmt.Errorf("peer SVID: %w", err)
+    }
+    id, err := spiffeIDFromCert(certs[0])
+    if err != nil {
+        return err
+    }
+    if !strings.HasPrefix(id, "spiffe://"+spiffeTrustDomain+"/") {
+        return fmt.Errorf("peer %s is outside trust domain %s", id, spiffeTrustDomain)
+    }
+    return nil
+}
+
+// An X.509-SVID carries exactly one URI SAN with the spiffe scheme
+func spiffeIDFromCert(cert *x509.Certificate) (string, error) {
+    if len(cert.URIs) != 1 || cert.URIs[0].Scheme != "spiffe" {
+        return "", errors.New("certificate is not an X.509-SVID")
+    }
+    return cert.URIs[0].String(), nil
+}
+
+// Principal for an mTLS peer already verified during the handshake
+func spiffePrincipal(r *http.Request) *Principal {
+    if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+        return nil
+    }
+    id, err := spiffeIDFromCert(r.TLS.PeerCertificates[0])
+    if err != nil {
+        return nil
+    }
+    return &Principal{
+        Subject: id,
+        Kind:    "workload",
+        Roles:   spiffeRoles.lookup(id),
+    }
+}
+
+// SPIFFE_ROLE_MAP maps IDs to roles, e.g.
+// "spiffe://minikube.local/ns/production/sa/api-service=service;spiffe://minikube.local/ns/ops/*=admin|service".
+// A trailing "*" matches any ID with that prefix; the first match wins.
+type spiffeRoleMap []spiffeRoleEntry
+
+type spiffeRoleEntry struct {
+    pattern string
+    roles   []string
+}
+
+func parseSPIFFERoleMap(value string) spiffeRoleMap {
+    var m spiffeRoleMap
+    for _, entry := range strings.Split(value, ";") {
+        pattern, roles, ok := strings.Cut(strings.TrimSpace(entry), "=")
+        if !ok || pattern == "" {
+            continue
+        }
+        m = append(m, spiffeRoleEntry{pattern: pattern, roles: strings.Split(roles, "|")})
+    }
+    return m
+}
+
+func (m spiffeRoleMap) lookup(id string) []string {
+    for _, e := range m {
+        if e.pattern == id || (strings.HasSuffix(e.pattern, "*") && strings.HasPrefix(id, strings.TrimSuffix(e.pattern, "*"))) {
+            return e.roles
+        }
+    }
+    return nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Storage persists everything the service needs to survive a restart. The
+// driver is chosen by STORAGE_DRIVER: "postgres" for the in-cluster
+// database, "sqlite" (the default) for a self-contained local file, or
+// "memory" for throwaway runs.
+type Storage interface {
+    CreateUser(ctx context.Context, u *User) error
+    GetUser(ctx context.Context, id string) (*User, error)
+    GetUserByEmail(ctx context.Context, tenant, email string) (*User, error)
+    UpdateUser(ctx context.Context, u *User) error
+    ListUsers(ctx context.Context, f UserFilter) ([]User, error)
+    ListDeletedUsers(ctx context.Context, before time.Time, limit int) ([]User, error)
+    PurgeUser(ctx context.Context, u *User) error
+
+    LinkIdentity(ctx context.Context, i *Identity) error
+    GetIdentity(ctx context.Context, tenant, provider, subject string) (*Identity, error)
+    ListIdentities(ctx context.Context, userID string) ([]Identity, error)
+
+    CreateAPIKey(ctx context.Context, k *APIKey) error
+    GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error)
+    ListAPIKeys(ctx context.Context) ([]APIKey, error)
+    RevokeAPIKey(ctx context.Context, id string) error
+    AddAPIKeyUsage(ctx context.Context, keyID string, periods ...string) ([]int64, error)
+    GetAPIKeyUsage(ctx context.Context, keyID string, periods ...string) ([]int64, error)
+
+    CreateSession(ctx context.Context, s *Session) error
+    GetSession(ctx context.Context, id string) (*Session, error)
+    DeleteSession(ctx context.Context, id string) error
+    ListSessions(ctx context.Context, f SessionFilter) ([]Session, error)
+    PruneSessions(ctx context.Context, expiredBefore time.Time) (int64, error)
+
+    RevokeTokens(ctx context.Context, rev *Revocation) error
+    ListRevocations(ctx context.Context, since time.Time) ([]Revocation, error)
+
+    CreateOneTimeToken(ctx context.Context, t *OneTimeToken) error
+    GetOneTimeToken(ctx context.Context, kind, hash string) (*OneTimeToken, error)
+    ConsumeOneTimeToken(ctx context.Context, kind, hash string) (*OneTimeToken, error)
+    DeleteOneTimeTokens(ctx context.Context, kind, userID string) error
+    PruneOneTimeTokens(ctx context.Context, expiredBefore time.Time) (int64, error)
+
+    CreateDeviceGrant(ctx context.Context, g *DeviceGrant) error
+    GetDeviceGrant(ctx context.Context, hash string) (*DeviceGrant, error)
+    DecideDeviceGrant(ctx context.Context, tenant, userCode, userID string, approve bool, now time.Time) error
+    UpdateDeviceGrantPoll(ctx context.Context, hash string, lastPoll time.Time, interval time.Duration) error
+    DeleteDeviceGrant(ctx context.Context, hash string) error
+    PruneDeviceGrants(ctx context.Context, expiredBefore time.Time) (int64, error)
+
+    AppendAudit(ctx context.Context, e *AuditEvent) error
+    ListAudit(ctx context.Context, f AuditFilter) ([]AuditEvent, error)
+    PruneAudit(ctx context.Context, before time.Time) (int64, error)
+
+    Ping(ctx context.Context) error
+    Close() error
+}
+
+// UserFilter selects a page of a tenant's users ordered by ID, or by
+// creation time with SortByCreated. Empty fields don't filter, except that
+// deleted users only appear when Status asks for them; After is the last ID
+// of the previous page and AfterCreated its creation time.
+type UserFilter struct {
+    Tenant        string
+    Status        string
+    Role          string
+    EmailPrefix   string
+    SortByCreated bool
+    Desc          bool
+    After         string
+    AfterCreated  time.Time
+    Limit         int
+}
+
+// AuditFilter selects a page of audit events ordered by time, oldest first
+// unless Desc. Empty fields don't filter; After is the last ID of the
+// previous page and AfterTime its time.
+type AuditFilter struct {
+    Tenant    string
+    Type      string
+    Subject   string
+    Desc      bool
+    After     string
+    AfterTime time.Time
+    Limit     int
+}
+
+// SessionFilter selects a tenant's sessions still live at ActiveAt, or all
+// of them with a zero ActiveAt, newest first. UserID narrows them to a user.
+type SessionFilter struct {
+    Tenant   string
+    UserID   string
+    ActiveAt time.Time
+    Limit    int
+}
+
+// APIKey is a long-lived credential for service consumers; only the SHA-256
+// of the secret is stored
+type APIKey struct {
+    ID        string    `json:"id"`
+    Tenant    string    `json:"tenant"`
+    Name      string    `json:"name"`
+    Owner     string    `json:"owner"`
+    Hash      string    `json:"-"`
+    Roles     []string  `json:"roles"`
+    Scopes    []string  `json:"scopes"`
+    CreatedAt time.Time `json:"createdAt"`
+    Revoked   bool      `json:"revoked"`
+
+    // Requests allowed per UTC day and month; 0 uses QUOTA_DAILY/QUOTA_MONTHLY
+    DailyQuota   int64 `json:"dailyQuota,omitempty"`
+    MonthlyQuota int64 `json:"monthlyQuota,omitempty"`
+}
+
+// Session records a token issued by /login; its ID is the token's jti
+type Session struct {
+    ID        string    `json:"id"`
+    UserID    string    `json:"userId"`
+    ClientIP  string    `json:"clientIp"`
+    CreatedAt time.Time `json:"createdAt"`
+    ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Revocation rejects the tokens of a whole tenant, a user or a client that
+// were issued at or before NotBefore
+type Revocation struct {
+    Tenant    string    `json:"tenant"`
+    Kind      string    `json:"kind"` // "all", "user" or "client"
+    Target    string    `json:"target,omitempty"`
+    NotBefore time.Time `json:"notBefore"`
+    RevokedAt time.Time `json:"revokedAt"`
+}
+
+// Identity links an account at an external identity provider to a user
+type Identity struct {
+    Tenant    string    `json:"tenant"`
+    Provider  string    `json:"provider"`
+    Subject   string    `json:"subject"`
+    UserID    string    `json:"userId"`
+    CreatedAt time.Time `json:"createdAt"`
+}
+
+// OneTimeToken is a single-use token handed to a user, such as an email
+// verification link. Only the SHA-256 of the token is stored; Data carries
+// whatever the flow needs back when the token is redeemed.
+type OneTimeToken struct {
+    Kind      string            `json:"kind"`
+    Hash      string            `json:"-"`
+    Tenant    string            `json:"tenant"`
+    UserID    string            `json:"userId,omitempty"`
+    Data      map[string]string `json:"data,omitempty"`
+    ExpiresAt time.Time         `json:"expiresAt"`
+}
+
+// DeviceGrant is a pending device authorization; Hash is the SHA-256 of the
+// device code the tool polls with. UserID is set once the user approves.
+type DeviceGrant struct {
+    Hash      string        `json:"-"`
+    UserCode  string        `json:"userCode"`
+    Tenant    string        `json:"tenant"`
+    Audience  string        `json:"audience"`
+    Interval  time.Duration `json:"interval"`
+    LastPoll  time.Time     `json:"lastPoll,omitempty"`
+    Denied    bool          `json:"denied,omitempty"`
+    UserID    string        `json:"userId,omitempty"`
+    ExpiresAt time.Time     `json:"expiresAt"`
+}
+
+type AuditEvent struct {
+    ID      string            `json:"id"`
+    Time    time.Time         `json:"time"`
+    Tenant  string            `json:"tenant"`
+    Type    string            `json:"type"`
+    Subject string            `json:"subject,omitempty"`
+    IP      string            `json:"ip,omitempty"`
+    Details map[string]string `json:"details,omitempty"`
+}
+
+var (
+    errAPIKeyNotFound   = autherr.ErrNotFound.WithMessage("API key not found")
+    errSessionNotFound  = autherr.ErrNotFound.WithMessage("Session not found")
+    errIdentityNotFound = autherr.ErrNotFound.WithMessage("Linked identity not found")
+    errIdentityLinked   = autherr.ErrConflict.WithMessage("Identity is already linked to an account")
+    errTokenNotFound    = autherr.ErrNotFound.WithMessage("Token not found")
+    errDeviceNotFound   = autherr.ErrNotFound.WithMessage("Device grant not found")
+)
+
+var (
+    storageDriver = getEnv("STORAGE_DRIVER", "sqlite")
+    store         Storage
+)
+
+func openStorage(ctx context.Context) (Storage, error) {
+    switch storageDriver {
+    case "memory":
+        return newMemoryStorage(), nil
+    case "sqlite":
+        path := getEnv("SQLITE_PATH", filepath.Join(os.TempDir(), "auth-service.db"))
+        db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+        if err != nil {
+            return nil, err
+        }
+        // SQLite allows a single writer; serialising through one connection
+        // avoids SQLITE_BUSY under concurrent requests
+        db.SetMaxOpenConns(1)
+        log.Printf("💾 Using SQLite storage at %s", path)
+        return newSQLStorage(ctx, db, dialectSQLite)
+    case "postgres":
+        dsn := os.Getenv("DATABASE_URL")
+        if dsn == "" {
+            dsn = fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+                getEnv("DB_HOST", "localhost"), getEnv("DB_PORT", "5432"), dbUser, dbPassword,
+                getEnv("DB_NAME", "postgres"), getEnv("DB_SSLMODE", "disable"))
+        }
+        db, err := sql.Open("postgres", dsn)
+        if err != nil {
+            return nil, err
+        }
+        db.SetMaxOpenConns(getEnvInt("DB_MAX_OPEN_CONNS", 10))
+        db.SetMaxIdleConns(getEnvInt("DB_MAX_IDLE_CONNS", 5))
+        db.SetConnMaxLifetime(30 * time.Minute)
+        log.Printf("💾 Using Postgres storage at %s", getEnv("DB_HOST", "localhost"))
+        return newSQLStorage(ctx, db, dialectPostgres)
+    default:
+        return nil, fmt.Errorf("unknown STORAGE_DRIVER %q", storageDriver)
+    }
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+    "context"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// memoryStorage keeps everything in process; state is lost on restart and
+// not shared between replicas
+type memoryStorage struct {
+    mu       sync.RWMutex
+    users    map[string]User
+    byEmail  map[string]string
+    keys     map[string]APIKey
+    usage    map[string]int64 // key ID + "/" + period
+    sessions map[string]Session
+    links    map[string]Identity // tenant + provider + subject
+    revoked  map[string]Revocation // tenant + kind + target
+    tokens   map[string]OneTimeToken // kind + hash
+    devices  map[string]DeviceGrant
+    audit    []AuditEvent
+}
+
+func newMemoryStorage() *memoryStorage {
+    return &memoryStorage{
+        users:    make(map[string]User),
+        byEmail:  make(map[string]string),
+        keys:     make(map[string]APIKey),
+        usage:    make(map[string]int64),
+        sessions: make(map[string]Session),
+        links:    make(map[string]Identity),
+        revoked:  make(map[string]Revocation),
+        tokens:   make(map[string]OneTimeToken),
+        devices:  make(map[string]DeviceGrant),
+    }
+}
+
+func (m *memoryStorage) CreateUser(ctx context.Context, u *User) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    if _, exists := m.byEmail[emailKey(u.Tenant, u.Email)]; exists {
+        return errUserExists
+    }
+    m.users[u.ID] = *u
+    m.byEmail[emailKey(u.Tenant, u.Email)] = u.ID
+    return nil
+}
+
+func (m *memoryStorage) GetUser(ctx context.Context, id string) (*User, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    u, ok := m.users[id]
+    if !ok {
+        return nil, errUserNotFound
+    }
+    return &u, nil
+}
+
+// Emails are unique per tenant
+func emailKey(tenant, email string) string {
+    return tenant + "\x00" + email
+}
+
+func (m *memoryStorage) GetUserByEmail(ctx context.Context, tenant, email string) (*User, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    u, ok := m.users[m.byEmail[emailKey(tenant, email)]]
+    if !ok || u.Status == UserStatusDeleted {
+        return nil, errUserNotFound
+    }
+    return &u, nil
+}
+
+func (m *memoryStorage) UpdateUser(ctx context.Context, u *User) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    if _, ok := m.users[u.ID]; !ok {
+        return errUserNotFound
+    }
+    m.users[u.ID] = *u
+    return nil
+}
+
+func (m *memoryStorage) ListUsers(ctx context.Context, f UserFilter) ([]User, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    // Without SortByCreated every user compares at the zero time, by ID
+    created := func(u User) time.Time {
+        if f.SortByCreated {
+            return u.CreatedAt
+        }
+        return time.Time{}
+    }
+    users := []User{}
+    for _, u := range m.users {
+        if u.Tenant != f.Tenant ||
+            (f.After != "" && !pastCursor(created(u), u.ID, f.AfterCreated, f.After, f.Desc)) ||
+            (f.Status != "" && u.Status != f.Status) ||
+            (f.Status == "" && u.Status == UserStatusDeleted) ||
+            (f.Role != "" && !containsString(u.Roles, f.Role)) ||
+            !strings.HasPrefix(u.Email, f.EmailPrefix) {
+            continue
+        }
+        users = append(users, u)
+    }
+    sort.Slice(users, func(i, j int) bool {
+        return pastCursor(created(users[j]), users[j].ID, created(users[i]), users[i].ID, f.Desc)
+    })
+    if len(users) > f.Limit {
+        users = users[:f.Limit]
+    }
+    return users, nil
+}
+
+// Whether (t, id) comes after the position (afterT, afterID) in ascending,
+// or with desc descending, order of time then ID
+func pastCursor(t time.Time, id string, afterT time.Time, afterID string, desc bool) bool {
+    if !t.Equal(afterT) {
+        return t.After(afterT) != desc
+    }
+    if desc {
+        return id < afterID
+    }
+    return id > afterID
+}
+
+func (m *memoryStorage) ListDeletedUsers(ctx context.Context, before time.Time, limit int) ([]User, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    users := []User{}
+    for _, u := range m.users {
+        if u.Status == UserStatusDeleted && !u.DeletedAt.After(before) {
+            users = append(users, u)
+        }
+    }
+    sort.Slice(users, func(i, j int) bool {
+        return pastCursor(users[j].DeletedAt, users[j].ID, users[i].DeletedAt, users[i].ID, false)
+    })
+    if len(users) > limit {
+        users = users[:limit]
+    }
+    return users, nil
+}
+
+func (m *memoryStorage) PurgeUser(ctx context.Context, u *User) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    stored, ok := m.users[u.ID]
+    if !ok {
+        return errUserNotFound
+    }
+    delete(m.byEmail, emailKey(stored.Tenant, stored.Email))
+    delete(m.users, u.ID)
+    for key, i := range m.links {
+        if i.UserID == u.ID {
+            delete(m.links, key)
+        }
+    }
+    for id, s := range m.sessions {
+        if s.UserID == u.ID {
+            delete(m.sessions, id)
+        }
+    }
+    for i, e := range m.audit {
+        if e.Subject == u.ID || e.Subject == u.Email {
+            m.audit[i] = AuditEvent{ID: e.ID, Time: e.Time, Tenant: e.Tenant, Type: e.Type, Subject: u.ID}
+        }
+    }
+    return nil
+}
+
+func identityKey(tenant, provider, subject string) string {
+    return tenant + "\x00" + provider + "\x00" + subject
+}
+
+func (m *memoryStorage) LinkIdentity(ctx context.Context, i *Identity) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    key := identityKey(i.Tenant, i.Provider, i.Subject)
+    if _, exists := m.links[key]; exists {
+        return errIdentityLinked
+    }
+    m.links[key] = *i
+    return nil
+}
+
+func (m *memoryStorage) GetIdentity(ctx context.Context, tenant, provider, subject string) (*Identity, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    i, ok := m.links[identityKey(tenant, provider, subject)]
+    if !ok {
+        return nil, errIdentityNotFound
+    }
+    return &i, nil
+}
+
+func (m *memoryStorage) ListIdentities(ctx context.Context, userID string) ([]Identity, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    identities := []Identity{}
+    for _, i := range m.links {
+        if i.UserID == userID {
+            identities = append(identities, i)
+        }
+    }
+    sort.Slice(identities, func(a, b int) bool { return identities[a].CreatedAt.Before(identities[b].CreatedAt) })
+    return identities, nil
+}
+
+func (m *memoryStorage) CreateAPIKey(ctx context.Context, k *APIKey) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    m.keys[k.ID] = *k
+    return nil
+}
+
+func (m *memoryStorage) GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    for _, k := range m.keys {
+        if k.Hash == hash {
+            return &k, nil
+        }
+    }
+    return nil, errAPIKeyNotFound
+}
+
+func (m *memoryStorage) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    keys := make([]APIKey, 0, len(m.keys))
+    for _, k := range m.keys {
+        keys = append(keys, k)
+    }
+    return keys, nil
+}
+
+func (m *memoryStorage) RevokeAPIKey(ctx context.Context, id string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    k, ok := m.keys[id]
+    if !ok {
+        return errAPIKeyNotFound
+    }
+    k.Revoked = true
+    m.keys[id] = k
+    return nil
+}
+
+func (m *memoryStorage) AddAPIKeyUsage(ctx context.Context, keyID string, periods ...string) ([]int64, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    counts := make([]int64, len(periods))
+    for i, period := range periods {
+        m.usage[keyID+"/"+period]++
+        counts[i] = m.usage[keyID+"/"+period]
+    }
+    return counts, nil
+}
+
+func (m *memoryStorage) GetAPIKeyUsage(ctx context.Context, keyID string, periods ...string) ([]int64, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    counts := make([]int64, len(periods))
+    for i, period := range periods {
+        counts[i] = m.usage[keyID+"/"+period]
+    }
+    return counts, nil
+}
+
+func (m *memoryStorage) CreateSession(ctx context.Context, s *Session) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    m.sessions[s.ID] = *s
+    return nil
+}
+
+func (m *memoryStorage) GetSession(ctx context.Context, id string) (*Session, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    s, ok := m.sessions[id]
+    if !ok {
+        return nil, errSessionNotFound
+    }
+    return &s, nil
+}
+
+func (m *memoryStorage) DeleteSession(ctx context.Context, id string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    delete(m.sessions, id)
+    return nil
+}
+
+func (m *memoryStorage) ListSessions(ctx context.Context, f SessionFilter) ([]Session, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    sessions := []Session{}
+    for _, s := range m.sessions {
+        if u, ok := m.users[s.UserID]; ok && u.Tenant == f.Tenant && (f.UserID == "" || s.UserID == f.UserID) &&
+            (f.ActiveAt.IsZero() || s.ExpiresAt.After(f.ActiveAt)) {
+            sessions = append(sessions, s)
+        }
+    }
+    sort.Slice(sessions, func(i, j int) bool {
+        return pastCursor(sessions[j].CreatedAt, sessions[j].ID, sessions[i].CreatedAt, sessions[i].ID, true)
+    })
+    if len(sessions) > f.Limit {
+        sessions = sessions[:f.Limit]
+    }
+    return sessions, nil
+}
+
+func (m *memoryStorage) PruneSessions(ctx context.Context, expiredBefore time.Time) (int64, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    var n int64
+    for id, s := range m.sessions {
+        if s.ExpiresAt.Before(expiredBefore) {
+            delete(m.sessions, id)
+            n++
+        }
+    }
+    return n, nil
+}
+
+// Revoking again only moves the cutoff later
+func (m *memoryStorage) RevokeTokens(ctx context.Context, rev *Revocation) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    key := rev.Tenant + "\x00" + rev.Kind + "\x00" + rev.Target
+    stored := *rev
+    stored.NotBefore = rev.NotBefore.Truncate(time.Second).UTC()
+    if old, ok := m.revoked[key]; ok && old.NotBefore.After(stored.NotBefore) {
+        stored.NotBefore = old.NotBefore
+    }
+    m.revoked[key] = stored
+    return nil
+}
+
+func (m *memoryStorage) ListRevocations(ctx context.Context, since time.Time) ([]Revocation, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    var list []Revocation
+    for _, rev := range m.revoked {
+        if !rev.NotBefore.Before(since.Truncate(time.Second)) {
+            list = append(list, rev)
+        }
+    }
+    return list, nil
+}
+
+func (m *memoryStorage) CreateOneTimeToken(ctx context.Context, t *OneTimeToken) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    m.tokens[t.Kind+"\x00"+t.Hash] = *t
+    return nil
+}
+
+func (m *memoryStorage) GetOneTimeToken(ctx context.Context, kind, hash string) (*OneTimeToken, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    t, ok := m.tokens[kind+"\x00"+hash]
+    if !ok {
+        return nil, errTokenNotFound
+    }
+    return &t, nil
+}
+
+func (m *memoryStorage) ConsumeOneTimeToken(ctx context.Context, kind, hash string) (*OneTimeToken, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    t, ok := m.tokens[kind+"\x00"+hash]
+    if !ok {
+        return nil, errTokenNotFound
+    }
+    delete(m.tokens, kind+"\x00"+hash)
+    return &t, nil
+}
+
+func (m *memoryStorage) DeleteOneTimeTokens(ctx context.Context, kind, userID string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    for key, t := range m.tokens {
+        if t.Kind == kind && t.UserID == userID {
+            delete(m.tokens, key)
+        }
+    }
+    return nil
+}
+
+func (m *memoryStorage) PruneOneTimeTokens(ctx context.Context, expiredBefore time.Time) (int64, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    var n int64
+    for key, t := range m.tokens {
+        if t.ExpiresAt.Before(expiredBefore) {
+            delete(m.tokens, key)
+            n++
+        }
+    }
+    return n, nil
+}
+
+func (m *memoryStorage) CreateDeviceGrant(ctx context.Context, g *DeviceGrant) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    m.devices[g.Hash] = *g
+    return nil
+}
+
+func (m *memoryStorage) GetDeviceGrant(ctx context.Context, hash string) (*DeviceGrant, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    g, ok := m.devices[hash]
+    if !ok {
+        return nil, errDeviceNotFound
+    }
+    return &g, nil
+}
+
+func (m *memoryStorage) DecideDeviceGrant(ctx context.Context, tenant, userCode, userID string, approve bool, now time.Time) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    for hash, g := range m.devices {
+        if g.Tenant != tenant || g.UserCode != userCode || !g.ExpiresAt.After(now) || g.Denied || g.UserID != "" {
+            continue
+        }
+        if approve {
+            g.UserID = userID
+        } else {
+            g.Denied = true
+        }
+        m.devices[hash] = g
+        return nil
+    }
+    return errDeviceNotFound
+}
+
+func (m *memoryStorage) UpdateDeviceGrantPoll(ctx context.Context, hash string, lastPoll time.Time, interval time.Duration) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    if g, ok := m.devices[hash]; ok {
+        g.LastPoll, g.Interval = lastPoll, interval
+        m.devices[hash] = g
+    }
+    return nil
+}
+
+func (m *memoryStorage) DeleteDeviceGrant(ctx context.Context, hash string) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    if _, ok := m.devices[hash]; !ok {
+        return errDeviceNotFound
+    }
+    delete(m.devices, hash)
+    return nil
+}
+
+func (m *memoryStorage) PruneDeviceGrants(ctx context.Context, expiredBefore time.Time) (int64, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    var n int64
+    for hash, g := range m.devices {
+        if g.ExpiresAt.Before(expiredBefore) {
+            delete(m.devices, hash)
+            n++
+        }
+    }
+    return n, nil
+}
+
+func (m *memoryStorage) AppendAudit(ctx context.Context, e *AuditEvent) error {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    m.audit = append(m.audit, *e)
+    return nil
+}
+
+func (m *memoryStorage) ListAudit(ctx context.Context, f AuditFilter) ([]AuditEvent, error) {
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+
+    events := []AuditEvent{}
+    for _, e := range m.audit {
+        if (f.Tenant != "" && e.Tenant != f.Tenant) || (f.Type != "" && e.Type != f.Type) || (f.Subject != "" && e.Subject != f.Subject) ||
+            (f.After != "" && !pastCursor(e.Time, e.ID, f.AfterTime, f.After, f.Desc)) {
+            continue
+        }
+        events = append(events, e)
+    }
+    sort.Slice(events, func(i, j int) bool {
+        return pastCursor(events[j].Time, events[j].ID, events[i].Time, events[i].ID, f.Desc)
+    })
+    if len(events) > f.Limit {
+        events = events[:f.Limit]
+    }
+    return events, nil
+}
+
+func (m *memoryStorage) PruneAudit(ctx context.Context, before time.Time) (int64, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+
+    kept := m.audit[:0]
+    for _, e := range m.audit {
+        if !e.Time.Before(before) {
+            kept = append(kept, e)
+        }
+    }
+    n := int64(len(m.audit) - len(kept))
+    m.audit = kept
+    return n, nil
+}
+
+func (m *memoryStorage) Ping(ctx context.Context) error { return nil }
+
+func (m *memoryStorage) Close() error { return nil }
@@ -0,0 +1,892 @@
+package main
+
+import (
+    "context"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    _ "github.com/lib/pq"
+    _ "modernc.org/sqlite"
+)
+
+type sqlDialect int
+
+const (
+    dialectSQLite sqlDialect = iota
+    dialectPostgres
+)
+
+// sqlStorage implements Storage on database/sql for both Postgres and SQLite.
+// Queries are written with "?" placeholders and rebound for Postgres.
+type sqlStorage struct {
+    db      *sql.DB
+    dialect sqlDialect
+}
+
+func newSQLStorage(ctx context.Context, db *sql.DB, dialect sqlDialect) (*sqlStorage, error) {
+    s := &sqlStorage{db: db, dialect: dialect}
+    if err := db.PingContext(ctx); err != nil {
+        db.Close()
+        return nil, err
+    }
+    if migrateOnStartup {
+        if _, err := s.migrate(ctx); err != nil {
+            db.Close()
+            return nil, err
+        }
+    }
+    return s, nil
+}
+
+func (s *sqlStorage) rebind(query string) string {
+    if s.dialect != dialectPostgres {
+        return query
+    }
+    var b strings.Builder
+    n := 0
+    for _, c := range query {
+        if c == '?' {
+            n++
+            b.WriteString("$" + strconv.Itoa(n))
+            continue
+        }
+        b.WriteRune(c)
+    }
+    return b.String()
+}
+
+func (s *sqlStorage) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+    return s.db.ExecContext(ctx, s.rebind(query), args...)
+}
+
+func (s *sqlStorage) queryRow(ctx context.Context, query string, args ...interface{}) *sql.Row {
+    return s.db.QueryRowContext(ctx, s.rebind(query), args...)
+}
+
+func isUniqueViolation(err error) bool {
+    msg := strings.ToLower(err.Error())
+    return strings.Contains(msg, "unique") || strings.Contains(msg, "duplicate key")
+}
+
+func joinList(list []string) string {
+    return strings.Join(list, ",")
+}
+
+func splitList(s string) []string {
+    if s == "" {
+        return nil
+    }
+    return strings.Split(s, ",")
+}
+
+func nullTime(t time.Time) sql.NullTime {
+    return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
+const userColumns = "id, tenant, email, password_hash, status, roles, created_at, verified_at, deleted_at"
+
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, error) {
+    var u User
+    var roles string
+    var verified, deleted sql.NullTime
+    if err := row.Scan(&u.ID, &u.Tenant, &u.Email, &u.PasswordHash, &u.Status, &roles, &u.CreatedAt, &verified, &deleted); err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return nil, errUserNotFound
+        }
+        return nil, err
+    }
+    u.Roles = splitList(roles)
+    u.VerifiedAt = verified.Time
+    u.DeletedAt = deleted.Time
+    email, err := openField("users.email:"+u.ID, u.Email)
+    if err != nil {
+        return nil, err
+    }
+    u.Email = email
+    return &u, nil
+}
+
+// The email as stored, sealed when field encryption is on, and its blind index
+func sealUserEmail(u *User) (string, sql.NullString, error) {
+    email, err := sealField("users.email:"+u.ID, u.Email)
+    index := emailIndex(u.Tenant, u.Email)
+    return email, sql.NullString{String: index, Valid: index != ""}, err
+}
+
+func (s *sqlStorage) CreateUser(ctx context.Context, u *User) error {
+    email, index, err := sealUserEmail(u)
+    if err != nil {
+        return err
+    }
+    _, err = s.exec(ctx, "INSERT INTO users ("+userColumns+", email_index) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+        u.ID, u.Tenant, email, u.PasswordHash, u.Status, joinList(u.Roles), u.CreatedAt.UTC(), nullTime(u.VerifiedAt), nullTime(u.DeletedAt), index)
+    if err != nil && isUniqueViolation(err) {
+        return errUserExists
+    }
+    return err
+}
+
+func (s *sqlStorage) GetUser(ctx context.Context, id string) (*User, error) {
+    return scanUser(s.queryRow(ctx, "SELECT "+userColumns+" FROM users WHERE id = ?", id))
+}
+
+func (s *sqlStorage) GetUserByEmail(ctx context.Context, tenant, email string) (*User, error) {
+    // Rows written before field encryption was switched on still match by value
+    return scanUser(s.queryRow(ctx, "SELECT "+userColumns+" FROM users WHERE tenant = ? AND (email = ? OR email_index = ?) AND status <> ?",
+        tenant, email, emailIndex(tenant, email), UserStatusDeleted))
+}
+
+func (s *sqlStorage) UpdateUser(ctx context.Context, u *User) error {
+    email, index, err := sealUserEmail(u)
+    if err != nil {
+        return err
+    }
+    res, err := s.exec(ctx, "UPDATE users SET email = ?, email_index = ?, password_hash = ?, status = ?, roles = ?, verified_at = ?, deleted_at = ? WHERE id = ?",
+        email, index, u.PasswordHash, u.Status, joinList(u.Roles), nullTime(u.VerifiedAt), nullTime(u.DeletedAt), u.ID)
+    if err != nil {
+        return err
+    }
+    if n, _ := res.RowsAffected(); n == 0 {
+        return errUserNotFound
+    }
+    return nil
+}
+
+func (s *sqlStorage) ListUsers(ctx context.Context, f UserFilter) ([]User, error) {
+    // Sealed emails only match a prefix once opened, so with field
+    // encryption on the prefix filter runs here, a page at a time
+    filterHere := f.EmailPrefix != "" && fieldKeys() != nil
+    users := []User{}
+    for {
+        page, err := s.listUsersPage(ctx, f, !filterHere)
+        if err != nil {
+            return nil, err
+        }
+        for _, u := range page {
+            if !filterHere || strings.HasPrefix(u.Email, f.EmailPrefix) {
+                users = append(users, u)
+            }
+            if len(users) == f.Limit {
+                return users, nil
+            }
+        }
+        if !filterHere || len(page) < f.Limit {
+            return users, nil
+        }
+        f.After, f.AfterCreated = page[len(page)-1].ID, page[len(page)-1].CreatedAt
+    }
+}
+
+func (s *sqlStorage) listUsersPage(ctx context.Context, f UserFilter, byEmail bool) ([]User, error) {
+    query := "SELECT " + userColumns + " FROM users WHERE tenant = ?"
+    args := []interface{}{f.Tenant}
+    cmp, order := ">", "ASC"
+    if f.Desc {
+        cmp, order = "<", "DESC"
+    }
+    if f.After != "" {
+        if f.SortByCreated {
+            query += " AND (created_at " + cmp + " ? OR (created_at = ? AND id " + cmp + " ?))"
+            args = append(args, f.AfterCreated.UTC(), f.AfterCreated.UTC(), f.After)
+        } else {
+            query += " AND id " + cmp + " ?"
+            args = append(args, f.After)
+        }
+    }
+    if f.Status != "" {
+        query += " AND status = ?"
+        args = append(args, f.Status)
+    } else {
+        query += " AND status <> ?"
+        args = append(args, UserStatusDeleted)
+    }
+    if f.Role != "" {
+        // roles is stored comma-joined
+        query += ` AND ',' || roles || ',' LIKE ? ESCAPE '\'`
+        args = append(args, "%,"+likeEscape(f.Role)+",%")
+    }
+    if f.EmailPrefix != "" && byEmail {
+        query += ` AND email LIKE ? ESCAPE '\'`
+        args = append(args, likeEscape(f.EmailPrefix)+"%")
+    }
+    if f.SortByCreated {
+        query += " ORDER BY created_at " + order + ", id " + order
+    } else {
+        query += " ORDER BY id " + order
+    }
+    query += " LIMIT ?"
+    args = append(args, f.Limit)
+
+    rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    users := []User{}
+    for rows.Next() {
+        u, err := scanUser(rows)
+        if err != nil {
+            return nil, err
+        }
+        users = append(users, *u)
+    }
+    return users, rows.Err()
+}
+
+// Users soft-deleted at or before the cutoff, longest deleted first
+func (s *sqlStorage) ListDeletedUsers(ctx context.Context, before time.Time, limit int) ([]User, error) {
+    rows, err := s.db.QueryContext(ctx, s.rebind("SELECT "+userColumns+" FROM users WHERE status = ? AND deleted_at <= ? ORDER BY deleted_at, id LIMIT ?"),
+        UserStatusDeleted, before.UTC(), limit)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    users := []User{}
+    for rows.Next() {
+        u, err := scanUser(rows)
+        if err != nil {
+            return nil, err
+        }
+        users = append(users, *u)
+    }
+    return users, rows.Err()
+}
+
+// Remove the user with their linked identities and sessions. Audit events
+// about them are kept for the trail but stripped of the address, IP and
+// details, leaving only the opaque user ID.
+func (s *sqlStorage) PurgeUser(ctx context.Context, u *User) error {
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+
+    res, err := tx.ExecContext(ctx, s.rebind("DELETE FROM users WHERE id = ?"), u.ID)
+    if err != nil {
+        return err
+    }
+    if n, _ := res.RowsAffected(); n == 0 {
+        return errUserNotFound
+    }
+    for _, stmt := range []string{
+        "DELETE FROM user_identities WHERE user_id = ?",
+        "DELETE FROM sessions WHERE user_id = ?",
+    } {
+        if _, err := tx.ExecContext(ctx, s.rebind(stmt), u.ID); err != nil {
+            return err
+        }
+    }
+    if _, err := tx.ExecContext(ctx, s.rebind("UPDATE audit_events SET subject = ?, ip = '', details = '{}' WHERE subject = ? OR subject = ?"),
+        u.ID, u.ID, u.Email); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+func (s *sqlStorage) LinkIdentity(ctx context.Context, i *Identity) error {
+    _, err := s.exec(ctx, "INSERT INTO user_identities (tenant, provider, subject, user_id, created_at) VALUES (?, ?, ?, ?, ?)",
+        i.Tenant, i.Provider, i.Subject, i.UserID, i.CreatedAt.UTC())
+    if err != nil && isUniqueViolation(err) {
+        return errIdentityLinked
+    }
+    return err
+}
+
+func (s *sqlStorage) GetIdentity(ctx context.Context, tenant, provider, subject string) (*Identity, error) {
+    var i Identity
+    err := s.queryRow(ctx, "SELECT tenant, provider, subject, user_id, created_at FROM user_identities WHERE tenant = ? AND provider = ? AND subject = ?",
+        tenant, provider, subject).Scan(&i.Tenant, &i.Provider, &i.Subject, &i.UserID, &i.CreatedAt)
+    if errors.Is(err, sql.ErrNoRows) {
+        return nil, errIdentityNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    return &i, nil
+}
+
+func (s *sqlStorage) ListIdentities(ctx context.Context, userID string) ([]Identity, error) {
+    rows, err := s.db.QueryContext(ctx, s.rebind("SELECT tenant, provider, subject, user_id, created_at FROM user_identities WHERE user_id = ? ORDER BY created_at"), userID)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    identities := []Identity{}
+    for rows.Next() {
+        var i Identity
+        if err := rows.Scan(&i.Tenant, &i.Provider, &i.Subject, &i.UserID, &i.CreatedAt); err != nil {
+            return nil, err
+        }
+        identities = append(identities, i)
+    }
+    return identities, rows.Err()
+}
+
+func likeEscape(s string) string {
+    return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+const apiKeyColumns = "id, tenant, name, owner, key_hash, roles, scopes, created_at, revoked, daily_quota, monthly_quota"
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+    var k APIKey
+    var roles, scopes string
+    if err := row.Scan(&k.ID, &k.Tenant, &k.Name, &k.Owner, &k.Hash, &roles, &scopes, &k.CreatedAt, &k.Revoked, &k.DailyQuota, &k.MonthlyQuota); err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return nil, errAPIKeyNotFound
+        }
+        return nil, err
+    }
+    k.Roles = splitList(roles)
+    k.Scopes = splitList(scopes)
+    return &k, nil
+}
+
+func (s *sqlStorage) CreateAPIKey(ctx context.Context, k *APIKey) error {
+    _, err := s.exec(ctx, "INSERT INTO api_keys ("+apiKeyColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+        k.ID, k.Tenant, k.Name, k.Owner, k.Hash, joinList(k.Roles), joinList(k.Scopes), k.CreatedAt.UTC(), k.Revoked,
+        k.DailyQuota, k.MonthlyQuota)
+    return err
+}
+
+func (s *sqlStorage) GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+    return scanAPIKey(s.queryRow(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = ?", hash))
+}
+
+func (s *sqlStorage) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+    rows, err := s.db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys ORDER BY created_at")
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var keys []APIKey
+    for rows.Next() {
+        k, err := scanAPIKey(rows)
+        if err != nil {
+            return nil, err
+        }
+        keys = append(keys, *k)
+    }
+    return keys, rows.Err()
+}
+
+func (s *sqlStorage) RevokeAPIKey(ctx context.Context, id string) error {
+    res, err := s.exec(ctx, "UPDATE api_keys SET revoked = ? WHERE id = ?", true, id)
+    if err != nil {
+        return err
+    }
+    if n, _ := res.RowsAffected(); n == 0 {
+        return errAPIKeyNotFound
+    }
+    return nil
+}
+
+// Count one request against each period, returning the new totals
+func (s *sqlStorage) AddAPIKeyUsage(ctx context.Context, keyID string, periods ...string) ([]int64, error) {
+    counts := make([]int64, len(periods))
+    for i, period := range periods {
+        err := s.queryRow(ctx, `INSERT INTO api_key_usage (key_id, period, count) VALUES (?, ?, 1)
+            ON CONFLICT (key_id, period) DO UPDATE SET count = api_key_usage.count + 1
+            RETURNING count`, keyID, period).Scan(&counts[i])
+        if err != nil {
+            return nil, err
+        }
+    }
+    return counts, nil
+}
+
+func (s *sqlStorage) GetAPIKeyUsage(ctx context.Context, keyID string, periods ...string) ([]int64, error) {
+    counts := make([]int64, len(periods))
+    for i, period := range periods {
+        err := s.queryRow(ctx, "SELECT count FROM api_key_usage WHERE key_id = ? AND period = ?", keyID, period).Scan(&counts[i])
+        if err != nil && !errors.Is(err, sql.ErrNoRows) {
+            return nil, err
+        }
+    }
+    return counts, nil
+}
+
+func (s *sqlStorage) CreateSession(ctx context.Context, sess *Session) error {
+    clientIP, err := sealField("sessions.client_ip:"+sess.ID, sess.ClientIP)
+    if err != nil {
+        return err
+    }
+    _, err = s.exec(ctx, "INSERT INTO sessions (id, user_id, client_ip, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+        sess.ID, sess.UserID, clientIP, sess.CreatedAt.UTC(), sess.ExpiresAt.UTC())
+    return err
+}
+
+func (s *sqlStorage) GetSession(ctx context.Context, id string) (*Session, error) {
+    var sess Session
+    err := s.queryRow(ctx, "SELECT id, user_id, client_ip, created_at, expires_at FROM sessions WHERE id = ?", id).
+        Scan(&sess.ID, &sess.UserID, &sess.ClientIP, &sess.CreatedAt, &sess.ExpiresAt)
+    if errors.Is(err, sql.ErrNoRows) {
+        return nil, errSessionNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    if sess.ClientIP, err = openField("sessions.client_ip:"+sess.ID, sess.ClientIP); err != nil {
+        return nil, err
+    }
+    return &sess, nil
+}
+
+func (s *sqlStorage) DeleteSession(ctx context.Context, id string) error {
+    _, err := s.exec(ctx, "DELETE FROM sessions WHERE id = ?", id)
+    return err
+}
+
+func (s *sqlStorage) ListSessions(ctx context.Context, f SessionFilter) ([]Session, error) {
+    query := `SELECT s.id, s.user_id, s.client_ip, s.created_at, s.expires_at
+        FROM sessions s JOIN users u ON u.id = s.user_id
+        WHERE u.tenant = ?`
+    args := []interface{}{f.Tenant}
+    if !f.ActiveAt.IsZero() {
+        query += " AND s.expires_at > ?"
+        args = append(args, f.ActiveAt.UTC())
+    }
+    if f.UserID != "" {
+        query += " AND s.user_id = ?"
+        args = append(args, f.UserID)
+    }
+    query += " ORDER BY s.created_at DESC, s.id DESC LIMIT ?"
+    args = append(args, f.Limit)
+    rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    sessions := []Session{}
+    for rows.Next() {
+        var sess Session
+        if err := rows.Scan(&sess.ID, &sess.UserID, &sess.ClientIP, &sess.CreatedAt, &sess.ExpiresAt); err != nil {
+            return nil, err
+        }
+        if sess.ClientIP, err = openField("sessions.client_ip:"+sess.ID, sess.ClientIP); err != nil {
+            return nil, err
+        }
+        sessions = append(sessions, sess)
+    }
+    return sessions, rows.Err()
+}
+
+// Delete sessions that expired before the cutoff
+func (s *sqlStorage) PruneSessions(ctx context.Context, expiredBefore time.Time) (int64, error) {
+    res, err := s.exec(ctx, "DELETE FROM sessions WHERE expires_at < ?", expiredBefore.UTC())
+    if err != nil {
+        return 0, err
+    }
+    return res.RowsAffected()
+}
+
+// Revoking again only moves the cutoff later
+func (s *sqlStorage) RevokeTokens(ctx context.Context, rev *Revocation) error {
+    _, err := s.exec(ctx, `INSERT INTO token_revocations (tenant, kind, target, not_before, revoked_at) VALUES (?, ?, ?, ?, ?)
+        ON CONFLICT (tenant, kind, target) DO UPDATE SET
+            not_before = CASE WHEN excluded.not_before > token_revocations.not_before THEN excluded.not_before ELSE token_revocations.not_before END,
+            revoked_at = excluded.revoked_at`,
+        rev.Tenant, rev.Kind, rev.Target, rev.NotBefore.Unix(), rev.RevokedAt.UTC())
+    return err
+}
+
+func (s *sqlStorage) ListRevocations(ctx context.Context, since time.Time) ([]Revocation, error) {
+    rows, err := s.db.QueryContext(ctx, s.rebind("SELECT tenant, kind, target, not_before, revoked_at FROM token_revocations WHERE not_before >= ?"), since.Unix())
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var list []Revocation
+    for rows.Next() {
+        var rev Revocation
+        var notBefore int64
+        if err := rows.Scan(&rev.Tenant, &rev.Kind, &rev.Target, &notBefore, &rev.RevokedAt); err != nil {
+            return nil, err
+        }
+        rev.NotBefore = time.Unix(notBefore, 0).UTC()
+        list = append(list, rev)
+    }
+    return list, rows.Err()
+}
+
+const oneTimeTokenColumns = "kind, hash, tenant, user_id, data, expires_at"
+
+func scanOneTimeToken(row interface{ Scan(...interface{}) error }) (*OneTimeToken, error) {
+    var t OneTimeToken
+    var data string
+    if err := row.Scan(&t.Kind, &t.Hash, &t.Tenant, &t.UserID, &data, &t.ExpiresAt); err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return nil, errTokenNotFound
+        }
+        return nil, err
+    }
+    json.Unmarshal([]byte(data), &t.Data)
+    return &t, nil
+}
+
+func (s *sqlStorage) CreateOneTimeToken(ctx context.Context, t *OneTimeToken) error {
+    data, err := json.Marshal(t.Data)
+    if err != nil {
+        return err
+    }
+    _, err = s.exec(ctx, "INSERT INTO one_time_tokens ("+oneTimeTokenColumns+") VALUES (?, ?, ?, ?, ?, ?)",
+        t.Kind, t.Hash, t.Tenant, t.UserID, string(data), t.ExpiresAt.UTC())
+    return err
+}
+
+func (s *sqlStorage) GetOneTimeToken(ctx context.Context, kind, hash string) (*OneTimeToken, error) {
+    return scanOneTimeToken(s.queryRow(ctx, "SELECT "+oneTimeTokenColumns+" FROM one_time_tokens WHERE kind = ? AND hash = ?", kind, hash))
+}
+
+// Delete the token and return it; when replicas race for the same token only
+// one gets it back
+func (s *sqlStorage) ConsumeOneTimeToken(ctx context.Context, kind, hash string) (*OneTimeToken, error) {
+    return scanOneTimeToken(s.queryRow(ctx, "DELETE FROM one_time_tokens WHERE kind = ? AND hash = ? RETURNING "+oneTimeTokenColumns, kind, hash))
+}
+
+func (s *sqlStorage) DeleteOneTimeTokens(ctx context.Context, kind, userID string) error {
+    _, err := s.exec(ctx, "DELETE FROM one_time_tokens WHERE kind = ? AND user_id = ?", kind, userID)
+    return err
+}
+
+// Delete tokens that expired before the cutoff
+func (s *sqlStorage) PruneOneTimeTokens(ctx context.Context, expiredBefore time.Time) (int64, error) {
+    res, err := s.exec(ctx, "DELETE FROM one_time_tokens WHERE expires_at < ?", expiredBefore.UTC())
+    if err != nil {
+        return 0, err
+    }
+    return res.RowsAffected()
+}
+
+const deviceGrantColumns = "hash, user_code, tenant, audience, poll_interval, last_poll, denied, user_id, expires_at"
+
+func scanDeviceGrant(row interface{ Scan(...interface{}) error }) (*DeviceGrant, error) {
+    var g DeviceGrant
+    var interval int64
+    var lastPoll sql.NullTime
+    if err := row.Scan(&g.Hash, &g.UserCode, &g.Tenant, &g.Audience, &interval, &lastPoll, &g.Denied, &g.UserID, &g.ExpiresAt); err != nil {
+        if errors.Is(err, sql.ErrNoRows) {
+            return nil, errDeviceNotFound
+        }
+        return nil, err
+    }
+    g.Interval = time.Duration(interval) * time.Second
+    g.LastPoll = lastPoll.Time
+    return &g, nil
+}
+
+func (s *sqlStorage) CreateDeviceGrant(ctx context.Context, g *DeviceGrant) error {
+    _, err := s.exec(ctx, "INSERT INTO device_grants ("+deviceGrantColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+        g.Hash, g.UserCode, g.Tenant, g.Audience, int64(g.Interval/time.Second), nullTime(g.LastPoll), g.Denied, g.UserID, g.ExpiresAt.UTC())
+    return err
+}
+
+func (s *sqlStorage) GetDeviceGrant(ctx context.Context, hash string) (*DeviceGrant, error) {
+    return scanDeviceGrant(s.queryRow(ctx, "SELECT "+deviceGrantColumns+" FROM device_grants WHERE hash = ?", hash))
+}
+
+// Approve or deny the live, undecided grant with the user code; the
+// condition makes the first decision stick when two land at once
+func (s *sqlStorage) DecideDeviceGrant(ctx context.Context, tenant, userCode, userID string, approve bool, now time.Time) error {
+    set, args := "denied = ?", []interface{}{true}
+    if approve {
+        set, args = "user_id = ?", []interface{}{userID}
+    }
+    res, err := s.exec(ctx, "UPDATE device_grants SET "+set+" WHERE tenant = ? AND user_code = ? AND expires_at > ? AND denied = ? AND user_id = ''",
+        append(args, tenant, userCode, now.UTC(), false)...)
+    if err != nil {
+        return err
+    }
+    if n, _ := res.RowsAffected(); n == 0 {
+        return errDeviceNotFound
+    }
+    return nil
+}
+
+func (s *sqlStorage) UpdateDeviceGrantPoll(ctx context.Context, hash string, lastPoll time.Time, interval time.Duration) error {
+    _, err := s.exec(ctx, "UPDATE device_grants SET last_poll = ?, poll_interval = ? WHERE hash = ?",
+        lastPoll.UTC(), int64(interval/time.Second), hash)
+    return err
+}
+
+// Deleting reports errDeviceNotFound when another replica got there first
+func (s *sqlStorage) DeleteDeviceGrant(ctx context.Context, hash string) error {
+    res, err := s.exec(ctx, "DELETE FROM device_grants WHERE hash = ?", hash)
+    if err != nil {
+        return err
+    }
+    if n, _ := res.RowsAffected(); n == 0 {
+        return errDeviceNotFound
+    }
+    return nil
+}
+
+// Delete grants that expired before the cutoff
+func (s *sqlStorage) PruneDeviceGrants(ctx context.Context, expiredBefore time.Time) (int64, error) {
+    res, err := s.exec(ctx, "DELETE FROM device_grants WHERE expires_at < ?", expiredBefore.UTC())
+    if err != nil {
+        return 0, err
+    }
+    return res.RowsAffected()
+}
+
+func (s *sqlStorage) AppendAudit(ctx context.Context, e *AuditEvent) error {
+    details, err := json.Marshal(e.Details)
+    if err != nil {
+        return err
+    }
+    _, err = s.exec(ctx, "INSERT INTO audit_events (id, created_at, tenant, type, subject, ip, details) VALUES (?, ?, ?, ?, ?, ?, ?)",
+        e.ID, e.Time.UTC(), e.Tenant, e.Type, e.Subject, e.IP, string(details))
+    return err
+}
+
+// Write an audit event and its outbox message atomically, so an event is
+// published if and only if it was recorded
+func (s *sqlStorage) AppendAuditOutbox(ctx context.Context, e *AuditEvent, m *OutboxMessage) error {
+    details, err := json.Marshal(e.Details)
+    if err != nil {
+        return err
+    }
+    tx, err := s.db.BeginTx(ctx, nil)
+    if err != nil {
+        return err
+    }
+    defer tx.Rollback()
+    if _, err := tx.ExecContext(ctx, s.rebind("INSERT INTO audit_events (id, created_at, tenant, type, subject, ip, details) VALUES (?, ?, ?, ?, ?, ?, ?)"),
+        e.ID, e.Time.UTC(), e.Tenant, e.Type, e.Subject, e.IP, string(details)); err != nil {
+        return err
+    }
+    if _, err := tx.ExecContext(ctx, s.rebind("INSERT INTO outbox (id, subject, payload, created_at) VALUES (?, ?, ?, ?)"),
+        m.ID, m.Subject, string(m.Payload), m.CreatedAt.UTC()); err != nil {
+        return err
+    }
+    return tx.Commit()
+}
+
+// Claim up to limit unsent messages, oldest first, until the given time.
+// The conditional update makes each claim exclusive across replicas.
+func (s *sqlStorage) ClaimOutbox(ctx context.Context, limit int, until time.Time) ([]OutboxMessage, error) {
+    now := time.Now().UTC()
+    rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT id, subject, payload, created_at FROM outbox
+        WHERE sent_at IS NULL AND (claimed_until IS NULL OR claimed_until < ?)
+        ORDER BY created_at, id LIMIT ?`), now, limit)
+    if err != nil {
+        return nil, err
+    }
+    var candidates []OutboxMessage
+    for rows.Next() {
+        var m OutboxMessage
+        var payload string
+        if err := rows.Scan(&m.ID, &m.Subject, &payload, &m.CreatedAt); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        m.Payload = []byte(payload)
+        candidates = append(candidates, m)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    claimed := candidates[:0]
+    for _, m := range candidates {
+        res, err := s.exec(ctx, `UPDATE outbox SET claimed_until = ?, attempts = attempts + 1
+            WHERE id = ? AND sent_at IS NULL AND (claimed_until IS NULL OR claimed_until < ?)`, until.UTC(), m.ID, now)
+        if err != nil {
+            return nil, err
+        }
+        if n, _ := res.RowsAffected(); n == 1 {
+            claimed = append(claimed, m)
+        }
+    }
+    return claimed, nil
+}
+
+func (s *sqlStorage) MarkOutboxSent(ctx context.Context, ids []string) error {
+    now := time.Now().UTC()
+    for _, id := range ids {
+        if _, err := s.exec(ctx, "UPDATE outbox SET sent_at = ? WHERE id = ?", now, id); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Give up claims after a failed publish so the next attempt retries at once
+func (s *sqlStorage) ReleaseOutbox(ctx context.Context, ids []string) error {
+    for _, id := range ids {
+        if _, err := s.exec(ctx, "UPDATE outbox SET claimed_until = NULL WHERE id = ? AND sent_at IS NULL", id); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Delete messages sent before the cutoff
+func (s *sqlStorage) PruneOutbox(ctx context.Context, before time.Time) (int64, error) {
+    res, err := s.exec(ctx, "DELETE FROM outbox WHERE sent_at IS NOT NULL AND sent_at < ?", before.UTC())
+    if err != nil {
+        return 0, err
+    }
+    return res.RowsAffected()
+}
+
+func (s *sqlStorage) OutboxPending(ctx context.Context) (int64, error) {
+    var n int64
+    err := s.queryRow(ctx, "SELECT COUNT(*) FROM outbox WHERE sent_at IS NULL").Scan(&n)
+    return n, err
+}
+
+func (s *sqlStorage) ListAudit(ctx context.Context, f AuditFilter) ([]AuditEvent, error) {
+    query := "SELECT id, created_at, tenant, type, subject, ip, details FROM audit_events WHERE 1 = 1"
+    var args []interface{}
+    if f.Tenant != "" {
+        query += " AND tenant = ?"
+        args = append(args, f.Tenant)
+    }
+    if f.Type != "" {
+        query += " AND type = ?"
+        args = append(args, f.Type)
+    }
+    if f.Subject != "" {
+        query += " AND subject = ?"
+        args = append(args, f.Subject)
+    }
+    cmp, order := ">", "ASC"
+    if f.Desc {
+        cmp, order = "<", "DESC"
+    }
+    if f.After != "" {
+        query += " AND (created_at " + cmp + " ? OR (created_at = ? AND id " + cmp + " ?))"
+        args = append(args, f.AfterTime.UTC(), f.AfterTime.UTC(), f.After)
+    }
+    query += " ORDER BY created_at " + order + ", id " + order + " LIMIT ?"
+    args = append(args, f.Limit)
+
+    rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    var events []AuditEvent
+    for rows.Next() {
+        var e AuditEvent
+        var details string
+        if err := rows.Scan(&e.ID, &e.Time, &e.Tenant, &e.Type, &e.Subject, &e.IP, &details); err != nil {
+            return nil, err
+        }
+        json.Unmarshal([]byte(details), &e.Details)
+        events = append(events, e)
+    }
+    if events == nil {
+        events = []AuditEvent{}
+    }
+    return events, rows.Err()
+}
+
+// Delete audit events recorded before the cutoff
+func (s *sqlStorage) PruneAudit(ctx context.Context, before time.Time) (int64, error) {
+    res, err := s.exec(ctx, "DELETE FROM audit_events WHERE created_at < ?", before.UTC())
+    if err != nil {
+        return 0, err
+    }
+    return res.RowsAffected()
+}
+
+func (s *sqlStorage) Ping(ctx context.Context) error {
+    return s.db.PingContext(ctx)
+}
+
+func (s *sqlStorage) Close() error {
+    return s.db.Close()
+}
+
+// ResealFields brings a page of sealed columns under the active field key.
+// The update only lands if the row still holds the value read, so a
+// concurrent write or another replica's pass is never overwritten.
+func (s *sqlStorage) ResealFields(ctx context.Context, table, after string, limit int) (string, int, error) {
+    type sealedRow struct {
+        id, tenant, value string
+        index             sql.NullString
+    }
+    var query string
+    switch table {
+    case "users":
+        query = "SELECT id, tenant, email, email_index FROM users WHERE id > ? ORDER BY id LIMIT ?"
+    case "sessions":
+        query = "SELECT id, '', client_ip, NULL FROM sessions WHERE id > ? ORDER BY id LIMIT ?"
+    default:
+        return "", 0, fmt.Errorf("no sealed fields in %s", table)
+    }
+    rows, err := s.db.QueryContext(ctx, s.rebind(query), after, limit)
+    if err != nil {
+        return "", 0, err
+    }
+    var page []sealedRow
+    for rows.Next() {
+        var r sealedRow
+        if err := rows.Scan(&r.id, &r.tenant, &r.value, &r.index); err != nil {
+            rows.Close()
+            return "", 0, err
+        }
+        page = append(page, r)
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return "", 0, err
+    }
+
+    changed := 0
+    for _, r := range page {
+        if table == "sessions" {
+            sealed, ok, err := resealField("sessions.client_ip:"+r.id, r.value)
+            if err != nil {
+                return "", changed, fmt.Errorf("session %s: %w", r.id, err)
+            }
+            if !ok {
+                continue
+            }
+            if _, err := s.exec(ctx, "UPDATE sessions SET client_ip = ? WHERE id = ? AND client_ip = ?", sealed, r.id, r.value); err != nil {
+                return "", changed, err
+            }
+            changed++
+            continue
+        }
+
+        binding := "users.email:" + r.id
+        sealed, ok, err := resealField(binding, r.value)
+        if err != nil {
+            return "", changed, fmt.Errorf("user %s: %w", r.id, err)
+        }
+        email, err := openField(binding, sealed)
+        if err != nil {
+            return "", changed, fmt.Errorf("user %s: %w", r.id, err)
+        }
+        index := emailIndex(r.tenant, email)
+        if !ok && r.index.String == index {
+            continue
+        }
+        if _, err := s.exec(ctx, "UPDATE users SET email = ?, email_index = ? WHERE id = ? AND email = ?", sealed, index, r.id, r.value); err != nil {
+            return "", changed, err
+        }
+        changed++
+    }
+    if len(page) < limit {
+        return "", changed, nil
+    }
+    return page[len(page)-1].id, changed, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+    "context"
+    "log"
+    "net"
+    "net/http"
+    "regexp"
+    "strings"
+
+    "auth-service/internal/autherr"
+    "auth-service/internal/tenantkey"
+)
+
+// Tenants let one deployment serve several demo applications with isolated
+// accounts, API keys and signing keys. Requests name their tenant with the
+// X-Tenant-ID header or a subdomain of TENANT_DOMAIN; anything else belongs
+// to the default tenant, so single-tenant callers see no change.
+const defaultTenant = tenantkey.Default
+
+var (
+    tenantDomain = strings.TrimPrefix(strings.ToLower(getEnv("TENANT_DOMAIN", "")), ".")
+    tenants      = parseTenants(getEnv("TENANTS", defaultTenant))
+    tenantIDRe   = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+)
+
+var errUnknownTenant = autherr.ErrNotFound.WithMessage("Unknown tenant")
+
+// Parse the comma-separated TENANTS list; the default tenant always exists
+func parseTenants(value string) map[string]bool {
+    set := map[string]bool{defaultTenant: true}
+    for _, id := range strings.Split(value, ",") {
+        id = strings.TrimSpace(strings.ToLower(id))
+        if id == "" {
+            continue
+        }
+        if !tenantIDRe.MatchString(id) {
+            log.Fatalf("❌ Invalid tenant ID %q in TENANTS", id)
+        }
+        set[id] = true
+    }
+    return set
+}
+
+// Resolve the tenant a request is addressed to
+func resolveTenant(r *http.Request) (string, error) {
+    if id := r.Header.Get("X-Tenant-ID"); id != "" {
+        id = strings.ToLower(id)
+        if !tenants[id] {
+            return "", errUnknownTenant
+        }
+        return id, nil
+    }
+    if tenantDomain != "" {
+        host := strings.ToLower(r.Host)
+        if h, _, err := net.SplitHostPort(host); err == nil {
+            host = h
+        }
+        if sub, ok := strings.CutSuffix(host, "."+tenantDomain); ok && !strings.Contains(sub, ".") {
+            if !tenants[sub] {
+                return "", errUnknownTenant
+            }
+            return sub, nil
+        }
+    }
+    return defaultTenant, nil
+}
+
+type tenantKey struct{}
+
+// Tenant resolved by tenantMiddleware, the default tenant if none was
+func tenantFromContext(ctx context.Context) string {
+    if t, ok := ctx.Value(tenantKey{}).(string); ok {
+        return t
+    }
+    return defaultTenant
+}
+
+func tenantMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        tenant, err := resolveTenant(r)
+        if err != nil {
+            autherr.Write(w, err)
+            return
+        }
+        next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant)))
+    })
+}
+
+// The tid claim is omitted for the default tenant so tokens issued before
+// tenants existed keep verifying unchanged
+func claimTenant(tenant string) string {
+    if tenant == defaultTenant {
+        return ""
+    }
+    return tenant
+}
+
+func tokenTenant(c *Claims) string {
+    if c.Tenant == "" {
+        return defaultTenant
+    }
+    return c.Tenant
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "strings"
+    "sync"
+
+    "auth-service/internal/autherr"
+)
+
+// Claims carried by tokens issued from /login and /token/exchange
+type Claims struct {
+    ID        string   `json:"jti,omitempty"`
+    Subject   string   `json:"sub"`
+    Email     string   `json:"email,omitempty"`
+    Roles     []string `json:"roles,omitempty"`
+    Scopes    []string `json:"scopes,omitempty"`
+    Audience  string   `json:"aud,omitempty"`
+    Tenant    string   `json:"tid,omitempty"`
+    Actor     *Actor   `json:"act,omitempty"`
+    IssuedAt  int64    `json:"iat"`
+    NotBefore int64    `json:"nbf,omitempty"`
+    ExpiresAt int64    `json:"exp"`
+
+    // Admin acting as the subject; see impersonate.go
+    ImpersonatedBy string `json:"impersonated_by,omitempty"`
+
+    // Client the token is bound to; see binding.go
+    Confirmation *Confirmation `json:"cnf,omitempty"`
+    BoundCIDR    string        `json:"cidr,omitempty"`
+}
+
+var (
+    errMalformedToken = autherr.ErrInvalidToken.WithMessage("Malformed token")
+    errBadSignature   = autherr.ErrInvalidToken.WithMessage("Invalid token signature")
+    errTokenExpired   = autherr.ErrExpiredToken
+    errTokenNotYet    = autherr.ErrInvalidToken.WithMessage("Token is not valid yet")
+)
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Scratch space for verifying tokens: a byte copy of the token followed by
+// its decoded payload
+var tokenBuffers = sync.Pool{
+    New: func() interface{} {
+        b := make([]byte, 0, 1024)
+        return &b
+    },
+}
+
+// Sign claims as a JWT with the signing key of the claims' tenant: EdDSA
+// when a token signing key is configured, HS256 otherwise
+func signToken(claims Claims) (string, error) {
+    set := secrets.get()
+    if edKey := set.tokenKeys[tokenTenant(&claims)]; edKey != nil {
+        return signEdDSA(edKey, claims)
+    }
+    key := set.signingKeys[tokenTenant(&claims)]
+    if key == nil {
+        return "", autherr.ErrNotConfigured.WithMessage("No signing key configured for tenant")
+    }
+    payload, err := json.Marshal(claims)
+    if err != nil {
+        return "", err
+    }
+
+    enc := base64.RawURLEncoding
+    payloadLen := enc.EncodedLen(len(payload))
+    sigLen := enc.EncodedLen(sha256.Size)
+    buf := make([]byte, len(jwtHeader)+1+payloadLen+1+sigLen)
+    n := copy(buf, jwtHeader)
+    buf[n] = '.'
+    enc.Encode(buf[n+1:], payload)
+    n += 1 + payloadLen
+
+    h := key.getMAC()
+    h.Write(buf[:n])
+    var sig [sha256.Size]byte
+    h.Sum(sig[:0])
+    key.putMAC(h)
+
+    buf[n] = '.'
+    enc.Encode(buf[n+1:], sig[:])
+    return string(buf), nil
+}
+
+// Verify signature and expiry, returning the embedded claims. This runs for
+// every authenticated request, so it avoids strings.Split, re-encoding the
+// expected signature and reflection-based JSON decoding.
+func parseToken(token string) (*Claims, error) {
+    dot1 := strings.IndexByte(token, '.')
+    if dot1 < 0 {
+        return nil, errMalformedToken
+    }
+    dot2 := strings.IndexByte(token[dot1+1:], '.')
+    if dot2 < 0 {
+        return nil, errMalformedToken
+    }
+    dot2 += dot1 + 1
+    if strings.IndexByte(token[dot2+1:], '.') >= 0 {
+        return nil, errMalformedToken
+    }
+    if token[:dot1] != jwtHeader {
+        return parseEdDSAToken(token, dot1, dot2)
+    }
+
+    enc := base64.RawURLEncoding
+    if enc.DecodedLen(len(token)-dot2-1) != sha256.Size {
+        return nil, errBadSignature
+    }
+
+    bufp := tokenBuffers.Get().(*[]byte)
+    defer tokenBuffers.Put(bufp)
+    need := len(token) + enc.DecodedLen(dot2-dot1-1)
+    if cap(*bufp) < need {
+        *bufp = make([]byte, need)
+    }
+    buf := (*bufp)[:need]
+    copy(buf, token)
+
+    var presented [sha256.Size]byte
+    if _, err := enc.Decode(presented[:], buf[dot2+1:len(token)]); err != nil {
+        return nil, errBadSignature
+    }
+
+    // The payload is decoded before the signature is checked because its tid
+    // claim selects the key; nothing in it is trusted until the MAC matches
+    n, err := enc.Decode(buf[len(token):], buf[dot1+1:dot2])
+    if err != nil {
+        return nil, errMalformedToken
+    }
+    payload := buf[len(token) : len(token)+n]
+
+    claims := &Claims{}
+    if err := decodeClaims(string(payload), claims); err != nil {
+        // Anything the fast decoder doesn't handle (escaped strings, unusual
+        // layouts) falls back to encoding/json
+        *claims = Claims{}
+        if err := json.Unmarshal(payload, claims); err != nil {
+            return nil, errMalformedToken
+        }
+    }
+
+    // Accept the previous secret during a rotation grace period
+    current, previous := secrets.accepted()
+    tenant := tokenTenant(claims)
+    signed := buf[:dot2]
+    if !verifyMAC(current, tenant, signed, &presented) && !verifyMAC(previous, tenant, signed, &presented) {
+        return nil, errBadSignature
+    }
+    if err := checkTokenTimes(claims); err != nil {
+        return nil, err
+    }
+    return claims, nil
+}
+
+// Check exp and nbf against the clock, allowing tokenLeeway of drift either way
+func checkTokenTimes(claims *Claims) error {
+    now := clock.Now()
+    if now.Add(-tokenLeeway).Unix() >= claims.ExpiresAt {
+        return errTokenExpired
+    }
+    if claims.NotBefore != 0 && now.Add(tokenLeeway).Unix() < claims.NotBefore {
+        return errTokenNotYet
+    }
+    return nil
+}
+
+func verifyMAC(set *secretSet, tenant string, signed []byte, presented *[sha256.Size]byte) bool {
+    if set == nil {
+        return false
+    }
+    key := set.signingKeys[tenant]
+    if key == nil {
+        return false
+    }
+    h := key.getMAC()
+    h.Write(signed)
+    var expected [sha256.Size]byte
+    h.Sum(expected[:0])
+    key.putMAC(h)
+    return hmac.Equal(expected[:], presented[:])
+}
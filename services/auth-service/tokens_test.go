@@ -0,0 +1,304 @@
+package main
+
+import (
+    "context"
+    "crypto/ed25519"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "auth-service/pkg/tokenverify"
+)
+
+func useTestSecrets(tb testing.TB) {
+    tb.Helper()
+    secrets.mu.Lock()
+    secrets.current = &secretSet{
+        jwtSecret:        "test-jwt-secret",
+        internalAPIKey:   "test-api-key",
+        authServiceToken: "test-service-token",
+        signingKeys:      tenantSigningKeys("test-jwt-secret", func(string) string { return "" }),
+    }
+    secrets.previous = nil
+    secrets.mu.Unlock()
+}
+
+func testClaims() Claims {
+    now := time.Now()
+    return Claims{
+        ID:        "0123456789abcdef0123456789abcdef",
+        Subject:   "user-0123456789abcdef",
+        Email:     "user@example.com",
+        Roles:     []string{"user", "admin"},
+        Scopes:    []string{"images:read"},
+        Audience:  "image-service",
+        Actor:     &Actor{Subject: "api-service", Actor: &Actor{Subject: "frontend"}},
+        IssuedAt:  now.Unix(),
+        NotBefore: now.Unix(),
+        ExpiresAt: now.Add(time.Hour).Unix(),
+    }
+}
+
+// Swap the signature's first character for another; the last ones carry
+// padding bits, so changing them doesn't always change the signature
+func tamperSignature(token string) string {
+    i := strings.LastIndex(token, ".") + 1
+    c := "A"
+    if token[i] == 'A' {
+        c = "B"
+    }
+    return token[:i] + c + token[i+1:]
+}
+
+func useTestClock(tb testing.TB, now time.Time) {
+    tb.Helper()
+    clock = fixedClock(now)
+    tb.Cleanup(func() { clock = systemClock{} })
+}
+
+func TestTokenRoundTrip(t *testing.T) {
+    useTestSecrets(t)
+    want := testClaims()
+    token, err := signToken(want)
+    if err != nil {
+        t.Fatal(err)
+    }
+    got, err := parseToken(token)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(*got, want) {
+        t.Fatalf("claims differ:\n got %+v\nwant %+v", *got, want)
+    }
+
+    if _, err := parseToken(tamperSignature(token)); err != errBadSignature {
+        t.Fatalf("tampered signature: got %v", err)
+    }
+}
+
+func TestTokenTimesAllowLeeway(t *testing.T) {
+    useTestSecrets(t)
+    issued := time.Unix(1700000000, 0)
+    claims := testClaims()
+    claims.IssuedAt = issued.Unix()
+    claims.NotBefore = issued.Unix()
+    claims.ExpiresAt = issued.Add(time.Hour).Unix()
+    token, err := signToken(claims)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for _, tc := range []struct {
+        name string
+        now  time.Time
+        want error
+    }{
+        {"valid", issued.Add(time.Minute), nil},
+        {"verifier clock slightly behind", issued.Add(-tokenLeeway / 2), nil},
+        {"verifier clock far behind", issued.Add(-2 * tokenLeeway), errTokenNotYet},
+        {"expired within leeway", issued.Add(time.Hour + tokenLeeway/2), nil},
+        {"expired beyond leeway", issued.Add(time.Hour + tokenLeeway), errTokenExpired},
+    } {
+        useTestClock(t, tc.now)
+        if _, err := parseToken(token); err != tc.want {
+            t.Errorf("%s: got %v, want %v", tc.name, err, tc.want)
+        }
+    }
+}
+
+func TestEdDSATokensVerifyLocally(t *testing.T) {
+    useTestSecrets(t)
+    _, master, _ := ed25519.GenerateKey(nil)
+    secrets.mu.Lock()
+    secrets.current.tokenSigningKey = master
+    secrets.current.tokenKeys = tenantEdKeys(master)
+    secrets.mu.Unlock()
+
+    want := testClaims()
+    token, err := signToken(want)
+    if err != nil {
+        t.Fatal(err)
+    }
+    got, err := parseToken(token)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(*got, want) {
+        t.Fatalf("claims differ:\n got %+v\nwant %+v", *got, want)
+    }
+
+    srv := httptest.NewServer(http.HandlerFunc(jwksHandler))
+    defer srv.Close()
+    v := tokenverify.New(tokenverify.Config{JWKSURL: srv.URL, Audience: "image-service"})
+    if err := v.Refresh(context.Background()); err != nil {
+        t.Fatal(err)
+    }
+    claims, err := v.Verify(token)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if claims.Subject != want.Subject || claims.Actor.Actor.Subject != "frontend" {
+        t.Fatalf("unexpected claims %+v", claims)
+    }
+    if _, err := v.Verify(tamperSignature(token)); err != tokenverify.ErrBadSignature {
+        t.Fatalf("tampered signature: got %v", err)
+    }
+
+    other := tokenverify.New(tokenverify.Config{JWKSURL: srv.URL, Audience: "api-service"})
+    other.Refresh(context.Background())
+    if _, err := other.Verify(token); err != tokenverify.ErrWrongAudience {
+        t.Fatalf("wrong audience: got %v", err)
+    }
+}
+
+func TestDecodeClaimsMatchesEncodingJSON(t *testing.T) {
+    payloads := []string{
+        `{"sub":"a","iat":1,"exp":2}`,
+        `{"jti":"x","sub":"a","roles":[],"aud":"svc","act":{"sub":"b","act":{"sub":"c"}},"iat":-5,"exp":9}`,
+        ` { "sub" : "a" , "extra" : [ "x" ] , "flag" : true , "n" : null , "exp" : 3 } `,
+        `{"sub":"a","act":null,"exp":3}`,
+        `{"jti":"imp-1","sub":"a","exp":3,"impersonated_by":"admin-1"}`,
+        `{"sub":"a","exp":3,"cnf":{"x5t#S256":"bwcK0esc1ClOFX1_zkRbFTfwM1TIZPDSQ6P0sKGBg9Q"},"cidr":"10.0.0.0/24"}`,
+    }
+    for _, p := range payloads {
+        var fast, slow Claims
+        if err := decodeClaims(p, &fast); err != nil {
+            t.Fatalf("%s: %v", p, err)
+        }
+        if err := json.Unmarshal([]byte(p), &slow); err != nil {
+            t.Fatal(err)
+        }
+        if !reflect.DeepEqual(fast, slow) {
+            t.Fatalf("%s:\nfast %+v\nslow %+v", p, fast, slow)
+        }
+    }
+
+    // Escapes and floats are left to encoding/json
+    for _, p := range []string{`{"sub":"a\"b"}`, `{"exp":1.5}`, `{"sub":"a"`, `{"x":{"y":1}}`} {
+        var c Claims
+        if err := decodeClaims(p, &c); err != errClaimsFallback {
+            t.Fatalf("%s: expected fallback, got %v", p, err)
+        }
+    }
+}
+
+func BenchmarkSignToken(b *testing.B) {
+    useTestSecrets(b)
+    claims := testClaims()
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        if _, err := signToken(claims); err != nil {
+            b.Fatal(err)
+        }
+    }
+}
+
+func BenchmarkParseToken(b *testing.B) {
+    useTestSecrets(b)
+    token, _ := signToken(testClaims())
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        if _, err := parseToken(token); err != nil {
+            b.Fatal(err)
+        }
+    }
+}
+
+func BenchmarkParseTokenParallel(b *testing.B) {
+    useTestSecrets(b)
+    token, _ := signToken(testClaims())
+    b.ReportAllocs()
+    b.ResetTimer()
+    b.RunParallel(func(pb *testing.PB) {
+        for pb.Next() {
+            if _, err := parseToken(token); err != nil {
+                b.Fatal(err)
+            }
+        }
+    })
+}
+
+func BenchmarkParseTokenEncodingJSON(b *testing.B) {
+    payload, _ := json.Marshal(testClaims())
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        var c Claims
+        if err := json.Unmarshal(payload, &c); err != nil {
+            b.Fatal(err)
+        }
+    }
+}
+
+func BenchmarkDecodeClaims(b *testing.B) {
+    payload, _ := json.Marshal(testClaims())
+    s := string(payload)
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        var c Claims
+        if err := decodeClaims(s, &c); err != nil {
+            b.Fatal(err)
+        }
+    }
+}
+
+func BenchmarkValidateHandler(b *testing.B) {
+    useTestSecrets(b)
+    req := httptest.NewRequest(http.MethodGet, "/validate", nil)
+    req.Header.Set("X-Service-Token", "test-service-token")
+    req.Header.Set("X-Internal-API-Key", "test-api-key")
+    b.ReportAllocs()
+    b.ResetTimer()
+    for i := 0; i < b.N; i++ {
+        validateHandler(httptest.NewRecorder(), req)
+    }
+}
+
+func TestCheckAudience(t *testing.T) {
+    saved := serviceAudiences
+    serviceAudiences = parseSPIFFERoleMap("internal-service=api-service;spiffe://minikube.local/ns/ops/*=admin-api|api-service")
+    t.Cleanup(func() { serviceAudiences = saved })
+
+    for _, tc := range []struct {
+        aud    string
+        caller string
+        want   error
+    }{
+        {"api-service", "internal-service", nil},
+        {"frontend", "internal-service", errWrongAudience},
+        {"admin-api", "spiffe://minikube.local/ns/ops/sa/console", nil},
+        {"frontend", "spiffe://minikube.local/ns/ops/sa/console", errWrongAudience},
+        {"image-service", "image-service", nil}, // unmapped callers accept their own name
+        {"", "image-service", nil},
+    } {
+        claims := &Claims{Audience: tc.aud}
+        if err := checkAudience(claims, &Principal{Subject: tc.caller}); err != tc.want {
+            t.Errorf("aud %q for %s: got %v, want %v", tc.aud, tc.caller, err, tc.want)
+        }
+    }
+}
+
+// Changing a copy leaves the cached claims alone
+func TestCopyClaimsIsDeep(t *testing.T) {
+    cached := testClaims()
+    cached.Confirmation = &Confirmation{X5tS256: "thumbprint"}
+    want := testClaims()
+    want.Confirmation = &Confirmation{X5tS256: "thumbprint"}
+
+    c := copyClaims(&cached)
+    c.Roles[0] = "changed"
+    c.Scopes[0] = "changed"
+    c.Actor.Subject = "changed"
+    c.Actor.Actor.Subject = "changed"
+    c.Confirmation.X5tS256 = "changed"
+    if !reflect.DeepEqual(cached, want) {
+        t.Fatalf("cached claims changed: %+v", cached)
+    }
+}
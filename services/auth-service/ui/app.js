@@ -0,0 +1,224 @@
+// Admin dashboard: polls the service's own admin endpoints with the admin
+// credential entered on sign in. Everything is rendered with textContent,
+// never as HTML, since audit details and metric labels come from callers.
+'use strict';
+
+const POLL_MS = 5000;
+const CREDENTIAL_KEY = 'auth-admin-credential';
+
+let timer = null;
+let metrics = null;
+
+function credentialHeaders() {
+    const credential = sessionStorage.getItem(CREDENTIAL_KEY) || '';
+    // Tokens are JWTs; anything else is an API key
+    if (credential.split('.').length === 3) {
+        return { Authorization: 'Bearer ' + credential };
+    }
+    return { 'X-API-Key': credential };
+}
+
+class AuthError extends Error {}
+
+// Relative to /admin/ui/, so the page works behind a path-prefixed ingress
+async function get(path, asText) {
+    const res = await fetch('../..' + path, { headers: credentialHeaders(), cache: 'no-store' });
+    if (res.status === 401 || res.status === 403) {
+        throw new AuthError('Not authorized: ' + res.status);
+    }
+    if (!res.ok) {
+        throw new Error(path + ' answered ' + res.status);
+    }
+    return asText ? res.text() : res.json();
+}
+
+function el(tag, text, className) {
+    const node = document.createElement(tag);
+    if (text !== undefined && text !== null) {
+        node.textContent = String(text);
+    }
+    if (className) {
+        node.className = className;
+    }
+    return node;
+}
+
+function fillRows(tableId, rows) {
+    const body = document.querySelector('#' + tableId + ' tbody');
+    body.replaceChildren(...rows.map((cells) => {
+        const tr = el('tr');
+        for (const cell of cells) {
+            tr.appendChild(cell instanceof Node ? cell : el('td', cell));
+        }
+        return tr;
+    }));
+}
+
+function formatTime(value) {
+    return value ? new Date(value).toLocaleString() : '';
+}
+
+function num(value, className) {
+    return el('td', value, 'num' + (className ? ' ' + className : ''));
+}
+
+// Prometheus text format, one sample per series
+function parseMetrics(text) {
+    const samples = new Map();
+    for (const line of text.split('\n')) {
+        if (!line || line.startsWith('#')) {
+            continue;
+        }
+        const space = line.lastIndexOf(' ');
+        const value = Number(line.slice(space + 1));
+        if (space > 0 && !Number.isNaN(value)) {
+            samples.set(line.slice(0, space), value);
+        }
+    }
+    return samples;
+}
+
+// Counter rates come from the difference between the last two polls
+function updateMetrics(samples) {
+    const now = Date.now();
+    const rates = new Map();
+    if (metrics) {
+        const elapsed = (now - metrics.at) / 1000;
+        for (const [series, value] of samples) {
+            if (/_total(\{|$)/.test(series) && metrics.samples.has(series)) {
+                rates.set(series, ((value - metrics.samples.get(series)) / elapsed).toFixed(2));
+            }
+        }
+    }
+    metrics = { at: now, samples, rates };
+    renderMetrics();
+}
+
+function renderMetrics() {
+    const filter = document.getElementById('metric-filter').value.trim();
+    const rows = [];
+    for (const [series, value] of metrics.samples) {
+        if (!filter || series.includes(filter)) {
+            rows.push([el('td', series, 'mono'), num(value), num(metrics.rates.get(series) || '')]);
+        }
+    }
+    fillRows('metrics', rows);
+}
+
+function renderSLOs(slos) {
+    fillRows('slos', slos.map((slo) => {
+        const burn = (name) => {
+            const w = slo.windows.find((w) => w.window === name);
+            return w ? w.burnRate.toFixed(2) : '';
+        };
+        const hour = slo.windows.find((w) => w.window === '1h');
+        const alert = slo.fastBurn ? 'fast burn' : slo.slowBurn ? 'slow burn' : 'ok';
+        return [
+            slo.name,
+            num(slo.objective + '%'),
+            num(hour ? hour.sli.toFixed(3) + '%' : ''),
+            num(burn('5m')),
+            num(burn('1h')),
+            num(burn('6h')),
+            el('td', alert, alert === 'ok' ? 'good' : 'bad'),
+        ];
+    }));
+}
+
+function renderAudit(events) {
+    fillRows('audit', events.map((e) => [
+        formatTime(e.time),
+        e.type,
+        el('td', e.subject, 'mono'),
+        e.ip,
+        el('td', Object.entries(e.details || {}).map(([k, v]) => k + '=' + v).join(' '), 'mono'),
+    ]));
+}
+
+function renderSessions(sessions) {
+    fillRows('sessions', sessions.map((s) => [
+        el('td', s.id, 'mono'),
+        el('td', s.userId, 'mono'),
+        s.clientIp,
+        formatTime(s.createdAt),
+        formatTime(s.expiresAt),
+    ]));
+}
+
+function renderKeys(keys) {
+    const rows = [];
+    for (const [name, value] of Object.entries(keys)) {
+        let shown = value;
+        if (typeof value === 'boolean') {
+            shown = value ? 'loaded' : 'not configured';
+        } else if (value && typeof value === 'object') {
+            shown = JSON.stringify(value);
+        }
+        rows.push([el('td', name), el('td', shown, 'mono')]);
+    }
+    fillRows('keys', rows);
+}
+
+async function refresh() {
+    try {
+        const [dashboard, audit, slo, metricsText] = await Promise.all([
+            get('/admin/dashboard'),
+            get('/audit?limit=20'),
+            get('/slo'),
+            get('/metrics', true),
+        ]);
+        renderSLOs(slo.slos);
+        updateMetrics(parseMetrics(metricsText));
+        renderAudit(audit.events);
+        renderSessions(dashboard.sessions);
+        renderKeys(Object.assign({}, dashboard.keys, {
+            fieldEncryptionKey: dashboard.encryption.activeKey || '',
+        }));
+        document.getElementById('status').textContent =
+            'Tenant ' + dashboard.tenant + ' · updated ' + new Date().toLocaleTimeString();
+    } catch (err) {
+        if (err instanceof AuthError) {
+            signOut(err.message);
+            return;
+        }
+        document.getElementById('status').textContent = err.message;
+    }
+}
+
+function showDashboard() {
+    document.getElementById('sign-in').hidden = true;
+    document.getElementById('dashboard').hidden = false;
+    document.getElementById('sign-out').hidden = false;
+    refresh();
+    timer = setInterval(refresh, POLL_MS);
+}
+
+function signOut(message) {
+    clearInterval(timer);
+    sessionStorage.removeItem(CREDENTIAL_KEY);
+    metrics = null;
+    document.getElementById('dashboard').hidden = true;
+    document.getElementById('sign-out').hidden = true;
+    document.getElementById('sign-in').hidden = false;
+    document.getElementById('sign-in-error').textContent = message || '';
+    document.getElementById('status').textContent = '';
+}
+
+document.getElementById('sign-in-form').addEventListener('submit', (event) => {
+    event.preventDefault();
+    sessionStorage.setItem(CREDENTIAL_KEY, document.getElementById('credential').value.trim());
+    document.getElementById('credential').value = '';
+    showDashboard();
+});
+document.getElementById('sign-out').addEventListener('click', () => signOut());
+document.getElementById('metric-filter').addEventListener('input', () => {
+    if (metrics) {
+        renderMetrics();
+    }
+});
+
+if (sessionStorage.getItem(CREDENTIAL_KEY)) {
+    showDashboard();
+} else {
+    signOut();
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/hex"
+    "strings"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+const (
+    UserStatusPending  = "pending"
+    UserStatusActive   = "active"
+    UserStatusDisabled = "disabled"
+    UserStatusDeleted  = "deleted" // kept until the retention job purges it
+)
+
+type User struct {
+    ID           string    `json:"id"`
+    Tenant       string    `json:"tenant"`
+    Email        string    `json:"email"`
+    PasswordHash string    `json:"-"`
+    Status       string    `json:"status"`
+    Roles        []string  `json:"roles"`
+    CreatedAt    time.Time `json:"createdAt"`
+    VerifiedAt   time.Time `json:"verifiedAt,omitempty"`
+    DeletedAt    time.Time `json:"deletedAt,omitempty"`
+}
+
+var (
+    errUserExists   = autherr.ErrConflict.WithMessage("Account already exists")
+    errUserNotFound = autherr.ErrNotFound.WithMessage("User not found")
+)
+
+// Create a pending account; the caller is expected to send the verification email
+func createUser(ctx context.Context, tenant, email, password string) (*User, error) {
+    user := &User{
+        ID:           "user-" + randomHex(8),
+        Tenant:       tenant,
+        Email:        normalizeEmail(email),
+        PasswordHash: hashPassword(password),
+        Status:       UserStatusPending,
+        Roles:        []string{"user"},
+        CreatedAt:    time.Now(),
+    }
+    if err := store.CreateUser(ctx, user); err != nil {
+        return nil, err
+    }
+    return user, nil
+}
+
+func activateUser(ctx context.Context, id string) (*User, error) {
+    user, err := store.GetUser(ctx, id)
+    if err != nil {
+        return nil, err
+    }
+    if user.Status == UserStatusPending {
+        user.Status = UserStatusActive
+        user.VerifiedAt = time.Now()
+        if err := store.UpdateUser(ctx, user); err != nil {
+            return nil, err
+        }
+    }
+    return user, nil
+}
+
+func normalizeEmail(email string) string {
+    return strings.ToLower(strings.TrimSpace(email))
+}
+
+func randomHex(n int) string {
+    b := make([]byte, n)
+    if _, err := rand.Read(b); err != nil {
+        panic(err)
+    }
+    return hex.EncodeToString(b)
+}
+
+// Password hashing: PBKDF2-HMAC-SHA256 encoded as "pbkdf2$<iter>$<salt>$<key>"
+const passwordIterations = 100000
+
+func hashPassword(password string) string {
+    salt := randomHex(16)
+    key := pbkdf2SHA256([]byte(password), []byte(salt), passwordIterations)
+    return "pbkdf2$100000$" + salt + "$" + hex.EncodeToString(key)
+}
+
+func checkPassword(hash, password string) bool {
+    parts := strings.Split(hash, "$")
+    if len(parts) != 4 || parts[0] != "pbkdf2" || parts[1] != "100000" {
+        return false
+    }
+    key := pbkdf2SHA256([]byte(password), []byte(parts[2]), passwordIterations)
+    return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(key)), []byte(parts[3])) == 1
+}
+
+// Single-block PBKDF2 (32-byte output matches the SHA-256 block size)
+func pbkdf2SHA256(password, salt []byte, iterations int) []byte {
+    prf := hmac.New(sha256.New, password)
+    prf.Write(salt)
+    prf.Write([]byte{0, 0, 0, 1})
+    u := prf.Sum(nil)
+    out := make([]byte, len(u))
+    copy(out, u)
+    for i := 1; i < iterations; i++ {
+        prf.Reset()
+        prf.Write(u)
+        u = prf.Sum(u[:0])
+        for j := range out {
+            out[j] ^= u[j]
+        }
+    }
+    return out
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+    "encoding/json"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// ?status= and ?role= match exactly, ?email= is a prefix
+var userListSpec = listSpec{
+    defaultLimit: 50,
+    maxLimit:     200,
+    sorts:        []string{"id", "createdAt"},
+    defaultSort:  "id",
+    filters:      []string{"status", "role", "email"},
+}
+
+// Reading users needs the admin or service role or the users:read scope;
+// changing them needs admin or users:write, and changing roles or an admin's
+// account needs admin
+func authorizeUsers(r *http.Request, write bool) error {
+    p := principalFromContext(r.Context())
+    if p == nil {
+        return autherr.ErrUnauthenticated
+    }
+    if p.HasRole("admin") || p.HasScope("users:write") || (!write && (p.HasRole("service") || p.HasScope("users:read"))) {
+        return nil
+    }
+    if write {
+        return autherr.ErrForbidden.WithMessage("Missing users:write scope")
+    }
+    return autherr.ErrForbidden.WithMessage("Missing users:read scope")
+}
+
+// Users endpoint: GET lists the tenant's users a page at a time, sorted by
+// id or createdAt and filtered by ?status=, ?role= and ?email= (prefix);
+// POST creates an active account
+func usersHandler(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        if err := authorizeUsers(r, false); err != nil {
+            autherr.Write(w, err)
+            return
+        }
+        listUsers(w, r)
+    case http.MethodPost:
+        if err := authorizeUsers(r, true); err != nil {
+            autherr.Write(w, err)
+            return
+        }
+        createUserAdmin(w, r)
+    default:
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+    }
+}
+
+func listUsers(w http.ResponseWriter, r *http.Request) {
+    lq, err := parseListQuery(r, userListSpec)
+    if err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    // Fetch one extra row to learn whether another page follows
+    f := UserFilter{
+        Tenant:        tenantFromContext(r.Context()),
+        Status:        lq.Filters["status"],
+        Role:          lq.Filters["role"],
+        EmailPrefix:   normalizeEmail(lq.Filters["email"]),
+        SortByCreated: lq.Sort == "createdAt",
+        Desc:          lq.Desc,
+        Limit:         lq.Limit + 1,
+    }
+    if lq.After != nil {
+        f.After = lq.After.ID
+        if f.SortByCreated {
+            if f.AfterCreated, err = parseSortKeyTime(lq.After.Key); err != nil {
+                autherr.Write(w, err)
+                return
+            }
+        }
+    }
+    users, err := store.ListUsers(r.Context(), f)
+    if err != nil {
+        log.Printf("❌ User list failed: %v", err)
+        autherr.Write(w, autherr.ErrInternal)
+        return
+    }
+    next := ""
+    if len(users) > lq.Limit {
+        users = users[:lq.Limit]
+        last := users[lq.Limit-1]
+        key := last.ID
+        if f.SortByCreated {
+            key = sortKeyTime(last.CreatedAt)
+        }
+        next = lq.cursor(key, last.ID)
+    }
+    writeListPage(w, "users", users, len(users), next)
+}
+
+func createUserAdmin(w http.ResponseWriter, r *http.Request) {
+    var req struct {
+        Email    string   `json:"email"`
+        Password string   `json:"password"`
+        Roles    []string `json:"roles"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !strings.Contains(req.Email, "@") || len(req.Password) < 8 {
+        autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("A valid email and a password of at least 8 characters are required"))
+        return
+    }
+    // users:write is enough for accounts, not for handing out roles
+    if len(req.Roles) > 0 {
+        if err := requireAdmin(r); err != nil {
+            autherr.Write(w, err)
+            return
+        }
+    }
+
+    // Accounts created by an administrator skip email verification
+    now := time.Now()
+    user := &User{
+        ID:           "user-" + randomHex(8),
+        Tenant:       tenantFromContext(r.Context()),
+        Email:        normalizeEmail(req.Email),
+        PasswordHash: hashPassword(req.Password),
+        Status:       UserStatusActive,
+        Roles:        req.Roles,
+        CreatedAt:    now,
+        VerifiedAt:   now,
+    }
+    if len(user.Roles) == 0 {
+        user.Roles = []string{"user"}
+    }
+    if err := store.CreateUser(r.Context(), user); err != nil {
+        autherr.Write(w, err)
+        return
+    }
+    recordAudit(r, "user.created", user.ID, map[string]string{"email": user.Email, "roles": strings.Join(user.Roles, ",")})
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusCreated)
+    json.NewEncoder(w).Encode(user)
+}
+
+// User endpoint: GET, PATCH {"roles": [...], "status": "active"|"disabled"}
+// and DELETE on /users/{id}. Only admins may change roles or touch another
+// admin's account. DELETE only marks the user deleted until the retention
+// job purges them, and setting the status back to active restores them;
+// /users/{id}/export and /users/{id}/purge are in retention.go.
+func userHandler(w http.ResponseWriter, r *http.Request) {
+    id, action, _ := strings.Cut(strings.Trim(strings.TrimPrefix(r.URL.Path, "/users/"), "/"), "/")
+    if id == "" || strings.Contains(action, "/") {
+        autherr.Write(w, autherr.ErrNotFound)
+        return
+    }
+    if err := authorizeUsers(r, r.Method != http.MethodGet); err != nil {
+        autherr.Write(w, err)
+        return
+    }
+
+    // Users of other tenants are invisible rather than forbidden
+    user, err := store.GetUser(r.Context(), id)
+    if err == nil && user.Tenant != tenantFromContext(r.Context()) {
+        err = errUserNotFound
+    }
+    if err != nil {
+        autherr.Write(w, err)
+        return
+    }
+
+    // Otherwise users:write could disable or delete the admins themselves
+    if r.Method != http.MethodGet && containsString(user.Roles, "admin") {
+        if err := requireAdmin(r); err != nil {
+            autherr.Write(w, err)
+            return
+        }
+    }
+
+    if action != "" {
+        userDataHandler(w, r, user, action)
+        return
+    }
+
+    switch r.Method {
+    case http.MethodGet:
+    case http.MethodPatch:
+        var req struct {
+            Roles  *[]string `json:"roles"`
+            Status *string   `json:"status"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+            autherr.Write(w, autherr.ErrInvalidRequest)
+            return
+        }
+        details := map[string]string{}
+        if req.Roles != nil {
+            if err := requireAdmin(r); err != nil {
+                autherr.Write(w, err)
+                return
+            }
+            user.Roles = *req.Roles
+            details["roles"] = strings.Join(user.Roles, ",")
+        }
+        disabled := false
+        if req.Status != nil {
+            switch *req.Status {
+            case UserStatusActive, UserStatusDisabled:
+                if user.Status == UserStatusDeleted {
+                    details["restored"] = "true"
+                    user.DeletedAt = time.Time{}
+                }
+                disabled = *req.Status == UserStatusDisabled && user.Status != UserStatusDisabled
+                user.Status = *req.Status
+                details["status"] = user.Status
+            default:
+                autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("status must be active or disabled"))
+                return
+            }
+        }
+        if err := store.UpdateUser(r.Context(), user); err != nil {
+            autherr.Write(w, err)
+            return
+        }
+        // A disabled user's tokens stop working at once, as on delete
+        if disabled {
+            if err := revokeUserTokens(r.Context(), user); err != nil {
+                autherr.Write(w, err)
+                return
+            }
+        }
+        recordAudit(r, "user.updated", user.ID, details)
+    case http.MethodDelete:
+        if user.Status != UserStatusDeleted {
+            if err := softDeleteUser(r.Context(), user); err != nil {
+                autherr.Write(w, err)
+                return
+            }
+            recordAudit(r, "user.deleted", id, map[string]string{"purgeAfter": user.DeletedAt.Add(userRetention).Format(time.RFC3339)})
+        }
+        w.WriteHeader(http.StatusNoContent)
+        return
+    default:
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(user)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+var (
+    validateBatchMax         = getEnvInt("VALIDATE_BATCH_MAX", 100)
+    validateBatchConcurrency = getEnvInt("VALIDATE_BATCH_CONCURRENCY", 8)
+)
+
+// BatchItem is one credential to check: a bearer token or an API key. A
+// token bound to its client (see binding.go) needs that client's details.
+type BatchItem struct {
+    ID     string `json:"id"`
+    Token  string `json:"token,omitempty"`
+    APIKey string `json:"apiKey,omitempty"`
+
+    ClientIP             string `json:"clientIp,omitempty"`
+    ClientCertThumbprint string `json:"clientCertThumbprint,omitempty"`
+}
+
+// BatchResult reports one item; Error is set when Valid is false
+type BatchResult struct {
+    ID        string         `json:"id"`
+    Valid     bool           `json:"valid"`
+    Principal *Principal     `json:"principal,omitempty"`
+    ExpiresAt int64          `json:"expiresAt,omitempty"`
+    Error     *autherr.Error `json:"error,omitempty"`
+}
+
+// Batch validation endpoint: POST {"items": [{"id", "token"|"apiKey"}]}
+// checks up to VALIDATE_BATCH_MAX credentials in one round trip, so the
+// gateway can resolve a whole request's dependency chain at once. Only
+// services and admins may call it; results are in request order.
+func validateBatchHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+    p := principalFromContext(r.Context())
+    if p == nil {
+        autherr.Write(w, autherr.ErrUnauthenticated)
+        return
+    }
+    if !p.HasRole("service") && !p.HasRole("admin") {
+        autherr.Write(w, autherr.ErrForbidden.WithMessage("Batch validation is for services"))
+        return
+    }
+
+    var req struct {
+        Items []BatchItem `json:"items"`
+    }
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil || len(req.Items) == 0 {
+        autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("items is required"))
+        return
+    }
+    if len(req.Items) > validateBatchMax {
+        autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("Too many items in batch"))
+        return
+    }
+
+    // Tokens verify in microseconds, but API keys hit storage, so bound how
+    // many lookups one batch can have in flight
+    tenant := tenantFromContext(r.Context())
+    results := make([]BatchResult, len(req.Items))
+    sem := make(chan struct{}, max(validateBatchConcurrency, 1))
+    var wg sync.WaitGroup
+    for i := range req.Items {
+        wg.Add(1)
+        sem <- struct{}{}
+        go func(i int) {
+            defer func() { <-sem; wg.Done() }()
+            results[i] = validateBatchItem(r.Context(), tenant, p, req.Items[i])
+        }(i)
+    }
+    wg.Wait()
+
+    valid := 0
+    for _, res := range results {
+        if res.Valid {
+            valid++
+        }
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Header().Set("Cache-Control", "no-store")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "results":   results,
+        "count":     len(results),
+        "valid":     valid,
+        "timestamp": time.Now(),
+    })
+}
+
+func validateBatchItem(ctx context.Context, tenant string, caller *Principal, item BatchItem) BatchResult {
+    res := BatchResult{ID: item.ID}
+    switch {
+    case item.Token != "" && item.APIKey != "":
+        res.Error = autherr.ErrInvalidRequest.WithMessage("Give either token or apiKey, not both")
+    case item.Token != "":
+        claims, err := verifyToken(ctx, item.Token)
+        if err == nil && tokenTenant(claims) != tenant {
+            err = autherr.ErrInvalidToken.WithMessage("Token belongs to another tenant")
+        }
+        if err == nil {
+            err = checkBinding(claims, tokenPresenter{
+                thumbprint: item.ClientCertThumbprint,
+                ip:         net.ParseIP(strings.TrimSpace(item.ClientIP)),
+            })
+        }
+        if err == nil {
+            err = checkAudience(claims, caller)
+        }
+        if err != nil {
+            res.Error = autherr.From(err)
+            break
+        }
+        res.Valid = true
+        res.ExpiresAt = claims.ExpiresAt
+        res.Principal = tokenPrincipal(claims)
+    case item.APIKey != "":
+        k, err := store.GetAPIKeyByHash(ctx, hashAPIKey(item.APIKey))
+        if err != nil && !errors.Is(err, errAPIKeyNotFound) {
+            log.Printf("❌ API key lookup failed: %v", err)
+            res.Error = autherr.ErrInternal
+            break
+        }
+        if err != nil || k.Revoked || k.Tenant != tenant {
+            res.Error = autherr.ErrInvalidCredentials.WithMessage("Invalid API key")
+            break
+        }
+        res.Valid = true
+        res.Principal = apiKeyPrincipal(k)
+    default:
+        res.Error = autherr.ErrInvalidRequest.WithMessage("token or apiKey is required")
+    }
+    return res
+}
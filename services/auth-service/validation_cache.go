@@ -0,0 +1,193 @@
+package main
+
+import (
+    "container/list"
+    "context"
+    "crypto/sha256"
+    "fmt"
+    "io"
+    "sync"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+// Verified tokens are remembered for a short while, keyed by their SHA-256,
+// so a service validating the same token on every call doesn't repeat the
+// signature check each time. An entry lives for VALIDATION_CACHE_TTL or
+// until the token expires, whichever is sooner, and is dropped once the
+// signing secrets rotate. The least recently used entry makes room when
+// VALIDATION_CACHE_SIZE is reached; 0 turns the cache off. Concurrent
+// lookups of the same token share one verification. Impersonation tokens
+// are never cached so ending one takes effect at once; bulk revocations
+// (revocation.go) are checked on every lookup, hits included.
+var validations = &validationCache{
+    size:     getEnvInt("VALIDATION_CACHE_SIZE", 10000),
+    ttl:      getEnvDuration("VALIDATION_CACHE_TTL", 30*time.Second),
+    entries:  make(map[[sha256.Size]byte]*list.Element),
+    order:    list.New(),
+    inflight: make(map[[sha256.Size]byte]*validationCall),
+}
+
+type validationEntry struct {
+    key     [sha256.Size]byte
+    claims  *Claims
+    secrets *secretSet // generation the token was verified against
+    expires time.Time
+}
+
+// A verification in progress that later lookups of the same token wait on
+type validationCall struct {
+    done   chan struct{}
+    claims *Claims
+    err    error
+}
+
+type validationCache struct {
+    size int
+    ttl  time.Duration
+
+    mu       sync.Mutex
+    entries  map[[sha256.Size]byte]*list.Element
+    order    *list.List // of *validationEntry, most recently used first
+    inflight map[[sha256.Size]byte]*validationCall
+
+    hits, misses, shared, evictions int64
+}
+
+// Verify a token through the validation cache, then against the
+// revocations in force, which cached entries don't capture
+func verifyToken(ctx context.Context, token string) (*Claims, error) {
+    claims, err := validations.verify(ctx, token)
+    if err != nil {
+        return nil, err
+    }
+    if err := revocations.check(claims); err != nil {
+        return nil, err
+    }
+    return claims, nil
+}
+
+func (c *validationCache) verify(ctx context.Context, token string) (*Claims, error) {
+    if c.size <= 0 {
+        return verifyTokenUncached(ctx, token)
+    }
+    key := sha256.Sum256([]byte(token))
+
+    c.mu.Lock()
+    if claims := c.lookupLocked(key); claims != nil {
+        c.hits++
+        c.mu.Unlock()
+        return claims, nil
+    }
+    if call, ok := c.inflight[key]; ok {
+        c.shared++
+        c.mu.Unlock()
+        select {
+        case <-call.done:
+        case <-ctx.Done():
+            return nil, ctx.Err()
+        }
+        return copyClaims(call.claims), call.err
+    }
+    c.misses++
+    // Waiters see an internal error rather than nothing if verification panics
+    call := &validationCall{done: make(chan struct{}), err: autherr.ErrInternal}
+    c.inflight[key] = call
+    set := secrets.get()
+    c.mu.Unlock()
+
+    defer func() {
+        c.mu.Lock()
+        delete(c.inflight, key)
+        if call.err == nil && call.claims != nil && call.claims.ImpersonatedBy == "" {
+            c.addLocked(key, call.claims, set)
+        }
+        c.mu.Unlock()
+        close(call.done)
+    }()
+    call.claims, call.err = verifyTokenUncached(ctx, token)
+    return copyClaims(call.claims), call.err
+}
+
+// A live entry's claims, nil on a miss
+func (c *validationCache) lookupLocked(key [sha256.Size]byte) *Claims {
+    elem, ok := c.entries[key]
+    if !ok {
+        return nil
+    }
+    entry := elem.Value.(*validationEntry)
+    if !clock.Now().Before(entry.expires) || entry.secrets != secrets.get() {
+        c.order.Remove(elem)
+        delete(c.entries, key)
+        return nil
+    }
+    c.order.MoveToFront(elem)
+    return copyClaims(entry.claims)
+}
+
+func (c *validationCache) addLocked(key [sha256.Size]byte, claims *Claims, set *secretSet) {
+    expires := clock.Now().Add(c.ttl)
+    if exp := time.Unix(claims.ExpiresAt, 0); exp.Before(expires) {
+        expires = exp
+    }
+    if !clock.Now().Before(expires) {
+        return
+    }
+    if elem, ok := c.entries[key]; ok {
+        c.order.Remove(elem)
+    }
+    c.entries[key] = c.order.PushFront(&validationEntry{key: key, claims: claims, secrets: set, expires: expires})
+    for c.order.Len() > c.size {
+        oldest := c.order.Back()
+        c.order.Remove(oldest)
+        delete(c.entries, oldest.Value.(*validationEntry).key)
+        c.evictions++
+    }
+}
+
+// Callers get their own copy so a cached entry can't be changed under
+// another request, down to its role and scope lists and actor chain
+func copyClaims(claims *Claims) *Claims {
+    if claims == nil {
+        return nil
+    }
+    c := *claims
+    c.Roles = copyStrings(claims.Roles)
+    c.Scopes = copyStrings(claims.Scopes)
+    for a := &c.Actor; *a != nil; a = &(*a).Actor {
+        actor := **a
+        *a = &actor
+    }
+    if claims.Confirmation != nil {
+        cnf := *claims.Confirmation
+        c.Confirmation = &cnf
+    }
+    return &c
+}
+
+// A nil list stays nil
+func copyStrings(list []string) []string {
+    if list == nil {
+        return nil
+    }
+    return append([]string{}, list...)
+}
+
+func writeValidationCacheMetrics(w io.Writer) {
+    c := validations
+    c.mu.Lock()
+    hits, misses, shared, evictions, entries := c.hits, c.misses, c.shared, c.evictions, c.order.Len()
+    c.mu.Unlock()
+    fmt.Fprintf(w, "# HELP auth_validation_cache_requests_total Token verifications by cache result\n")
+    fmt.Fprintf(w, "# TYPE auth_validation_cache_requests_total counter\n")
+    fmt.Fprintf(w, "auth_validation_cache_requests_total{result=\"hit\"} %d\n", hits)
+    fmt.Fprintf(w, "auth_validation_cache_requests_total{result=\"miss\"} %d\n", misses)
+    fmt.Fprintf(w, "auth_validation_cache_requests_total{result=\"shared\"} %d\n", shared)
+    fmt.Fprintf(w, "# HELP auth_validation_cache_evictions_total Entries dropped to make room\n")
+    fmt.Fprintf(w, "# TYPE auth_validation_cache_evictions_total counter\n")
+    fmt.Fprintf(w, "auth_validation_cache_evictions_total %d\n", evictions)
+    fmt.Fprintf(w, "# HELP auth_validation_cache_entries Verified tokens currently cached\n")
+    fmt.Fprintf(w, "# TYPE auth_validation_cache_entries gauge\n")
+    fmt.Fprintf(w, "auth_validation_cache_entries %d\n", entries)
+}
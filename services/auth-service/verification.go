@@ -0,0 +1,103 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "auth-service/internal/autherr"
+)
+
+var (
+    verificationTTL = getEnvDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour)
+    publicBaseURL   = strings.TrimRight(getEnv("PUBLIC_BASE_URL", "http://localhost:8080"), "/")
+    resendLimiter   = newRateLimiter(
+        getEnvInt("VERIFICATION_RESEND_LIMIT", 3),
+        getEnvDuration("VERIFICATION_RESEND_WINDOW", time.Hour),
+    )
+)
+
+// Email verification tokens are one-time tokens of this kind
+const verificationTokenKind = "email_verification"
+
+func sendVerificationEmail(ctx context.Context, user *User) error {
+    token, _, err := issueOneTimeToken(ctx, verificationTokenKind, user.Tenant, user.ID, verificationTTL, nil)
+    if err != nil {
+        return err
+    }
+    link := fmt.Sprintf("%s/verify-email?token=%s", publicBaseURL, token)
+    body := fmt.Sprintf("Confirm your account by opening the link below within %s:\n\n%s\n", verificationTTL, link)
+    return sendMail(ctx, user.Email, "Verify your email address", body)
+}
+
+// Verify endpoint: consumes the emailed token and activates the account
+func verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+    token := r.URL.Query().Get("token")
+    if token == "" && r.Method == http.MethodPost {
+        var req map[string]string
+        if json.NewDecoder(r.Body).Decode(&req) == nil {
+            token = req["token"]
+        }
+    }
+
+    t, ok := consumeOneTimeToken(r.Context(), verificationTokenKind, token)
+    if !ok {
+        autherr.Write(w, autherr.ErrInvalidToken.WithMessage("Invalid or expired verification token"))
+        return
+    }
+    user, err := activateUser(r.Context(), t.UserID)
+    if err != nil {
+        autherr.Write(w, err)
+        return
+    }
+
+    log.Printf("✅ Email verified for %s", user.Email)
+    recordAudit(r, "user.verified", user.ID, nil)
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "id":      user.ID,
+        "email":   user.Email,
+        "status":  user.Status,
+        "message": "Email verified, account activated",
+    })
+}
+
+// Resend endpoint: rate limited per address; responds identically whether or
+// not the account exists so it can't be used to enumerate users
+func resendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        autherr.Write(w, autherr.ErrMethodNotAllowed)
+        return
+    }
+
+    var req credentialsRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+        autherr.Write(w, autherr.ErrInvalidRequest.WithMessage("Email is required"))
+        return
+    }
+
+    tenant := tenantFromContext(r.Context())
+    email := normalizeEmail(req.Email)
+    if ok, retryAfter := resendLimiter.Allow(tenant + "/" + email); !ok {
+        w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+        autherr.Write(w, autherr.ErrRateLimited.WithMessage("Too many verification emails requested"))
+        return
+    }
+
+    if user, err := store.GetUserByEmail(r.Context(), tenant, email); err == nil && user.Status == UserStatusPending {
+        if err := sendVerificationEmail(r.Context(), user); err != nil {
+            log.Printf("⚠️  Verification email failed: %v", err)
+        }
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(http.StatusAccepted)
+    json.NewEncoder(w).Encode(map[string]string{
+        "message": "If the account is awaiting verification, a new email has been sent",
+    })
+}
@@ -1,6 +1,6 @@
-import React, { useState } from 'react';
-import { Card, Form, Input, Button, Alert, Tabs, Tag, Descriptions } from 'antd';
-import { UserOutlined, LockOutlined, LoginOutlined, UserAddOutlined } from '@ant-design/icons';
+import React, { useState, useEffect } from 'react';
+import { Card, Form, Input, Button, Alert, Tabs, Tag, Descriptions, Divider, Space } from 'antd';
+import { UserOutlined, LockOutlined, LoginOutlined, UserAddOutlined, GithubOutlined, GoogleOutlined } from '@ant-design/icons';
 
 const { TabPane } = Tabs;
 
@@ -10,10 +10,47 @@ const AuthService = () => {
   const [token, setToken] = useState(localStorage.getItem('auth_token'));
   const [loading, setLoading] = useState(false);
   const [user, setUser] = useState(JSON.parse(localStorage.getItem('user') || 'null'));
+  const [providers, setProviders] = useState([]);
+  const [socialError, setSocialError] = useState(null);
+
+  useEffect(() => {
+    // The auth service hands the token back in the URL fragment after a
+    // GitHub/Google sign-in; take it and clean the address bar
+    const params = new URLSearchParams(window.location.hash.slice(1));
+    const accessToken = params.get('access_token');
+    if (accessToken) {
+      window.history.replaceState(null, '', window.location.pathname);
+      try {
+        const payload = accessToken.split('.')[1].replace(/-/g, '+').replace(/_/g, '/');
+        const claims = JSON.parse(atob(payload));
+        const socialUser = { id: claims.sub, username: claims.email, email: claims.email };
+        localStorage.setItem('auth_token', accessToken);
+        localStorage.setItem('user', JSON.stringify(socialUser));
+        setToken(accessToken);
+        setUser(socialUser);
+      } catch (error) {
+        setSocialError('Sign-in returned an unreadable token');
+      }
+    }
+
+    fetch('/auth/login/providers')
+      .then((response) => (response.ok ? response.json() : { providers: [] }))
+      .then((data) => setProviders(data.providers || []))
+      .catch(() => setProviders([]));
+  }, []);
+
+  const providerIcons = { github: <GithubOutlined />, google: <GoogleOutlined /> };
+  const providerNames = { github: 'GitHub', google: 'Google' };
+
+  const handleSocialLogin = (provider) => {
+    const returnTo = window.location.origin + window.location.pathname;
+    window.location.href = `/auth/login/${provider}?redirect_uri=${encodeURIComponent(returnTo)}`;
+  };
 
   const endpoints = [
     { method: 'POST', path: '/auth/register', description: 'User registration' },
     { method: 'POST', path: '/auth/login', description: 'User authentication' },
+    { method: 'GET', path: '/auth/login/{provider}', description: 'Sign in with GitHub or Google' },
     { method: 'POST', path: '/auth/refresh', description: 'Refresh JWT token' },
     { method: 'POST', path: '/auth/logout', description: 'User logout' },
     { method: 'GET', path: '/auth/profile', description: 'Get user profile' },
@@ -198,6 +235,29 @@ const AuthService = () => {
               </Form.Item>
             </Form>
 
+            {providers.length > 0 && (
+              <>
+                <Divider plain>or</Divider>
+                <Space direction="vertical" style={{ width: '100%', marginBottom: 24 }}>
+                  {providers.map((provider) => (
+                    <Button
+                      key={provider.name}
+                      icon={providerIcons[provider.name]}
+                      onClick={() => handleSocialLogin(provider.name)}
+                      size="large"
+                      block
+                    >
+                      Continue with {providerNames[provider.name] || provider.name}
+                    </Button>
+                  ))}
+                </Space>
+              </>
+            )}
+
+            {socialError && (
+              <Alert message={socialError} type="error" showIcon style={{ marginBottom: 24 }} />
+            )}
+
             <Alert
               message="Demo Mode"
               description="Enter any username and password to simulate login. In production, this would authenticate against a real user database."
@@ -172,7 +172,11 @@ eval $(minikube docker-env)
 # Step 8: Build images
 log_info "Building Docker images..."
 docker build -t api-service:$API_VERSION ./services/api-service &
-docker build -t auth-service:$AUTH_VERSION ./services/auth-service &
+docker build -t auth-service:$AUTH_VERSION \
+    --build-arg VERSION=$AUTH_VERSION \
+    --build-arg COMMIT=$(git rev-parse HEAD 2>/dev/null || echo unknown) \
+    --build-arg BUILD_TIME=$(date -u +%Y-%m-%dT%H:%M:%SZ) \
+    ./services/auth-service &
 docker build -t image-service:$IMAGE_VERSION ./services/image-service &
 docker build -t frontend:$FRONTEND_VERSION ./services/frontend &
 wait